@@ -0,0 +1,93 @@
+package observer
+
+import (
+	"context"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// initAuditLogger creates the logger for audit entries.
+// The audit logger is separate from the observer logger, so the audit entries are written regardless of the logging level
+// and they are not dropped by the logger options (i.e. hooks and syslog). Sensitive data are still scrubbed.
+func initAuditLogger(c configs) (*zap.Logger, shutdownFunc) {
+	config := zap.Config{
+		Level:            zap.NewAtomicLevelAt(zapcore.DebugLevel),
+		Development:      false,
+		Sampling:         nil,
+		Encoding:         "json",
+		EncoderConfig:    encoderConfig(c),
+		OutputPaths:      []string{"stdout"},
+		ErrorOutputPaths: []string{"stdout"},
+	}
+
+	if len(c.auditOutput) > 0 {
+		config.OutputPaths = c.auditOutput
+	}
+
+	if len(c.loggerErrOut) > 0 {
+		config.ErrorOutputPaths = c.loggerErrOut
+	}
+
+	opts := []zap.Option{
+		zap.AddCaller(),
+		zap.AddCallerSkip(1),
+		zap.Fields(initialFields(c)...),
+		zap.Fields(zap.Bool("audit", true)),
+	}
+
+	if len(c.logScrubbers) > 0 {
+		opts = append(opts, zap.WrapCore(func(core zapcore.Core) zapcore.Core {
+			return newScrubCore(core, c.logScrubbers)
+		}))
+	}
+
+	logger, err := config.Build(opts...)
+	if err != nil {
+		// The audit output cannot be opened, so the audit entries are written to stdout
+		config.OutputPaths = []string{"stdout"}
+		logger, _ = config.Build(opts...)
+		logger.WithOptions(zap.AddCallerSkip(-1)).Error("Failed to open the audit output, writing audit entries to stdout.", zap.Error(err))
+	}
+
+	if c.loggerName != "" {
+		logger = logger.Named(c.loggerName)
+	}
+
+	shutdown := func(context.Context) error {
+		return logger.Sync()
+	}
+
+	return logger, shutdown
+}
+
+// Audit writes an audit entry for an action (i.e. "user deleted") with the observer of a context.
+// Audit entries are marked with audit: true and they include the uuid of the request, the authenticated principal
+// (see ContextWithPrincipal), and the trace id of the request if they are set on the context.
+// They are written regardless of the logging level, and they can be routed to a separate output using WithAuditOutput.
+// If no observer found on the context, the singleton observer will be used!
+//
+//	observer.Audit(ctx, "user deleted", zap.String("user.id", userID))
+func Audit(ctx context.Context, action string, fields ...zap.Field) {
+	// Observers that are not created by this package (i.e. mocks) cannot write audit entries
+	o, ok := ObserverFromContext(ctx).(*observer)
+	if !ok {
+		o = singleton
+	}
+
+	auditFields := make([]zap.Field, 0, len(fields)+3)
+	if md, ok := RequestMetadataFromContext(ctx); ok {
+		if md.UUID != "" {
+			auditFields = append(auditFields, zap.String("req.uuid", md.UUID))
+		}
+		if md.Principal != "" {
+			auditFields = append(auditFields, zap.String("principal", md.Principal))
+		}
+		if md.TraceID.IsValid() {
+			auditFields = append(auditFields, zap.String("traceId", md.TraceID.String()))
+		}
+	}
+	auditFields = append(auditFields, fields...)
+
+	o.auditLogger.Info(action, auditFields...)
+}
@@ -0,0 +1,204 @@
+package observer
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+)
+
+// readAuditEntries reads the json log entries written to a file.
+func readAuditEntries(t *testing.T, path string) []map[string]interface{} {
+	data, err := ioutil.ReadFile(path)
+	assert.NoError(t, err)
+
+	entries := []map[string]interface{}{}
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		if line == "" {
+			continue
+		}
+		entry := map[string]interface{}{}
+		assert.NoError(t, json.Unmarshal([]byte(line), &entry))
+		entries = append(entries, entry)
+	}
+
+	return entries
+}
+
+func TestInitAuditLogger(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audit")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		name string
+		path string
+	}{
+		{
+			name: "File",
+			path: filepath.Join(dir, "audit.log"),
+		},
+		{
+			name: "InvalidPath",
+			path: filepath.Join(dir, "missing", "audit.log"),
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			logger, shutdown := initAuditLogger(configs{
+				name:        "my-service",
+				auditOutput: []string{tc.path},
+			})
+
+			assert.NotNil(t, logger)
+			assert.NotNil(t, shutdown)
+		})
+	}
+}
+
+func TestAudit(t *testing.T) {
+	tests := []struct {
+		name           string
+		ctx            func(Observer) context.Context
+		fields         []zap.Field
+		expectedFields map[string]interface{}
+	}{
+		{
+			name: "WithoutRequestMetadata",
+			ctx: func(o Observer) context.Context {
+				return ContextWithObserver(context.Background(), o)
+			},
+			fields: []zap.Field{zap.String("user.id", "1234")},
+			expectedFields: map[string]interface{}{
+				"service": "my-service",
+				"logger":  "my-service",
+				"audit":   true,
+				"user.id": "1234",
+			},
+		},
+		{
+			name: "WithRequestMetadata",
+			ctx: func(o Observer) context.Context {
+				ctx := ContextWithObserver(context.Background(), o)
+				return ContextWithRequestMetadata(ctx, RequestMetadata{
+					UUID:      "aaaaaaaa-aaaa-aaaa-aaaa-aaaaaaaaaaaa",
+					Principal: "admin",
+					TraceID:   trace.TraceID{0x01},
+				})
+			},
+			fields: []zap.Field{zap.String("user.id", "1234")},
+			expectedFields: map[string]interface{}{
+				"service":   "my-service",
+				"logger":    "my-service",
+				"audit":     true,
+				"req.uuid":  "aaaaaaaa-aaaa-aaaa-aaaa-aaaaaaaaaaaa",
+				"principal": "admin",
+				"traceId":   "01000000000000000000000000000000",
+				"user.id":   "1234",
+			},
+		},
+		{
+			name: "Singleton",
+			ctx: func(Observer) context.Context {
+				return ContextWithPrincipal(context.Background(), "admin")
+			},
+			fields: nil,
+			expectedFields: map[string]interface{}{
+				"service":   "my-service",
+				"logger":    "my-service",
+				"audit":     true,
+				"principal": "admin",
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			defer ResetSingleton()
+
+			dir, err := ioutil.TempDir("", "audit")
+			assert.NoError(t, err)
+			defer os.RemoveAll(dir)
+			path := filepath.Join(dir, "audit.log")
+
+			// Audit entries are written although the logger is not enabled
+			obsv := New(true,
+				WithMetadata("my-service", "", "", "", nil),
+				WithAuditOutput(path),
+			)
+
+			Audit(tc.ctx(obsv), "user deleted", tc.fields...)
+			assert.NoError(t, obsv.Shutdown(context.Background()))
+
+			entries := readAuditEntries(t, path)
+			assert.Len(t, entries, 1)
+			assert.Equal(t, "user deleted", entries[0]["message"])
+			assert.Equal(t, "info", entries[0]["level"])
+			for k, v := range tc.expectedFields {
+				assert.Equal(t, v, entries[0][k], "field %q", k)
+			}
+		})
+	}
+}
+
+func TestAuditLoggerName(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audit")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "audit.log")
+
+	obsv := New(false,
+		WithMetadata("my-service", "", "", "", nil),
+		WithLoggerName("billing"),
+		WithAuditOutput(path),
+	)
+	ctx := ContextWithObserver(context.Background(), obsv)
+
+	Audit(ctx, "invoice voided")
+	obsv.Shutdown(context.Background())
+
+	entries := readAuditEntries(t, path)
+	assert.Len(t, entries, 1)
+	assert.Equal(t, "my-service", entries[0]["service"])
+	assert.Equal(t, "billing", entries[0]["logger"])
+}
+
+func TestAuditLoggingLevel(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audit")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "audit.log")
+
+	obsv := New(false,
+		WithLogger("none"),
+		WithAuditOutput(path),
+	)
+	ctx := ContextWithObserver(context.Background(), obsv)
+
+	// Audit entries are written regardless of the logging level
+	Audit(ctx, "user created")
+	obsv.SetLogLevel(99)
+	Audit(ctx, "user deleted")
+	obsv.Shutdown(context.Background())
+
+	entries := readAuditEntries(t, path)
+	assert.Len(t, entries, 2)
+	assert.Equal(t, "user created", entries[0]["message"])
+	assert.Equal(t, "user deleted", entries[1]["message"])
+}
+
+func TestAuditNoop(t *testing.T) {
+	ctx := ContextWithObserver(context.Background(), NewNoop())
+
+	assert.NotPanics(t, func() {
+		Audit(ctx, "user deleted")
+	})
+}
@@ -2,26 +2,95 @@ package observer
 
 import (
 	"context"
+	"sync"
+	"time"
 
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
 )
 
 type contextKey string
 
 const (
-	uuidContextKey   = contextKey("UUID")
-	loggerContextKey = contextKey("Logger")
+	requestMetadataContextKey = contextKey("RequestMetadata")
+	loggerContextKey          = contextKey("Logger")
+	observerContextKey        = contextKey("Observer")
+	fieldsContextKey          = contextKey("Fields")
+	startTimeContextKey       = contextKey("StartTime")
 )
 
+// RequestMetadata bundles the metadata of a request.
+type RequestMetadata struct {
+	UUID       string
+	ClientName string
+	Principal  string
+	StartTime  time.Time
+	TraceID    trace.TraceID
+	SpanID     trace.SpanID
+}
+
+// ContextWithRequestMetadata creates a new context with a request metadata.
+func ContextWithRequestMetadata(ctx context.Context, md RequestMetadata) context.Context {
+	return context.WithValue(ctx, requestMetadataContextKey, md)
+}
+
+// RequestMetadataFromContext retrieves a request metadata from a context.
+func RequestMetadataFromContext(ctx context.Context) (RequestMetadata, bool) {
+	md, ok := ctx.Value(requestMetadataContextKey).(RequestMetadata)
+	return md, ok
+}
+
 // ContextWithUUID creates a new context with a uuid.
+// The uuid is set on the request metadata of the context.
 func ContextWithUUID(ctx context.Context, uuid string) context.Context {
-	return context.WithValue(ctx, uuidContextKey, uuid)
+	md, _ := RequestMetadataFromContext(ctx)
+	md.UUID = uuid
+	return ContextWithRequestMetadata(ctx, md)
 }
 
-// UUIDFromContext retrieves a uuid from a context.
+// UUIDFromContext retrieves a uuid from the request metadata of a context.
 func UUIDFromContext(ctx context.Context) (string, bool) {
-	uuid, ok := ctx.Value(uuidContextKey).(string)
-	return uuid, ok
+	md, ok := RequestMetadataFromContext(ctx)
+	if !ok || md.UUID == "" {
+		return "", false
+	}
+	return md.UUID, true
+}
+
+// ContextWithPrincipal creates a new context with the authenticated principal of a request (i.e. a user or service account).
+// The principal is set on the request metadata of the context, and it is included in audit entries (see Audit).
+func ContextWithPrincipal(ctx context.Context, principal string) context.Context {
+	md, _ := RequestMetadataFromContext(ctx)
+	md.Principal = principal
+	return ContextWithRequestMetadata(ctx, md)
+}
+
+// PrincipalFromContext retrieves the authenticated principal from the request metadata of a context.
+func PrincipalFromContext(ctx context.Context) (string, bool) {
+	md, ok := RequestMetadataFromContext(ctx)
+	if !ok || md.Principal == "" {
+		return "", false
+	}
+	return md.Principal, true
+}
+
+// ContextWithStartTime creates a new context with the arrival time of a request.
+// If a request waits in a queue before it is handled (i.e. a concurrency limiter),
+// the arrival time can be set before the request is queued, so the wait time can be measured.
+// The arrival time is kept separately from the request metadata, so it is never overwritten by the observable handlers.
+func ContextWithStartTime(ctx context.Context, startTime time.Time) context.Context {
+	return context.WithValue(ctx, startTimeContextKey, startTime)
+}
+
+// StartTimeFromContext retrieves the arrival time of a request from a context.
+func StartTimeFromContext(ctx context.Context) (time.Time, bool) {
+	startTime, ok := ctx.Value(startTimeContextKey).(time.Time)
+	if !ok || startTime.IsZero() {
+		return time.Time{}, false
+	}
+	return startTime, true
 }
 
 // ContextWithLogger returns a new context that holds a reference to a logger.
@@ -40,3 +109,138 @@ func LoggerFromContext(ctx context.Context) *zap.Logger {
 	// Return the singleton logger as the default
 	return singleton.logger
 }
+
+// ContextWithObserver returns a new context that holds a reference to an observer.
+func ContextWithObserver(ctx context.Context, observer Observer) context.Context {
+	return context.WithValue(ctx, observerContextKey, observer)
+}
+
+// ObserverFromContext returns an observer set on a context.
+// If no observer found on the context, the singleton observer will be returned!
+// This can be used by libraries for accessing the logger, meter, and tracer of the service handling a request.
+func ObserverFromContext(ctx context.Context) Observer {
+	val := ctx.Value(observerContextKey)
+	if observer, ok := val.(Observer); ok {
+		return observer
+	}
+
+	// Return the singleton observer as the default
+	return singleton
+}
+
+// fieldsAccumulator collects the log fields added while a request is being handled.
+type fieldsAccumulator struct {
+	sync.Mutex
+	fields []zap.Field
+}
+
+// ContextWithFields returns a new context that holds an empty accumulator for log fields.
+// The ohttp, ogrpc, and omq middleware create an accumulator for every request they handle
+// and add the accumulated fields to the log entry written when the request is completed.
+func ContextWithFields(ctx context.Context) context.Context {
+	return context.WithValue(ctx, fieldsContextKey, new(fieldsAccumulator))
+}
+
+// AddFields adds log fields to the accumulator set on a context.
+// The accumulator is shared by all contexts derived from the context it is set on,
+// so the fields added by a middleware or handler running inside our middleware (i.e. the authenticated principal)
+// appear in the log entry of the completed request, although the logger of the request was created before them.
+// It returns false if no accumulator found on the context.
+//
+//	observer.AddFields(ctx, zap.String("principal", principal))
+func AddFields(ctx context.Context, fields ...zap.Field) bool {
+	acc, ok := ctx.Value(fieldsContextKey).(*fieldsAccumulator)
+	if !ok {
+		return false
+	}
+
+	acc.Lock()
+	defer acc.Unlock()
+	acc.fields = append(acc.fields, fields...)
+
+	return true
+}
+
+// FieldsFromContext returns the log fields added to the accumulator set on a context so far.
+func FieldsFromContext(ctx context.Context) []zap.Field {
+	acc, ok := ctx.Value(fieldsContextKey).(*fieldsAccumulator)
+	if !ok {
+		return nil
+	}
+
+	acc.Lock()
+	defer acc.Unlock()
+
+	return append([]zap.Field(nil), acc.fields...)
+}
+
+// detachedContext is a context that carries the values of its parent context but not its cancellation and deadline.
+type detachedContext struct {
+	parent context.Context
+}
+
+func (c detachedContext) Deadline() (time.Time, bool) {
+	return time.Time{}, false
+}
+
+func (c detachedContext) Done() <-chan struct{} {
+	return nil
+}
+
+func (c detachedContext) Err() error {
+	return nil
+}
+
+func (c detachedContext) Value(key interface{}) interface{} {
+	return c.parent.Value(key)
+}
+
+// DetachedContext returns a new context that preserves all values of a context (UUID, logger, span, baggage, etc.),
+// but it is never cancelled and has no deadline.
+// This can be used for passing a request context to goroutines that outlive the request.
+func DetachedContext(ctx context.Context) context.Context {
+	return detachedContext{
+		parent: ctx,
+	}
+}
+
+// MapCarrier implements propagation.TextMapCarrier interface for a map.
+// It can be used for propagating trace context over any transport with string headers (i.e. AMQP or NATS headers).
+//
+//	headers := map[string]string{}
+//	otel.GetTextMapPropagator().Inject(ctx, observer.MapCarrier(headers))
+//	ctx = otel.GetTextMapPropagator().Extract(ctx, observer.MapCarrier(headers))
+type MapCarrier map[string]string
+
+// Make sure MapCarrier implements the propagation.TextMapCarrier interface.
+var _ propagation.TextMapCarrier = MapCarrier(nil)
+
+// Get returns the value of a key.
+func (c MapCarrier) Get(key string) string {
+	return c[key]
+}
+
+// Set sets the value of a key.
+func (c MapCarrier) Set(key, value string) {
+	c[key] = value
+}
+
+// InjectContext injects the span context and baggage of a context into a carrier using the global propagator.
+// This can be used for propagating spans across transports other than HTTP and gRPC (i.e. Kafka headers).
+func InjectContext(ctx context.Context, carrier map[string]string) {
+	otel.GetTextMapPropagator().Inject(ctx, MapCarrier(carrier))
+}
+
+// ExtractContext extracts the span context and baggage from a carrier into a new context using the global propagator.
+// The spans started with the returned context will continue the remote span.
+func ExtractContext(ctx context.Context, carrier map[string]string) context.Context {
+	return otel.GetTextMapPropagator().Extract(ctx, MapCarrier(carrier))
+}
+
+// StartSpanWithLinks starts a new span and links it to a list of other spans.
+// The span is started using the tracer of the observer in the context, or the singleton observer if the context has no observer.
+// This can be used for batch processing where a batch of messages originate from different traces.
+// The returned context holds the new span and the caller is responsible for ending the span.
+func StartSpanWithLinks(ctx context.Context, name string, links []trace.Link) (context.Context, trace.Span) {
+	return ObserverFromContext(ctx).Tracer().Start(ctx, name, trace.WithLinks(links...))
+}
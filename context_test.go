@@ -3,11 +3,62 @@ package observer
 import (
 	"context"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/baggage"
+	"go.opentelemetry.io/otel/label"
+	"go.opentelemetry.io/otel/oteltest"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
 )
 
+func TestRequestMetadata(t *testing.T) {
+	tests := []struct {
+		name string
+		ctx  context.Context
+		md   RequestMetadata
+	}{
+		{
+			name: "OK",
+			ctx:  context.Background(),
+			md: RequestMetadata{
+				UUID:       "aaaaaaaa-aaaa-aaaa-aaaa-aaaaaaaaaaaa",
+				ClientName: "test-client",
+				StartTime:  time.Now(),
+				TraceID:    trace.TraceID{0x01},
+				SpanID:     trace.SpanID{0x02},
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			_, ok := RequestMetadataFromContext(tc.ctx)
+			assert.False(t, ok)
+
+			ctx := ContextWithRequestMetadata(tc.ctx, tc.md)
+			md, ok := RequestMetadataFromContext(ctx)
+			assert.True(t, ok)
+			assert.Equal(t, tc.md, md)
+
+			uuid, ok := UUIDFromContext(ctx)
+			assert.True(t, ok)
+			assert.Equal(t, tc.md.UUID, uuid)
+
+			// Setting the uuid should preserve the rest of the metadata
+			ctx = ContextWithUUID(ctx, "bbbbbbbb-bbbb-bbbb-bbbb-bbbbbbbbbbbb")
+			md, ok = RequestMetadataFromContext(ctx)
+			assert.True(t, ok)
+			assert.Equal(t, "bbbbbbbb-bbbb-bbbb-bbbb-bbbbbbbbbbbb", md.UUID)
+			assert.Equal(t, tc.md.ClientName, md.ClientName)
+			assert.Equal(t, tc.md.TraceID, md.TraceID)
+		})
+	}
+}
+
 func TestContextWithUUID(t *testing.T) {
 	tests := []struct {
 		name      string
@@ -25,7 +76,9 @@ func TestContextWithUUID(t *testing.T) {
 		t.Run(tc.name, func(t *testing.T) {
 			ctx := ContextWithUUID(tc.ctx, tc.requestID)
 
-			assert.Equal(t, tc.requestID, ctx.Value(uuidContextKey))
+			md, ok := ctx.Value(requestMetadataContextKey).(RequestMetadata)
+			assert.True(t, ok)
+			assert.Equal(t, tc.requestID, md.UUID)
 		})
 	}
 }
@@ -45,7 +98,7 @@ func TestUUIDFromContext(t *testing.T) {
 		},
 		{
 			"WithUUID",
-			context.WithValue(context.Background(), uuidContextKey, "aaaaaaaa-aaaa-aaaa-aaaa-aaaaaaaaaaaa"),
+			context.WithValue(context.Background(), requestMetadataContextKey, RequestMetadata{UUID: "aaaaaaaa-aaaa-aaaa-aaaa-aaaaaaaaaaaa"}),
 			true,
 			"aaaaaaaa-aaaa-aaaa-aaaa-aaaaaaaaaaaa",
 		},
@@ -61,6 +114,108 @@ func TestUUIDFromContext(t *testing.T) {
 	}
 }
 
+func TestContextWithPrincipal(t *testing.T) {
+	ctx := ContextWithUUID(context.Background(), "aaaaaaaa-aaaa-aaaa-aaaa-aaaaaaaaaaaa")
+	ctx = ContextWithPrincipal(ctx, "admin")
+
+	md, ok := ctx.Value(requestMetadataContextKey).(RequestMetadata)
+	assert.True(t, ok)
+	assert.Equal(t, "aaaaaaaa-aaaa-aaaa-aaaa-aaaaaaaaaaaa", md.UUID)
+	assert.Equal(t, "admin", md.Principal)
+}
+
+func TestPrincipalFromContext(t *testing.T) {
+	tests := []struct {
+		name              string
+		ctx               context.Context
+		expectedOK        bool
+		expectedPrincipal string
+	}{
+		{
+			"WithoutPrincipal",
+			ContextWithUUID(context.Background(), "aaaaaaaa-aaaa-aaaa-aaaa-aaaaaaaaaaaa"),
+			false,
+			"",
+		},
+		{
+			"WithPrincipal",
+			context.WithValue(context.Background(), requestMetadataContextKey, RequestMetadata{Principal: "admin"}),
+			true,
+			"admin",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			principal, ok := PrincipalFromContext(tc.ctx)
+
+			assert.Equal(t, tc.expectedOK, ok)
+			assert.Equal(t, tc.expectedPrincipal, principal)
+		})
+	}
+}
+
+func TestContextWithStartTime(t *testing.T) {
+	startTime := time.Now()
+	ctx := ContextWithUUID(context.Background(), "aaaaaaaa-aaaa-aaaa-aaaa-aaaaaaaaaaaa")
+	ctx = ContextWithStartTime(ctx, startTime)
+
+	st, ok := ctx.Value(startTimeContextKey).(time.Time)
+	assert.True(t, ok)
+	assert.Equal(t, startTime, st)
+
+	// The request metadata is not changed
+	md, ok := ctx.Value(requestMetadataContextKey).(RequestMetadata)
+	assert.True(t, ok)
+	assert.Equal(t, "aaaaaaaa-aaaa-aaaa-aaaa-aaaaaaaaaaaa", md.UUID)
+	assert.True(t, md.StartTime.IsZero())
+}
+
+func TestStartTimeFromContext(t *testing.T) {
+	startTime := time.Now()
+
+	tests := []struct {
+		name              string
+		ctx               context.Context
+		expectedOK        bool
+		expectedStartTime time.Time
+	}{
+		{
+			"WithoutStartTime",
+			context.Background(),
+			false,
+			time.Time{},
+		},
+		{
+			"WithZeroStartTime",
+			context.WithValue(context.Background(), startTimeContextKey, time.Time{}),
+			false,
+			time.Time{},
+		},
+		{
+			"WithRequestMetadataStartTime",
+			context.WithValue(context.Background(), requestMetadataContextKey, RequestMetadata{StartTime: startTime}),
+			false,
+			time.Time{},
+		},
+		{
+			"WithStartTime",
+			context.WithValue(context.Background(), startTimeContextKey, startTime),
+			true,
+			startTime,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			st, ok := StartTimeFromContext(tc.ctx)
+
+			assert.Equal(t, tc.expectedOK, ok)
+			assert.Equal(t, tc.expectedStartTime, st)
+		})
+	}
+}
+
 func TestContextWithLogger(t *testing.T) {
 	tests := []struct {
 		name   string
@@ -112,3 +267,249 @@ func TestLoggerFromContext(t *testing.T) {
 		})
 	}
 }
+
+func TestObserverFromContext(t *testing.T) {
+	obsv := NewNoop()
+
+	tests := []struct {
+		name             string
+		ctx              context.Context
+		expectedObserver Observer
+	}{
+		{
+			name:             "WithoutObserver",
+			ctx:              context.Background(),
+			expectedObserver: singleton,
+		},
+		{
+			name:             "WithObserver",
+			ctx:              ContextWithObserver(context.Background(), obsv),
+			expectedObserver: obsv,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			o := ObserverFromContext(tc.ctx)
+
+			assert.Same(t, tc.expectedObserver, o)
+		})
+	}
+}
+
+func TestAddFields(t *testing.T) {
+	tests := []struct {
+		name           string
+		ctx            context.Context
+		fields         [][]zap.Field
+		expectedOK     bool
+		expectedFields []zap.Field
+	}{
+		{
+			name:           "WithoutAccumulator",
+			ctx:            context.Background(),
+			fields:         [][]zap.Field{{zap.String("principal", "admin")}},
+			expectedOK:     false,
+			expectedFields: nil,
+		},
+		{
+			name:           "Empty",
+			ctx:            ContextWithFields(context.Background()),
+			fields:         nil,
+			expectedOK:     true,
+			expectedFields: nil,
+		},
+		{
+			name: "WithAccumulator",
+			ctx:  ContextWithFields(context.Background()),
+			fields: [][]zap.Field{
+				{zap.String("principal", "admin")},
+				{zap.String("role", "owner"), zap.Bool("mfa", true)},
+			},
+			expectedOK: true,
+			expectedFields: []zap.Field{
+				zap.String("principal", "admin"),
+				zap.String("role", "owner"),
+				zap.Bool("mfa", true),
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			for _, fields := range tc.fields {
+				// The fields are added through a derived context
+				ctx := ContextWithUUID(tc.ctx, "aaaa")
+				assert.Equal(t, tc.expectedOK, AddFields(ctx, fields...))
+			}
+
+			assert.Equal(t, tc.expectedFields, FieldsFromContext(tc.ctx))
+		})
+	}
+}
+
+func TestFieldsFromContextCopy(t *testing.T) {
+	ctx := ContextWithFields(context.Background())
+	AddFields(ctx, zap.String("principal", "admin"))
+
+	fields := FieldsFromContext(ctx)
+	fields[0] = zap.String("principal", "guest")
+
+	assert.Equal(t, []zap.Field{zap.String("principal", "admin")}, FieldsFromContext(ctx))
+}
+
+func TestDetachedContext(t *testing.T) {
+	logger := zap.NewNop()
+
+	tests := []struct {
+		name   string
+		uuid   string
+		logger *zap.Logger
+	}{
+		{
+			name:   "OK",
+			uuid:   "aaaaaaaa-aaaa-aaaa-aaaa-aaaaaaaaaaaa",
+			logger: logger,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+			ctx = ContextWithUUID(ctx, tc.uuid)
+			ctx = ContextWithLogger(ctx, tc.logger)
+			ctx, span := oteltest.DefaultTracer().Start(ctx, "test")
+			defer span.End()
+
+			detached := DetachedContext(ctx)
+			cancel()
+
+			assert.Error(t, ctx.Err())
+			assert.NoError(t, detached.Err())
+			assert.Nil(t, detached.Done())
+
+			_, ok := detached.Deadline()
+			assert.False(t, ok)
+
+			uuid, ok := UUIDFromContext(detached)
+			assert.True(t, ok)
+			assert.Equal(t, tc.uuid, uuid)
+			assert.Equal(t, tc.logger, LoggerFromContext(detached))
+			assert.Equal(t, span.SpanContext(), trace.SpanContextFromContext(detached))
+		})
+	}
+}
+
+func TestInjectExtractContext(t *testing.T) {
+	// Restore the global propagator after the test
+	defer otel.SetTextMapPropagator(otel.GetTextMapPropagator())
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{},
+		propagation.Baggage{},
+	))
+
+	sr := new(oteltest.StandardSpanRecorder)
+	tracer := oteltest.NewTracerProvider(oteltest.WithSpanRecorder(sr)).Tracer("")
+
+	// Producer side
+	ctx := baggage.ContextWithValues(context.Background(), label.String("tenant", "acme"))
+	ctx, producerSpan := tracer.Start(ctx, "produce")
+	carrier := map[string]string{}
+	InjectContext(ctx, carrier)
+	producerSpan.End()
+
+	assert.NotEmpty(t, carrier["traceparent"])
+
+	// Consumer side
+	ctx = ExtractContext(context.Background(), carrier)
+	_, consumerSpan := tracer.Start(ctx, "consume")
+	consumerSpan.End()
+
+	assert.Equal(t, "acme", baggage.Value(ctx, label.Key("tenant")).AsString())
+
+	spans := sr.Completed()
+	assert.Len(t, spans, 2)
+	assert.Equal(t, spans[0].SpanContext().TraceID, spans[1].SpanContext().TraceID)
+	assert.Equal(t, spans[0].SpanContext().SpanID, spans[1].ParentSpanID())
+}
+
+func TestMapCarrier(t *testing.T) {
+	propagator := propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{},
+		propagation.Baggage{},
+	)
+
+	sr := new(oteltest.StandardSpanRecorder)
+	tracer := oteltest.NewTracerProvider(oteltest.WithSpanRecorder(sr)).Tracer("")
+
+	ctx := baggage.ContextWithValues(context.Background(), label.String("tenant", "acme"))
+	ctx, span := tracer.Start(ctx, "publish")
+	span.End()
+
+	headers := map[string]string{}
+	propagator.Inject(ctx, MapCarrier(headers))
+	assert.NotEmpty(t, headers["traceparent"])
+	assert.Equal(t, headers["traceparent"], MapCarrier(headers).Get("traceparent"))
+
+	ctx = propagator.Extract(context.Background(), MapCarrier(headers))
+	sc := trace.RemoteSpanContextFromContext(ctx)
+
+	assert.True(t, sc.IsValid())
+	assert.Equal(t, span.SpanContext().TraceID, sc.TraceID)
+	assert.Equal(t, span.SpanContext().SpanID, sc.SpanID)
+	assert.Equal(t, span.SpanContext().TraceFlags, sc.TraceFlags)
+	assert.Equal(t, "acme", baggage.Value(ctx, label.Key("tenant")).AsString())
+}
+
+func TestStartSpanWithLinks(t *testing.T) {
+	sr := new(oteltest.StandardSpanRecorder)
+	tracer := oteltest.NewTracerProvider(oteltest.WithSpanRecorder(sr)).Tracer("")
+
+	// Restore the singleton observer after the test
+	defer func(o *observer) { singleton = o }(singleton)
+	singleton = newNoop()
+	singleton.tracer = tracer
+
+	// Spans from different traces
+	_, span1 := tracer.Start(context.Background(), "message-1")
+	span1.End()
+	_, span2 := tracer.Start(context.Background(), "message-2")
+	span2.End()
+
+	links := []trace.Link{
+		{SpanContext: span1.SpanContext()},
+		{SpanContext: span2.SpanContext(), Attributes: []label.KeyValue{label.String("topic", "orders")}},
+	}
+
+	ctx, span := StartSpanWithLinks(context.Background(), "process-batch", links)
+	span.End()
+
+	assert.Equal(t, span.SpanContext(), trace.SpanContextFromContext(ctx))
+
+	spans := sr.Completed()
+	assert.Len(t, spans, 3)
+	assert.Equal(t, "process-batch", spans[2].Name())
+	assert.Equal(t, links, spans[2].Links())
+}
+
+func TestStartSpanWithLinksObserverInContext(t *testing.T) {
+	singletonRecorder := new(oteltest.StandardSpanRecorder)
+	contextRecorder := new(oteltest.StandardSpanRecorder)
+
+	// Restore the singleton observer after the test
+	defer func(o *observer) { singleton = o }(singleton)
+	singleton = newNoop()
+	singleton.tracer = oteltest.NewTracerProvider(oteltest.WithSpanRecorder(singletonRecorder)).Tracer("")
+
+	obsv := newNoop()
+	obsv.tracer = oteltest.NewTracerProvider(oteltest.WithSpanRecorder(contextRecorder)).Tracer("")
+	ctx := ContextWithObserver(context.Background(), obsv)
+
+	_, span := StartSpanWithLinks(ctx, "process-batch", nil)
+	span.End()
+
+	// The span is started by the tracer of the observer in the context
+	assert.Len(t, singletonRecorder.Completed(), 0)
+	assert.Len(t, contextRecorder.Completed(), 1)
+	assert.Equal(t, "process-batch", contextRecorder.Completed()[0].Name())
+}
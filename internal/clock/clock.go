@@ -0,0 +1,70 @@
+// Package clock provides an abstraction of the current time.
+// It is used by the observer and the interceptors, so time-dependent behavior can be tested deterministically.
+package clock
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock provides the current time.
+type Clock interface {
+	Now() time.Time
+}
+
+// Func is an adapter for using an ordinary function as a Clock.
+type Func func() time.Time
+
+// Now calls f().
+func (f Func) Now() time.Time {
+	return f()
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+// Real is the clock that reads the system time.
+var Real Clock = realClock{}
+
+// New returns a clock for a function.
+// If the function is nil, the real clock is returned.
+func New(now func() time.Time) Clock {
+	if now == nil {
+		return Real
+	}
+
+	return Func(now)
+}
+
+// Fake is a clock that only moves when it is advanced.
+// It starts at 2021-01-01 00:00:00 UTC, so tests have a deterministic time.
+type Fake struct {
+	sync.Mutex
+	now time.Time
+}
+
+// NewFake creates a new fake clock.
+func NewFake() *Fake {
+	return &Fake{
+		now: time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC),
+	}
+}
+
+// Now returns the current time of the fake clock.
+func (c *Fake) Now() time.Time {
+	c.Lock()
+	defer c.Unlock()
+
+	return c.now
+}
+
+// Advance moves the fake clock forward by a duration.
+func (c *Fake) Advance(d time.Duration) {
+	c.Lock()
+	defer c.Unlock()
+
+	c.now = c.now.Add(d)
+}
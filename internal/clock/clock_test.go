@@ -0,0 +1,63 @@
+package clock
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFunc(t *testing.T) {
+	now := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	c := Func(func() time.Time { return now })
+
+	assert.Equal(t, now, c.Now())
+}
+
+func TestReal(t *testing.T) {
+	before := time.Now()
+	now := Real.Now()
+	after := time.Now()
+
+	assert.False(t, now.Before(before))
+	assert.False(t, now.After(after))
+}
+
+func TestNew(t *testing.T) {
+	now := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name string
+		now  func() time.Time
+	}{
+		{
+			name: "Nil",
+			now:  nil,
+		},
+		{
+			name: "Func",
+			now:  func() time.Time { return now },
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			c := New(tc.now)
+
+			if tc.now == nil {
+				assert.Equal(t, Real, c)
+			} else {
+				assert.Equal(t, now, c.Now())
+			}
+		})
+	}
+}
+
+func TestFake(t *testing.T) {
+	c := NewFake()
+	start := c.Now()
+	assert.Equal(t, time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC), start)
+
+	c.Advance(250 * time.Millisecond)
+	assert.Equal(t, 250*time.Millisecond, c.Now().Sub(start))
+}
@@ -0,0 +1,46 @@
+// Package errs provides helpers for errors reported by the ohttp, ogrpc, and omq packages.
+package errs
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+
+	"github.com/hashicorp/go-multierror"
+)
+
+// Stacks returns the stack traces of an error formatted with %+v.
+// An error has a stack trace if it or an error in its chain implements a StackTrace() method (i.e. pkg/errors).
+// The innermost stack trace is returned, since it is where the error originated.
+// For a multierror, the stack traces of all its errors are returned.
+func Stacks(err error) []string {
+	var stack string
+	for e := err; e != nil; e = errors.Unwrap(e) {
+		if merr, ok := e.(*multierror.Error); ok {
+			var stacks []string
+			for _, e := range merr.Errors {
+				stacks = append(stacks, Stacks(e)...)
+			}
+			return stacks
+		}
+
+		if m := reflect.ValueOf(e).MethodByName("StackTrace"); m.IsValid() && m.Type().NumIn() == 0 && m.Type().NumOut() == 1 {
+			stack = fmt.Sprintf("%+v", m.Call(nil)[0].Interface())
+		}
+	}
+
+	if stack == "" {
+		return nil
+	}
+
+	return []string{stack}
+}
+
+// FromPanic converts a recovered panic value to an error.
+// If the value is an error, it is wrapped, so it can be inspected using errors.Is and errors.As.
+func FromPanic(msg string, r interface{}) error {
+	if err, ok := r.(error); ok {
+		return fmt.Errorf("%s: %w", msg, err)
+	}
+	return fmt.Errorf("%s: %v", msg, r)
+}
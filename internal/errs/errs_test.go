@@ -0,0 +1,122 @@
+package errs
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/hashicorp/go-multierror"
+	"github.com/stretchr/testify/assert"
+)
+
+type stackError struct {
+	msg   string
+	stack string
+	cause error
+}
+
+func (e *stackError) Error() string {
+	return e.msg
+}
+
+func (e *stackError) Unwrap() error {
+	return e.cause
+}
+
+func (e *stackError) StackTrace() string {
+	return e.stack
+}
+
+func TestStacks(t *testing.T) {
+	tests := []struct {
+		name           string
+		err            error
+		expectedStacks []string
+	}{
+		{
+			name:           "Nil",
+			err:            nil,
+			expectedStacks: nil,
+		},
+		{
+			name:           "PlainError",
+			err:            errors.New("item not found"),
+			expectedStacks: nil,
+		},
+		{
+			name:           "StackError",
+			err:            &stackError{msg: "item not found", stack: "main.getItem\n\tmain.go:10"},
+			expectedStacks: []string{"main.getItem\n\tmain.go:10"},
+		},
+		{
+			name:           "WrappedStackError",
+			err:            fmt.Errorf("failed to get item: %w", &stackError{msg: "item not found", stack: "main.getItem\n\tmain.go:10"}),
+			expectedStacks: []string{"main.getItem\n\tmain.go:10"},
+		},
+		{
+			name: "NestedStackErrors",
+			err: &stackError{
+				msg:   "failed to get item",
+				stack: "main.handler\n\tmain.go:20",
+				cause: &stackError{msg: "item not found", stack: "main.getItem\n\tmain.go:10"},
+			},
+			expectedStacks: []string{"main.getItem\n\tmain.go:10"},
+		},
+		{
+			name: "MultiError",
+			err: multierror.Append(
+				&stackError{msg: "item not found", stack: "main.getItem\n\tmain.go:10"},
+				errors.New("cache not available"),
+				&stackError{msg: "store not available", stack: "main.getStore\n\tmain.go:30"},
+			),
+			expectedStacks: []string{"main.getItem\n\tmain.go:10", "main.getStore\n\tmain.go:30"},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expectedStacks, Stacks(tc.err))
+		})
+	}
+}
+
+func TestFromPanic(t *testing.T) {
+	errNotFound := errors.New("item not found")
+
+	tests := []struct {
+		name          string
+		r             interface{}
+		expectedError string
+		expectedIs    error
+	}{
+		{
+			name:          "String",
+			r:             "something went wrong",
+			expectedError: "panic occurred: something went wrong",
+			expectedIs:    nil,
+		},
+		{
+			name:          "Error",
+			r:             errNotFound,
+			expectedError: "panic occurred: item not found",
+			expectedIs:    errNotFound,
+		},
+		{
+			name:          "Other",
+			r:             42,
+			expectedError: "panic occurred: 42",
+			expectedIs:    nil,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			err := FromPanic("panic occurred", tc.r)
+
+			assert.EqualError(t, err, tc.expectedError)
+			if tc.expectedIs != nil {
+				assert.True(t, errors.Is(err, tc.expectedIs))
+			}
+		})
+	}
+}
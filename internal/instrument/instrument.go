@@ -0,0 +1,159 @@
+// Package instrument provides helpers for creating and sharing metric instruments.
+// It is used by the ohttp, ogrpc, and omq packages, so they create instruments in the same way.
+package instrument
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"go.opentelemetry.io/otel/metric"
+	"go.uber.org/zap"
+)
+
+var noopMeter = new(metric.NoopMeterProvider).Meter("")
+
+// SafeMeter is a wrapper for metric.Meter that never panics when creating instruments.
+// If an instrument cannot be created, the error will be logged and a no-op instrument will be returned instead.
+type SafeMeter struct {
+	meter  metric.Meter
+	logger *zap.Logger
+}
+
+// NewSafeMeter creates a new safe meter.
+func NewSafeMeter(meter metric.Meter, logger *zap.Logger) *SafeMeter {
+	return &SafeMeter{
+		meter:  meter,
+		logger: logger,
+	}
+}
+
+func (m *SafeMeter) logError(name string, err error) {
+	m.logger.Error("Failed to create metric instrument.", zap.String("instrument", name), zap.Error(err))
+}
+
+// NewInt64Counter creates a new integer counter instrument.
+func (m *SafeMeter) NewInt64Counter(name string, opts ...metric.InstrumentOption) metric.Int64Counter {
+	c, err := m.meter.NewInt64Counter(name, opts...)
+	if err != nil {
+		m.logError(name, err)
+		c, _ = noopMeter.NewInt64Counter(name, opts...)
+	}
+	return c
+}
+
+// NewInt64UpDownCounter creates a new integer up-down counter instrument.
+func (m *SafeMeter) NewInt64UpDownCounter(name string, opts ...metric.InstrumentOption) metric.Int64UpDownCounter {
+	c, err := m.meter.NewInt64UpDownCounter(name, opts...)
+	if err != nil {
+		m.logError(name, err)
+		c, _ = noopMeter.NewInt64UpDownCounter(name, opts...)
+	}
+	return c
+}
+
+// NewInt64ValueRecorder creates a new integer value recorder instrument.
+func (m *SafeMeter) NewInt64ValueRecorder(name string, opts ...metric.InstrumentOption) metric.Int64ValueRecorder {
+	r, err := m.meter.NewInt64ValueRecorder(name, opts...)
+	if err != nil {
+		m.logError(name, err)
+		r, _ = noopMeter.NewInt64ValueRecorder(name, opts...)
+	}
+	return r
+}
+
+// NewFloat64ValueRecorder creates a new floating point value recorder instrument.
+func (m *SafeMeter) NewFloat64ValueRecorder(name string, opts ...metric.InstrumentOption) metric.Float64ValueRecorder {
+	r, err := m.meter.NewFloat64ValueRecorder(name, opts...)
+	if err != nil {
+		m.logError(name, err)
+		r, _ = noopMeter.NewFloat64ValueRecorder(name, opts...)
+	}
+	return r
+}
+
+// NewInt64ValueObserver creates a new integer value observer instrument.
+func (m *SafeMeter) NewInt64ValueObserver(name string, callback metric.Int64ObserverFunc, opts ...metric.InstrumentOption) metric.Int64ValueObserver {
+	o, err := m.meter.NewInt64ValueObserver(name, callback, opts...)
+	if err != nil {
+		m.logError(name, err)
+		o, _ = noopMeter.NewInt64ValueObserver(name, callback, opts...)
+	}
+	return o
+}
+
+// HighWaterMark keeps track of the number of in-flight requests and the peak number of in-flight requests since the last collection.
+type HighWaterMark struct {
+	current int64
+	peak    int64
+}
+
+// Inc increments the number of in-flight requests.
+func (h *HighWaterMark) Inc() {
+	n := atomic.AddInt64(&h.current, 1)
+	for {
+		peak := atomic.LoadInt64(&h.peak)
+		if n <= peak || atomic.CompareAndSwapInt64(&h.peak, peak, n) {
+			return
+		}
+	}
+}
+
+// Dec decrements the number of in-flight requests.
+func (h *HighWaterMark) Dec() {
+	atomic.AddInt64(&h.current, -1)
+}
+
+// Collect returns the peak number of in-flight requests since the last collection.
+// The peak is reset to the current number of in-flight requests, so the next collection reports the peak of the next interval.
+func (h *HighWaterMark) Collect() int64 {
+	return atomic.SwapInt64(&h.peak, atomic.LoadInt64(&h.current))
+}
+
+// Cache memoizes instruments per meter.
+// Creating multiple interceptors, middleware, producers, or consumers from observers with the same meter will reuse the same instruments.
+// Instruments for a meter that cannot be used as a map key are not cached.
+type Cache struct {
+	sync.Mutex
+	instruments map[metric.Meter]interface{}
+}
+
+// NewCache creates a new instruments cache.
+func NewCache() *Cache {
+	return &Cache{
+		instruments: map[metric.Meter]interface{}{},
+	}
+}
+
+// Get returns the instruments for a meter.
+// If there are no instruments for the meter yet, they are created by calling the create function.
+func (c *Cache) Get(meter metric.Meter, create func() interface{}) interface{} {
+	if !hashable(meter) {
+		return create()
+	}
+
+	c.Lock()
+	defer c.Unlock()
+
+	if instruments, ok := c.instruments[meter]; ok {
+		return instruments
+	}
+
+	instruments := create()
+	c.instruments[meter] = instruments
+
+	return instruments
+}
+
+// hashable determines whether or not a value can be used as a map key.
+// A value with a dynamic type that is not comparable (i.e. a slice or a map) panics when it is hashed.
+func hashable(v interface{}) (ok bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			ok = false
+		}
+	}()
+
+	_ = map[interface{}]struct{}{v: {}}
+
+	return true
+}
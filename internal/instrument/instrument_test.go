@@ -0,0 +1,128 @@
+package instrument
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/label"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/oteltest"
+	"go.uber.org/zap"
+)
+
+type mockMeterImpl struct {
+	NewSyncInstrumentOutError  error
+	NewAsyncInstrumentOutError error
+}
+
+func (m *mockMeterImpl) RecordBatch(ctx context.Context, labels []label.KeyValue, measurement ...metric.Measurement) {
+	// Noop
+}
+
+func (m *mockMeterImpl) NewSyncInstrument(descriptor metric.Descriptor) (metric.SyncImpl, error) {
+	if m.NewSyncInstrumentOutError != nil {
+		return nil, m.NewSyncInstrumentOutError
+	}
+	return metric.NoopSync{}, nil
+}
+
+func (m *mockMeterImpl) NewAsyncInstrument(descriptor metric.Descriptor, runner metric.AsyncRunner) (metric.AsyncImpl, error) {
+	if m.NewAsyncInstrumentOutError != nil {
+		return nil, m.NewAsyncInstrumentOutError
+	}
+	return metric.NoopAsync{}, nil
+}
+
+func TestSafeMeter(t *testing.T) {
+	tests := []struct {
+		name  string
+		meter metric.Meter
+	}{
+		{
+			name:  "NoopMeter",
+			meter: new(metric.NoopMeterProvider).Meter(""),
+		},
+		{
+			name:  "WorkingMeter",
+			meter: metric.WrapMeterImpl(&mockMeterImpl{}, ""),
+		},
+		{
+			name: "FailingMeter",
+			meter: metric.WrapMeterImpl(&mockMeterImpl{
+				NewSyncInstrumentOutError: errors.New("error on creating instrument"),
+			}, ""),
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			mm := NewSafeMeter(tc.meter, zap.NewNop())
+
+			assert.NotPanics(t, func() {
+				mm.NewInt64Counter("counter").Add(context.Background(), 1)
+				mm.NewInt64UpDownCounter("gauge").Add(context.Background(), 1)
+				mm.NewInt64ValueRecorder("histogram").Record(context.Background(), 1)
+			})
+		})
+	}
+}
+
+func TestHighWaterMark(t *testing.T) {
+	h := new(HighWaterMark)
+	assert.Equal(t, int64(0), h.Collect())
+
+	h.Inc()
+	h.Inc()
+	h.Inc()
+	h.Dec()
+	assert.Equal(t, int64(3), h.Collect())
+
+	// The peak is reset to the number of in-flight requests
+	assert.Equal(t, int64(2), h.Collect())
+
+	h.Dec()
+	h.Dec()
+	assert.Equal(t, int64(2), h.Collect())
+	assert.Equal(t, int64(0), h.Collect())
+}
+
+// unhashableMeterImpl is a metric.MeterImpl that cannot be used as a map key.
+type unhashableMeterImpl struct {
+	metric.MeterImpl
+	_ []string
+}
+
+func TestCache(t *testing.T) {
+	type instruments struct {
+		name string
+	}
+
+	_, meter1 := oteltest.NewMeter()
+	impl, meter2 := oteltest.NewMeter()
+	unhashable := metric.WrapMeterImpl(unhashableMeterImpl{MeterImpl: impl}, "test")
+	cache := NewCache()
+
+	create := func() interface{} {
+		return &instruments{name: "test"}
+	}
+
+	i1 := cache.Get(meter1, create)
+	i2 := cache.Get(meter1, create)
+	i3 := cache.Get(meter2, create)
+	assert.Same(t, i1, i2)
+	assert.NotSame(t, i1, i3)
+
+	// Instruments for an unhashable meter are created every time
+	i4 := cache.Get(unhashable, create)
+	i5 := cache.Get(unhashable, create)
+	assert.NotSame(t, i4, i5)
+}
+
+func TestHashable(t *testing.T) {
+	assert.True(t, hashable("key"))
+	assert.True(t, hashable(metric.Meter{}))
+	assert.False(t, hashable([]string{"key"}))
+	assert.False(t, hashable(unhashableMeterImpl{}))
+}
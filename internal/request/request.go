@@ -0,0 +1,82 @@
+// Package request provides helpers for the metadata of requests observed by the ohttp and ogrpc packages.
+package request
+
+import (
+	"context"
+	"regexp"
+	"unicode"
+
+	"go.opentelemetry.io/otel/baggage"
+	"go.opentelemetry.io/otel/label"
+	"go.uber.org/zap"
+)
+
+// MaxUUIDLength is the maximum length of an incoming request uuid.
+const MaxUUIDLength = 128
+
+// DefaultUUIDRegexp is the default pattern for incoming request uuids that accepts UUIDs and ULIDs.
+var DefaultUUIDRegexp = regexp.MustCompile(`^([0-9A-Fa-f]{8}-[0-9A-Fa-f]{4}-[0-9A-Fa-f]{4}-[0-9A-Fa-f]{4}-[0-9A-Fa-f]{12}|[0-9A-HJKMNP-TV-Za-hjkmnp-tv-z]{26})$`)
+
+// ValidUUID determines whether or not an incoming request uuid can be safely used.
+func ValidUUID(id string, re *regexp.Regexp) bool {
+	if id == "" || len(id) > MaxUUIDLength {
+		return false
+	}
+
+	for _, r := range id {
+		if unicode.IsControl(r) {
+			return false
+		}
+	}
+
+	return re.MatchString(id)
+}
+
+// LabelFields converts labels to log fields.
+func LabelFields(labels []label.KeyValue) []zap.Field {
+	fields := make([]zap.Field, 0, len(labels))
+	for _, kv := range labels {
+		fields = append(fields, zap.Any(string(kv.Key), kv.Value.AsInterface()))
+	}
+
+	return fields
+}
+
+// BaggageFields returns the baggage key-values on a context as log fields prefixed with baggage.
+// The req.uuid entry is skipped since it is already a field of contextual loggers.
+func BaggageFields(ctx context.Context) []zap.Field {
+	set := baggage.Set(ctx)
+	fields := make([]zap.Field, 0, set.Len())
+	for iter := set.Iter(); iter.Next(); {
+		kv := iter.Label()
+		if kv.Key == "req.uuid" {
+			continue
+		}
+		fields = append(fields, zap.String("baggage."+string(kv.Key), kv.Value.Emit()))
+	}
+
+	return fields
+}
+
+// TenantLabels returns the metric labels for the tenant of a request read from a baggage key.
+// If the tenant is not in the allow-list, it will be reported as "other".
+// No label is returned if the baggage key is empty.
+func TenantLabels(ctx context.Context, baggageKey string, allowlist []string) []label.KeyValue {
+	if baggageKey == "" {
+		return nil
+	}
+
+	tenant := "other"
+	if val := baggage.Value(ctx, label.Key(baggageKey)); val.Type() != label.INVALID {
+		for _, t := range allowlist {
+			if val.Emit() == t {
+				tenant = t
+				break
+			}
+		}
+	}
+
+	return []label.KeyValue{
+		label.String("tenant", tenant),
+	}
+}
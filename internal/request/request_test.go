@@ -0,0 +1,133 @@
+package request
+
+import (
+	"context"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/baggage"
+	"go.opentelemetry.io/otel/label"
+	"go.uber.org/zap"
+)
+
+func TestValidUUID(t *testing.T) {
+	tests := []struct {
+		name          string
+		id            string
+		re            *regexp.Regexp
+		expectedValid bool
+	}{
+		{
+			name:          "Empty",
+			id:            "",
+			re:            DefaultUUIDRegexp,
+			expectedValid: false,
+		},
+		{
+			name:          "UUID",
+			id:            "aaaaaaaa-aaaa-aaaa-aaaa-aaaaaaaaaaaa",
+			re:            DefaultUUIDRegexp,
+			expectedValid: true,
+		},
+		{
+			name:          "ULID",
+			id:            "01ARZ3NDEKTSV4RRFFQ69G5FAV",
+			re:            DefaultUUIDRegexp,
+			expectedValid: true,
+		},
+		{
+			name:          "Arbitrary",
+			id:            "<script>alert(1)</script>",
+			re:            DefaultUUIDRegexp,
+			expectedValid: false,
+		},
+		{
+			name:          "ControlCharacter",
+			id:            "req-1234\r",
+			re:            regexp.MustCompile(`.*`),
+			expectedValid: false,
+		},
+		{
+			name:          "TooLong",
+			id:            strings.Repeat("a", MaxUUIDLength+1),
+			re:            regexp.MustCompile(`.*`),
+			expectedValid: false,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expectedValid, ValidUUID(tc.id, tc.re))
+		})
+	}
+}
+
+func TestLabelFields(t *testing.T) {
+	fields := LabelFields([]label.KeyValue{
+		label.String("region", "ca-central-1"),
+		label.Int("tier", 2),
+	})
+
+	assert.Equal(t, []zap.Field{
+		zap.Any("region", "ca-central-1"),
+		zap.Any("tier", int64(2)),
+	}, fields)
+}
+
+func TestBaggageFields(t *testing.T) {
+	ctx := baggage.ContextWithValues(context.Background(),
+		label.String("req.uuid", "aaaaaaaa-aaaa-aaaa-aaaa-aaaaaaaaaaaa"),
+		label.String("tenant", "acme"),
+	)
+
+	assert.Equal(t, []zap.Field{
+		zap.String("baggage.tenant", "acme"),
+	}, BaggageFields(ctx))
+}
+
+func TestTenantLabels(t *testing.T) {
+	tests := []struct {
+		name           string
+		ctx            context.Context
+		baggageKey     string
+		allowlist      []string
+		expectedLabels []label.KeyValue
+	}{
+		{
+			name:           "Disabled",
+			ctx:            baggage.ContextWithValues(context.Background(), label.String("tenant", "acme")),
+			baggageKey:     "",
+			allowlist:      []string{"acme"},
+			expectedLabels: nil,
+		},
+		{
+			name:           "NoTenant",
+			ctx:            context.Background(),
+			baggageKey:     "tenant",
+			allowlist:      []string{"acme"},
+			expectedLabels: []label.KeyValue{label.String("tenant", "other")},
+		},
+		{
+			name:           "NotAllowed",
+			ctx:            baggage.ContextWithValues(context.Background(), label.String("tenant", "umbrella")),
+			baggageKey:     "tenant",
+			allowlist:      []string{"acme"},
+			expectedLabels: []label.KeyValue{label.String("tenant", "other")},
+		},
+		{
+			name:           "Allowed",
+			ctx:            baggage.ContextWithValues(context.Background(), label.String("tenant", "acme")),
+			baggageKey:     "tenant",
+			allowlist:      []string{"acme"},
+			expectedLabels: []label.KeyValue{label.String("tenant", "acme")},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expectedLabels, TenantLabels(tc.ctx, tc.baggageKey, tc.allowlist))
+		})
+	}
+}
@@ -0,0 +1,43 @@
+// Package tracing provides helpers for spans started by the ohttp and ogrpc packages.
+package tracing
+
+import (
+	"reflect"
+	"runtime"
+
+	"github.com/moorara/observer/internal/errs"
+	"go.opentelemetry.io/otel/label"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+)
+
+// CodeLocation returns span attributes describing the source code location of a function.
+func CodeLocation(fn interface{}) []label.KeyValue {
+	f := runtime.FuncForPC(reflect.ValueOf(fn).Pointer())
+	if f == nil {
+		return nil
+	}
+
+	file, line := f.FileLine(f.Entry())
+
+	return []label.KeyValue{
+		label.String("code.function", f.Name()),
+		label.String("code.filepath", file),
+		label.Int("code.lineno", line),
+	}
+}
+
+// RecordErrorStacks records the stack traces of an error as span events and returns them as a log field.
+// No event and no field is returned if the error has no stack trace.
+func RecordErrorStacks(span trace.Span, err error) []zap.Field {
+	stacks := errs.Stacks(err)
+	if len(stacks) == 0 {
+		return nil
+	}
+
+	for _, stack := range stacks {
+		span.RecordError(err, trace.WithAttributes(label.String("exception.stacktrace", stack)))
+	}
+
+	return []zap.Field{zap.Strings("error.stacks", stacks)}
+}
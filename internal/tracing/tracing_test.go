@@ -0,0 +1,70 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/label"
+	"go.opentelemetry.io/otel/oteltest"
+)
+
+type stackError struct {
+	msg   string
+	stack string
+}
+
+func (e *stackError) Error() string {
+	return e.msg
+}
+
+func (e *stackError) StackTrace() string {
+	return e.stack
+}
+
+func TestCodeLocation(t *testing.T) {
+	attrs := CodeLocation(TestCodeLocation)
+
+	assert.Len(t, attrs, 3)
+	assert.Equal(t, label.String("code.function", "github.com/moorara/observer/internal/tracing.TestCodeLocation"), attrs[0])
+	assert.Equal(t, label.Key("code.filepath"), attrs[1].Key)
+	assert.Contains(t, attrs[1].Value.AsString(), "tracing_test.go")
+	assert.Equal(t, label.Key("code.lineno"), attrs[2].Key)
+	assert.NotZero(t, attrs[2].Value.AsInt64())
+}
+
+func TestRecordErrorStacks(t *testing.T) {
+	tests := []struct {
+		name           string
+		err            error
+		expectedEvents int
+		expectedFields int
+	}{
+		{
+			name:           "PlainError",
+			err:            errors.New("item not found"),
+			expectedEvents: 0,
+			expectedFields: 0,
+		},
+		{
+			name:           "StackError",
+			err:            &stackError{msg: "item not found", stack: "main.getItem\n\tmain.go:10"},
+			expectedEvents: 1,
+			expectedFields: 1,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			sr := new(oteltest.StandardSpanRecorder)
+			_, span := oteltest.NewTracerProvider(oteltest.WithSpanRecorder(sr)).Tracer("").Start(context.Background(), "test")
+
+			fields := RecordErrorStacks(span, tc.err)
+			span.End()
+
+			assert.Len(t, fields, tc.expectedFields)
+			assert.Len(t, sr.Completed()[0].Events(), tc.expectedEvents)
+		})
+	}
+}
@@ -0,0 +1,104 @@
+package observer
+
+import (
+	"go.opentelemetry.io/otel/semconv"
+)
+
+// logSchema describes the fields of the log entries written by the observer and the ohttp, ogrpc, and omq packages.
+var logSchema = map[string]string{
+	// Entry
+	"timestamp":  "The time of the log entry",
+	"level":      "The level of the log entry (debug, info, warn, error, dpanic, panic, or fatal)",
+	"message":    "The message of the log entry",
+	"caller":     "The source code location that wrote the log entry",
+	"stacktrace": "The stack trace of the log entry (error level and above)",
+	"error":      "The error of the log entry",
+
+	// Metadata
+	"service":     "The name of the service",
+	"logger":      "The name of the logger (the name of the service by default)",
+	"version":     "The version of the service",
+	"environment": "The environment the service is running in",
+	"region":      "The region the service is running in",
+
+	// Kubernetes
+	string(semconv.K8SPodNameKey):       "The name of the Kubernetes pod",
+	string(semconv.K8SNamespaceNameKey): "The namespace of the Kubernetes pod",
+	string(semconv.K8SPodUIDKey):        "The uid of the Kubernetes pod",
+	"k8s.node.name":                     "The name of the Kubernetes node",
+	string(semconv.K8SContainerNameKey): "The name of the Kubernetes container",
+
+	// Requests
+	"req.uuid":            "The uuid of the request propagated across services",
+	"req.kind":            "The kind of the request (server, client, consumer, or producer)",
+	"req.method":          "The method of the request (http method or grpc method)",
+	"req.url":             "The url path of the http request",
+	"req.route":           "The route of the http request (url path with ids replaced or the matched route pattern)",
+	"req.package":         "The package of the grpc request",
+	"req.service":         "The service of the grpc request",
+	"req.stream":          "Whether or not the grpc request is a stream",
+	"req.type":            "The proto message type of the grpc request",
+	"req.queue_wait":      "The time the request waited before being handled in milliseconds",
+	"req.body_read_ms":    "The time spent reading the http request body in milliseconds",
+	"req.body":            "The captured body of the http request with an error response",
+	"req.body_size":       "The size of the body of the http request with an error response in bytes",
+	"req.rate_limited":    "Whether or not the http request was rejected by the rate limit",
+	"req.attempts":        "The number of attempts of the http request when retried",
+	"principal":           "The authenticated principal of the request (audit entries)",
+	"client.name":         "The name of the service that sent the request",
+	"client.request_uuid": "The uuid of the request claimed by the client when it is ignored",
+	"url.truncated":       "Whether or not the url path of the http request was truncated",
+	"baggage.*":           "The baggage key-values of the request",
+
+	// Responses
+	"resp.success":     "Whether or not the request succeeded",
+	"resp.duration":    "The duration of the request in milliseconds",
+	"resp.statusCode":  "The status code of the http response",
+	"resp.statusClass": "The status class of the http response (i.e. 2xx)",
+	"resp.body":        "The captured body of the http error response",
+	"resp.body_size":   "The size of the body of the http error response in bytes",
+	"resp.type":        "The proto message type of the grpc response",
+
+	// Errors
+	"grpc.error":   "The error of the grpc request",
+	"mq.error":     "The error of processing or producing the message",
+	"error.stacks": "The stack traces of the error of the request",
+	"panic.type":   "The type of the value recovered from a panic (i.e. string or *errors.errorString)",
+	"suppressed":   "The number of identical error logs suppressed since the last one was written",
+
+	// Protocols
+	"grpc.encoding":   "The compression encoding of the grpc request",
+	"conn.hijacked":   "Whether or not the http connection was hijacked (i.e. websockets)",
+	"conn.duration":   "The duration of the hijacked http connection in milliseconds",
+	"stream.active":   "The time the grpc stream was active (not waiting for the client) in milliseconds",
+	"stream.sent":     "The number of messages sent on the grpc stream",
+	"stream.received": "The number of messages received on the grpc stream",
+	"stream.bytes":    "The total size of the messages sent and received on the grpc stream in bytes",
+	"msg.topic":       "The topic of the message",
+	"producer.name":   "The name of the service that produced the message",
+
+	// Tracing
+	"traceId": "The id of the trace of the request",
+	"spanId":  "The id of the span of the request",
+
+	// Audit
+	"audit": "Whether or not the log entry is an audit entry",
+
+	// Observer
+	"instrument":        "The name of the metric instrument that could not be created",
+	"shutdown.duration": "The total duration of shutting down the observer in milliseconds",
+	"shutdown.*":        "The duration in milliseconds (.duration) and the error (.error) of flushing each exporter on shutdown (i.e. shutdown.jaeger.duration)",
+}
+
+// LogSchema returns the keys of the log fields written by the observer and the ohttp, ogrpc, and omq packages with their descriptions.
+// It can be exposed on a debug endpoint or used for generating the configurations of log parsers.
+// Fields whose keys are only known at runtime (i.e. baggage key-values) are described by a key ending with a wildcard (baggage.*).
+// The tags and custom attributes set by the application are not included.
+func LogSchema() map[string]string {
+	schema := make(map[string]string, len(logSchema))
+	for k, v := range logSchema {
+		schema[k] = v
+	}
+
+	return schema
+}
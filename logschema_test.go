@@ -0,0 +1,36 @@
+package observer
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLogSchema(t *testing.T) {
+	schema := LogSchema()
+
+	for _, key := range []string{
+		"timestamp", "level", "message", "caller",
+		"service", "logger", "version", "environment", "region",
+		"req.uuid", "req.kind", "req.method", "req.url", "req.route",
+		"resp.success", "resp.duration", "resp.statusCode",
+		"traceId", "spanId",
+	} {
+		assert.Contains(t, schema, key)
+		assert.NotEmpty(t, schema[key])
+	}
+
+	// The initial fields of the logger are included
+	c := configs{name: "my-service", version: "0.1.0", environment: "production", region: "ca-central-1"}
+	for _, f := range initialFields(c) {
+		assert.Contains(t, schema, f.Key)
+	}
+
+	for _, v := range kubernetesEnvVars {
+		assert.Contains(t, schema, string(v.key))
+	}
+
+	// The schema is a copy
+	schema["req.uuid"] = ""
+	assert.NotEmpty(t, LogSchema()["req.uuid"])
+}
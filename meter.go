@@ -0,0 +1,243 @@
+package observer
+
+import (
+	"context"
+	"sync"
+
+	"go.opentelemetry.io/otel/label"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/metric/number"
+)
+
+// teeMeterImpl is a metric.MeterImpl that reports every measurement to multiple meter implementations.
+// It is used for reporting the same metrics to multiple backends (i.e. Prometheus and OpenTelemetry Collector).
+type teeMeterImpl struct {
+	impls []metric.MeterImpl
+
+	sync.Mutex
+	batches map[metric.AsyncBatchRunner]*teeBatch
+}
+
+func newTeeMeterImpl(impls ...metric.MeterImpl) *teeMeterImpl {
+	return &teeMeterImpl{
+		impls:   impls,
+		batches: map[metric.AsyncBatchRunner]*teeBatch{},
+	}
+}
+
+func (m *teeMeterImpl) RecordBatch(ctx context.Context, labels []label.KeyValue, measurements ...metric.Measurement) {
+	// Measurements cannot be created for the underlying instruments, so they are recorded one by one
+	for _, measurement := range measurements {
+		if s, ok := measurement.SyncImpl().(*teeSyncImpl); ok {
+			s.RecordOne(ctx, measurement.Number(), labels)
+		}
+	}
+}
+
+func (m *teeMeterImpl) NewSyncInstrument(descriptor metric.Descriptor) (metric.SyncImpl, error) {
+	s := &teeSyncImpl{
+		descriptor: descriptor,
+		impls:      make([]metric.SyncImpl, len(m.impls)),
+	}
+
+	for i, impl := range m.impls {
+		inst, err := impl.NewSyncInstrument(descriptor)
+		if err != nil {
+			return nil, err
+		}
+		s.impls[i] = inst
+	}
+
+	return s, nil
+}
+
+func (m *teeMeterImpl) NewAsyncInstrument(descriptor metric.Descriptor, runner metric.AsyncRunner) (metric.AsyncImpl, error) {
+	a := &teeAsyncImpl{
+		descriptor: descriptor,
+	}
+
+	// A single runner is called with the underlying instrument, so it can be shared by all meter implementations
+	if _, ok := runner.(metric.AsyncSingleRunner); ok {
+		for _, impl := range m.impls {
+			if _, err := impl.NewAsyncInstrument(descriptor, runner); err != nil {
+				return nil, err
+			}
+		}
+		return a, nil
+	}
+
+	// A batch runner creates observations for the tee instrument, so they are translated for every meter implementation
+	if r, ok := runner.(metric.AsyncBatchRunner); ok {
+		if err := m.batch(r, descriptor).add(a); err != nil {
+			return nil, err
+		}
+	}
+
+	return a, nil
+}
+
+// batch returns the batch observers of all meter implementations for a batch runner.
+func (m *teeMeterImpl) batch(runner metric.AsyncBatchRunner, descriptor metric.Descriptor) *teeBatch {
+	m.Lock()
+	defer m.Unlock()
+
+	if b, ok := m.batches[runner]; ok {
+		return b
+	}
+
+	b := &teeBatch{
+		observers:    make([]metric.BatchObserver, len(m.impls)),
+		observations: make([]map[metric.AsyncImpl]func(number.Number) metric.Observation, len(m.impls)),
+	}
+
+	for i, impl := range m.impls {
+		i := i
+		b.observations[i] = map[metric.AsyncImpl]func(number.Number) metric.Observation{}
+		meter := metric.WrapMeterImpl(impl, descriptor.InstrumentationName(), metric.WithInstrumentationVersion(descriptor.InstrumentationVersion()))
+		b.observers[i] = meter.NewBatchObserver(func(ctx context.Context, result metric.BatchObserverResult) {
+			runner.Run(ctx, func(labels []label.KeyValue, obs ...metric.Observation) {
+				result.Observe(labels, b.translate(i, obs)...)
+			})
+		})
+	}
+
+	m.batches[runner] = b
+
+	return b
+}
+
+// teeBatch translates the observations of a batch runner for the batch observers of all meter implementations.
+type teeBatch struct {
+	sync.RWMutex
+	observers    []metric.BatchObserver
+	observations []map[metric.AsyncImpl]func(number.Number) metric.Observation
+}
+
+// add creates an instrument for a tee instrument in every batch observer.
+func (b *teeBatch) add(a *teeAsyncImpl) error {
+	b.Lock()
+	defer b.Unlock()
+
+	for i, observer := range b.observers {
+		observation, err := newObservationFunc(observer, a.descriptor)
+		if err != nil {
+			return err
+		}
+		b.observations[i][a] = observation
+	}
+
+	return nil
+}
+
+func (b *teeBatch) translate(i int, obs []metric.Observation) []metric.Observation {
+	b.RLock()
+	defer b.RUnlock()
+
+	translated := make([]metric.Observation, 0, len(obs))
+	for _, ob := range obs {
+		if observation, ok := b.observations[i][ob.AsyncImpl()]; ok {
+			translated = append(translated, observation(ob.Number()))
+		}
+	}
+
+	return translated
+}
+
+// newObservationFunc creates an instrument in a batch observer and returns a function for creating observations of it.
+func newObservationFunc(observer metric.BatchObserver, descriptor metric.Descriptor) (func(number.Number) metric.Observation, error) {
+	name := descriptor.Name()
+	opts := []metric.InstrumentOption{
+		metric.WithDescription(descriptor.Description()),
+		metric.WithUnit(descriptor.Unit()),
+	}
+
+	isFloat := descriptor.NumberKind() == number.Float64Kind
+
+	switch descriptor.InstrumentKind() {
+	case metric.ValueObserverInstrumentKind:
+		if isFloat {
+			inst, err := observer.NewFloat64ValueObserver(name, opts...)
+			return func(n number.Number) metric.Observation { return inst.Observation(n.AsFloat64()) }, err
+		}
+		inst, err := observer.NewInt64ValueObserver(name, opts...)
+		return func(n number.Number) metric.Observation { return inst.Observation(n.AsInt64()) }, err
+
+	case metric.SumObserverInstrumentKind:
+		if isFloat {
+			inst, err := observer.NewFloat64SumObserver(name, opts...)
+			return func(n number.Number) metric.Observation { return inst.Observation(n.AsFloat64()) }, err
+		}
+		inst, err := observer.NewInt64SumObserver(name, opts...)
+		return func(n number.Number) metric.Observation { return inst.Observation(n.AsInt64()) }, err
+
+	default:
+		if isFloat {
+			inst, err := observer.NewFloat64UpDownSumObserver(name, opts...)
+			return func(n number.Number) metric.Observation { return inst.Observation(n.AsFloat64()) }, err
+		}
+		inst, err := observer.NewInt64UpDownSumObserver(name, opts...)
+		return func(n number.Number) metric.Observation { return inst.Observation(n.AsInt64()) }, err
+	}
+}
+
+// teeSyncImpl is a metric.SyncImpl that records every measurement in multiple instruments.
+type teeSyncImpl struct {
+	descriptor metric.Descriptor
+	impls      []metric.SyncImpl
+}
+
+func (s *teeSyncImpl) Implementation() interface{} {
+	return s
+}
+
+func (s *teeSyncImpl) Descriptor() metric.Descriptor {
+	return s.descriptor
+}
+
+func (s *teeSyncImpl) Bind(labels []label.KeyValue) metric.BoundSyncImpl {
+	b := &teeBoundSyncImpl{
+		impls: make([]metric.BoundSyncImpl, len(s.impls)),
+	}
+
+	for i, impl := range s.impls {
+		b.impls[i] = impl.Bind(labels)
+	}
+
+	return b
+}
+
+func (s *teeSyncImpl) RecordOne(ctx context.Context, number number.Number, labels []label.KeyValue) {
+	for _, impl := range s.impls {
+		impl.RecordOne(ctx, number, labels)
+	}
+}
+
+// teeBoundSyncImpl is a metric.BoundSyncImpl that records every measurement in multiple bound instruments.
+type teeBoundSyncImpl struct {
+	impls []metric.BoundSyncImpl
+}
+
+func (b *teeBoundSyncImpl) RecordOne(ctx context.Context, number number.Number) {
+	for _, impl := range b.impls {
+		impl.RecordOne(ctx, number)
+	}
+}
+
+func (b *teeBoundSyncImpl) Unbind() {
+	for _, impl := range b.impls {
+		impl.Unbind()
+	}
+}
+
+// teeAsyncImpl is a metric.AsyncImpl whose observations are reported to multiple meter implementations.
+type teeAsyncImpl struct {
+	descriptor metric.Descriptor
+}
+
+func (a *teeAsyncImpl) Implementation() interface{} {
+	return a
+}
+
+func (a *teeAsyncImpl) Descriptor() metric.Descriptor {
+	return a.descriptor
+}
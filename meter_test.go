@@ -0,0 +1,111 @@
+package observer
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/label"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/oteltest"
+)
+
+func TestTeeMeterImplSync(t *testing.T) {
+	impl1, meter1 := oteltest.NewMeter()
+	impl2, meter2 := oteltest.NewMeter()
+	meter := metric.WrapMeterImpl(newTeeMeterImpl(meter1.MeterImpl(), meter2.MeterImpl()), "test")
+
+	counter, err := meter.NewInt64Counter("requests_total")
+	assert.NoError(t, err)
+
+	recorder, err := meter.NewFloat64ValueRecorder("requests_duration")
+	assert.NoError(t, err)
+
+	ctx := context.Background()
+	counter.Add(ctx, 1, label.String("method", "GET"))
+	counter.Bind(label.String("method", "PUT")).Add(ctx, 2)
+	meter.RecordBatch(ctx, []label.KeyValue{label.String("method", "POST")},
+		counter.Measurement(3),
+		recorder.Measurement(0.5),
+	)
+
+	for _, impl := range []*oteltest.MeterImpl{impl1, impl2} {
+		measurements := oteltest.AsStructs(impl.MeasurementBatches)
+		assert.Len(t, measurements, 4)
+
+		assert.Equal(t, "requests_total", measurements[0].Name)
+		assert.Equal(t, int64(1), measurements[0].Number.AsInt64())
+		assert.Equal(t, "GET", measurements[0].Labels["method"].AsString())
+
+		assert.Equal(t, "requests_total", measurements[1].Name)
+		assert.Equal(t, int64(2), measurements[1].Number.AsInt64())
+		assert.Equal(t, "PUT", measurements[1].Labels["method"].AsString())
+
+		assert.Equal(t, "requests_total", measurements[2].Name)
+		assert.Equal(t, int64(3), measurements[2].Number.AsInt64())
+		assert.Equal(t, "POST", measurements[2].Labels["method"].AsString())
+
+		assert.Equal(t, "requests_duration", measurements[3].Name)
+		assert.Equal(t, 0.5, measurements[3].Number.AsFloat64())
+		assert.Equal(t, "POST", measurements[3].Labels["method"].AsString())
+	}
+}
+
+func TestTeeMeterImplAsync(t *testing.T) {
+	impl1, meter1 := oteltest.NewMeter()
+	impl2, meter2 := oteltest.NewMeter()
+	meter := metric.WrapMeterImpl(newTeeMeterImpl(meter1.MeterImpl(), meter2.MeterImpl()), "test")
+
+	_, err := meter.NewInt64ValueObserver("build_info", func(_ context.Context, result metric.Int64ObserverResult) {
+		result.Observe(1, label.String("version", "0.1.0"))
+	})
+	assert.NoError(t, err)
+
+	var goroutines metric.Int64UpDownSumObserver
+	var gcTotal metric.Int64SumObserver
+	var heapRatio metric.Float64ValueObserver
+
+	batch := meter.NewBatchObserver(func(_ context.Context, result metric.BatchObserverResult) {
+		result.Observe(nil,
+			goroutines.Observation(10),
+			gcTotal.Observation(2),
+			heapRatio.Observation(0.25),
+		)
+	})
+
+	goroutines, err = batch.NewInt64UpDownSumObserver("runtime_go_goroutines")
+	assert.NoError(t, err)
+
+	gcTotal, err = batch.NewInt64SumObserver("runtime_go_gc_total")
+	assert.NoError(t, err)
+
+	heapRatio, err = batch.NewFloat64ValueObserver("runtime_go_heap_ratio")
+	assert.NoError(t, err)
+
+	for _, impl := range []*oteltest.MeterImpl{impl1, impl2} {
+		impl.RunAsyncInstruments()
+
+		// Observations should be reported for the instruments of the underlying meter
+		for _, b := range impl.MeasurementBatches {
+			for _, m := range b.Measurements {
+				assert.IsType(t, &oteltest.Async{}, m.Instrument)
+			}
+		}
+
+		values := map[string]float64{}
+		for _, m := range oteltest.AsStructs(impl.MeasurementBatches) {
+			if m.Name == "runtime_go_heap_ratio" {
+				values[m.Name] = m.Number.AsFloat64()
+			} else {
+				values[m.Name] = float64(m.Number.AsInt64())
+			}
+		}
+
+		assert.Equal(t, map[string]float64{
+			"build_info":            1,
+			"runtime_go_goroutines": 10,
+			"runtime_go_gc_total":   2,
+			"runtime_go_heap_ratio": 0.25,
+		}, values)
+	}
+}
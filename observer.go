@@ -7,31 +7,46 @@ package observer
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"net/http"
 	"os"
+	"regexp"
+	"runtime"
+	"sort"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
+	"unicode/utf8"
 
 	"github.com/hashicorp/go-multierror"
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	dto "github.com/prometheus/client_model/go"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/status"
 
+	"go.opentelemetry.io/contrib/propagators/b3"
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/exporters/otlp"
 	"go.opentelemetry.io/otel/exporters/otlp/otlpgrpc"
 	"go.opentelemetry.io/otel/label"
 	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/metric/registry"
 	"go.opentelemetry.io/otel/propagation"
 	"go.opentelemetry.io/otel/sdk/metric/selector/simple"
 	"go.opentelemetry.io/otel/sdk/resource"
 	"go.opentelemetry.io/otel/semconv"
 	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/unit"
 
 	promexporter "go.opentelemetry.io/otel/exporters/metric/prometheus"
 	jaegerexporter "go.opentelemetry.io/otel/exporters/trace/jaeger"
+	export "go.opentelemetry.io/otel/sdk/export/metric"
 	controller "go.opentelemetry.io/otel/sdk/metric/controller/basic"
 	processor "go.opentelemetry.io/otel/sdk/metric/processor/basic"
 	tracesdk "go.opentelemetry.io/otel/sdk/trace"
@@ -46,13 +61,64 @@ type configs struct {
 	environment string
 	region      string
 	tags        map[string]string
+	processTags map[string]string
+	color       string
+	kubernetes  bool
+	buildCommit string
+	buildDate   string
 
 	// Logger
 	loggerEnabled bool
 	loggerLevel   string
+	loggerName    string
+	loggerTime    string
+	loggerStack   *zapcore.Level
+	loggerSampled bool
+	loggerHooks   []func(zapcore.Entry) error
+	loggerMetrics bool
+	loggerErrOut  []string
+	logScrubbers  []*regexp.Regexp
+	logMaxMessage int
+	logMaxField   int
+	auditOutput   []string
+
+	// Syslog
+	syslogEnabled bool
+	syslogNetwork string
+	syslogAddr    string
+	syslogTag     string
+
+	// Sentry
+	sentryDSN       string
+	sentryTransport http.RoundTripper
 
 	// Prometheus
-	prometheusEnabled bool
+	prometheusEnabled      bool
+	prometheusOpenMetrics  bool
+	prometheusConstLabels  map[string]string
+	prometheusVersionLabel bool
+
+	// StatsD
+	statsdEnabled bool
+	statsdAddr    string
+	statsdPrefix  string
+
+	// Meter
+	meterAggregation string
+	runtimeMetrics   bool
+
+	// Providers
+	meterProvider  metric.MeterProvider
+	tracerProvider trace.TracerProvider
+
+	// Propagation
+	b3Enabled      bool
+	b3SingleHeader bool
+
+	// Sampling
+	adaptiveSampling   bool
+	samplingBaseRatio  float64
+	samplingErrorBoost float64
 
 	// Jaeger
 	jaegerEnabled           bool
@@ -65,6 +131,9 @@ type configs struct {
 	opentelemetryEnabled              bool
 	opentelemetryCollectorAddress     string
 	opentelemetryCollectorCredentials credentials.TransportCredentials
+	opentelemetryTracesOnly           bool
+	opentelemetryMetricsOnly          bool
+	opentelemetryReconnectionPeriod   time.Duration
 }
 
 func configsFromEnv() configs {
@@ -120,6 +189,10 @@ func configsFromEnv() configs {
 
 	c.opentelemetryCollectorAddress = os.Getenv("OBSERVER_OPENTELEMETRY_COLLECTOR_ADDRESS")
 
+	if val := os.Getenv("OBSERVER_OPENTELEMETRY_RECONNECTION_PERIOD"); val != "" {
+		c.opentelemetryReconnectionPeriod, _ = time.ParseDuration(val)
+	}
+
 	// Defaults
 	if c.opentelemetryCollectorAddress == "" {
 		c.opentelemetryCollectorAddress = "localhost:55680"
@@ -143,6 +216,51 @@ func WithMetadata(name, version, environment, region string, tags map[string]str
 	}
 }
 
+// WithProcessTags is the option for specifying additional tags for describing the process (i.e. build hash).
+// These tags are reported as Jaeger process tags and OpenTelemetry resource attributes.
+// The host name and process id are always reported by default.
+func WithProcessTags(tags map[string]string) Option {
+	return func(c *configs) {
+		c.processTags = tags
+	}
+}
+
+// WithDeploymentColor is the option for specifying the color of the deployment (i.e. blue, green, or canary).
+// The color is set as the deployment.color attribute on every span started by the observer tracer,
+// so traces can be filtered by the deployment color.
+// If not set, the color tag (OBSERVER_TAG_COLOR) is used.
+func WithDeploymentColor(color string) Option {
+	return func(c *configs) {
+		c.color = color
+	}
+}
+
+// WithKubernetesMetadata is the option for reporting the metadata of Kubernetes pods.
+// The metadata are read from the following environment variables, which are usually set using the downward API:
+//
+//	POD_NAME:       k8s.pod.name
+//	POD_NAMESPACE:  k8s.namespace.name
+//	POD_UID:        k8s.pod.uid
+//	NODE_NAME:      k8s.node.name
+//	CONTAINER_NAME: k8s.container.name
+//
+// They are reported as initial log fields, Jaeger process tags, and OpenTelemetry resource attributes.
+// The environment variables that are not set are ignored.
+func WithKubernetesMetadata() Option {
+	return func(c *configs) {
+		c.kubernetes = true
+	}
+}
+
+// WithBuildInfo is the option for specifying the commit and date of the build.
+// They are reported as labels of the build_info metric.
+func WithBuildInfo(commit, date string) Option {
+	return func(c *configs) {
+		c.buildCommit = commit
+		c.buildDate = date
+	}
+}
+
 // WithLogger is the option for configuring the logger.
 // The default log level is info.
 func WithLogger(level string) Option {
@@ -156,6 +274,137 @@ func WithLogger(level string) Option {
 	}
 }
 
+// WithLoggerName is the option for naming the logger separately from the service (i.e. per component).
+// The name is reported in the logger field of log entries, and the service name is still reported in the service field.
+// By default, the logger is named after the service.
+func WithLoggerName(name string) Option {
+	return func(c *configs) {
+		c.loggerName = name
+	}
+}
+
+// WithLoggerTimeFormat is the option for choosing how the timestamps of log entries are encoded.
+// The supported formats are:
+//
+//	rfc3339nano:  RFC3339 with nanosecond precision (i.e. 2020-12-31T23:59:59.123456789Z).
+//	rfc3339:      RFC3339 with second precision (i.e. 2020-12-31T23:59:59Z).
+//	iso8601:      ISO8601 with millisecond precision (i.e. 2020-12-31T23:59:59.123Z).
+//	epoch:        floating-point number of seconds since the Unix epoch (i.e. 1609459199.123457).
+//	epochmillis:  floating-point number of milliseconds since the Unix epoch (i.e. 1609459199123.4568).
+//
+// The default format is rfc3339nano.
+func WithLoggerTimeFormat(format string) Option {
+	return func(c *configs) {
+		c.loggerTime = format
+	}
+}
+
+// WithLoggerStackTraceLevel is the option for adding stack traces to log entries at or above a given level.
+// The stack trace is reported in the stacktrace field of log entries.
+// By default, no stack traces are added to log entries.
+func WithLoggerStackTraceLevel(level zapcore.Level) Option {
+	return func(c *configs) {
+		c.loggerStack = &level
+	}
+}
+
+// WithLoggerDebugForSampledTraces is the option for logging at debug level for the requests whose traces are sampled.
+// The contextual loggers of the requests with sampled spans write debug entries regardless of the logging level,
+// and the contextual loggers of other requests use the logging level.
+// This ties the verbosity of logs to the trace sampler, so detailed logs are available exactly for the traced requests.
+func WithLoggerDebugForSampledTraces() Option {
+	return func(c *configs) {
+		c.loggerSampled = true
+	}
+}
+
+// WithLoggerHooks is the option for registering hooks that are called every time the logger writes an entry.
+// Hooks are only called for the entries that are enabled by the current logging level.
+func WithLoggerHooks(hooks ...func(zapcore.Entry) error) Option {
+	return func(c *configs) {
+		c.loggerHooks = append(c.loggerHooks, hooks...)
+	}
+}
+
+// WithLoggerErrorOutput is the option for specifying where the internal errors of the logger are written.
+// The paths can be file paths or stdout and stderr. The default is stdout.
+func WithLoggerErrorOutput(paths ...string) Option {
+	return func(c *configs) {
+		c.loggerErrOut = paths
+	}
+}
+
+// WithAuditOutput is the option for specifying where the audit entries (see Audit) are written.
+// The paths can be file paths or stdout and stderr. The default is stdout.
+// The audit entries are written even if the logger is not enabled when an audit output is specified.
+func WithAuditOutput(paths ...string) Option {
+	return func(c *configs) {
+		c.auditOutput = paths
+	}
+}
+
+// WithLogScrubbers is the option for scrubbing sensitive data (i.e. emails, credit card numbers, etc.) from logs.
+// Every match of the patterns in log messages and string field values is replaced with [SCRUBBED].
+// Scrubbing runs every pattern against every message and string field, so it adds a noticeable cost to logging.
+// Use it only when sensitive data may leak into free-text log messages and prefer simple patterns.
+func WithLogScrubbers(patterns ...*regexp.Regexp) Option {
+	return func(c *configs) {
+		c.logScrubbers = append(c.logScrubbers, patterns...)
+	}
+}
+
+// WithMaxMessageLength is the option for truncating log messages longer than n bytes.
+// Truncated messages are suffixed with …(truncated), so extremely long messages (i.e. a dumped struct) do not blow up the log storage.
+// By default, log messages are not truncated.
+func WithMaxMessageLength(n int) Option {
+	return func(c *configs) {
+		c.logMaxMessage = n
+	}
+}
+
+// WithMaxFieldLength is the option for truncating the values of string fields longer than n bytes.
+// Truncated values are suffixed with …(truncated) in the same way as log messages.
+// By default, string fields are not truncated.
+func WithMaxFieldLength(n int) Option {
+	return func(c *configs) {
+		c.logMaxField = n
+	}
+}
+
+// WithSyslog is the option for writing logs to a syslog server instead of stdout.
+// network and addr specify the syslog server (i.e. udp and localhost:514). If network is empty, the local syslog server is used.
+// The tag is added to every syslog message and defaults to the name of the program.
+// The severity of syslog messages is determined by the level of log entries (debug, info, warning, err, crit, and emerg).
+// If the connection to the syslog server fails, logs are written to stdout and a warning is logged.
+// Syslog is only supported on Unix systems.
+func WithSyslog(network, addr, tag string) Option {
+	return func(c *configs) {
+		c.syslogEnabled = true
+		c.syslogNetwork = network
+		c.syslogAddr = addr
+		c.syslogTag = tag
+	}
+}
+
+// WithSentry is the option for reporting error logs to Sentry.
+// Every log entry at error level and above (including recovered panics) is reported to Sentry with its stack trace.
+// Events are tagged with the trace id of the request (if any) and the metadata of the service.
+// Events are sent asynchronously and pending events are flushed when the observer is shut down.
+// If the DSN is empty, no error is reported to Sentry.
+func WithSentry(dsn string) Option {
+	return func(c *configs) {
+		c.sentryDSN = dsn
+	}
+}
+
+// WithLogLevelMetrics is the option for reporting the number of log entries per level as a metric (log_entries_total).
+// This can be used for alerting on the rate of error logs.
+func WithLogLevelMetrics() Option {
+	return func(c *configs) {
+		c.loggerMetrics = true
+	}
+}
+
 // WithPrometheus is the option for reporting metrics for Prometheus.
 func WithPrometheus() Option {
 	return func(c *configs) {
@@ -163,6 +412,116 @@ func WithPrometheus() Option {
 	}
 }
 
+// WithPrometheusOpenMetrics is the option for serving Prometheus metrics in OpenMetrics format.
+// The OpenMetrics format is only served if it is requested by the scraper through the Accept header.
+func WithPrometheusOpenMetrics() Option {
+	return func(c *configs) {
+		c.prometheusOpenMetrics = true
+	}
+}
+
+// WithPrometheusConstLabels is the option for adding constant labels to all Prometheus metrics.
+// The labels are set at the registry level, so every exported metric carries them.
+func WithPrometheusConstLabels(labels map[string]string) Option {
+	return func(c *configs) {
+		c.prometheusConstLabels = labels
+	}
+}
+
+// WithPrometheusVersionLabel is the option for adding the version of the service as a constant label (version) to Prometheus metrics.
+// The label is added to all metrics reported through the meter (including the metrics of ohttp and ogrpc),
+// but not to the Go and process metrics, since go_info has its own version label (the Go version).
+// This allows comparing the metrics of different versions running at the same time (i.e. canary deployments).
+func WithPrometheusVersionLabel() Option {
+	return func(c *configs) {
+		c.prometheusVersionLabel = true
+	}
+}
+
+// WithStatsD is the option for reporting metrics to StatsD (or DogStatsD) over UDP.
+// Value recorders are reported as timers, counters are reported as counters, and up-down counters and observers are reported as gauges.
+// The prefix is added to the names of all metrics (i.e. myservice.), and the labels are reported as DogStatsD tags.
+// Metrics are pushed every two seconds and once more when the observer is shut down.
+// If Prometheus or OpenTelemetry are also enabled, metrics are reported to all of them.
+// The default address is localhost:8125.
+func WithStatsD(addr, prefix string) Option {
+	if addr == "" {
+		addr = "localhost:8125"
+	}
+
+	return func(c *configs) {
+		c.statsdEnabled = true
+		c.statsdAddr = addr
+		c.statsdPrefix = prefix
+	}
+}
+
+// WithMeterAggregation is the option for choosing how the distribution of ValueRecorder measurements is aggregated.
+// This is only used for reporting metrics to OpenTelemetry Collector.
+// The supported kinds are:
+//
+//	exact:        keeps all measurements (most accurate, but uses the most memory).
+//	histogram:    keeps counts of measurements in buckets (a good choice for most cases).
+//	sketch:       only keeps min, max, sum, and count of measurements (uses the least memory).
+//
+// The OpenTelemetry SDK currently does not provide a DDSketch aggregator,
+// so sketch uses the constant-memory min-max-sum-count aggregator instead.
+// The default kind is exact.
+func WithMeterAggregation(kind string) Option {
+	return func(c *configs) {
+		c.meterAggregation = kind
+	}
+}
+
+// WithRuntimeMetrics is the option for reporting Go runtime metrics (goroutines, heap allocation, and garbage collections).
+// These metrics are reported through the meter of the observer, so they are also available when reporting to OpenTelemetry Collector.
+func WithRuntimeMetrics() Option {
+	return func(c *configs) {
+		c.runtimeMetrics = true
+	}
+}
+
+// WithMeterProvider is the option for using an already configured meter provider.
+// If set, Prometheus and OpenTelemetry options are not used for creating the meter.
+func WithMeterProvider(provider metric.MeterProvider) Option {
+	return func(c *configs) {
+		c.meterProvider = provider
+	}
+}
+
+// WithTracerProvider is the option for using an already configured tracer provider.
+// If set, Jaeger and OpenTelemetry options are not used for creating the tracer.
+func WithTracerProvider(provider trace.TracerProvider) Option {
+	return func(c *configs) {
+		c.tracerProvider = provider
+	}
+}
+
+// WithB3Propagation is the option for propagating trace contexts with B3 headers (Zipkin) besides W3C Trace Context.
+// Incoming B3 headers in both the single-header (b3) and the multi-header (X-B3-TraceId, X-B3-SpanId, ...) formats are extracted,
+// so the traces of upstream services that only send B3 headers are continued.
+// Outgoing B3 headers are injected in the single-header format if singleHeader is true, or the multi-header format otherwise.
+func WithB3Propagation(singleHeader bool) Option {
+	return func(c *configs) {
+		c.b3Enabled = true
+		c.b3SingleHeader = singleHeader
+	}
+}
+
+// WithAdaptiveSampling is the option for sampling more traces when errors are likely.
+// New traces are sampled with baseRatio plus errorBoost times the recent error rate (the ratio of spans with an error status),
+// so more traces are captured exactly when error rates spike. Spans started with the error attribute set to true
+// (i.e. retrying a failed operation) are sampled with baseRatio plus errorBoost even if their parent is not sampled.
+// The spans with a sampled parent are always sampled. This is only used for the tracers created by Jaeger and OpenTelemetry options.
+// By default, all traces are sampled.
+func WithAdaptiveSampling(baseRatio, errorBoost float64) Option {
+	return func(c *configs) {
+		c.adaptiveSampling = true
+		c.samplingBaseRatio = baseRatio
+		c.samplingErrorBoost = errorBoost
+	}
+}
+
 // WithJaeger is the option for reporting traces to Jaeger.
 // Only one of agentEndpoint or collectorEndpoint is required.
 // collectorUserName and collectorPassword are optional.
@@ -184,6 +543,7 @@ func WithJaeger(agentEndpoint, collectorEndpoint, collectorUserName, collectorPa
 // WithOpenTelemetry is the option for reporting metrics and traces to OpenTelemetry Collector.
 // collectorCredentials is optional. If not specified, the connection will be insecure.
 // The default collector address is localhost:55680.
+// If Prometheus is also enabled, metrics are reported to both Prometheus and OpenTelemetry Collector.
 func WithOpenTelemetry(collectorAddress string, collectorCredentials credentials.TransportCredentials) Option {
 	if collectorAddress == "" {
 		collectorAddress = "localhost:55680"
@@ -196,9 +556,36 @@ func WithOpenTelemetry(collectorAddress string, collectorCredentials credentials
 	}
 }
 
+// WithOpenTelemetryTracesOnly is the option for only reporting traces to OpenTelemetry Collector.
+// It should be used together with WithOpenTelemetry, and no metric pusher will be started.
+func WithOpenTelemetryTracesOnly() Option {
+	return func(c *configs) {
+		c.opentelemetryTracesOnly = true
+	}
+}
+
+// WithOpenTelemetryMetricsOnly is the option for only reporting metrics to OpenTelemetry Collector.
+// It should be used together with WithOpenTelemetry, and no trace provider will be created.
+func WithOpenTelemetryMetricsOnly() Option {
+	return func(c *configs) {
+		c.opentelemetryMetricsOnly = true
+	}
+}
+
+// WithOpenTelemetryReconnectionPeriod is the option for setting the delay between attempts to reconnect to OpenTelemetry Collector.
+// The exporter connects to the collector in the background, so an unavailable collector does not fail the startup.
+// Telemetry is dropped while the collector is unavailable, and the exporter keeps reconnecting until the collector comes back.
+// If not specified, the default period of the exporter (10s) is used.
+func WithOpenTelemetryReconnectionPeriod(period time.Duration) Option {
+	return func(c *configs) {
+		c.opentelemetryReconnectionPeriod = period
+	}
+}
+
 // Observer provides logging, metrics, and tracing capabilities for observability.
 type Observer interface {
 	// Shutdown flushes and closes the logger, meter, and tracer.
+	// A report with the duration and the error of flushing each of them is logged at info level.
 	Shutdown(context.Context) error
 
 	// Name is returns the name of the observer.
@@ -219,18 +606,121 @@ type Observer interface {
 	// Tracer is used for accessing the tracer.
 	Tracer() trace.Tracer
 
+	// SpanLogger returns the logger for building the contextual logger of a request with the given span.
+	// If the span is sampled and debug logs are enabled for sampled traces, the logger writes debug entries regardless of the logging level.
+	SpanLogger(sc trace.SpanContext) *zap.Logger
+
+	// WithContext returns a scope that binds the logger, meter, and tracer to a context.
+	// This can be used by background workers that have no incoming request for correlating their logs and spans.
+	WithContext(ctx context.Context) *Scope
+
 	// ServeHTTP implements http.Handler interface. It serves the metrics endpoint for Prometheus metrics.
 	ServeHTTP(w http.ResponseWriter, r *http.Request)
+
+	// Gather returns the current metric families of the Prometheus registry (i.e. for a custom metrics view or for tests).
+	// It returns an error if Prometheus is not enabled.
+	Gather() ([]*dto.MetricFamily, error)
+
+	// SetTag adds or updates a tag on the log entries written after the call.
+	// Traces and metrics keep the resource set at startup and do not get the tag.
+	SetTag(key, value string)
 }
 
+// Make sure the observer implements the Observer interface.
+var _ Observer = (*observer)(nil)
+
 type observer struct {
 	name          string
 	logger        *zap.Logger
 	loggerConfig  *zap.Config
+	sampledLogger *zap.Logger
+	auditLogger   *zap.Logger
 	meter         metric.Meter
 	promHandler   http.Handler
+	promGatherer  prometheus.Gatherer
 	tracer        trace.Tracer
+	tags          *tags
 	shutdownFuncs []shutdownFunc
+	shutdownNames []string
+}
+
+// validate reports the combinations of options that conflict with each other or have no effect.
+// The returned error describes which option takes effect for each conflict.
+func (c configs) validate() error {
+	var err error
+
+	otelTraces := c.opentelemetryEnabled && !c.opentelemetryMetricsOnly
+	otelMetrics := c.opentelemetryEnabled && !c.opentelemetryTracesOnly
+
+	if c.opentelemetryTracesOnly && c.opentelemetryMetricsOnly {
+		err = multierror.Append(err, errors.New("both traces-only and metrics-only are set for OpenTelemetry: nothing is reported to OpenTelemetry"))
+	}
+
+	if c.meterProvider != nil && (c.prometheusEnabled || otelMetrics) {
+		err = multierror.Append(err, errors.New("a meter provider is provided: Prometheus and OpenTelemetry are not used for metrics"))
+	}
+
+	if c.meterProvider != nil && c.statsdEnabled {
+		err = multierror.Append(err, errors.New("a meter provider is provided: StatsD is not used for metrics"))
+	}
+
+	if c.tracerProvider != nil && (c.jaegerEnabled || otelTraces) {
+		err = multierror.Append(err, errors.New("a tracer provider is provided: Jaeger and OpenTelemetry are not used for traces"))
+	}
+
+	if c.jaegerEnabled && otelTraces {
+		err = multierror.Append(err, errors.New("both Jaeger and OpenTelemetry provide a tracer: the OpenTelemetry tracer is used"))
+	}
+
+	if !c.loggerEnabled && (len(c.loggerHooks) > 0 || c.loggerMetrics) {
+		err = multierror.Append(err, errors.New("logger hooks and log level metrics have no effect when the logger is not enabled"))
+	}
+
+	if !c.loggerEnabled && c.sentryDSN != "" {
+		err = multierror.Append(err, errors.New("Sentry has no effect when the logger is not enabled"))
+	}
+
+	if !c.prometheusEnabled && c.prometheusOpenMetrics {
+		err = multierror.Append(err, errors.New("OpenMetrics format has no effect when Prometheus is not enabled"))
+	}
+
+	if !c.prometheusEnabled && len(c.prometheusConstLabels) > 0 {
+		err = multierror.Append(err, errors.New("constant labels have no effect when Prometheus is not enabled"))
+	}
+
+	if !c.prometheusEnabled && c.prometheusVersionLabel {
+		err = multierror.Append(err, errors.New("version label has no effect when Prometheus is not enabled"))
+	}
+
+	if c.prometheusVersionLabel && c.version == "" {
+		err = multierror.Append(err, errors.New("version label has no effect when the version is not set"))
+	}
+
+	if !otelMetrics && c.meterAggregation != "" {
+		err = multierror.Append(err, errors.New("meter aggregation has no effect when OpenTelemetry is not used for metrics"))
+	}
+
+	if c.adaptiveSampling && !c.jaegerEnabled && !otelTraces {
+		err = multierror.Append(err, errors.New("adaptive sampling has no effect when neither Jaeger nor OpenTelemetry is used for traces"))
+	}
+
+	if c.adaptiveSampling && (c.samplingBaseRatio < 0 || c.samplingBaseRatio > 1 || c.samplingErrorBoost < 0) {
+		err = multierror.Append(err, fmt.Errorf("invalid adaptive sampling ratios %g and %g: the ratios are clamped", c.samplingBaseRatio, c.samplingErrorBoost))
+	}
+
+	switch strings.ToLower(c.loggerTime) {
+	case "", "rfc3339nano", "rfc3339", "iso8601", "epoch", "epochmillis":
+	default:
+		err = multierror.Append(err, fmt.Errorf("unknown logger time format %q: the rfc3339nano format is used", c.loggerTime))
+	}
+
+	switch strings.ToLower(c.meterAggregation) {
+	case "", "exact", "histogram", "sketch":
+	default:
+		err = multierror.Append(err, fmt.Errorf("unknown meter aggregation %q: the exact aggregation is used", c.meterAggregation))
+	}
+
+	return err
 }
 
 // New creates a new observer.
@@ -242,30 +732,87 @@ func New(setAsSingleton bool, opts ...Option) Observer {
 		opt(&c)
 	}
 
+	// The options are validated before the provided providers take precedence
+	validationErr := c.validate()
+
 	o := &observer{
 		name: c.name,
+		tags: newTags(),
 	}
 
 	if c.loggerEnabled {
 		var shutdown shutdownFunc
 		o.logger, o.loggerConfig, shutdown = initLogger(c)
-		o.shutdownFuncs = append(o.shutdownFuncs, shutdown)
+		o.addShutdownFunc("logger", shutdown)
+
+		if c.sentryDSN != "" {
+			o.logger, shutdown = initSentry(c, o.logger)
+			o.addShutdownFunc("sentry", shutdown)
+		}
+	}
+
+	// The provided meter and tracer providers take precedence over the configured ones
+	if c.meterProvider != nil {
+		c.prometheusEnabled = false
+		c.statsdEnabled = false
+		c.opentelemetryTracesOnly = true
+		o.meter = c.meterProvider.Meter(c.name)
+	}
+
+	if c.tracerProvider != nil {
+		c.jaegerEnabled = false
+		c.opentelemetryMetricsOnly = true
+		o.tracer = c.tracerProvider.Tracer(c.name)
 	}
 
 	if c.prometheusEnabled {
-		o.meter, o.promHandler = initPrometheus(c)
+		o.meter, o.promHandler, o.promGatherer = initPrometheus(c)
 	}
 
 	if c.jaegerEnabled {
 		var shutdown shutdownFunc
 		o.tracer, shutdown = initJaeger(c)
-		o.shutdownFuncs = append(o.shutdownFuncs, shutdown)
+		o.addShutdownFunc("jaeger", shutdown)
 	}
 
-	if c.opentelemetryEnabled {
-		var shutdown shutdownFunc
-		o.meter, o.tracer, shutdown = initOpenTelemetry(c)
-		o.shutdownFuncs = append(o.shutdownFuncs, shutdown)
+	if c.opentelemetryEnabled && !(c.opentelemetryTracesOnly && c.opentelemetryMetricsOnly) {
+		meter, tracer, shutdown := initOpenTelemetry(c)
+		o.addShutdownFunc("opentelemetry", shutdown)
+
+		// The meter is not created if only traces are reported
+		if meter != (metric.Meter{}) {
+			if c.prometheusEnabled {
+				// Both Prometheus and OpenTelemetry Collector receive the same measurements
+				provider := registry.NewMeterProvider(newTeeMeterImpl(o.meter.MeterImpl(), meter.MeterImpl()))
+				otel.SetMeterProvider(provider)
+				o.meter = provider.Meter(c.name)
+			} else {
+				o.meter = meter
+			}
+		}
+
+		// The tracer is not created if only metrics are reported
+		if tracer != nil {
+			o.tracer = tracer
+		}
+	}
+
+	if c.statsdEnabled {
+		meter, shutdown := initStatsD(c)
+		o.addShutdownFunc("statsd", shutdown)
+
+		if o.meter != (metric.Meter{}) {
+			// StatsD receives the same measurements as Prometheus and/or OpenTelemetry Collector
+			provider := registry.NewMeterProvider(newTeeMeterImpl(o.meter.MeterImpl(), meter.MeterImpl()))
+			otel.SetMeterProvider(provider)
+			o.meter = provider.Meter(c.name)
+		} else {
+			o.meter = meter
+		}
+	}
+
+	if c.b3Enabled {
+		otel.SetTextMapPropagator(textMapPropagator(c))
 	}
 
 	// Create noop logger, meter, and/or tracer if they are not created so far
@@ -274,6 +821,22 @@ func New(setAsSingleton bool, opts ...Option) Observer {
 		o.logger = zap.NewNop()
 	}
 
+	if c.loggerEnabled || len(c.auditOutput) > 0 {
+		var shutdown shutdownFunc
+		o.auditLogger, shutdown = initAuditLogger(c)
+		o.addShutdownFunc("audit", shutdown)
+	} else {
+		o.auditLogger = zap.NewNop()
+	}
+
+	o.logger = withTags(o.logger, o.tags)
+
+	// The logger is built at debug level and the logging level is enforced on top of it, so the loggers of sampled spans can bypass it
+	if c.loggerEnabled && c.loggerSampled {
+		o.sampledLogger = o.logger
+		o.logger = o.logger.WithOptions(zap.IncreaseLevel(o.loggerConfig.Level))
+	}
+
 	if o.loggerConfig == nil {
 		o.loggerConfig = &zap.Config{}
 	}
@@ -290,6 +853,35 @@ func New(setAsSingleton bool, opts ...Option) Observer {
 		o.tracer = trace.NewNoopTracerProvider().Tracer("")
 	}
 
+	if color := c.deploymentColor(); color != "" {
+		o.tracer = withSpanAttributes(o.tracer, deploymentColorKey.String(color))
+	}
+
+	// Warn about conflicting options now that the logger is available
+	if validationErr != nil {
+		if merr, ok := validationErr.(*multierror.Error); ok {
+			for _, e := range merr.Errors {
+				o.logger.Warn("Conflicting observer options.", zap.Error(e))
+			}
+		}
+	}
+
+	// The meter is only available at this point
+	if c.loggerMetrics {
+		if o.sampledLogger != nil {
+			o.sampledLogger = initLogLevelMetrics(o.sampledLogger, o.meter)
+			o.logger = o.sampledLogger.WithOptions(zap.IncreaseLevel(o.loggerConfig.Level))
+		} else {
+			o.logger = initLogLevelMetrics(o.logger, o.meter)
+		}
+	}
+
+	if c.runtimeMetrics {
+		initRuntimeMetrics(o.meter, o.logger)
+	}
+
+	initBuildInfo(c, o.meter, o.logger)
+
 	// Assign the new observer to the singleton observer
 	if setAsSingleton {
 		singleton = o
@@ -298,48 +890,65 @@ func New(setAsSingleton bool, opts ...Option) Observer {
 	return o
 }
 
-func initLogger(c configs) (*zap.Logger, *zap.Config, shutdownFunc) {
-	config := zap.Config{
-		Level:       zap.NewAtomicLevelAt(zapcore.InfoLevel),
-		Development: false,
-		Sampling:    nil,
-		Encoding:    "json",
-		EncoderConfig: zapcore.EncoderConfig{
-			TimeKey:        "timestamp",
-			LevelKey:       "level",
-			NameKey:        "logger",
-			MessageKey:     "message",
-			CallerKey:      "caller",
-			StacktraceKey:  "stacktrace",
-			LineEnding:     zapcore.DefaultLineEnding,
-			EncodeLevel:    zapcore.LowercaseLevelEncoder,
-			EncodeTime:     zapcore.RFC3339NanoTimeEncoder,
-			EncodeCaller:   zapcore.ShortCallerEncoder,
-			EncodeDuration: zapcore.SecondsDurationEncoder,
-		},
-		OutputPaths:      []string{"stdout"},
-		ErrorOutputPaths: []string{"stdout"},
-		InitialFields:    make(map[string]interface{}),
-	}
-
-	if c.name != "" {
-		config.InitialFields["logger"] = c.name
+// deploymentColor returns the color of the deployment.
+// The color option takes precedence over the color tag.
+func (c configs) deploymentColor() string {
+	if c.color != "" {
+		return c.color
 	}
+	return c.tags["color"]
+}
 
-	if c.version != "" {
-		config.InitialFields["version"] = c.version
+// timeEncoder returns a time encoder for a format of timestamps.
+func timeEncoder(format string) zapcore.TimeEncoder {
+	switch strings.ToLower(format) {
+	case "rfc3339":
+		return zapcore.RFC3339TimeEncoder
+	case "iso8601":
+		return zapcore.ISO8601TimeEncoder
+	case "epoch":
+		return zapcore.EpochTimeEncoder
+	case "epochmillis":
+		return zapcore.EpochMillisTimeEncoder
+	case "rfc3339nano":
+		fallthrough
+	default:
+		return zapcore.RFC3339NanoTimeEncoder
 	}
+}
 
-	if c.environment != "" {
-		config.InitialFields["environment"] = c.environment
+// encoderConfig returns the configuration of the encoder for log entries.
+func encoderConfig(c configs) zapcore.EncoderConfig {
+	return zapcore.EncoderConfig{
+		TimeKey:        "timestamp",
+		LevelKey:       "level",
+		NameKey:        "logger",
+		MessageKey:     "message",
+		CallerKey:      "caller",
+		StacktraceKey:  "stacktrace",
+		LineEnding:     zapcore.DefaultLineEnding,
+		EncodeLevel:    zapcore.LowercaseLevelEncoder,
+		EncodeTime:     timeEncoder(c.loggerTime),
+		EncodeCaller:   zapcore.ShortCallerEncoder,
+		EncodeDuration: zapcore.SecondsDurationEncoder,
 	}
+}
 
-	if c.region != "" {
-		config.InitialFields["region"] = c.region
+func initLogger(c configs) (*zap.Logger, *zap.Config, shutdownFunc) {
+	config := zap.Config{
+		Level:            zap.NewAtomicLevelAt(zapcore.InfoLevel),
+		Development:      false,
+		Sampling:         nil,
+		Encoding:         "json",
+		EncoderConfig:    encoderConfig(c),
+		OutputPaths:      []string{"stdout"},
+		ErrorOutputPaths: []string{"stdout"},
+		// Stack traces are only added if a level is set by WithLoggerStackTraceLevel
+		DisableStacktrace: true,
 	}
 
-	for k, v := range c.tags {
-		config.InitialFields[k] = v
+	if len(c.loggerErrOut) > 0 {
+		config.ErrorOutputPaths = c.loggerErrOut
 	}
 
 	switch strings.ToLower(c.loggerLevel) {
@@ -357,26 +966,390 @@ func initLogger(c configs) (*zap.Logger, *zap.Config, shutdownFunc) {
 		config.Level = zap.NewAtomicLevelAt(zapcore.Level(99))
 	}
 
-	logger, _ := config.Build(
+	opts := []zap.Option{
 		zap.AddCaller(),
 		zap.AddCallerSkip(0),
-	)
+		zap.Fields(initialFields(c)...),
+	}
+
+	if c.loggerStack != nil {
+		opts = append(opts, zap.AddStacktrace(*c.loggerStack))
+	}
+
+	if len(c.loggerHooks) > 0 {
+		opts = append(opts, zap.Hooks(c.loggerHooks...))
+	}
+
+	// The truncate core is wrapped by the scrub core, so sensitive data are scrubbed before they can be cut in half
+	if c.logMaxMessage > 0 || c.logMaxField > 0 {
+		opts = append(opts, zap.WrapCore(func(core zapcore.Core) zapcore.Core {
+			return newTruncateCore(core, c.logMaxMessage, c.logMaxField)
+		}))
+	}
+
+	if len(c.logScrubbers) > 0 {
+		opts = append(opts, zap.WrapCore(func(core zapcore.Core) zapcore.Core {
+			return newScrubCore(core, c.logScrubbers)
+		}))
+	}
+
+	buildConfig := config
+	if c.loggerSampled {
+		// The logging level is enforced by the observer, so sampled requests can log at debug level
+		buildConfig.Level = zap.NewAtomicLevelAt(zapcore.DebugLevel)
+	}
+
+	// The syslog core replaces the stdout core, so it has to be the innermost core
+	var syslogClose func() error
+	var syslogErr error
+	if c.syslogEnabled {
+		var syslogCore zapcore.Core
+		enc := zapcore.NewJSONEncoder(config.EncoderConfig)
+		syslogCore, syslogClose, syslogErr = newSyslogCore(c.syslogNetwork, c.syslogAddr, c.syslogTag, enc, buildConfig.Level)
+		if syslogErr == nil {
+			opts = append([]zap.Option{
+				zap.WrapCore(func(zapcore.Core) zapcore.Core {
+					return syslogCore
+				}),
+			}, opts...)
+		}
+	}
+
+	logger, err := buildConfig.Build(opts...)
+	if err != nil {
+		// The outputs cannot be opened (i.e. an invalid path), so the log entries are written to stdout
+		config.OutputPaths, config.ErrorOutputPaths = []string{"stdout"}, []string{"stdout"}
+		buildConfig.OutputPaths, buildConfig.ErrorOutputPaths = config.OutputPaths, config.ErrorOutputPaths
+		logger, _ = buildConfig.Build(opts...)
+		logger.Warn("Failed to open the logger outputs, logging to stdout.", zap.Error(err))
+	}
+
+	if c.loggerName != "" {
+		logger = logger.Named(c.loggerName)
+	}
+
+	if syslogErr != nil {
+		logger.Warn("Failed to connect to syslog, logging to stdout.", zap.Error(syslogErr))
+	}
 
 	shutdown := func(context.Context) error {
-		return logger.Sync()
+		err := logger.Sync()
+		if syslogClose != nil {
+			if e := syslogClose(); e != nil {
+				err = multierror.Append(err, e)
+			}
+		}
+		return err
 	}
 
 	return logger, &config, shutdown
 }
 
-func initPrometheus(c configs) (metric.Meter, http.Handler) {
+const scrubbedValue = "[SCRUBBED]"
+
+// scrubCore is a zapcore.Core that replaces the matches of a list of patterns in log messages and string fields.
+type scrubCore struct {
+	zapcore.Core
+	patterns []*regexp.Regexp
+}
+
+func newScrubCore(core zapcore.Core, patterns []*regexp.Regexp) *scrubCore {
+	return &scrubCore{
+		Core:     core,
+		patterns: patterns,
+	}
+}
+
+func (c *scrubCore) scrub(s string) string {
+	for _, p := range c.patterns {
+		s = p.ReplaceAllString(s, scrubbedValue)
+	}
+	return s
+}
+
+func (c *scrubCore) scrubFields(fields []zapcore.Field) []zapcore.Field {
+	scrubbed := make([]zapcore.Field, len(fields))
+	for i, f := range fields {
+		if f.Type == zapcore.StringType {
+			f.String = c.scrub(f.String)
+		}
+		scrubbed[i] = f
+	}
+	return scrubbed
+}
+
+func (c *scrubCore) With(fields []zapcore.Field) zapcore.Core {
+	return newScrubCore(c.Core.With(c.scrubFields(fields)), c.patterns)
+}
+
+func (c *scrubCore) Check(e zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(e.Level) {
+		return ce.AddCore(e, c)
+	}
+	return ce
+}
+
+func (c *scrubCore) Write(e zapcore.Entry, fields []zapcore.Field) error {
+	e.Message = c.scrub(e.Message)
+	return c.Core.Write(e, c.scrubFields(fields))
+}
+
+const truncatedSuffix = "…(truncated)"
+
+// truncateCore is a zapcore.Core that truncates log messages and string fields longer than a maximum length in bytes.
+// A zero maximum length means no truncation.
+type truncateCore struct {
+	zapcore.Core
+	maxMessage int
+	maxField   int
+}
+
+func newTruncateCore(core zapcore.Core, maxMessage, maxField int) *truncateCore {
+	return &truncateCore{
+		Core:       core,
+		maxMessage: maxMessage,
+		maxField:   maxField,
+	}
+}
+
+// truncate cuts a string to at most max bytes without splitting a multi-byte character.
+func truncate(s string, max int) string {
+	if max <= 0 || len(s) <= max {
+		return s
+	}
+
+	for max > 0 && !utf8.RuneStart(s[max]) {
+		max--
+	}
+
+	return s[:max] + truncatedSuffix
+}
+
+func (c *truncateCore) truncateFields(fields []zapcore.Field) []zapcore.Field {
+	if c.maxField <= 0 {
+		return fields
+	}
+
+	truncated := make([]zapcore.Field, len(fields))
+	for i, f := range fields {
+		if f.Type == zapcore.StringType {
+			f.String = truncate(f.String, c.maxField)
+		}
+		truncated[i] = f
+	}
+	return truncated
+}
+
+func (c *truncateCore) With(fields []zapcore.Field) zapcore.Core {
+	return newTruncateCore(c.Core.With(c.truncateFields(fields)), c.maxMessage, c.maxField)
+}
+
+func (c *truncateCore) Check(e zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(e.Level) {
+		return ce.AddCore(e, c)
+	}
+	return ce
+}
+
+func (c *truncateCore) Write(e zapcore.Entry, fields []zapcore.Field) error {
+	e.Message = truncate(e.Message, c.maxMessage)
+	return c.Core.Write(e, c.truncateFields(fields))
+}
+
+// logLevelCounter counts the number of log entries per level.
+// The hook only updates in-memory counters and the counts are reported asynchronously when the metrics are collected.
+// So, the hook never records metrics directly and it will not recurse or deadlock if recording metrics results in logging.
+type logLevelCounter struct {
+	counts [zapcore.FatalLevel - zapcore.DebugLevel + 1]int64
+}
+
+func (c *logLevelCounter) hook(e zapcore.Entry) error {
+	if e.Level >= zapcore.DebugLevel && e.Level <= zapcore.FatalLevel {
+		atomic.AddInt64(&c.counts[e.Level-zapcore.DebugLevel], 1)
+	}
+	return nil
+}
+
+func (c *logLevelCounter) observe(_ context.Context, result metric.Int64ObserverResult) {
+	for i := range c.counts {
+		level := zapcore.DebugLevel + zapcore.Level(i)
+		result.Observe(atomic.LoadInt64(&c.counts[i]), label.String("level", level.String()))
+	}
+}
+
+func initLogLevelMetrics(logger *zap.Logger, meter metric.Meter) *zap.Logger {
+	counter := new(logLevelCounter)
+
+	_, err := meter.NewInt64SumObserver("log_entries_total", counter.observe,
+		metric.WithDescription("The total number of log entries per level"),
+		metric.WithUnit(unit.Dimensionless),
+	)
+
+	if err != nil {
+		logger.Error("Failed to create metric instrument.", zap.String("instrument", "log_entries_total"), zap.Error(err))
+		return logger
+	}
+
+	return logger.WithOptions(zap.Hooks(counter.hook))
+}
+
+// initRuntimeMetrics registers asynchronous instruments for reporting Go runtime metrics.
+// The metric names are prefixed with runtime_ to not collide with the metrics of the Prometheus Go collector.
+func initRuntimeMetrics(meter metric.Meter, logger *zap.Logger) {
+	var goroutines, heapAlloc metric.Int64UpDownSumObserver
+	var gcCount metric.Int64SumObserver
+
+	// The memory statistics are read once per collection for all instruments
+	batch := meter.NewBatchObserver(func(_ context.Context, result metric.BatchObserverResult) {
+		var stats runtime.MemStats
+		runtime.ReadMemStats(&stats)
+
+		result.Observe(nil,
+			goroutines.Observation(int64(runtime.NumGoroutine())),
+			heapAlloc.Observation(int64(stats.HeapAlloc)),
+			gcCount.Observation(int64(stats.NumGC)),
+		)
+	})
+
+	var err error
+
+	if goroutines, err = batch.NewInt64UpDownSumObserver("runtime_go_goroutines",
+		metric.WithDescription("The number of goroutines that currently exist"),
+		metric.WithUnit(unit.Dimensionless),
+	); err != nil {
+		logger.Error("Failed to create metric instrument.", zap.String("instrument", "runtime_go_goroutines"), zap.Error(err))
+	}
+
+	if heapAlloc, err = batch.NewInt64UpDownSumObserver("runtime_go_heap_alloc_bytes",
+		metric.WithDescription("The number of bytes of allocated heap objects"),
+		metric.WithUnit(unit.Bytes),
+	); err != nil {
+		logger.Error("Failed to create metric instrument.", zap.String("instrument", "runtime_go_heap_alloc_bytes"), zap.Error(err))
+	}
+
+	if gcCount, err = batch.NewInt64SumObserver("runtime_go_gc_total",
+		metric.WithDescription("The total number of completed garbage collection cycles"),
+		metric.WithUnit(unit.Dimensionless),
+	); err != nil {
+		logger.Error("Failed to create metric instrument.", zap.String("instrument", "runtime_go_gc_total"), zap.Error(err))
+	}
+}
+
+// initBuildInfo registers a gauge with a constant value of 1 for reporting the build information as labels.
+// This can be used for tracking deployments.
+func initBuildInfo(c configs, meter metric.Meter, logger *zap.Logger) {
+	labels := []label.KeyValue{
+		label.String("version", c.version),
+		label.String("commit", c.buildCommit),
+		label.String("date", c.buildDate),
+		label.String("goversion", runtime.Version()),
+	}
+
+	_, err := meter.NewInt64ValueObserver("build_info",
+		func(_ context.Context, result metric.Int64ObserverResult) {
+			result.Observe(1, labels...)
+		},
+		metric.WithDescription("The build information of the service with a constant value of 1"),
+		metric.WithUnit(unit.Dimensionless),
+	)
+
+	if err != nil {
+		logger.Error("Failed to create metric instrument.", zap.String("instrument", "build_info"), zap.Error(err))
+	}
+}
+
+// initialFields returns the initial fields for the logger in a deterministic order.
+// The metadata fields come first and the tags come next sorted by their keys.
+func initialFields(c configs) []zap.Field {
+	fields := []zap.Field{}
+
+	if c.name != "" {
+		fields = append(fields, zap.String("service", c.name))
+		// The logger is named after the service, unless it is named separately
+		if c.loggerName == "" {
+			fields = append(fields, zap.String("logger", c.name))
+		}
+	}
+
+	if c.version != "" {
+		fields = append(fields, zap.String("version", c.version))
+	}
+
+	if c.environment != "" {
+		fields = append(fields, zap.String("environment", c.environment))
+	}
+
+	if c.region != "" {
+		fields = append(fields, zap.String("region", c.region))
+	}
+
+	keys := make([]string, 0, len(c.tags))
+	for k := range c.tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		fields = append(fields, zap.String(k, c.tags[k]))
+	}
+
+	for _, kv := range kubernetesMetadata(c) {
+		fields = append(fields, zap.String(string(kv.Key), kv.Value.AsString()))
+	}
+
+	return fields
+}
+
+// kubernetesEnvVars maps the environment variables set by the Kubernetes downward API to their semantic conventions.
+var kubernetesEnvVars = []struct {
+	name string
+	key  label.Key
+}{
+	{"POD_NAME", semconv.K8SPodNameKey},
+	{"POD_NAMESPACE", semconv.K8SNamespaceNameKey},
+	{"POD_UID", semconv.K8SPodUIDKey},
+	{"NODE_NAME", label.Key("k8s.node.name")},
+	{"CONTAINER_NAME", semconv.K8SContainerNameKey},
+}
+
+// kubernetesMetadata returns the metadata of the Kubernetes pod if enabled.
+func kubernetesMetadata(c configs) []label.KeyValue {
+	if !c.kubernetes {
+		return nil
+	}
+
+	kvs := []label.KeyValue{}
+	for _, v := range kubernetesEnvVars {
+		if val := os.Getenv(v.name); val != "" {
+			kvs = append(kvs, v.key.String(val))
+		}
+	}
+
+	return kvs
+}
+
+func initPrometheus(c configs) (metric.Meter, http.Handler, prometheus.Gatherer) {
 	// Create a new Prometheus registry
 	registry := prometheus.NewRegistry()
-	registry.MustRegister(prometheus.NewGoCollector())
-	registry.MustRegister(prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}))
+
+	// All collectors registered through this registerer will have the constant labels
+	var registerer prometheus.Registerer = registry
+	if len(c.prometheusConstLabels) > 0 {
+		registerer = prometheus.WrapRegistererWith(c.prometheusConstLabels, registry)
+	}
+
+	registerer.MustRegister(prometheus.NewGoCollector())
+	registerer.MustRegister(prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}))
+
+	// The version label is only added to the metrics reported through the meter,
+	// since the Go collector has its own version label (go_info).
+	exporterRegisterer := registerer
+	if c.prometheusVersionLabel && c.version != "" {
+		exporterRegisterer = prometheus.WrapRegistererWith(prometheus.Labels{"version": c.version}, registerer)
+	}
 
 	config := promexporter.Config{
-		Registerer: registry,
+		Registerer: exporterRegisterer,
 		Gatherer:   registry,
 		// DefaultSummaryQuantiles:    []float64{},
 		// DefaultHistogramBoundaries: []float64{},
@@ -390,7 +1363,69 @@ func initPrometheus(c configs) (metric.Meter, http.Handler) {
 	otel.SetMeterProvider(exporter.MeterProvider())
 	meter := exporter.MeterProvider().Meter(c.name)
 
-	return meter, exporter
+	var handler http.Handler = exporter
+	if c.prometheusOpenMetrics {
+		handler = promhttp.HandlerFor(registry, promhttp.HandlerOpts{
+			EnableOpenMetrics: true,
+		})
+	}
+
+	return meter, handler, registry
+}
+
+// processTags returns the tags describing the process sorted by their keys.
+// The host name and process id are included by default.
+func processTags(c configs) []label.KeyValue {
+	tags := map[label.Key]label.KeyValue{}
+
+	if hostname, err := os.Hostname(); err == nil {
+		tags[semconv.HostNameKey] = semconv.HostNameKey.String(hostname)
+	}
+
+	tags[semconv.ProcessPIDKey] = semconv.ProcessPIDKey.Int(os.Getpid())
+
+	for k, v := range c.tags {
+		tags[label.Key(k)] = label.String(k, v)
+	}
+
+	for _, kv := range kubernetesMetadata(c) {
+		tags[kv.Key] = kv
+	}
+
+	for k, v := range c.processTags {
+		tags[label.Key(k)] = label.String(k, v)
+	}
+
+	kvs := make([]label.KeyValue, 0, len(tags))
+	for _, kv := range tags {
+		kvs = append(kvs, kv)
+	}
+
+	sort.Slice(kvs, func(i, j int) bool {
+		return kvs[i].Key < kvs[j].Key
+	})
+
+	return kvs
+}
+
+// textMapPropagator returns the propagator for trace contexts across process boundaries.
+// W3C Trace Context is always propagated, and B3 headers are propagated too if they are enabled.
+// B3 headers in both the single-header and the multi-header formats are extracted,
+// and they are injected in the format chosen by WithB3Propagation.
+func textMapPropagator(c configs) propagation.TextMapPropagator {
+	if !c.b3Enabled {
+		return propagation.TraceContext{}
+	}
+
+	encoding := b3.B3MultipleHeader
+	if c.b3SingleHeader {
+		encoding = b3.B3SingleHeader
+	}
+
+	return propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{},
+		b3.B3{InjectEncoding: encoding},
+	)
 }
 
 func initJaeger(c configs) (trace.Tracer, shutdownFunc) {
@@ -406,21 +1441,17 @@ func initJaeger(c configs) (trace.Tracer, shutdownFunc) {
 		)
 	}
 
-	tags := []label.KeyValue{}
-	for k, v := range c.tags {
-		tags = append(tags, label.String(k, v))
-	}
-
 	processOpt := jaegerexporter.WithProcess(
 		jaegerexporter.Process{
 			ServiceName: c.name,
-			Tags:        tags,
+			Tags:        processTags(c),
 		},
 	)
 
+	sampler, processor := newSampler(c)
 	sdkOpt := jaegerexporter.WithSDK(
 		&tracesdk.Config{
-			DefaultSampler: tracesdk.AlwaysSample(),
+			DefaultSampler: sampler,
 		},
 	)
 
@@ -429,6 +1460,10 @@ func initJaeger(c configs) (trace.Tracer, shutdownFunc) {
 		panic(err)
 	}
 
+	if p, ok := provider.(*tracesdk.TracerProvider); ok && processor != nil {
+		p.RegisterSpanProcessor(processor)
+	}
+
 	otel.SetTracerProvider(provider)
 	tracer := otel.Tracer(c.name)
 
@@ -440,6 +1475,23 @@ func initJaeger(c configs) (trace.Tracer, shutdownFunc) {
 	return tracer, shutdown
 }
 
+// defaultHistogramBoundaries are the histogram bucket boundaries suitable for durations in milliseconds.
+var defaultHistogramBoundaries = []float64{5, 10, 25, 50, 100, 250, 500, 1000, 2500, 5000, 10000}
+
+// aggregatorSelector returns an aggregator selector for a kind of aggregation.
+func aggregatorSelector(kind string) export.AggregatorSelector {
+	switch strings.ToLower(kind) {
+	case "histogram":
+		return simple.NewWithHistogramDistribution(defaultHistogramBoundaries)
+	case "sketch":
+		return simple.NewWithInexpensiveDistribution()
+	case "exact":
+		fallthrough
+	default:
+		return simple.NewWithExactDistribution()
+	}
+}
+
 func initOpenTelemetry(c configs) (metric.Meter, trace.Tracer, shutdownFunc) {
 	ctx := context.Background()
 
@@ -455,6 +1507,11 @@ func initOpenTelemetry(c configs) (metric.Meter, trace.Tracer, shutdownFunc) {
 		driverOpts = append(driverOpts, otlpgrpc.WithTLSCredentials(c.opentelemetryCollectorCredentials))
 	}
 
+	// The connection is established in the background and re-attempted periodically while the collector is unavailable.
+	if c.opentelemetryReconnectionPeriod > 0 {
+		driverOpts = append(driverOpts, otlpgrpc.WithReconnectionPeriod(c.opentelemetryReconnectionPeriod))
+	}
+
 	driver := otlpgrpc.NewDriver(driverOpts...)
 	exporter, err := otlp.NewExporter(ctx, driver)
 	if err != nil {
@@ -463,72 +1520,148 @@ func initOpenTelemetry(c configs) (metric.Meter, trace.Tracer, shutdownFunc) {
 
 	// ====================> Trace Provider <====================
 
-	r, err := resource.New(ctx,
-		resource.WithAttributes(
+	var tracer trace.Tracer
+	var traceProvider *tracesdk.TracerProvider
+
+	if !c.opentelemetryMetricsOnly {
+		attrs := append([]label.KeyValue{
 			semconv.ServiceNameKey.String(c.name),
-		),
-	)
+		}, processTags(c)...)
 
-	if err != nil {
-		panic(err)
-	}
+		r, err := resource.New(ctx,
+			resource.WithAttributes(attrs...),
+		)
 
-	traceProvider := tracesdk.NewTracerProvider(
-		tracesdk.WithResource(r),
-		tracesdk.WithConfig(tracesdk.Config{
-			DefaultSampler: tracesdk.AlwaysSample(),
-		}),
-		tracesdk.WithSpanProcessor(
-			tracesdk.NewBatchSpanProcessor(exporter),
-		),
-	)
+		if err != nil {
+			panic(err)
+		}
+
+		sampler, processor := newSampler(c)
+		providerOpts := []tracesdk.TracerProviderOption{
+			tracesdk.WithResource(r),
+			tracesdk.WithConfig(tracesdk.Config{
+				DefaultSampler: sampler,
+			}),
+			tracesdk.WithSpanProcessor(
+				tracesdk.NewBatchSpanProcessor(exporter),
+			),
+		}
+
+		if processor != nil {
+			providerOpts = append(providerOpts, tracesdk.WithSpanProcessor(processor))
+		}
+
+		traceProvider = tracesdk.NewTracerProvider(providerOpts...)
+
+		otel.SetTracerProvider(traceProvider)
+		otel.SetTextMapPropagator(textMapPropagator(c))
+
+		tracer = otel.Tracer(c.name)
+	}
 
 	// ====================> Meter Provider <====================
 
-	aggregator := simple.NewWithExactDistribution()
-	checkpointer := processor.New(aggregator, exporter)
+	var meter metric.Meter
+	var cont *controller.Controller
 
-	cont := controller.New(checkpointer,
-		controller.WithPusher(exporter),
-		controller.WithCollectPeriod(2*time.Second),
-	)
+	if !c.opentelemetryTracesOnly {
+		aggregator := aggregatorSelector(c.meterAggregation)
+		checkpointer := processor.New(aggregator, exporter)
 
-	// ====================> Set Globals <====================
+		cont = controller.New(checkpointer,
+			controller.WithPusher(exporter),
+			controller.WithCollectPeriod(2*time.Second),
+		)
 
-	otel.SetTracerProvider(traceProvider)
-	otel.SetMeterProvider(cont.MeterProvider())
-	otel.SetTextMapPropagator(propagation.TraceContext{})
+		otel.SetMeterProvider(cont.MeterProvider())
 
-	if err := cont.Start(ctx); err != nil {
-		panic(err)
-	}
+		if err := cont.Start(ctx); err != nil {
+			panic(err)
+		}
 
-	meter := otel.Meter(c.name)
-	tracer := otel.Tracer(c.name)
+		meter = otel.Meter(c.name)
+	}
 
+	// The controller (pusher) and trace provider should be stopped before the exporter is closed.
 	shutdown := func(ctx context.Context) error {
-		if err := traceProvider.Shutdown(ctx); err != nil {
-			return err
+		var err error
+		if cont != nil {
+			// Metrics cannot be flushed while the collector is unavailable, and they are dropped as they would be by the pusher.
+			if e := cont.Stop(ctx); e != nil && status.Code(e) != codes.Unavailable {
+				err = multierror.Append(err, e)
+			}
 		}
-		if err := exporter.Shutdown(ctx); err != nil {
-			return err
+		if traceProvider != nil {
+			if e := traceProvider.Shutdown(ctx); e != nil {
+				err = multierror.Append(err, e)
+			}
 		}
-		// FIXME:
-		// pusher.Stop()
-		return nil
+		if e := exporter.Shutdown(ctx); e != nil {
+			err = multierror.Append(err, e)
+		}
+		return err
 	}
 
 	return meter, tracer, shutdown
 }
 
+// addShutdownFunc registers a shutdown function with a name for the shutdown report.
+func (o *observer) addShutdownFunc(name string, f shutdownFunc) {
+	o.shutdownFuncs = append(o.shutdownFuncs, f)
+	o.shutdownNames = append(o.shutdownNames, name)
+}
+
+// shutdownName returns the name of a shutdown function for the shutdown report.
+func (o *observer) shutdownName(i int) string {
+	if i < len(o.shutdownNames) {
+		return o.shutdownNames[i]
+	}
+	return fmt.Sprintf("func%d", i)
+}
+
+// Shutdown calls the shutdown functions in the reverse order of registration (LIFO).
+// All shutdown functions are called even if some of them fail, and all errors are aggregated.
+// A report with the duration and the error of every shutdown function is logged at info level.
+// The report is logged before the logger is flushed, so the flush of the logger itself is not included.
 func (o *observer) Shutdown(ctx context.Context) error {
 	var err error
-	for _, endFunc := range o.shutdownFuncs {
-		if e := endFunc(ctx); e != nil {
+	var fields []zap.Field
+	reported := false
+	start := time.Now()
+
+	report := func() {
+		reported = true
+		if o.logger != nil {
+			fields = append(fields, zap.Int64("shutdown.duration", time.Since(start).Milliseconds()))
+			o.logger.Info("Observer shut down.", fields...)
+		}
+	}
+
+	for i := len(o.shutdownFuncs) - 1; i >= 0; i-- {
+		name := o.shutdownName(i)
+		if name == "logger" {
+			report()
+		}
+
+		t := time.Now()
+		e := o.shutdownFuncs[i](ctx)
+
+		if !reported {
+			fields = append(fields, zap.Int64("shutdown."+name+".duration", time.Since(t).Milliseconds()))
+			if e != nil {
+				fields = append(fields, zap.String("shutdown."+name+".error", e.Error()))
+			}
+		}
+
+		if e != nil {
 			err = multierror.Append(err, e)
 		}
 	}
 
+	if !reported {
+		report()
+	}
+
 	return err
 }
 
@@ -556,27 +1689,98 @@ func (o *observer) Tracer() trace.Tracer {
 	return o.tracer
 }
 
+func (o *observer) SpanLogger(sc trace.SpanContext) *zap.Logger {
+	if o.sampledLogger != nil && sc.IsSampled() {
+		return o.sampledLogger
+	}
+	return o.logger
+}
+
+func (o *observer) WithContext(ctx context.Context) *Scope {
+	return newScope(ctx, o)
+}
+
 func (o *observer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	if o.promHandler != nil {
 		o.promHandler.ServeHTTP(w, r)
 	}
 }
 
-var singleton *observer
+func (o *observer) Gather() ([]*dto.MetricFamily, error) {
+	if o.promGatherer == nil {
+		return nil, errors.New("Prometheus is not enabled")
+	}
+
+	return o.promGatherer.Gather()
+}
+
+// SetTag adds or updates a tag on the log entries written after the call.
+// The tag is also added to the entries of the loggers that are derived from the observer logger before the call.
+// If the key is the same as an initial field (i.e. version), both fields are written.
+// Traces and metrics keep the resource set at startup and do not get the tag.
+func (o *observer) SetTag(key, value string) {
+	o.tags.set(key, value)
+}
+
+func newNoop() *observer {
+	return &observer{
+		logger: zap.NewNop(),
+		loggerConfig: &zap.Config{
+			Level: zap.NewAtomicLevel(),
+		},
+		auditLogger: zap.NewNop(),
+		meter:       new(metric.NoopMeterProvider).Meter(""),
+		promHandler: http.NotFoundHandler(),
+		tracer:      trace.NewNoopTracerProvider().Tracer(""),
+		tags:        newTags(),
+	}
+}
+
+// NewNoop creates a new no-op observer.
+// The no-op observer has a no-op logger, meter, and tracer, and its metrics endpoint responds with 404 Not Found.
+// It can be used in tests and as a default where an observer is optional.
+func NewNoop() Observer {
+	return newNoop()
+}
+
+var (
+	singleton *observer
+
+	// noopSingleton is the no-op observer that the singleton observer is initialized with.
+	noopSingleton *observer
+)
 
 // Initialize the singleton observer with a no-op observer.
 // init function will be only called once in runtime regardless of how many times the package is imported.
 func init() {
-	singleton = &observer{
-		logger:       zap.NewNop(),
-		loggerConfig: &zap.Config{},
-		meter:        new(metric.NoopMeterProvider).Meter(""),
-		promHandler:  http.NotFoundHandler(),
-		tracer:       trace.NewNoopTracerProvider().Tracer(""),
-	}
+	noopSingleton = newNoop()
+	singleton = noopSingleton
 }
 
 // Get returns the singleton Observer.
+// If the singleton observer is not set by New yet, a no-op observer is returned.
 func Get() Observer {
 	return singleton
 }
+
+// MustGet returns the singleton Observer.
+// Unlike Get, it panics if the singleton observer is not set by New yet.
+// A no-op singleton silently drops all logs, metrics, and traces,
+// so this can be used for catching a missing call to New (or a wrong initialization order) early.
+func MustGet() Observer {
+	if singleton == noopSingleton {
+		panic("observer: the singleton observer is not set, New(true, ...) should be called before MustGet")
+	}
+
+	return singleton
+}
+
+// ResetSingleton resets the singleton observer to the initial no-op observer.
+// It is meant for testing only, so the tests creating singleton observers do not interfere with each other
+// (i.e. through the fallback of LoggerFromContext and ObserverFromContext).
+// The singleton is not safe for concurrent access, so such tests should not run in parallel.
+//
+//	t.Cleanup(observer.ResetSingleton)
+func ResetSingleton() {
+	singleton = noopSingleton
+}
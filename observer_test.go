@@ -3,16 +3,38 @@ package observer
 import (
 	"context"
 	"errors"
+	"io/ioutil"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/hashicorp/go-multierror"
 	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/label"
 	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/metric/number"
+	"go.opentelemetry.io/otel/oteltest"
+	"go.opentelemetry.io/otel/propagation"
 	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
+	zapobserver "go.uber.org/zap/zaptest/observer"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/emptypb"
+
+	export "go.opentelemetry.io/otel/sdk/export/metric"
+	"go.opentelemetry.io/otel/sdk/metric/aggregator/exact"
+	"go.opentelemetry.io/otel/sdk/metric/aggregator/histogram"
+	"go.opentelemetry.io/otel/sdk/metric/aggregator/minmaxsumcount"
 )
 
 func TestConfigsFromEnv(t *testing.T) {
@@ -54,6 +76,7 @@ func TestConfigsFromEnv(t *testing.T) {
 				keyval{"OBSERVER_JAEGER_COLLECTOR_PASSWORD", "password"},
 				keyval{"OBSERVER_OPENTELEMETRY_ENABLED", "true"},
 				keyval{"OBSERVER_OPENTELEMETRY_COLLECTOR_ADDRESS", "localhost:55680"},
+				keyval{"OBSERVER_OPENTELEMETRY_RECONNECTION_PERIOD", "5s"},
 			},
 			expectedConfigs: configs{
 				name:        "my-service",
@@ -74,6 +97,7 @@ func TestConfigsFromEnv(t *testing.T) {
 				opentelemetryEnabled:              true,
 				opentelemetryCollectorAddress:     "localhost:55680",
 				opentelemetryCollectorCredentials: nil,
+				opentelemetryReconnectionPeriod:   5 * time.Second,
 			},
 		},
 	}
@@ -124,6 +148,18 @@ func TestOption(t *testing.T) {
 				},
 			},
 		},
+		{
+			name:    "WithProcessTags",
+			configs: &configs{},
+			option: WithProcessTags(map[string]string{
+				"build.hash": "abcdef0",
+			}),
+			expectedConfigs: &configs{
+				processTags: map[string]string{
+					"build.hash": "abcdef0",
+				},
+			},
+		},
 		{
 			name:    "WithLoggerDefaults",
 			configs: &configs{},
@@ -133,6 +169,23 @@ func TestOption(t *testing.T) {
 				loggerLevel:   "info",
 			},
 		},
+		{
+			name:    "WithKubernetesMetadata",
+			configs: &configs{},
+			option:  WithKubernetesMetadata(),
+			expectedConfigs: &configs{
+				kubernetes: true,
+			},
+		},
+		{
+			name:    "WithBuildInfo",
+			configs: &configs{},
+			option:  WithBuildInfo("abcdef0", "2021-01-01T00:00:00Z"),
+			expectedConfigs: &configs{
+				buildCommit: "abcdef0",
+				buildDate:   "2021-01-01T00:00:00Z",
+			},
+		},
 		{
 			name:    "WithLogger",
 			configs: &configs{},
@@ -142,6 +195,89 @@ func TestOption(t *testing.T) {
 				loggerLevel:   "warn",
 			},
 		},
+		{
+			name:    "WithLoggerTimeFormat",
+			configs: &configs{},
+			option:  WithLoggerTimeFormat("epochmillis"),
+			expectedConfigs: &configs{
+				loggerTime: "epochmillis",
+			},
+		},
+		{
+			name:    "WithLoggerStackTraceLevel",
+			configs: &configs{},
+			option:  WithLoggerStackTraceLevel(zapcore.ErrorLevel),
+			expectedConfigs: &configs{
+				loggerStack: func() *zapcore.Level { l := zapcore.ErrorLevel; return &l }(),
+			},
+		},
+		{
+			name:    "WithLoggerName",
+			configs: &configs{},
+			option:  WithLoggerName("billing"),
+			expectedConfigs: &configs{
+				loggerName: "billing",
+			},
+		},
+		{
+			name:    "WithLoggerErrorOutput",
+			configs: &configs{},
+			option:  WithLoggerErrorOutput("stderr"),
+			expectedConfigs: &configs{
+				loggerErrOut: []string{"stderr"},
+			},
+		},
+		{
+			name:    "WithLogScrubbers",
+			configs: &configs{},
+			option:  WithLogScrubbers(regexp.MustCompile(`[0-9]{16}`)),
+			expectedConfigs: &configs{
+				logScrubbers: []*regexp.Regexp{regexp.MustCompile(`[0-9]{16}`)},
+			},
+		},
+		{
+			name:    "WithMaxMessageLength",
+			configs: &configs{},
+			option:  WithMaxMessageLength(1024),
+			expectedConfigs: &configs{
+				logMaxMessage: 1024,
+			},
+		},
+		{
+			name:    "WithMaxFieldLength",
+			configs: &configs{},
+			option:  WithMaxFieldLength(256),
+			expectedConfigs: &configs{
+				logMaxField: 256,
+			},
+		},
+		{
+			name:    "WithSyslog",
+			configs: &configs{},
+			option:  WithSyslog("udp", "localhost:514", "my-service"),
+			expectedConfigs: &configs{
+				syslogEnabled: true,
+				syslogNetwork: "udp",
+				syslogAddr:    "localhost:514",
+				syslogTag:     "my-service",
+			},
+		},
+		{
+			name:    "WithSentry",
+			configs: &configs{},
+			option:  WithSentry("https://key@sentry.io/1234"),
+			expectedConfigs: &configs{
+				sentryDSN: "https://key@sentry.io/1234",
+			},
+		},
+		{
+			name:    "WithLogLevelMetrics",
+			configs: &configs{},
+			option:  WithLogLevelMetrics(),
+			expectedConfigs: &configs{
+				loggerMetrics: true,
+			},
+		},
 		{
 			name:    "WithPrometheus",
 			configs: &configs{},
@@ -150,6 +286,107 @@ func TestOption(t *testing.T) {
 				prometheusEnabled: true,
 			},
 		},
+		{
+			name:    "WithPrometheusOpenMetrics",
+			configs: &configs{},
+			option:  WithPrometheusOpenMetrics(),
+			expectedConfigs: &configs{
+				prometheusOpenMetrics: true,
+			},
+		},
+		{
+			name:    "WithAuditOutput",
+			configs: &configs{},
+			option:  WithAuditOutput("stderr", "/var/log/audit.log"),
+			expectedConfigs: &configs{
+				auditOutput: []string{"stderr", "/var/log/audit.log"},
+			},
+		},
+		{
+			name:    "WithDeploymentColor",
+			configs: &configs{},
+			option:  WithDeploymentColor("canary"),
+			expectedConfigs: &configs{
+				color: "canary",
+			},
+		},
+		{
+			name:    "WithPrometheusConstLabels",
+			configs: &configs{},
+			option:  WithPrometheusConstLabels(map[string]string{"role": "worker"}),
+			expectedConfigs: &configs{
+				prometheusConstLabels: map[string]string{"role": "worker"},
+			},
+		},
+		{
+			name:    "WithPrometheusVersionLabel",
+			configs: &configs{},
+			option:  WithPrometheusVersionLabel(),
+			expectedConfigs: &configs{
+				prometheusVersionLabel: true,
+			},
+		},
+		{
+			name:    "WithStatsD",
+			configs: &configs{},
+			option:  WithStatsD("", "myservice."),
+			expectedConfigs: &configs{
+				statsdEnabled: true,
+				statsdAddr:    "localhost:8125",
+				statsdPrefix:  "myservice.",
+			},
+		},
+		{
+			name:    "WithMeterAggregation",
+			configs: &configs{},
+			option:  WithMeterAggregation("histogram"),
+			expectedConfigs: &configs{
+				meterAggregation: "histogram",
+			},
+		},
+		{
+			name:    "WithRuntimeMetrics",
+			configs: &configs{},
+			option:  WithRuntimeMetrics(),
+			expectedConfigs: &configs{
+				runtimeMetrics: true,
+			},
+		},
+		{
+			name:    "WithMeterProvider",
+			configs: &configs{},
+			option:  WithMeterProvider(new(metric.NoopMeterProvider)),
+			expectedConfigs: &configs{
+				meterProvider: new(metric.NoopMeterProvider),
+			},
+		},
+		{
+			name:    "WithTracerProvider",
+			configs: &configs{},
+			option:  WithTracerProvider(trace.NewNoopTracerProvider()),
+			expectedConfigs: &configs{
+				tracerProvider: trace.NewNoopTracerProvider(),
+			},
+		},
+		{
+			name:    "WithAdaptiveSampling",
+			configs: &configs{},
+			option:  WithAdaptiveSampling(0.1, 0.5),
+			expectedConfigs: &configs{
+				adaptiveSampling:   true,
+				samplingBaseRatio:  0.1,
+				samplingErrorBoost: 0.5,
+			},
+		},
+		{
+			name:    "WithB3Propagation",
+			configs: &configs{},
+			option:  WithB3Propagation(true),
+			expectedConfigs: &configs{
+				b3Enabled:      true,
+				b3SingleHeader: true,
+			},
+		},
 		{
 			name:    "WithJaegerDefaults",
 			configs: &configs{},
@@ -190,6 +427,30 @@ func TestOption(t *testing.T) {
 				opentelemetryCollectorCredentials: nil,
 			},
 		},
+		{
+			name:    "WithOpenTelemetryTracesOnly",
+			configs: &configs{},
+			option:  WithOpenTelemetryTracesOnly(),
+			expectedConfigs: &configs{
+				opentelemetryTracesOnly: true,
+			},
+		},
+		{
+			name:    "WithOpenTelemetryMetricsOnly",
+			configs: &configs{},
+			option:  WithOpenTelemetryMetricsOnly(),
+			expectedConfigs: &configs{
+				opentelemetryMetricsOnly: true,
+			},
+		},
+		{
+			name:    "WithOpenTelemetryReconnectionPeriod",
+			configs: &configs{},
+			option:  WithOpenTelemetryReconnectionPeriod(5 * time.Second),
+			expectedConfigs: &configs{
+				opentelemetryReconnectionPeriod: 5 * time.Second,
+			},
+		},
 	}
 
 	for _, tc := range tests {
@@ -201,6 +462,217 @@ func TestOption(t *testing.T) {
 	}
 }
 
+func TestWithLoggerHooks(t *testing.T) {
+	hook := func(zapcore.Entry) error {
+		return nil
+	}
+
+	tests := []struct {
+		name          string
+		configs       *configs
+		hooks         []func(zapcore.Entry) error
+		expectedHooks int
+	}{
+		{
+			name:          "NoHook",
+			configs:       &configs{},
+			hooks:         nil,
+			expectedHooks: 0,
+		},
+		{
+			name:          "WithHooks",
+			configs:       &configs{},
+			hooks:         []func(zapcore.Entry) error{hook, hook},
+			expectedHooks: 2,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			opt := WithLoggerHooks(tc.hooks...)
+			opt(tc.configs)
+
+			assert.Len(t, tc.configs.loggerHooks, tc.expectedHooks)
+		})
+	}
+}
+
+func TestConfigsValidate(t *testing.T) {
+	tests := []struct {
+		name           string
+		configs        configs
+		expectedErrors []string
+	}{
+		{
+			name:           "NoConflict",
+			configs:        configs{loggerEnabled: true, prometheusEnabled: true, jaegerEnabled: true},
+			expectedErrors: nil,
+		},
+		{
+			name:           "PrometheusAndOpenTelemetry",
+			configs:        configs{prometheusEnabled: true, opentelemetryEnabled: true},
+			expectedErrors: nil,
+		},
+		{
+			name:           "MeterAggregationWithPrometheus",
+			configs:        configs{prometheusEnabled: true, opentelemetryEnabled: true, meterAggregation: "histogram"},
+			expectedErrors: nil,
+		},
+		{
+			name:           "AdaptiveSamplingWithJaeger",
+			configs:        configs{jaegerEnabled: true, adaptiveSampling: true, samplingBaseRatio: 0.1, samplingErrorBoost: 0.5},
+			expectedErrors: nil,
+		},
+		{
+			name:    "AdaptiveSamplingWithoutTracer",
+			configs: configs{adaptiveSampling: true, samplingBaseRatio: 0.1, samplingErrorBoost: 0.5},
+			expectedErrors: []string{
+				"adaptive sampling has no effect when neither Jaeger nor OpenTelemetry is used for traces",
+			},
+		},
+		{
+			name:    "InvalidAdaptiveSampling",
+			configs: configs{jaegerEnabled: true, adaptiveSampling: true, samplingBaseRatio: 1.5, samplingErrorBoost: 0.5},
+			expectedErrors: []string{
+				"invalid adaptive sampling ratios 1.5 and 0.5: the ratios are clamped",
+			},
+		},
+		{
+			name:    "MeterAggregationWithOpenTelemetryTracesOnly",
+			configs: configs{prometheusEnabled: true, opentelemetryEnabled: true, opentelemetryTracesOnly: true, meterAggregation: "histogram"},
+			expectedErrors: []string{
+				"meter aggregation has no effect when OpenTelemetry is not used for metrics",
+			},
+		},
+		{
+			name:    "JaegerAndOpenTelemetry",
+			configs: configs{jaegerEnabled: true, opentelemetryEnabled: true},
+			expectedErrors: []string{
+				"both Jaeger and OpenTelemetry provide a tracer: the OpenTelemetry tracer is used",
+			},
+		},
+		{
+			name:    "TracesOnlyAndMetricsOnly",
+			configs: configs{opentelemetryEnabled: true, opentelemetryTracesOnly: true, opentelemetryMetricsOnly: true},
+			expectedErrors: []string{
+				"both traces-only and metrics-only are set for OpenTelemetry: nothing is reported to OpenTelemetry",
+			},
+		},
+		{
+			name:           "PrometheusAndOpenTelemetryMetricsOnly",
+			configs:        configs{prometheusEnabled: true, opentelemetryEnabled: true, opentelemetryMetricsOnly: true},
+			expectedErrors: nil,
+		},
+		{
+			name:           "JaegerAndOpenTelemetryMetricsOnly",
+			configs:        configs{jaegerEnabled: true, opentelemetryEnabled: true, opentelemetryMetricsOnly: true},
+			expectedErrors: nil,
+		},
+		{
+			name:    "MeterProviderAndPrometheus",
+			configs: configs{prometheusEnabled: true, meterProvider: new(metric.NoopMeterProvider)},
+			expectedErrors: []string{
+				"a meter provider is provided: Prometheus and OpenTelemetry are not used for metrics",
+			},
+		},
+		{
+			name:    "MeterProviderAndStatsD",
+			configs: configs{statsdEnabled: true, meterProvider: new(metric.NoopMeterProvider)},
+			expectedErrors: []string{
+				"a meter provider is provided: StatsD is not used for metrics",
+			},
+		},
+		{
+			name:    "TracerProviderAndJaeger",
+			configs: configs{jaegerEnabled: true, tracerProvider: trace.NewNoopTracerProvider()},
+			expectedErrors: []string{
+				"a tracer provider is provided: Jaeger and OpenTelemetry are not used for traces",
+			},
+		},
+		{
+			name:    "LoggerOptionsWithoutLogger",
+			configs: configs{loggerMetrics: true},
+			expectedErrors: []string{
+				"logger hooks and log level metrics have no effect when the logger is not enabled",
+			},
+		},
+		{
+			name:    "OpenMetricsWithoutPrometheus",
+			configs: configs{prometheusOpenMetrics: true},
+			expectedErrors: []string{
+				"OpenMetrics format has no effect when Prometheus is not enabled",
+			},
+		},
+		{
+			name:    "ConstLabelsWithoutPrometheus",
+			configs: configs{prometheusConstLabels: map[string]string{"role": "worker"}},
+			expectedErrors: []string{
+				"constant labels have no effect when Prometheus is not enabled",
+			},
+		},
+		{
+			name:    "VersionLabelWithoutPrometheus",
+			configs: configs{version: "0.1.0", prometheusVersionLabel: true},
+			expectedErrors: []string{
+				"version label has no effect when Prometheus is not enabled",
+			},
+		},
+		{
+			name:    "VersionLabelWithoutVersion",
+			configs: configs{prometheusEnabled: true, prometheusVersionLabel: true},
+			expectedErrors: []string{
+				"version label has no effect when the version is not set",
+			},
+		},
+		{
+			name:    "UnknownMeterAggregation",
+			configs: configs{opentelemetryEnabled: true, meterAggregation: "average"},
+			expectedErrors: []string{
+				`unknown meter aggregation "average": the exact aggregation is used`,
+			},
+		},
+		{
+			name:    "SentryWithoutLogger",
+			configs: configs{sentryDSN: "https://key@sentry.io/1234"},
+			expectedErrors: []string{
+				"Sentry has no effect when the logger is not enabled",
+			},
+		},
+		{
+			name:    "UnknownLoggerTimeFormat",
+			configs: configs{loggerEnabled: true, loggerTime: "unix"},
+			expectedErrors: []string{
+				`unknown logger time format "unix": the rfc3339nano format is used`,
+			},
+		},
+		{
+			name:    "MultipleConflicts",
+			configs: configs{jaegerEnabled: true, prometheusOpenMetrics: true, opentelemetryEnabled: true},
+			expectedErrors: []string{
+				"both Jaeger and OpenTelemetry provide a tracer: the OpenTelemetry tracer is used",
+				"OpenMetrics format has no effect when Prometheus is not enabled",
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.configs.validate()
+
+			if tc.expectedErrors == nil {
+				assert.NoError(t, err)
+			} else {
+				merr, ok := err.(*multierror.Error)
+				assert.True(t, ok)
+				assert.Len(t, merr.Errors, len(tc.expectedErrors))
+				for i, expectedError := range tc.expectedErrors {
+					assert.EqualError(t, merr.Errors[i], expectedError)
+				}
+			}
+		})
+	}
+}
+
 func TestNew(t *testing.T) {
 	tests := []struct {
 		name           string
@@ -250,22 +722,222 @@ func TestNew(t *testing.T) {
 	}
 }
 
-func TestInitLogger(t *testing.T) {
-	tests := []struct {
-		name          string
-		configs       configs
-		expectedLevel zapcore.Level
-	}{
-		{
-			name: "Production",
-			configs: configs{
-				name:        "my-service",
-				version:     "0.1.0",
-				environment: "production",
-				region:      "ca-central-1",
-				tags: map[string]string{
-					"domain": "auth",
-				},
+// mockCollector is a grpc server that accepts any request and records the raw payloads per method.
+type mockCollector struct {
+	sync.Mutex
+	addr     string
+	server   *grpc.Server
+	payloads map[string][]byte
+}
+
+func newMockCollector(t *testing.T) *mockCollector {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+
+	c := &mockCollector{
+		addr:     lis.Addr().String(),
+		payloads: map[string][]byte{},
+	}
+
+	c.server = grpc.NewServer(grpc.UnknownServiceHandler(func(_ interface{}, stream grpc.ServerStream) error {
+		// The fields of the request are kept as unknown fields
+		req := new(emptypb.Empty)
+		if err := stream.RecvMsg(req); err != nil {
+			return err
+		}
+
+		method, _ := grpc.MethodFromServerStream(stream)
+		payload, _ := proto.Marshal(req)
+
+		c.Lock()
+		c.payloads[method] = append(c.payloads[method], payload...)
+		c.Unlock()
+
+		return stream.SendMsg(new(emptypb.Empty))
+	}))
+
+	go func() {
+		_ = c.server.Serve(lis)
+	}()
+
+	return c
+}
+
+func (c *mockCollector) payload(method string) []byte {
+	c.Lock()
+	defer c.Unlock()
+	return c.payloads[method]
+}
+
+func TestNewWithPrometheusAndOpenTelemetry(t *testing.T) {
+	collector := newMockCollector(t)
+	defer collector.server.Stop()
+
+	obsv := New(false,
+		WithMetadata("my-service", "0.1.0", "production", "ca-central-1", nil),
+		WithPrometheus(),
+		WithOpenTelemetry(collector.addr, nil),
+		WithOpenTelemetryMetricsOnly(),
+	)
+
+	counter, err := obsv.Meter().NewInt64Counter("requests_total")
+	assert.NoError(t, err)
+	counter.Add(context.Background(), 1)
+
+	r := httptest.NewRequest("GET", "/metrics", nil)
+	w := httptest.NewRecorder()
+	obsv.ServeHTTP(w, r)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "requests_total")
+
+	// Wait for the exporter to connect to the collector in the background
+	time.Sleep(100 * time.Millisecond)
+
+	// Metrics are pushed to the collector on shutdown
+	assert.NoError(t, obsv.Shutdown(context.Background()))
+	assert.Contains(t, string(collector.payload("/opentelemetry.proto.collector.metrics.v1.MetricsService/Export")), "requests_total")
+}
+
+func TestConfigsDeploymentColor(t *testing.T) {
+	tests := []struct {
+		name          string
+		configs       configs
+		expectedColor string
+	}{
+		{
+			name:          "NotSet",
+			configs:       configs{},
+			expectedColor: "",
+		},
+		{
+			name: "FromTag",
+			configs: configs{
+				tags: map[string]string{"color": "blue"},
+			},
+			expectedColor: "blue",
+		},
+		{
+			name: "FromOption",
+			configs: configs{
+				color: "canary",
+				tags:  map[string]string{"color": "blue"},
+			},
+			expectedColor: "canary",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expectedColor, tc.configs.deploymentColor())
+		})
+	}
+}
+
+func TestNewWithDeploymentColor(t *testing.T) {
+	tests := []struct {
+		name          string
+		opts          []Option
+		expectedColor string
+	}{
+		{
+			name:          "NotSet",
+			opts:          nil,
+			expectedColor: "",
+		},
+		{
+			name: "FromTag",
+			opts: []Option{
+				WithMetadata("my-service", "0.1.0", "production", "ca-central-1", map[string]string{"color": "blue"}),
+			},
+			expectedColor: "blue",
+		},
+		{
+			name: "FromOption",
+			opts: []Option{
+				WithDeploymentColor("canary"),
+			},
+			expectedColor: "canary",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			sr := new(oteltest.StandardSpanRecorder)
+			tracerProvider := oteltest.NewTracerProvider(oteltest.WithSpanRecorder(sr))
+
+			obsv := New(false, append(tc.opts, WithTracerProvider(tracerProvider))...)
+			defer obsv.Shutdown(context.Background())
+
+			_, span := obsv.Tracer().Start(context.Background(), "request")
+			span.End()
+
+			spans := sr.Completed()
+			assert.Len(t, spans, 1)
+
+			color, ok := spans[0].Attributes()[deploymentColorKey]
+			if tc.expectedColor == "" {
+				assert.False(t, ok)
+			} else {
+				assert.True(t, ok)
+				assert.Equal(t, tc.expectedColor, color.AsString())
+			}
+		})
+	}
+}
+
+func TestNewWithProviders(t *testing.T) {
+	impl, meterProvider := oteltest.NewMeterProvider()
+	sr := new(oteltest.StandardSpanRecorder)
+	tracerProvider := oteltest.NewTracerProvider(oteltest.WithSpanRecorder(sr))
+
+	obsv := New(false,
+		WithMetadata("my-service", "0.1.0", "production", "ca-central-1", nil),
+		WithMeterProvider(meterProvider),
+		WithTracerProvider(tracerProvider),
+		WithPrometheus(),
+		WithOpenTelemetry("localhost:55680", nil),
+	)
+	defer obsv.Shutdown(context.Background())
+
+	counter, err := obsv.Meter().NewInt64Counter("requests_total")
+	assert.NoError(t, err)
+	counter.Add(context.Background(), 1)
+
+	_, span := obsv.Tracer().Start(context.Background(), "request")
+	span.End()
+
+	measurements := oteltest.AsStructs(impl.MeasurementBatches)
+	assert.Len(t, measurements, 1)
+	assert.Equal(t, "requests_total", measurements[0].Name)
+
+	spans := sr.Completed()
+	assert.Len(t, spans, 1)
+	assert.Equal(t, "request", spans[0].Name())
+
+	// Prometheus should not be used for metrics
+	r := httptest.NewRequest("GET", "/metrics", nil)
+	w := httptest.NewRecorder()
+	obsv.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestInitLogger(t *testing.T) {
+	tests := []struct {
+		name          string
+		configs       configs
+		expectedLevel zapcore.Level
+	}{
+		{
+			name: "Production",
+			configs: configs{
+				name:        "my-service",
+				version:     "0.1.0",
+				environment: "production",
+				region:      "ca-central-1",
+				tags: map[string]string{
+					"domain": "auth",
+				},
 				loggerLevel: "warn",
 			},
 			expectedLevel: zapcore.WarnLevel,
@@ -333,26 +1005,845 @@ func TestInitLogger(t *testing.T) {
 	}
 }
 
-func TestInitPrometheus(t *testing.T) {
+func TestInitLoggerName(t *testing.T) {
+	tests := []struct {
+		name               string
+		configs            configs
+		expectedLoggerName string
+	}{
+		{
+			name: "Default",
+			configs: configs{
+				name:        "my-service",
+				loggerLevel: "info",
+			},
+			expectedLoggerName: "",
+		},
+		{
+			name: "WithLoggerName",
+			configs: configs{
+				name:        "my-service",
+				loggerLevel: "info",
+				loggerName:  "billing",
+			},
+			expectedLoggerName: "billing",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var loggerName string
+			tc.configs.loggerHooks = []func(zapcore.Entry) error{
+				func(e zapcore.Entry) error {
+					loggerName = e.LoggerName
+					return nil
+				},
+			}
+
+			logger, _, _ := initLogger(tc.configs)
+			logger.Info("Hello, World!")
+
+			assert.Equal(t, tc.expectedLoggerName, loggerName)
+		})
+	}
+}
+
+func TestInitLoggerErrorOutput(t *testing.T) {
+	tests := []struct {
+		name                     string
+		configs                  configs
+		expectedOutputPaths      []string
+		expectedErrorOutputPaths []string
+	}{
+		{
+			name: "Default",
+			configs: configs{
+				loggerLevel: "info",
+			},
+			expectedOutputPaths:      []string{"stdout"},
+			expectedErrorOutputPaths: []string{"stdout"},
+		},
+		{
+			name: "Stderr",
+			configs: configs{
+				loggerLevel:  "info",
+				loggerErrOut: []string{"stderr"},
+			},
+			expectedOutputPaths:      []string{"stdout"},
+			expectedErrorOutputPaths: []string{"stderr"},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			_, config, _ := initLogger(tc.configs)
+
+			assert.Equal(t, tc.expectedOutputPaths, config.OutputPaths)
+			assert.Equal(t, tc.expectedErrorOutputPaths, config.ErrorOutputPaths)
+		})
+	}
+}
+
+func TestInitLoggerInvalidOutput(t *testing.T) {
+	dir, err := ioutil.TempDir("", "logger")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	var messages []string
+	logger, config, shutdown := initLogger(configs{
+		name:         "my-service",
+		loggerLevel:  "info",
+		loggerErrOut: []string{filepath.Join(dir, "missing", "errors.log")},
+		loggerHooks: []func(zapcore.Entry) error{
+			func(e zapcore.Entry) error {
+				messages = append(messages, e.Message)
+				return nil
+			},
+		},
+	})
+
+	// The logger falls back to stdout instead of being nil
+	assert.NotNil(t, logger)
+	assert.NotNil(t, shutdown)
+	assert.Equal(t, []string{"stdout"}, config.OutputPaths)
+	assert.Equal(t, []string{"stdout"}, config.ErrorOutputPaths)
+
+	logger.Info("Hello, World!")
+	assert.Equal(t, []string{"Failed to open the logger outputs, logging to stdout.", "Hello, World!"}, messages)
+}
+
+func TestTimeEncoder(t *testing.T) {
+	ts := time.Date(2020, 12, 31, 23, 59, 59, 123456789, time.UTC)
+
+	tests := []struct {
+		name         string
+		format       string
+		expectedTime interface{}
+	}{
+		{
+			name:         "Default",
+			format:       "",
+			expectedTime: "2020-12-31T23:59:59.123456789Z",
+		},
+		{
+			name:         "RFC3339Nano",
+			format:       "rfc3339nano",
+			expectedTime: "2020-12-31T23:59:59.123456789Z",
+		},
+		{
+			name:         "RFC3339",
+			format:       "rfc3339",
+			expectedTime: "2020-12-31T23:59:59Z",
+		},
+		{
+			name:         "ISO8601",
+			format:       "iso8601",
+			expectedTime: "2020-12-31T23:59:59.123Z",
+		},
+		{
+			name:         "Epoch",
+			format:       "epoch",
+			expectedTime: float64(ts.UnixNano()) / float64(time.Second),
+		},
+		{
+			name:         "EpochMillis",
+			format:       "EpochMillis",
+			expectedTime: float64(ts.UnixNano()) / float64(time.Millisecond),
+		},
+		{
+			name:         "Unknown",
+			format:       "unix",
+			expectedTime: "2020-12-31T23:59:59.123456789Z",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			enc := zapcore.NewMapObjectEncoder()
+			err := enc.AddArray("ts", zapcore.ArrayMarshalerFunc(func(arr zapcore.ArrayEncoder) error {
+				timeEncoder(tc.format)(ts, arr)
+				return nil
+			}))
+
+			assert.NoError(t, err)
+			assert.Equal(t, []interface{}{tc.expectedTime}, enc.Fields["ts"])
+		})
+	}
+}
+
+func TestInitLoggerWithHooks(t *testing.T) {
+	var entries []zapcore.Entry
+
+	c := configs{
+		name:        "my-service",
+		loggerLevel: "error",
+		loggerHooks: []func(zapcore.Entry) error{
+			func(e zapcore.Entry) error {
+				entries = append(entries, e)
+				return nil
+			},
+		},
+	}
+
+	logger, _, _ := initLogger(c)
+	logger.Info("this entry is not enabled")
+	logger.Error("something went wrong")
+
+	assert.Len(t, entries, 1)
+	assert.Equal(t, zapcore.ErrorLevel, entries[0].Level)
+	assert.Equal(t, "something went wrong", entries[0].Message)
+}
+
+func TestInitLoggerWithStackTrace(t *testing.T) {
+	warnLevel := zapcore.WarnLevel
+	fatalLevel := zapcore.FatalLevel
+
+	tests := []struct {
+		name               string
+		stackLevel         *zapcore.Level
+		expectedWarnStack  bool
+		expectedErrorStack bool
+	}{
+		{
+			name:               "Default",
+			stackLevel:         nil,
+			expectedWarnStack:  false,
+			expectedErrorStack: false,
+		},
+		{
+			name:               "WarnLevel",
+			stackLevel:         &warnLevel,
+			expectedWarnStack:  true,
+			expectedErrorStack: true,
+		},
+		{
+			name:               "FatalLevel",
+			stackLevel:         &fatalLevel,
+			expectedWarnStack:  false,
+			expectedErrorStack: false,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var entries []zapcore.Entry
+
+			c := configs{
+				name:        "my-service",
+				loggerLevel: "info",
+				loggerStack: tc.stackLevel,
+				loggerHooks: []func(zapcore.Entry) error{
+					func(e zapcore.Entry) error {
+						entries = append(entries, e)
+						return nil
+					},
+				},
+			}
+
+			logger, _, _ := initLogger(c)
+			logger.Warn("something is not right")
+			logger.Error("something went wrong")
+
+			assert.Len(t, entries, 2)
+			assert.Equal(t, tc.expectedWarnStack, entries[0].Stack != "")
+			assert.Equal(t, tc.expectedErrorStack, entries[1].Stack != "")
+		})
+	}
+}
+
+func TestInitLoggerWithScrubbers(t *testing.T) {
+	var entries []zapcore.Entry
+
+	c := configs{
+		name:        "my-service",
+		loggerLevel: "info",
+		loggerHooks: []func(zapcore.Entry) error{
+			func(e zapcore.Entry) error {
+				entries = append(entries, e)
+				return nil
+			},
+		},
+		logScrubbers: []*regexp.Regexp{
+			regexp.MustCompile(`[a-z0-9._%+-]+@[a-z0-9.-]+\.[a-z]{2,}`),
+		},
+	}
+
+	logger, _, _ := initLogger(c)
+	logger.Info("user jane@example.com signed up")
+
+	assert.Len(t, entries, 1)
+	assert.Equal(t, "user [SCRUBBED] signed up", entries[0].Message)
+}
+
+func TestScrubCore(t *testing.T) {
+	patterns := []*regexp.Regexp{
+		regexp.MustCompile(`[a-z0-9._%+-]+@[a-z0-9.-]+\.[a-z]{2,}`),
+		regexp.MustCompile(`[0-9]{4}-[0-9]{4}-[0-9]{4}-[0-9]{4}`),
+	}
+
+	tests := []struct {
+		name            string
+		message         string
+		fields          []zap.Field
+		expectedMessage string
+		expectedFields  map[string]interface{}
+	}{
+		{
+			name:            "NoMatch",
+			message:         "user signed up",
+			fields:          []zap.Field{zap.String("user", "jane"), zap.Int("age", 42)},
+			expectedMessage: "user signed up",
+			expectedFields: map[string]interface{}{
+				"user":    "jane",
+				"age":     int64(42),
+				"context": "contact [SCRUBBED]",
+			},
+		},
+		{
+			name:            "EmailInMessage",
+			message:         "user jane@example.com signed up",
+			fields:          []zap.Field{},
+			expectedMessage: "user [SCRUBBED] signed up",
+			expectedFields: map[string]interface{}{
+				"context": "contact [SCRUBBED]",
+			},
+		},
+		{
+			name:            "EmailAndCardInFields",
+			message:         "payment received",
+			fields:          []zap.Field{zap.String("email", "jane@example.com"), zap.String("card", "card 1234-5678-9012-3456")},
+			expectedMessage: "payment received",
+			expectedFields: map[string]interface{}{
+				"email":   "[SCRUBBED]",
+				"card":    "card [SCRUBBED]",
+				"context": "contact [SCRUBBED]",
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			core, logs := zapobserver.New(zapcore.InfoLevel)
+			logger := zap.New(newScrubCore(core, patterns))
+			logger = logger.With(zap.String("context", "contact jane@example.com"))
+			logger.Info(tc.message, tc.fields...)
+			logger.Debug("this entry is not enabled")
+
+			entries := logs.All()
+			assert.Len(t, entries, 1)
+			assert.Equal(t, tc.expectedMessage, entries[0].Message)
+			assert.Equal(t, tc.expectedFields, entries[0].ContextMap())
+		})
+	}
+}
+
+func TestInitLoggerWithMaxMessageLength(t *testing.T) {
+	var entries []zapcore.Entry
+
+	c := configs{
+		name:          "my-service",
+		loggerLevel:   "info",
+		logMaxMessage: 16,
+		loggerHooks: []func(zapcore.Entry) error{
+			func(e zapcore.Entry) error {
+				entries = append(entries, e)
+				return nil
+			},
+		},
+		logScrubbers: []*regexp.Regexp{
+			regexp.MustCompile(`[a-z0-9._%+-]+@[a-z0-9.-]+\.[a-z]{2,}`),
+		},
+	}
+
+	logger, _, _ := initLogger(c)
+	logger.Info("short message")
+	logger.Info("user jane@example.com signed up")
+
+	assert.Len(t, entries, 2)
+	assert.Equal(t, "short message", entries[0].Message)
+	// Messages are scrubbed before they are truncated
+	assert.Equal(t, "user [SCRUBBED] …(truncated)", entries[1].Message)
+}
+
+func TestTruncateCore(t *testing.T) {
+	tests := []struct {
+		name            string
+		maxMessage      int
+		maxField        int
+		message         string
+		fields          []zap.Field
+		expectedMessage string
+		expectedFields  map[string]interface{}
+	}{
+		{
+			name:            "Unlimited",
+			maxMessage:      0,
+			maxField:        0,
+			message:         "this is a long message",
+			fields:          []zap.Field{zap.String("user", "jane doe")},
+			expectedMessage: "this is a long message",
+			expectedFields: map[string]interface{}{
+				"context": "long context value",
+				"user":    "jane doe",
+			},
+		},
+		{
+			name:            "ShortMessage",
+			maxMessage:      32,
+			maxField:        0,
+			message:         "this is a long message",
+			fields:          []zap.Field{zap.String("user", "jane doe")},
+			expectedMessage: "this is a long message",
+			expectedFields: map[string]interface{}{
+				"context": "long context value",
+				"user":    "jane doe",
+			},
+		},
+		{
+			name:            "LongMessage",
+			maxMessage:      9,
+			maxField:        0,
+			message:         "this is a long message",
+			fields:          []zap.Field{zap.String("user", "jane doe")},
+			expectedMessage: "this is a…(truncated)",
+			expectedFields: map[string]interface{}{
+				"context": "long context value",
+				"user":    "jane doe",
+			},
+		},
+		{
+			name:            "MultiByteCharacters",
+			maxMessage:      4,
+			maxField:        0,
+			message:         "héllo",
+			fields:          []zap.Field{},
+			expectedMessage: "hél…(truncated)",
+			expectedFields: map[string]interface{}{
+				"context": "long context value",
+			},
+		},
+		{
+			name:            "LongFields",
+			maxMessage:      0,
+			maxField:        4,
+			message:         "this is a long message",
+			fields:          []zap.Field{zap.String("user", "jane doe"), zap.Int("age", 42)},
+			expectedMessage: "this is a long message",
+			expectedFields: map[string]interface{}{
+				"context": "long…(truncated)",
+				"user":    "jane…(truncated)",
+				"age":     int64(42),
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			core, logs := zapobserver.New(zapcore.InfoLevel)
+			logger := zap.New(newTruncateCore(core, tc.maxMessage, tc.maxField))
+			logger = logger.With(zap.String("context", "long context value"))
+			logger.Info(tc.message, tc.fields...)
+			logger.Debug("this entry is not enabled")
+
+			entries := logs.All()
+			assert.Len(t, entries, 1)
+			assert.Equal(t, tc.expectedMessage, entries[0].Message)
+			assert.Equal(t, tc.expectedFields, entries[0].ContextMap())
+		})
+	}
+}
+
+func TestInitLogLevelMetrics(t *testing.T) {
+	tests := []struct {
+		name           string
+		logs           map[zapcore.Level]int
+		expectedCounts map[string]int64
+	}{
+		{
+			name: "OK",
+			logs: map[zapcore.Level]int{
+				zapcore.DebugLevel: 1,
+				zapcore.InfoLevel:  4,
+				zapcore.WarnLevel:  2,
+				zapcore.ErrorLevel: 3,
+			},
+			expectedCounts: map[string]int64{
+				"debug":  1,
+				"info":   4,
+				"warn":   2,
+				"error":  3,
+				"dpanic": 0,
+				"panic":  0,
+				"fatal":  0,
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			impl, meter := oteltest.NewMeter()
+			core := zapcore.NewCore(zapcore.NewJSONEncoder(zapcore.EncoderConfig{}), zapcore.AddSync(ioutil.Discard), zapcore.DebugLevel)
+
+			logger := initLogLevelMetrics(zap.New(core), meter)
+			for level, n := range tc.logs {
+				for i := 0; i < n; i++ {
+					logger.Check(level, "test").Write()
+				}
+			}
+
+			impl.RunAsyncInstruments()
+
+			counts := map[string]int64{}
+			for _, m := range oteltest.AsStructs(impl.MeasurementBatches) {
+				assert.Equal(t, "log_entries_total", m.Name)
+				counts[m.Labels["level"].AsString()] = m.Number.AsInt64()
+			}
+
+			assert.Equal(t, tc.expectedCounts, counts)
+		})
+	}
+}
+
+func TestInitRuntimeMetrics(t *testing.T) {
+	impl, meter := oteltest.NewMeter()
+	initRuntimeMetrics(meter, zap.NewNop())
+
+	impl.RunAsyncInstruments()
+
+	values := map[string]int64{}
+	for _, m := range oteltest.AsStructs(impl.MeasurementBatches) {
+		values[m.Name] = m.Number.AsInt64()
+	}
+
+	assert.Len(t, values, 3)
+	assert.Greater(t, values["runtime_go_goroutines"], int64(0))
+	assert.Greater(t, values["runtime_go_heap_alloc_bytes"], int64(0))
+	assert.Contains(t, values, "runtime_go_gc_total")
+}
+
+func TestInitBuildInfo(t *testing.T) {
+	c := configs{
+		version:     "0.1.0",
+		buildCommit: "abcdef0",
+		buildDate:   "2021-01-01T00:00:00Z",
+	}
+
+	impl, meter := oteltest.NewMeter()
+	initBuildInfo(c, meter, zap.NewNop())
+
+	impl.RunAsyncInstruments()
+
+	measurements := oteltest.AsStructs(impl.MeasurementBatches)
+	assert.Len(t, measurements, 1)
+	assert.Equal(t, "build_info", measurements[0].Name)
+	assert.Equal(t, int64(1), measurements[0].Number.AsInt64())
+	assert.Equal(t, "0.1.0", measurements[0].Labels["version"].AsString())
+	assert.Equal(t, "abcdef0", measurements[0].Labels["commit"].AsString())
+	assert.Equal(t, "2021-01-01T00:00:00Z", measurements[0].Labels["date"].AsString())
+	assert.Equal(t, runtime.Version(), measurements[0].Labels["goversion"].AsString())
+}
+
+func TestNewWithBuildInfo(t *testing.T) {
+	obsv := New(false,
+		WithMetadata("my-service", "0.1.0", "production", "ca-central-1", nil),
+		WithBuildInfo("abcdef0", "2021-01-01T00:00:00Z"),
+		WithPrometheus(),
+	)
+	defer obsv.Shutdown(context.Background())
+
+	r := httptest.NewRequest("GET", "/metrics", nil)
+	w := httptest.NewRecorder()
+	obsv.ServeHTTP(w, r)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `build_info{commit="abcdef0",date="2021-01-01T00:00:00Z",goversion="`+runtime.Version()+`",version="0.1.0"} 1`)
+}
+
+// setKubernetesEnvVars sets the environment variables of a Kubernetes pod for the duration of a test.
+func setKubernetesEnvVars(t *testing.T) {
+	vars := map[string]string{
+		"POD_NAME":       "my-service-6f7b9c8d5-x2k4p",
+		"POD_NAMESPACE":  "default",
+		"POD_UID":        "",
+		"NODE_NAME":      "node-1",
+		"CONTAINER_NAME": "",
+	}
+
+	for name, value := range vars {
+		name := name
+		if orig, ok := os.LookupEnv(name); ok {
+			t.Cleanup(func() { os.Setenv(name, orig) })
+		} else {
+			t.Cleanup(func() { os.Unsetenv(name) })
+		}
+
+		assert.NoError(t, os.Setenv(name, value))
+	}
+}
+
+func TestInitialFields(t *testing.T) {
+	tests := []struct {
+		name         string
+		configs      configs
+		expectedKeys []string
+	}{
+		{
+			name:         "NoMetadata",
+			configs:      configs{},
+			expectedKeys: []string{},
+		},
+		{
+			name: "WithMetadata",
+			configs: configs{
+				name:        "my-service",
+				version:     "0.1.0",
+				environment: "production",
+				region:      "ca-central-1",
+				tags: map[string]string{
+					"team":   "backend",
+					"domain": "auth",
+					"tier":   "api",
+					"app":    "accounts",
+				},
+			},
+			expectedKeys: []string{"service", "logger", "version", "environment", "region", "app", "domain", "team", "tier"},
+		},
+		{
+			name: "WithKubernetesMetadata",
+			configs: configs{
+				name:       "my-service",
+				kubernetes: true,
+			},
+			expectedKeys: []string{"service", "logger", "k8s.pod.name", "k8s.namespace.name", "k8s.node.name"},
+		},
+		{
+			name: "WithLoggerName",
+			configs: configs{
+				name:       "my-service",
+				version:    "0.1.0",
+				loggerName: "billing",
+			},
+			expectedKeys: []string{"service", "version"},
+		},
+	}
+
+	setKubernetesEnvVars(t)
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			// Map iteration order is random, so the order should be verified across multiple runs
+			for i := 0; i < 10; i++ {
+				fields := initialFields(tc.configs)
+
+				keys := []string{}
+				for _, f := range fields {
+					keys = append(keys, f.Key)
+				}
+
+				assert.Equal(t, tc.expectedKeys, keys)
+			}
+		})
+	}
+}
+
+func TestInitPrometheus(t *testing.T) {
+	tests := []struct {
+		name                string
+		configs             configs
+		accept              string
+		expectedContentType string
+	}{
+		{
+			name: "Production",
+			configs: configs{
+				name:              "my-service",
+				prometheusEnabled: true,
+			},
+			accept:              "",
+			expectedContentType: "text/plain; version=0.0.4",
+		},
+		{
+			name: "OpenMetricsNotRequested",
+			configs: configs{
+				name:                  "my-service",
+				prometheusEnabled:     true,
+				prometheusOpenMetrics: true,
+			},
+			accept:              "",
+			expectedContentType: "text/plain; version=0.0.4",
+		},
+		{
+			name: "OpenMetrics",
+			configs: configs{
+				name:                  "my-service",
+				prometheusEnabled:     true,
+				prometheusOpenMetrics: true,
+			},
+			accept:              "application/openmetrics-text; version=0.0.1",
+			expectedContentType: "application/openmetrics-text; version=0.0.1",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			meter, handler, _ := initPrometheus(tc.configs)
+
+			assert.NotNil(t, meter)
+			assert.NotNil(t, handler)
+
+			req := httptest.NewRequest("GET", "/metrics", nil)
+			req.Header.Set("Accept", tc.accept)
+			resp := httptest.NewRecorder()
+			handler.ServeHTTP(resp, req)
+
+			assert.Equal(t, http.StatusOK, resp.Code)
+			assert.Contains(t, resp.Header().Get("Content-Type"), tc.expectedContentType)
+		})
+	}
+}
+
+func TestInitPrometheusConstLabels(t *testing.T) {
+	c := configs{
+		name:              "my-service",
+		prometheusEnabled: true,
+		prometheusConstLabels: map[string]string{
+			"role": "worker",
+		},
+	}
+
+	meter, handler, _ := initPrometheus(c)
+
+	counter, err := meter.NewInt64Counter("jobs_total")
+	assert.NoError(t, err)
+	counter.Add(context.Background(), 1)
+
+	req := httptest.NewRequest("GET", "/metrics", nil)
+	resp := httptest.NewRecorder()
+	handler.ServeHTTP(resp, req)
+
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.Contains(t, resp.Body.String(), `jobs_total{role="worker"} 1`)
+	assert.Contains(t, resp.Body.String(), `go_goroutines{role="worker"}`)
+}
+
+func TestInitPrometheusVersionLabel(t *testing.T) {
+	tests := []struct {
+		name            string
+		constLabels     map[string]string
+		expectedMetrics []string
+	}{
+		{
+			name:        "VersionOnly",
+			constLabels: nil,
+			expectedMetrics: []string{
+				`incoming_http_requests_total{version="0.1.0"} 1`,
+				"go_goroutines ",
+			},
+		},
+		{
+			name:        "WithConstLabels",
+			constLabels: map[string]string{"role": "worker"},
+			expectedMetrics: []string{
+				`incoming_http_requests_total{role="worker",version="0.1.0"} 1`,
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			c := configs{
+				name:                   "my-service",
+				version:                "0.1.0",
+				prometheusEnabled:      true,
+				prometheusConstLabels:  tc.constLabels,
+				prometheusVersionLabel: true,
+			}
+
+			meter, handler, _ := initPrometheus(c)
+
+			counter, err := meter.NewInt64Counter("incoming_http_requests_total")
+			assert.NoError(t, err)
+			counter.Add(context.Background(), 1)
+
+			req := httptest.NewRequest("GET", "/metrics", nil)
+			resp := httptest.NewRecorder()
+			handler.ServeHTTP(resp, req)
+
+			assert.Equal(t, http.StatusOK, resp.Code)
+			for _, m := range tc.expectedMetrics {
+				assert.Contains(t, resp.Body.String(), m)
+			}
+		})
+	}
+}
+
+func TestProcessTags(t *testing.T) {
+	hostname, _ := os.Hostname()
+
 	tests := []struct {
-		name    string
-		configs configs
+		name         string
+		configs      configs
+		expectedTags []label.KeyValue
 	}{
 		{
-			name: "Production",
+			name:    "Defaults",
+			configs: configs{},
+			expectedTags: []label.KeyValue{
+				label.String("host.name", hostname),
+				label.Int("process.pid", os.Getpid()),
+			},
+		},
+		{
+			name: "WithTags",
 			configs: configs{
-				name:              "my-service",
-				prometheusEnabled: true,
+				tags: map[string]string{
+					"domain": "auth",
+				},
+				processTags: map[string]string{
+					"build.hash": "abcdef0",
+				},
+			},
+			expectedTags: []label.KeyValue{
+				label.String("build.hash", "abcdef0"),
+				label.String("domain", "auth"),
+				label.String("host.name", hostname),
+				label.Int("process.pid", os.Getpid()),
+			},
+		},
+		{
+			name: "WithKubernetesMetadata",
+			configs: configs{
+				kubernetes: true,
+			},
+			expectedTags: []label.KeyValue{
+				label.String("host.name", hostname),
+				label.String("k8s.namespace.name", "default"),
+				label.String("k8s.node.name", "node-1"),
+				label.String("k8s.pod.name", "my-service-6f7b9c8d5-x2k4p"),
+				label.Int("process.pid", os.Getpid()),
+			},
+		},
+		{
+			name: "OverrideDefaults",
+			configs: configs{
+				processTags: map[string]string{
+					"host.name": "my-host",
+				},
+			},
+			expectedTags: []label.KeyValue{
+				label.String("host.name", "my-host"),
+				label.Int("process.pid", os.Getpid()),
 			},
 		},
 	}
 
+	setKubernetesEnvVars(t)
+
 	for _, tc := range tests {
 		t.Run(tc.name, func(t *testing.T) {
-			meter, handler := initPrometheus(tc.configs)
+			tags := processTags(tc.configs)
 
-			assert.NotNil(t, meter)
-			assert.NotNil(t, handler)
+			assert.Equal(t, tc.expectedTags, tags)
 		})
 	}
 }
@@ -399,10 +1890,156 @@ func TestInitJaeger(t *testing.T) {
 	}
 }
 
+func TestTextMapPropagator(t *testing.T) {
+	tests := []struct {
+		name           string
+		configs        configs
+		expectedFields []string
+	}{
+		{
+			name:           "Default",
+			configs:        configs{},
+			expectedFields: []string{"traceparent", "tracestate"},
+		},
+		{
+			name: "B3Multi",
+			configs: configs{
+				b3Enabled: true,
+			},
+			expectedFields: []string{"traceparent", "tracestate", "x-b3-traceid", "x-b3-spanid", "x-b3-sampled", "x-b3-flags"},
+		},
+		{
+			name: "B3Single",
+			configs: configs{
+				b3Enabled:      true,
+				b3SingleHeader: true,
+			},
+			expectedFields: []string{"traceparent", "tracestate", "b3"},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			p := textMapPropagator(tc.configs)
+
+			assert.ElementsMatch(t, tc.expectedFields, p.Fields())
+		})
+	}
+}
+
+func TestNewWithB3Propagation(t *testing.T) {
+	traceID, _ := trace.TraceIDFromHex("4bf92f3577b34da6a3ce929d0e0e4736")
+	spanID, _ := trace.SpanIDFromHex("00f067aa0ba902b7")
+
+	tests := []struct {
+		name            string
+		singleHeader    bool
+		headers         map[string]string
+		expectedHeaders []string
+	}{
+		{
+			name:         "MultiHeader",
+			singleHeader: false,
+			headers: map[string]string{
+				"X-B3-TraceId": "4bf92f3577b34da6a3ce929d0e0e4736",
+				"X-B3-SpanId":  "00f067aa0ba902b7",
+				"X-B3-Sampled": "1",
+			},
+			expectedHeaders: []string{"traceparent", "tracestate", "x-b3-traceid", "x-b3-spanid", "x-b3-sampled"},
+		},
+		{
+			name:         "SingleHeader",
+			singleHeader: true,
+			headers: map[string]string{
+				"b3": "4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-1",
+			},
+			expectedHeaders: []string{"traceparent", "tracestate", "b3"},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			defer otel.SetTextMapPropagator(otel.GetTextMapPropagator())
+			otel.SetTextMapPropagator(propagation.TraceContext{})
+
+			obsv := New(false, WithB3Propagation(tc.singleHeader))
+			assert.NotNil(t, obsv)
+
+			header := http.Header{}
+			for k, v := range tc.headers {
+				header.Set(k, v)
+			}
+
+			// Incoming B3 headers are extracted
+			ctx := otel.GetTextMapPropagator().Extract(context.Background(), header)
+			sc := trace.RemoteSpanContextFromContext(ctx)
+			assert.Equal(t, traceID, sc.TraceID)
+			assert.Equal(t, spanID, sc.SpanID)
+			assert.True(t, sc.IsSampled())
+
+			// Outgoing B3 headers are injected in the chosen format
+			tracer := oteltest.NewTracerProvider().Tracer("test")
+			ctx, span := tracer.Start(ctx, "child")
+			defer span.End()
+
+			carrier := MapCarrier{}
+			otel.GetTextMapPropagator().Inject(ctx, carrier)
+			var keys []string
+			for k := range carrier {
+				keys = append(keys, k)
+			}
+			assert.ElementsMatch(t, tc.expectedHeaders, keys)
+		})
+	}
+}
+
+func TestAggregatorSelector(t *testing.T) {
+	tests := []struct {
+		name               string
+		kind               string
+		expectedAggregator export.Aggregator
+	}{
+		{
+			name:               "Default",
+			kind:               "",
+			expectedAggregator: &exact.Aggregator{},
+		},
+		{
+			name:               "Exact",
+			kind:               "exact",
+			expectedAggregator: &exact.Aggregator{},
+		},
+		{
+			name:               "Histogram",
+			kind:               "histogram",
+			expectedAggregator: &histogram.Aggregator{},
+		},
+		{
+			name:               "Sketch",
+			kind:               "sketch",
+			expectedAggregator: &minmaxsumcount.Aggregator{},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			selector := aggregatorSelector(tc.kind)
+			desc := metric.NewDescriptor("duration", metric.ValueRecorderInstrumentKind, number.Int64Kind)
+
+			var agg export.Aggregator
+			selector.AggregatorFor(&desc, &agg)
+
+			assert.IsType(t, tc.expectedAggregator, agg)
+		})
+	}
+}
+
 func TestInitOpenTelemetry(t *testing.T) {
 	tests := []struct {
-		name    string
-		configs configs
+		name           string
+		configs        configs
+		expectedMeter  bool
+		expectedTracer bool
 	}{
 		{
 			name: "Insecure",
@@ -411,6 +2048,52 @@ func TestInitOpenTelemetry(t *testing.T) {
 				opentelemetryEnabled:          true,
 				opentelemetryCollectorAddress: "localhost:55680",
 			},
+			expectedMeter:  true,
+			expectedTracer: true,
+		},
+		{
+			name: "WithPrometheus",
+			configs: configs{
+				name:                          "my-service",
+				prometheusEnabled:             true,
+				opentelemetryEnabled:          true,
+				opentelemetryCollectorAddress: "localhost:55680",
+			},
+			expectedMeter:  true,
+			expectedTracer: true,
+		},
+		{
+			name: "TracesOnly",
+			configs: configs{
+				name:                          "my-service",
+				opentelemetryEnabled:          true,
+				opentelemetryCollectorAddress: "localhost:55680",
+				opentelemetryTracesOnly:       true,
+			},
+			expectedMeter:  false,
+			expectedTracer: true,
+		},
+		{
+			name: "MetricsOnly",
+			configs: configs{
+				name:                          "my-service",
+				opentelemetryEnabled:          true,
+				opentelemetryCollectorAddress: "localhost:55680",
+				opentelemetryMetricsOnly:      true,
+			},
+			expectedMeter:  true,
+			expectedTracer: false,
+		},
+		{
+			name: "WithReconnectionPeriod",
+			configs: configs{
+				name:                            "my-service",
+				opentelemetryEnabled:            true,
+				opentelemetryCollectorAddress:   "localhost:55680",
+				opentelemetryReconnectionPeriod: 5 * time.Second,
+			},
+			expectedMeter:  true,
+			expectedTracer: true,
 		},
 	}
 
@@ -419,13 +2102,79 @@ func TestInitOpenTelemetry(t *testing.T) {
 			meter, tracer, shutdown := initOpenTelemetry(tc.configs)
 			defer shutdown(context.Background())
 
-			assert.NotNil(t, meter)
-			assert.NotNil(t, tracer)
+			assert.Equal(t, tc.expectedMeter, meter != (metric.Meter{}))
+			assert.Equal(t, tc.expectedTracer, tracer != nil)
 			assert.NotNil(t, shutdown)
 		})
 	}
 }
 
+func TestInitOpenTelemetryUnavailableCollector(t *testing.T) {
+	// Nothing is listening on this address
+	c := configs{
+		name:                            "my-service",
+		opentelemetryEnabled:            true,
+		opentelemetryCollectorAddress:   "127.0.0.1:1",
+		opentelemetryReconnectionPeriod: 10 * time.Millisecond,
+	}
+
+	var meter metric.Meter
+	var tracer trace.Tracer
+	var shutdown shutdownFunc
+
+	assert.NotPanics(t, func() {
+		meter, tracer, shutdown = initOpenTelemetry(c)
+	})
+
+	assert.NotEqual(t, metric.Meter{}, meter)
+	assert.NotNil(t, tracer)
+
+	counter, err := meter.NewInt64Counter("requests_total")
+	assert.NoError(t, err)
+	counter.Add(context.Background(), 1)
+
+	_, span := tracer.Start(context.Background(), "test")
+	span.End()
+
+	// Wait for a few reconnection attempts
+	time.Sleep(50 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	assert.NoError(t, shutdown(ctx))
+}
+
+func TestNewNoop(t *testing.T) {
+	obsv := NewNoop()
+	assert.NotNil(t, obsv)
+
+	assert.NoError(t, obsv.Shutdown(context.Background()))
+	assert.Equal(t, "", obsv.Name())
+	assert.NotNil(t, obsv.Logger())
+	obsv.Logger().Info("test")
+
+	obsv.SetLogLevel(zapcore.WarnLevel)
+	assert.Equal(t, zapcore.WarnLevel, obsv.GetLogLevel())
+
+	obsv.SetTag("deployment", "blue")
+
+	counter, err := obsv.Meter().NewInt64Counter("test_total")
+	assert.NoError(t, err)
+	counter.Add(context.Background(), 1)
+
+	_, span := obsv.Tracer().Start(context.Background(), "test")
+	span.End()
+
+	r := httptest.NewRequest("GET", "/metrics", nil)
+	w := httptest.NewRecorder()
+	obsv.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	_, err = obsv.Gather()
+	assert.Error(t, err)
+}
+
 func TestObserverShutdown(t *testing.T) {
 	tests := []struct {
 		name          string
@@ -472,6 +2221,73 @@ func TestObserverShutdown(t *testing.T) {
 	}
 }
 
+func TestObserverShutdownReport(t *testing.T) {
+	core, logs := zapobserver.New(zapcore.InfoLevel)
+	o := &observer{
+		logger: zap.New(core),
+	}
+
+	var entriesBeforeLoggerFlush int
+	o.addShutdownFunc("logger", func(context.Context) error {
+		entriesBeforeLoggerFlush = logs.Len()
+		return nil
+	})
+	o.addShutdownFunc("jaeger", func(context.Context) error {
+		time.Sleep(20 * time.Millisecond)
+		return nil
+	})
+	o.addShutdownFunc("statsd", func(context.Context) error {
+		return errors.New("error on flushing")
+	})
+
+	err := o.Shutdown(context.Background())
+	assert.EqualError(t, err, "1 error occurred:\n\t* error on flushing\n\n")
+
+	// The report is logged before the logger is flushed
+	assert.Equal(t, 1, entriesBeforeLoggerFlush)
+
+	entries := logs.All()
+	assert.Len(t, entries, 1)
+	assert.Equal(t, zapcore.InfoLevel, entries[0].Level)
+	assert.Equal(t, "Observer shut down.", entries[0].Message)
+
+	fields := entries[0].ContextMap()
+	assert.GreaterOrEqual(t, fields["shutdown.jaeger.duration"], int64(20))
+	assert.GreaterOrEqual(t, fields["shutdown.duration"], fields["shutdown.jaeger.duration"])
+	assert.Contains(t, fields, "shutdown.statsd.duration")
+	assert.Equal(t, "error on flushing", fields["shutdown.statsd.error"])
+	assert.NotContains(t, fields, "shutdown.jaeger.error")
+	assert.NotContains(t, fields, "shutdown.logger.duration")
+}
+
+func TestObserverShutdownOrder(t *testing.T) {
+	var order []string
+	newShutdownFunc := func(name string, err error) shutdownFunc {
+		return func(context.Context) error {
+			order = append(order, name)
+			return err
+		}
+	}
+
+	o := &observer{
+		shutdownFuncs: []shutdownFunc{
+			newShutdownFunc("first", errors.New("error on closing first")),
+			newShutdownFunc("second", nil),
+			newShutdownFunc("third", errors.New("error on closing third")),
+		},
+	}
+
+	err := o.Shutdown(context.Background())
+
+	assert.Equal(t, []string{"third", "second", "first"}, order)
+
+	merr, ok := err.(*multierror.Error)
+	assert.True(t, ok)
+	assert.Len(t, merr.Errors, 2)
+	assert.EqualError(t, merr.Errors[0], "error on closing third")
+	assert.EqualError(t, merr.Errors[1], "error on closing first")
+}
+
 func TestObserverName(t *testing.T) {
 	tests := []struct {
 		name     string
@@ -688,11 +2504,158 @@ func TestObserverServeHTTP(t *testing.T) {
 	}
 }
 
+func TestObserverGather(t *testing.T) {
+	tests := []struct {
+		name          string
+		opts          []Option
+		expectedError string
+	}{
+		{
+			name:          "PrometheusNotEnabled",
+			opts:          nil,
+			expectedError: "Prometheus is not enabled",
+		},
+		{
+			name: "PrometheusEnabled",
+			opts: []Option{
+				WithPrometheus(),
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			obsv := New(false, tc.opts...)
+			defer obsv.Shutdown(context.Background())
+
+			counter, err := obsv.Meter().NewInt64Counter("requests_total")
+			assert.NoError(t, err)
+			counter.Add(context.Background(), 2, label.String("method", "GET"))
+
+			families, err := obsv.Gather()
+
+			if tc.expectedError != "" {
+				assert.Nil(t, families)
+				assert.EqualError(t, err, tc.expectedError)
+			} else {
+				assert.NoError(t, err)
+
+				values := map[string]float64{}
+				for _, f := range families {
+					for _, m := range f.GetMetric() {
+						if c := m.GetCounter(); c != nil {
+							values[f.GetName()] = c.GetValue()
+						}
+					}
+				}
+				assert.Equal(t, float64(2), values["requests_total"])
+			}
+		})
+	}
+}
+
+func TestObserverSpanLogger(t *testing.T) {
+	sampled := trace.SpanContext{
+		TraceID:    trace.TraceID{0x4b, 0xf9, 0x2f, 0x35, 0x77, 0xb3, 0x4d, 0xa6, 0xa3, 0xce, 0x92, 0x9d, 0x0e, 0x0e, 0x47, 0x36},
+		SpanID:     trace.SpanID{0x00, 0xf0, 0x67, 0xaa, 0x0b, 0xa9, 0x02, 0xb7},
+		TraceFlags: trace.FlagsSampled,
+	}
+
+	unsampled := sampled
+	unsampled.TraceFlags = 0
+
+	tests := []struct {
+		name            string
+		opts            []Option
+		sc              trace.SpanContext
+		expectedEntries []string
+	}{
+		{
+			name:            "Sampled",
+			opts:            []Option{WithLogger("info")},
+			sc:              sampled,
+			expectedEntries: []string{"info"},
+		},
+		{
+			name:            "DebugForSampledTraces_Sampled",
+			opts:            []Option{WithLogger("info"), WithLoggerDebugForSampledTraces()},
+			sc:              sampled,
+			expectedEntries: []string{"debug", "info"},
+		},
+		{
+			name:            "DebugForSampledTraces_Unsampled",
+			opts:            []Option{WithLogger("info"), WithLoggerDebugForSampledTraces()},
+			sc:              unsampled,
+			expectedEntries: []string{"info"},
+		},
+		{
+			name:            "DebugForSampledTraces_WithLogLevelMetrics",
+			opts:            []Option{WithLogger("info"), WithLoggerDebugForSampledTraces(), WithLogLevelMetrics()},
+			sc:              sampled,
+			expectedEntries: []string{"debug", "info"},
+		},
+		{
+			name:            "DebugForSampledTraces_None",
+			opts:            []Option{WithLogger("none"), WithLoggerDebugForSampledTraces()},
+			sc:              unsampled,
+			expectedEntries: nil,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var entries []string
+			hook := func(e zapcore.Entry) error {
+				entries = append(entries, e.Message)
+				return nil
+			}
+
+			opts := append(tc.opts, WithLoggerHooks(hook))
+			obsv := New(false, opts...)
+
+			logger := obsv.SpanLogger(tc.sc).With(zap.String("traceId", tc.sc.TraceID.String()))
+			logger.Debug("debug")
+			logger.Info("info")
+
+			// The observer logger always uses the logging level
+			obsv.Logger().Debug("debug")
+
+			assert.Equal(t, tc.expectedEntries, entries)
+		})
+	}
+}
+
+func TestObserverSetTag(t *testing.T) {
+	core, logs := zapobserver.New(zapcore.InfoLevel)
+	tg := newTags()
+	o := &observer{
+		logger: withTags(zap.New(core).With(zap.String("version", "0.1.0")), tg),
+		tags:   tg,
+	}
+
+	o.Logger().Info("before")
+	o.SetTag("deployment", "blue")
+	o.Logger().Info("after")
+	o.SetTag("deployment", "green")
+	o.Logger().Info("updated")
+
+	entries := logs.AllUntimed()
+	assert.Len(t, entries, 3)
+	assert.NotContains(t, entries[0].ContextMap(), "deployment")
+	assert.Equal(t, "blue", entries[1].ContextMap()["deployment"])
+	assert.Equal(t, "green", entries[2].ContextMap()["deployment"])
+	assert.Equal(t, "0.1.0", entries[2].ContextMap()["version"])
+}
+
 func TestSingleton(t *testing.T) {
 	tests := []struct {
 		name      string
 		singleton *observer
 	}{
+		{
+			name:      "Uninitialized",
+			singleton: noopSingleton,
+		},
 		{
 			name:      "OK",
 			singleton: &observer{},
@@ -707,3 +2670,59 @@ func TestSingleton(t *testing.T) {
 		})
 	}
 }
+
+func TestResetSingleton(t *testing.T) {
+	// Restore the singleton observer after the test
+	defer func(o *observer) { singleton = o }(singleton)
+
+	o1 := New(true, WithMetadata("service-1", "", "", "", nil))
+	assert.Equal(t, o1, Get())
+	assert.Equal(t, o1, ObserverFromContext(context.Background()))
+
+	ResetSingleton()
+	assert.Equal(t, noopSingleton, Get())
+	assert.Panics(t, func() { MustGet() })
+
+	// The second observer does not see the first one
+	o2 := New(true, WithMetadata("service-2", "", "", "", nil))
+	assert.Equal(t, o2, Get())
+	assert.Equal(t, "service-2", ObserverFromContext(context.Background()).Name())
+	assert.Equal(t, o2.Logger(), LoggerFromContext(context.Background()))
+
+	ResetSingleton()
+	assert.Equal(t, noopSingleton, Get())
+}
+
+func TestMustGet(t *testing.T) {
+	// Restore the singleton observer after the test
+	defer func(o *observer) { singleton = o }(singleton)
+
+	tests := []struct {
+		name          string
+		singleton     *observer
+		expectedPanic bool
+	}{
+		{
+			name:          "Uninitialized",
+			singleton:     noopSingleton,
+			expectedPanic: true,
+		},
+		{
+			name:          "OK",
+			singleton:     &observer{},
+			expectedPanic: false,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			singleton = tc.singleton
+
+			if tc.expectedPanic {
+				assert.Panics(t, func() { MustGet() })
+			} else {
+				assert.Equal(t, tc.singleton, MustGet())
+			}
+		})
+	}
+}
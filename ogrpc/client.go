@@ -3,10 +3,12 @@ package ogrpc
 import (
 	"context"
 	"fmt"
+	"strconv"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/moorara/observer"
+	"github.com/moorara/observer/internal/instrument"
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/baggage"
 	"go.opentelemetry.io/otel/codes"
@@ -25,10 +27,12 @@ type clientInstruments struct {
 	reqCounter  metric.Int64Counter
 	reqGauge    metric.Int64UpDownCounter
 	reqDuration metric.Int64ValueRecorder
+	reqSeconds  metric.Float64ValueRecorder
+	streamSend  metric.Int64ValueRecorder
 }
 
-func newClientInstruments(meter metric.Meter) *clientInstruments {
-	mm := metric.Must(meter)
+func newClientInstruments(meter metric.Meter, logger *zap.Logger) *clientInstruments {
+	mm := instrument.NewSafeMeter(meter, logger)
 
 	return &clientInstruments{
 		reqCounter: mm.NewInt64Counter(
@@ -45,13 +49,27 @@ func newClientInstruments(meter metric.Meter) *clientInstruments {
 		),
 		reqDuration: mm.NewInt64ValueRecorder(
 			"outgoing_grpc_requests_duration",
+			metric.WithDescription("The duration of outgoing grpc requests in milliseconds (client-side)"),
+			metric.WithUnit(unit.Milliseconds),
+			metric.WithInstrumentationName(libraryName),
+		),
+		reqSeconds: mm.NewFloat64ValueRecorder(
+			"outgoing_grpc_requests_duration_seconds",
 			metric.WithDescription("The duration of outgoing grpc requests in seconds (client-side)"),
+			metric.WithUnit(unitSeconds),
+			metric.WithInstrumentationName(libraryName),
+		),
+		streamSend: mm.NewInt64ValueRecorder(
+			"outgoing_grpc_streams_send_block_duration",
+			metric.WithDescription("The time sending messages on outgoing grpc streams blocked in milliseconds (client-side)"),
 			metric.WithUnit(unit.Milliseconds),
 			metric.WithInstrumentationName(libraryName),
 		),
 	}
 }
 
+var clientInstrumentsCache = instrument.NewCache()
+
 // ClientInterceptor creates interceptors with logging, metrics, and tracing for grpc clients.
 type ClientInterceptor struct {
 	opts        Options
@@ -62,7 +80,9 @@ type ClientInterceptor struct {
 // NewClientInterceptor creates a new server interceptor for observability.
 func NewClientInterceptor(observer observer.Observer, opts Options) *ClientInterceptor {
 	opts = opts.withDefaults()
-	instruments := newClientInstruments(observer.Meter())
+	instruments := clientInstrumentsCache.Get(observer.Meter(), func() interface{} {
+		return newClientInstruments(observer.Meter(), observer.Logger())
+	}).(*clientInstruments)
 
 	return &ClientInterceptor{
 		opts:        opts,
@@ -81,23 +101,21 @@ func (i *ClientInterceptor) DialOptions() []grpc.DialOption {
 }
 
 func (i *ClientInterceptor) unaryInterceptor(ctx context.Context, fullMethod string, req, res interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
-	startTime := time.Now()
+	startTime := i.opts.clock.Now()
 	kind := "client"
 	stream := false
 
+	// Check excluded methods before doing any work
+	if i.opts.isExcluded(fullMethod) {
+		return invoker(ctx, fullMethod, req, res, cc, opts...)
+	}
+
 	// Get the package, service, and method name for the request
 	e, ok := parseEndpoint(fullMethod)
 	if !ok {
 		return invoker(ctx, fullMethod, req, res, cc, opts...)
 	}
 
-	// Check excluded methods
-	for _, m := range i.opts.ExcludedMethods {
-		if e.Method == m {
-			return invoker(ctx, fullMethod, req, res, cc, opts...)
-		}
-	}
-
 	// Increase the number of in-flight requests
 	i.instruments.reqGauge.Add(ctx, 1,
 		label.String("package", e.Package),
@@ -131,6 +149,11 @@ func (i *ClientInterceptor) unaryInterceptor(ctx context.Context, fullMethod str
 	// Propagate request metadata by adding them to outgoing grpc request metadata
 	md.Set(requestUUIDKey, requestUUID)
 	md.Set(clientNameKey, i.observer.Name())
+	if i.opts.PropagateDeadline {
+		if deadline, ok := ctx.Deadline(); ok {
+			md.Set(requestDeadlineKey, strconv.FormatInt(time.Until(deadline).Milliseconds(), 10))
+		}
+	}
 	ctx = metadata.NewOutgoingContext(ctx, md)
 
 	// Create a new context
@@ -140,12 +163,18 @@ func (i *ClientInterceptor) unaryInterceptor(ctx context.Context, fullMethod str
 	)
 
 	// Start a new span
-	ctx, span := i.observer.Tracer().Start(ctx,
+	ctx, span := i.opts.tracer(e.Method, i.observer.Tracer()).Start(ctx,
 		fmt.Sprintf("%s (client unary)", e.Method),
 		trace.WithSpanKind(trace.SpanKindClient),
 	)
 	defer span.End()
 
+	// Record the compressor of the request if any
+	encoding := callEncoding(opts)
+	if encoding != "" {
+		span.SetAttributes(label.String("grpc.encoding", encoding))
+	}
+
 	// Inject the context and the span context into the grpc metadata
 	otel.GetTextMapPropagator().Inject(ctx, &metadataTextMapCarrier{md: &md})
 	ctx = metadata.NewOutgoingContext(ctx, md)
@@ -154,21 +183,27 @@ func (i *ClientInterceptor) unaryInterceptor(ctx context.Context, fullMethod str
 	span.AddEvent("invoking grpc method")
 	err := invoker(ctx, fullMethod, req, res, cc, opts...)
 
-	duration := time.Since(startTime).Milliseconds()
+	elapsed := i.opts.clock.Now().Sub(startTime)
+	duration := elapsed.Milliseconds()
 	success := err == nil
 
 	// Report metrics
-	i.observer.Meter().RecordBatch(ctx,
-		[]label.KeyValue{
-			label.String("package", e.Package),
-			label.String("service", e.Service),
-			label.String("method", e.Method),
-			label.Bool("stream", stream),
-			label.Bool("success", success),
-		},
+	labels := []label.KeyValue{
+		label.String("package", e.Package),
+		label.String("service", e.Service),
+		label.String("method", e.Method),
+		label.Bool("stream", stream),
+		label.Bool("success", success),
+	}
+	labels = append(labels, i.opts.tenantLabels(ctx)...)
+	labels = append(labels, i.opts.metricLabels(ctx, i.observer.Logger())...)
+	i.observer.Meter().RecordBatch(ctx, labels,
 		i.instruments.reqCounter.Measurement(1),
 		i.instruments.reqDuration.Measurement(duration),
 	)
+	if i.opts.DurationSeconds {
+		i.instruments.reqSeconds.Record(ctx, elapsed.Seconds(), labels...)
+	}
 
 	// Report logs
 	logger := i.observer.Logger()
@@ -185,10 +220,20 @@ func (i *ClientInterceptor) unaryInterceptor(ctx context.Context, fullMethod str
 		zap.String("traceId", span.SpanContext().TraceID.String()),
 		zap.String("spanId", span.SpanContext().SpanID.String()),
 	}
+	if encoding != "" {
+		fields = append(fields, zap.String("grpc.encoding", encoding))
+	}
 	if err != nil {
 		fields = append(fields, zap.String("grpc.error", err.Error()))
 	}
 
+	var typeAttrs []label.KeyValue
+	if i.opts.RecordMessageType {
+		var typeFields []zap.Field
+		typeFields, typeAttrs = messageTypes(req, res)
+		fields = append(fields, typeFields...)
+	}
+
 	// Determine the log level based on the result
 	if success {
 		if i.opts.LogInDebugLevel {
@@ -196,6 +241,8 @@ func (i *ClientInterceptor) unaryInterceptor(ctx context.Context, fullMethod str
 		} else {
 			logger.Info(message, fields...)
 		}
+	} else if i.opts.isQuiet(e.Method) {
+		logger.Info(message, fields...)
 	} else {
 		logger.Error(message, fields...)
 	}
@@ -208,6 +255,7 @@ func (i *ClientInterceptor) unaryInterceptor(ctx context.Context, fullMethod str
 		label.Bool("stream", stream),
 		label.Bool("success", success),
 	)
+	span.SetAttributes(typeAttrs...)
 	if err != nil {
 		code := codes.Code(status.Code(err))
 		span.SetStatus(code, err.Error())
@@ -217,23 +265,21 @@ func (i *ClientInterceptor) unaryInterceptor(ctx context.Context, fullMethod str
 }
 
 func (i *ClientInterceptor) streamInterceptor(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, fullMethod string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
-	startTime := time.Now()
+	startTime := i.opts.clock.Now()
 	kind := "client"
 	stream := true
 
+	// Check excluded methods before doing any work
+	if i.opts.isExcluded(fullMethod) {
+		return streamer(ctx, desc, cc, fullMethod, opts...)
+	}
+
 	// Get the package, service, and method name for the request
 	e, ok := parseEndpoint(fullMethod)
 	if !ok {
 		return streamer(ctx, desc, cc, fullMethod, opts...)
 	}
 
-	// Check excluded methods
-	for _, m := range i.opts.ExcludedMethods {
-		if e.Method == m {
-			return streamer(ctx, desc, cc, fullMethod, opts...)
-		}
-	}
-
 	// Increase the number of in-flight requests
 	i.instruments.reqGauge.Add(ctx, 1,
 		label.String("package", e.Package),
@@ -267,6 +313,11 @@ func (i *ClientInterceptor) streamInterceptor(ctx context.Context, desc *grpc.St
 	// Propagate request metadata by adding them to outgoing grpc request metadata
 	md.Set(requestUUIDKey, requestUUID)
 	md.Set(clientNameKey, i.observer.Name())
+	if i.opts.PropagateDeadline {
+		if deadline, ok := ctx.Deadline(); ok {
+			md.Set(requestDeadlineKey, strconv.FormatInt(time.Until(deadline).Milliseconds(), 10))
+		}
+	}
 	ctx = metadata.NewOutgoingContext(ctx, md)
 
 	// Create a new context
@@ -276,12 +327,18 @@ func (i *ClientInterceptor) streamInterceptor(ctx context.Context, desc *grpc.St
 	)
 
 	// Start a new span
-	ctx, span := i.observer.Tracer().Start(ctx,
+	ctx, span := i.opts.tracer(e.Method, i.observer.Tracer()).Start(ctx,
 		fmt.Sprintf("%s (client stream)", e.Method),
 		trace.WithSpanKind(trace.SpanKindClient),
 	)
 	defer span.End()
 
+	// Record the compressor of the request if any
+	encoding := callEncoding(opts)
+	if encoding != "" {
+		span.SetAttributes(label.String("grpc.encoding", encoding))
+	}
+
 	// Inject the context and the span context into the grpc metadata
 	otel.GetTextMapPropagator().Inject(ctx, &metadataTextMapCarrier{md: &md})
 	ctx = metadata.NewOutgoingContext(ctx, md)
@@ -290,21 +347,27 @@ func (i *ClientInterceptor) streamInterceptor(ctx context.Context, desc *grpc.St
 	span.AddEvent("invoking grpc method")
 	cs, err := streamer(ctx, desc, cc, fullMethod, opts...)
 
-	duration := time.Since(startTime).Milliseconds()
+	elapsed := i.opts.clock.Now().Sub(startTime)
+	duration := elapsed.Milliseconds()
 	success := err == nil
 
 	// Report metrics
-	i.observer.Meter().RecordBatch(ctx,
-		[]label.KeyValue{
-			label.String("package", e.Package),
-			label.String("service", e.Service),
-			label.String("method", e.Method),
-			label.Bool("stream", stream),
-			label.Bool("success", success),
-		},
+	labels := []label.KeyValue{
+		label.String("package", e.Package),
+		label.String("service", e.Service),
+		label.String("method", e.Method),
+		label.Bool("stream", stream),
+		label.Bool("success", success),
+	}
+	labels = append(labels, i.opts.tenantLabels(ctx)...)
+	labels = append(labels, i.opts.metricLabels(ctx, i.observer.Logger())...)
+	i.observer.Meter().RecordBatch(ctx, labels,
 		i.instruments.reqCounter.Measurement(1),
 		i.instruments.reqDuration.Measurement(duration),
 	)
+	if i.opts.DurationSeconds {
+		i.instruments.reqSeconds.Record(ctx, elapsed.Seconds(), labels...)
+	}
 
 	// Report logs
 	logger := i.observer.Logger()
@@ -321,6 +384,9 @@ func (i *ClientInterceptor) streamInterceptor(ctx context.Context, desc *grpc.St
 		zap.String("traceId", span.SpanContext().TraceID.String()),
 		zap.String("spanId", span.SpanContext().SpanID.String()),
 	}
+	if encoding != "" {
+		fields = append(fields, zap.String("grpc.encoding", encoding))
+	}
 	if err != nil {
 		fields = append(fields, zap.String("grpc.error", err.Error()))
 	}
@@ -332,6 +398,8 @@ func (i *ClientInterceptor) streamInterceptor(ctx context.Context, desc *grpc.St
 		} else {
 			logger.Info(message, fields...)
 		}
+	} else if i.opts.isQuiet(e.Method) {
+		logger.Info(message, fields...)
 	} else {
 		logger.Error(message, fields...)
 	}
@@ -349,5 +417,19 @@ func (i *ClientInterceptor) streamInterceptor(ctx context.Context, desc *grpc.St
 		span.SetStatus(code, err.Error())
 	}
 
+	// Measure how long sending messages blocks (i.e. flow control when the server is slow to receive messages)
+	if cs != nil {
+		cs = &timingClientStream{
+			ClientStream: cs,
+			onSend: func(d time.Duration) {
+				i.instruments.streamSend.Record(ctx, d.Milliseconds(),
+					label.String("package", e.Package),
+					label.String("service", e.Service),
+					label.String("method", e.Method),
+				)
+			},
+		}
+	}
+
 	return cs, err
 }
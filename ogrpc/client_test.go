@@ -3,13 +3,22 @@ package ogrpc
 import (
 	"context"
 	"errors"
+	"strconv"
 	"testing"
 	"time"
 
 	"github.com/moorara/observer"
 	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/label"
+	"go.opentelemetry.io/otel/oteltest"
+	"go.opentelemetry.io/otel/propagation"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	zapobserver "go.uber.org/zap/zaptest/observer"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/types/known/wrapperspb"
 )
 
 func TestClientUnaryInterceptor(t *testing.T) {
@@ -318,3 +327,181 @@ func TestClientStreamInterceptor(t *testing.T) {
 		})
 	}
 }
+
+func TestClientStreamInterceptorSendBlock(t *testing.T) {
+	impl, meter := oteltest.NewMeter()
+	obsv := newMockObserver()
+	obsv.meter = meter
+	ci := NewClientInterceptor(obsv, Options{})
+
+	ready := make(chan struct{})
+	streamer := func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		return &blockingSendClientStream{ready: ready}, nil
+	}
+
+	cs, err := ci.streamInterceptor(context.Background(), nil, nil, "/itemPB.ItemManager/CreateItems", streamer)
+	assert.NoError(t, err)
+
+	// The server is slow to receive the first message
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		close(ready)
+	}()
+
+	assert.NoError(t, cs.SendMsg(wrapperspb.String("hello")))
+	assert.NoError(t, cs.SendMsg(wrapperspb.String("hello")))
+
+	var blocks []int64
+	for _, m := range oteltest.AsStructs(impl.MeasurementBatches) {
+		if m.Name == "outgoing_grpc_streams_send_block_duration" {
+			blocks = append(blocks, m.Number.AsInt64())
+			assert.Equal(t, "CreateItems", m.Labels["method"].AsString())
+		}
+	}
+	assert.Len(t, blocks, 2)
+	assert.GreaterOrEqual(t, blocks[0], int64(20))
+	assert.Less(t, blocks[1], int64(20))
+}
+
+func TestClientInterceptorPropagateDeadline(t *testing.T) {
+	deadlineCtx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	tests := []struct {
+		name             string
+		opts             Options
+		ctx              context.Context
+		expectedDeadline bool
+	}{
+		{
+			name:             "Disabled",
+			opts:             Options{},
+			ctx:              deadlineCtx,
+			expectedDeadline: false,
+		},
+		{
+			name: "WithoutDeadline",
+			opts: Options{
+				PropagateDeadline: true,
+			},
+			ctx:              context.Background(),
+			expectedDeadline: false,
+		},
+		{
+			name: "WithDeadline",
+			opts: Options{
+				PropagateDeadline: true,
+			},
+			ctx:              deadlineCtx,
+			expectedDeadline: true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			obsv := newMockObserver()
+			ci := NewClientInterceptor(obsv, tc.opts)
+			assert.NotNil(t, ci)
+
+			verify := func(ctx context.Context) {
+				md, ok := metadata.FromOutgoingContext(ctx)
+				assert.True(t, ok)
+
+				vals := md.Get(requestDeadlineKey)
+				if !tc.expectedDeadline {
+					assert.Empty(t, vals)
+					return
+				}
+
+				assert.Len(t, vals, 1)
+				ms, err := strconv.ParseInt(vals[0], 10, 64)
+				assert.NoError(t, err)
+				assert.True(t, ms > 0 && ms <= time.Minute.Milliseconds())
+			}
+
+			t.Run("Unary", func(t *testing.T) {
+				invoker := func(ctx context.Context, method string, req, res interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+					verify(ctx)
+					return nil
+				}
+
+				err := ci.unaryInterceptor(tc.ctx, "/itemPB.ItemManager/GetItem", nil, nil, &grpc.ClientConn{}, invoker)
+				assert.NoError(t, err)
+			})
+
+			t.Run("Stream", func(t *testing.T) {
+				streamer := func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+					verify(ctx)
+					return nil, nil
+				}
+
+				_, err := ci.streamInterceptor(tc.ctx, &grpc.StreamDesc{}, &grpc.ClientConn{}, "/itemPB.ItemManager/GetItems", streamer)
+				assert.NoError(t, err)
+			})
+		})
+	}
+}
+
+func TestClientInterceptorMethodSamplingPropagation(t *testing.T) {
+	defer otel.SetTextMapPropagator(otel.GetTextMapPropagator())
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	sr := new(oteltest.StandardSpanRecorder)
+	obsv := newMockObserver()
+	obsv.tracer = oteltest.NewTracerProvider(oteltest.WithSpanRecorder(sr)).Tracer("")
+	ci := NewClientInterceptor(obsv, Options{
+		MethodSamplingRatios: map[string]float64{"GetItem": 0},
+	})
+
+	var traceparent string
+	invoker := func(ctx context.Context, method string, req, res interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		md, _ := metadata.FromOutgoingContext(ctx)
+		if vals := md.Get("traceparent"); len(vals) > 0 {
+			traceparent = vals[0]
+		}
+		return nil
+	}
+
+	err := ci.unaryInterceptor(context.Background(), "/itemPB.ItemManager/GetItem", nil, nil, &grpc.ClientConn{}, invoker)
+	assert.NoError(t, err)
+
+	// The span is not recorded, but the trace context is still injected as not sampled
+	assert.Empty(t, sr.Completed())
+	assert.Regexp(t, `^00-[0-9a-f]{32}-[0-9a-f]{16}-00$`, traceparent)
+	assert.NotContains(t, traceparent, "00000000000000000000000000000000")
+}
+
+func TestClientInterceptorEncoding(t *testing.T) {
+	core, logs := zapobserver.New(zapcore.DebugLevel)
+	sr := new(oteltest.StandardSpanRecorder)
+	obsv := newMockObserver()
+	obsv.logger = zap.New(core)
+	obsv.tracer = oteltest.NewTracerProvider(oteltest.WithSpanRecorder(sr)).Tracer("")
+	ci := NewClientInterceptor(obsv, Options{})
+
+	invoker := func(ctx context.Context, method string, req, res interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		return nil
+	}
+
+	streamer := func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		return nil, nil
+	}
+
+	err := ci.unaryInterceptor(context.Background(), "/itemPB.ItemManager/GetItem", nil, nil, &grpc.ClientConn{}, invoker, grpc.UseCompressor("gzip"))
+	assert.NoError(t, err)
+
+	_, err = ci.streamInterceptor(context.Background(), &grpc.StreamDesc{}, &grpc.ClientConn{}, "/itemPB.ItemManager/GetItems", streamer, grpc.UseCompressor("gzip"))
+	assert.NoError(t, err)
+
+	entries := logs.All()
+	assert.Len(t, entries, 2)
+	for _, e := range entries {
+		assert.Equal(t, "gzip", e.ContextMap()["grpc.encoding"])
+	}
+
+	spans := sr.Completed()
+	assert.Len(t, spans, 2)
+	for _, s := range spans {
+		assert.Equal(t, label.StringValue("gzip"), s.Attributes()["grpc.encoding"])
+	}
+}
@@ -4,33 +4,365 @@ package ogrpc
 
 import (
 	"context"
+	"crypto/rand"
 	"fmt"
 	"regexp"
+	"strings"
+	"sync/atomic"
+	"time"
 
+	"github.com/moorara/observer/internal/clock"
+	"github.com/moorara/observer/internal/request"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/label"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/unit"
+	"go.uber.org/zap"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/proto"
+
+	tracesdk "go.opentelemetry.io/otel/sdk/trace"
 )
 
 const (
-	libraryName    = "observer/ogrpc"
-	requestUUIDKey = "request-uuid"
-	clientNameKey  = "client-name"
+	libraryName        = "observer/ogrpc"
+	unitSeconds        = unit.Unit("s")
+	requestUUIDKey     = "request-uuid"
+	clientNameKey      = "client-name"
+	requestDeadlineKey = "x-request-deadline"
 )
 
 var (
 	fullMethodRegex = regexp.MustCompile(`/|\.`)
+
+	// defaultSystemMethods are the methods of the grpc health and reflection services.
+	defaultSystemMethods = []string{
+		"/grpc.health.v1.Health/Check",
+		"/grpc.health.v1.Health/Watch",
+		"/grpc.reflection.v1alpha.ServerReflection/ServerReflectionInfo",
+		"/grpc.reflection.v1.ServerReflection/ServerReflectionInfo",
+	}
 )
 
 // Options are optional configurations for creating interceptors.
 type Options struct {
 	LogInDebugLevel bool
 	ExcludedMethods []string
+
+	// RequestUUIDRegexp is the pattern that an incoming request uuid must match.
+	// Request uuids are received from untrusted clients and propagated into logs and response headers,
+	// so a request uuid that does not match the pattern, is longer than 128 characters, or has control characters
+	// is replaced with a new one. The default pattern accepts UUIDs and ULIDs.
+	// This is only used by server interceptors.
+	RequestUUIDRegexp *regexp.Regexp
+
+	// IgnoreIncomingRequestID determines whether or not the request uuid received from clients (request-uuid metadata)
+	// should be ignored. By default, a valid request uuid received from a client is reused for the request.
+	// If true, a new request uuid is always generated, so clients cannot make their request uuids collide
+	// with the ones of other clients (i.e. across tenants), and the request uuid claimed by the client is logged as client.request_uuid.
+	// This is only used by server interceptors.
+	IgnoreIncomingRequestID bool
+
+	// QuietMethods are the methods whose errors are logged at info level instead of error level.
+	// This is useful for methods that are expected to fail frequently (i.e. CreateIfNotExists).
+	// The errors are still reported accurately in metrics and spans.
+	QuietMethods []string
+
+	// PropagateDeadline determines whether or not the remaining time until the deadline of an outgoing request
+	// should be added to the request metadata (x-request-deadline) in milliseconds.
+	// This is only used by client interceptors and only if the request context has a deadline.
+	PropagateDeadline bool
+
+	// RecordCodeLocation determines whether or not the source code location of method handlers
+	// should be added to server spans (code.function, code.filepath, and code.lineno).
+	RecordCodeLocation bool
+
+	// ErrorStackInSpan determines whether or not the stack traces of the errors returned by grpc method handlers should be recorded.
+	// If an error (or an error in its chain) implements a StackTrace() method (i.e. pkg/errors), or it is a multierror,
+	// the stack trace is recorded as a span event (exception.stacktrace) and a log field (error.stacks).
+	// Errors without stack traces are reported as before.
+	// This is only used by server interceptors.
+	ErrorStackInSpan bool
+
+	// BaggageToLogs determines whether or not the baggage key-values of a request
+	// should be added as fields (prefixed with baggage.) to the contextual logger.
+	// This is only used by server interceptors.
+	BaggageToLogs bool
+
+	// TenantBaggageKey is the baggage key for reading the tenant of a request.
+	// If set, the tenant will be added as a label (tenant) to request metrics.
+	// For controlling the cardinality of metrics, tenants not in TenantLabelAllowlist are reported as "other".
+	TenantBaggageKey     string
+	TenantLabelAllowlist []string
+
+	// MetricLabelsFromContext computes custom labels for request metrics from the context of a request
+	// (i.e. the region or the plan tier of a customer read from baggage).
+	// The labels are added to the request metrics after the built-in labels.
+	// Every distinct combination of label values creates a new metric series, so the function is responsible
+	// for returning only a small and bounded set of values (i.e. by mapping unknown values to "other").
+	// A panic in this function is recovered and logged, and no label is added.
+	MetricLabelsFromContext func(context.Context) []label.KeyValue
+
+	// AttributesFromRequest computes custom attributes from a request message (i.e. the user id in a GetUser request).
+	// The attributes are added to the span and as fields to the contextual logger.
+	// A panic in this function is recovered and logged, and no attribute is added.
+	// This is only used by server interceptors for unary calls, since stream messages are received after the call is intercepted.
+	AttributesFromRequest func(fullMethod string, req interface{}) []label.KeyValue
+
+	// MethodSamplingRatios specifies the ratio of requests that should be traced for each method.
+	// The sampling decision is made by the interceptors based on the trace id and the decision of a parent span is respected.
+	// Requests not sampled still propagate the trace context, so logs and downstream services keep the same trace id.
+	// The spans for the methods not specified here are sampled by the sampler of the observer tracer.
+	MethodSamplingRatios map[string]float64
+
+	// SuppressResponseMetadata determines whether or not the request metadata (request-uuid and client-name)
+	// should be left out of the response header metadata. The request metadata are still used for logging and tracing.
+	// This prevents echoing internal metadata (i.e. the client name) back to untrusted clients.
+	// SuppressedResponseMetadata can be used for leaving out only some of the keys (i.e. client-name).
+	// This is only used by server interceptors.
+	SuppressResponseMetadata   bool
+	SuppressedResponseMetadata []string
+
+	// StreamSendBlockThreshold is the threshold for recording a span event (send blocked)
+	// when sending a stream message blocks for longer than this threshold (i.e. flow control due to a slow receiver).
+	// The time sending every stream message blocks is reported as a histogram regardless of this threshold.
+	// Span events are only recorded by server interceptors, since client spans end once a stream is created.
+	// If not set, no span event is recorded.
+	StreamSendBlockThreshold time.Duration
+
+	// RecordMessageType determines whether or not the proto message types of requests and responses (i.e. itemPB.GetItemRequest)
+	// should be added to spans and logs (req.type and resp.type). Messages that are not proto messages are skipped.
+	// Message types have a low cardinality, so they can be used for correlating behavior with the shape of messages.
+	// This is only used for unary calls, since stream messages are sent and received after the call is intercepted.
+	RecordMessageType bool
+
+	// DurationSeconds records the durations of requests in seconds too (i.e. incoming_grpc_requests_duration_seconds), see ohttp.Options.DurationSeconds.
+	DurationSeconds bool
+
+	// SystemMethods are the full methods (i.e. /grpc.health.v1.Health/Check) that are called by the infrastructure
+	// rather than by clients, so they are observed separately from the other requests.
+	// The requests to these methods are counted by system_grpc_requests_total instead of incoming_grpc_requests_total,
+	// their durations are not recorded, and they are logged at debug level.
+	// If not set, the methods of the grpc health and reflection services are used. Set an empty slice for observing them as usual.
+	// This is only used by server interceptors.
+	SystemMethods []string
+
+	// Now returns the current time for measuring the duration of requests.
+	// If not set, the system time is used. This is meant for making durations deterministic in tests.
+	Now func() time.Time
+
+	// excludedMethods is a set of ExcludedMethods for constant-time lookups.
+	excludedMethods map[string]struct{}
+
+	// systemMethods is a set of SystemMethods for constant-time lookups.
+	systemMethods map[string]struct{}
+
+	// clock is the clock created from Now.
+	clock clock.Clock
 }
 
 func (opts Options) withDefaults() Options {
+	if opts.RequestUUIDRegexp == nil {
+		opts.RequestUUIDRegexp = request.DefaultUUIDRegexp
+	}
+
+	opts.excludedMethods = make(map[string]struct{}, len(opts.ExcludedMethods))
+	for _, m := range opts.ExcludedMethods {
+		opts.excludedMethods[m] = struct{}{}
+	}
+
+	if opts.SystemMethods == nil {
+		opts.SystemMethods = defaultSystemMethods
+	}
+
+	opts.systemMethods = make(map[string]struct{}, len(opts.SystemMethods))
+	for _, m := range opts.SystemMethods {
+		opts.systemMethods[m] = struct{}{}
+	}
+
+	opts.clock = clock.New(opts.Now)
+
 	return opts
 }
 
+// echoMetadata determines whether or not a request metadata key should be echoed back in the response metadata.
+func (opts Options) echoMetadata(key string) bool {
+	if opts.SuppressResponseMetadata {
+		return false
+	}
+
+	for _, k := range opts.SuppressedResponseMetadata {
+		if strings.EqualFold(k, key) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// isExcluded determines whether or not a method is excluded from observability.
+// It does not allocate, so it can be called on the hot path before parsing the full method name.
+func (opts Options) isExcluded(fullMethod string) bool {
+	if len(opts.excludedMethods) == 0 {
+		return false
+	}
+
+	method := fullMethod[strings.LastIndexByte(fullMethod, '/')+1:]
+	_, ok := opts.excludedMethods[method]
+
+	return ok
+}
+
+// isSystem determines whether or not a method is a system method that is observed separately.
+func (opts Options) isSystem(fullMethod string) bool {
+	_, ok := opts.systemMethods[fullMethod]
+	return ok
+}
+
+// validRequestUUID determines whether or not an incoming request uuid can be safely used.
+func (opts Options) validRequestUUID(id string) bool {
+	return request.ValidUUID(id, opts.RequestUUIDRegexp)
+}
+
+// untrustedRequestUUID returns the request uuid claimed by a client if it is ignored.
+// An invalid request uuid is not returned, since it is not safe to be logged.
+func (opts Options) untrustedRequestUUID(requestUUID string) string {
+	if !opts.IgnoreIncomingRequestID || !opts.validRequestUUID(requestUUID) {
+		return ""
+	}
+	return requestUUID
+}
+
+// isQuiet determines whether or not the errors of a method should be logged at info level.
+func (opts Options) isQuiet(method string) bool {
+	for _, m := range opts.QuietMethods {
+		if method == m {
+			return true
+		}
+	}
+
+	return false
+}
+
+// metricLabels calls the user function for computing custom metric labels from the context of a request.
+// A panic in the user function is recovered and logged, and no label is returned.
+func (opts Options) metricLabels(ctx context.Context, logger *zap.Logger) (labels []label.KeyValue) {
+	if opts.MetricLabelsFromContext == nil {
+		return nil
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			labels = nil
+			err := fmt.Errorf("panic occurred: %v", r)
+			logger.Error("Panic occurred in MetricLabelsFromContext.", zap.Error(err))
+		}
+	}()
+
+	return opts.MetricLabelsFromContext(ctx)
+}
+
+// tenantLabels returns the metric labels for the tenant of a request.
+// If the tenant is not in the allow-list, it will be reported as "other".
+func (opts Options) tenantLabels(ctx context.Context) []label.KeyValue {
+	return request.TenantLabels(ctx, opts.TenantBaggageKey, opts.TenantLabelAllowlist)
+}
+
+// tracer returns the tracer for starting spans for a method.
+// If a sampling ratio is specified for the method, the spans are sampled by a parent-based trace id ratio sampler.
+func (opts Options) tracer(method string, tracer trace.Tracer) trace.Tracer {
+	ratio, ok := opts.MethodSamplingRatios[method]
+	if !ok {
+		return tracer
+	}
+
+	return &samplingTracer{
+		tracer:  tracer,
+		sampler: tracesdk.ParentBased(tracesdk.TraceIDRatioBased(ratio)),
+	}
+}
+
+// samplingTracer is a trace.Tracer that makes the sampling decision for spans before they are started.
+// Sampled spans are started by the underlying tracer. For spans not sampled, a non-recording span is returned,
+// so the trace id is still propagated to the contextual logger and to downstream services.
+type samplingTracer struct {
+	tracer  trace.Tracer
+	sampler tracesdk.Sampler
+}
+
+func (t *samplingTracer) Start(ctx context.Context, name string, opts ...trace.SpanOption) (context.Context, trace.Span) {
+	config := trace.NewSpanConfig(opts...)
+
+	var parent trace.SpanContext
+	var remote bool
+	if !config.NewRoot {
+		if parent = trace.SpanContextFromContext(ctx); !parent.IsValid() {
+			parent = trace.RemoteSpanContextFromContext(ctx)
+			remote = parent.IsValid()
+		}
+	}
+
+	traceID := parent.TraceID
+	if !parent.IsValid() {
+		traceID = newTraceID()
+	}
+
+	result := t.sampler.ShouldSample(tracesdk.SamplingParameters{
+		ParentContext:   parent,
+		TraceID:         traceID,
+		Name:            name,
+		HasRemoteParent: remote,
+		Kind:            config.SpanKind,
+		Attributes:      config.Attributes,
+		Links:           config.Links,
+	})
+
+	if result.Decision == tracesdk.RecordAndSample {
+		return t.tracer.Start(ctx, name, opts...)
+	}
+
+	span := &nonRecordingSpan{
+		tracer: t,
+		sc: trace.SpanContext{
+			TraceID:    traceID,
+			SpanID:     newSpanID(),
+			TraceFlags: parent.TraceFlags &^ trace.FlagsSampled,
+			TraceState: parent.TraceState,
+		},
+	}
+
+	return trace.ContextWithSpan(ctx, span), span
+}
+
+// nonRecordingSpan is a trace.Span that only carries a span context.
+type nonRecordingSpan struct {
+	tracer trace.Tracer
+	sc     trace.SpanContext
+}
+
+func (s *nonRecordingSpan) Tracer() trace.Tracer                    { return s.tracer }
+func (s *nonRecordingSpan) End(...trace.SpanOption)                 {}
+func (s *nonRecordingSpan) AddEvent(string, ...trace.EventOption)   {}
+func (s *nonRecordingSpan) IsRecording() bool                       { return false }
+func (s *nonRecordingSpan) RecordError(error, ...trace.EventOption) {}
+func (s *nonRecordingSpan) SpanContext() trace.SpanContext          { return s.sc }
+func (s *nonRecordingSpan) SetStatus(codes.Code, string)            {}
+func (s *nonRecordingSpan) SetName(string)                          {}
+func (s *nonRecordingSpan) SetAttributes(...label.KeyValue)         {}
+
+func newTraceID() trace.TraceID {
+	var id trace.TraceID
+	_, _ = rand.Read(id[:])
+	return id
+}
+
+func newSpanID() trace.SpanID {
+	var id trace.SpanID
+	_, _ = rand.Read(id[:])
+	return id
+}
+
 // endpoint is a grpc endpoint.
 type endpoint struct {
 	Package string
@@ -87,6 +419,148 @@ func ServerStreamWithContext(ctx context.Context, s grpc.ServerStream) grpc.Serv
 	}
 }
 
+const encodingKey = "grpc-encoding"
+
+// requestEncoding returns the compression algorithm of an incoming request (i.e. gzip) if any.
+// The grpc-encoding header is a reserved header and it is not usually available in the request metadata,
+// so it is read from the server transport stream if it is not in the metadata.
+func requestEncoding(ctx context.Context, md metadata.MD) string {
+	if vals := md.Get(encodingKey); len(vals) > 0 {
+		return vals[0]
+	}
+
+	if s, ok := grpc.ServerTransportStreamFromContext(ctx).(interface{ RecvCompress() string }); ok {
+		return s.RecvCompress()
+	}
+
+	return ""
+}
+
+// callEncoding returns the compressor configured for an outgoing request (i.e. gzip) if any.
+func callEncoding(opts []grpc.CallOption) string {
+	var encoding string
+	for _, opt := range opts {
+		if c, ok := opt.(grpc.CompressorCallOption); ok {
+			encoding = c.CompressorType
+		}
+	}
+
+	return encoding
+}
+
+// countingServerStream is a grpc.ServerStream that counts the messages and bytes sent and received on a stream.
+// It also keeps track of the time spent waiting for messages to be received from the client.
+type countingServerStream struct {
+	grpc.ServerStream
+	clock    clock.Clock
+	sent     int64
+	received int64
+	bytes    int64
+	recvWait int64
+
+	// onSend is called with the time every message took to be sent if set.
+	onSend func(time.Duration)
+}
+
+// The time spent waiting is measured with the given clock, so it can be compared with the duration of the stream.
+func newCountingServerStream(s grpc.ServerStream, c clock.Clock) *countingServerStream {
+	return &countingServerStream{
+		ServerStream: s,
+		clock:        c,
+	}
+}
+
+func (s *countingServerStream) SendMsg(m interface{}) error {
+	start := s.clock.Now()
+	err := s.ServerStream.SendMsg(m)
+	if s.onSend != nil {
+		s.onSend(s.clock.Now().Sub(start))
+	}
+
+	if err == nil {
+		atomic.AddInt64(&s.sent, 1)
+		atomic.AddInt64(&s.bytes, messageSize(m))
+	}
+
+	return err
+}
+
+func (s *countingServerStream) RecvMsg(m interface{}) error {
+	start := s.clock.Now()
+	err := s.ServerStream.RecvMsg(m)
+	atomic.AddInt64(&s.recvWait, int64(s.clock.Now().Sub(start)))
+
+	if err == nil {
+		atomic.AddInt64(&s.received, 1)
+		atomic.AddInt64(&s.bytes, messageSize(m))
+	}
+
+	return err
+}
+
+// totals returns the number of messages sent, the number of messages received, and the total size of messages in bytes.
+func (s *countingServerStream) totals() (int64, int64, int64) {
+	return atomic.LoadInt64(&s.sent), atomic.LoadInt64(&s.received), atomic.LoadInt64(&s.bytes)
+}
+
+// idle returns the total time spent waiting for messages to be received from the client.
+func (s *countingServerStream) idle() time.Duration {
+	return time.Duration(atomic.LoadInt64(&s.recvWait))
+}
+
+// timingClientStream is a grpc.ClientStream that measures the time every message takes to be sent.
+// Sending a message blocks when the server is slow to receive messages and the flow-control window is full.
+type timingClientStream struct {
+	grpc.ClientStream
+	onSend func(time.Duration)
+}
+
+func (s *timingClientStream) SendMsg(m interface{}) error {
+	start := time.Now()
+	err := s.ClientStream.SendMsg(m)
+	s.onSend(time.Since(start))
+
+	return err
+}
+
+// messageSize returns the encoded size of a protobuf message in bytes.
+// Zero is returned for other messages.
+func messageSize(m interface{}) int64 {
+	if pm, ok := m.(proto.Message); ok {
+		return int64(proto.Size(pm))
+	}
+
+	return 0
+}
+
+// messageType returns the full name of a proto message type (i.e. itemPB.Item).
+// An empty string is returned for other messages.
+func messageType(m interface{}) string {
+	if pm, ok := m.(proto.Message); ok {
+		return string(pm.ProtoReflect().Descriptor().FullName())
+	}
+
+	return ""
+}
+
+// messageTypes returns the proto message types of a request and a response as log fields and span attributes.
+func messageTypes(req, res interface{}) ([]zap.Field, []label.KeyValue) {
+	var fields []zap.Field
+	var attrs []label.KeyValue
+
+	if t := messageType(req); t != "" {
+		fields = append(fields, zap.String("req.type", t))
+		attrs = append(attrs, label.String("req.type", t))
+	}
+
+	if t := messageType(res); t != "" {
+		fields = append(fields, zap.String("resp.type", t))
+		attrs = append(attrs, label.String("resp.type", t))
+	}
+
+	return fields, attrs
+}
+
 // metadataTextMapCarrier implements propagation.HTTPSupplier interface.
 type metadataTextMapCarrier struct {
 	md *metadata.MD
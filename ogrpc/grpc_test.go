@@ -2,38 +2,50 @@ package ogrpc
 
 import (
 	"context"
-	"net/http"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/moorara/observer"
+	"github.com/moorara/observer/internal/clock"
 	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/baggage"
+	"go.opentelemetry.io/otel/label"
 	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/oteltest"
 	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/unit"
 	"go.uber.org/zap"
-	"go.uber.org/zap/zapcore"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/types/known/wrapperspb"
 )
 
+// mockObserver is a no-op observer with overridable name, logger, meter, and tracer.
 type mockObserver struct {
+	observer.Observer
 	name   string
 	logger *zap.Logger
 	meter  metric.Meter
 	tracer trace.Tracer
 }
 
+var _ observer.Observer = (*mockObserver)(nil)
+
 func newMockObserver() *mockObserver {
+	noop := observer.NewNoop()
 	return &mockObserver{
-		name:   "test",
-		logger: zap.NewNop(),
-		meter:  new(metric.NoopMeterProvider).Meter(""),
-		tracer: trace.NewNoopTracerProvider().Tracer(""),
+		Observer: noop,
+		name:     "test",
+		logger:   noop.Logger(),
+		meter:    noop.Meter(),
+		tracer:   noop.Tracer(),
 	}
 }
 
-func (m *mockObserver) Shutdown(ctx context.Context) error {
-	return nil
-}
-
 func (m *mockObserver) Name() string {
 	return m.name
 }
@@ -42,12 +54,8 @@ func (m *mockObserver) Logger() *zap.Logger {
 	return m.logger
 }
 
-func (m *mockObserver) SetLogLevel(level zapcore.Level) {
-	// Noop
-}
-
-func (m *mockObserver) GetLogLevel() zapcore.Level {
-	return zapcore.Level(99)
+func (m *mockObserver) SpanLogger(trace.SpanContext) *zap.Logger {
+	return m.logger
 }
 
 func (m *mockObserver) Meter() metric.Meter {
@@ -58,10 +66,44 @@ func (m *mockObserver) Tracer() trace.Tracer {
 	return m.tracer
 }
 
-func (m *mockObserver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+type mockMeterImpl struct {
+	NewSyncInstrumentOutError  error
+	NewAsyncInstrumentOutError error
+}
+
+func (m *mockMeterImpl) RecordBatch(ctx context.Context, labels []label.KeyValue, measurement ...metric.Measurement) {
 	// Noop
 }
 
+func (m *mockMeterImpl) NewSyncInstrument(descriptor metric.Descriptor) (metric.SyncImpl, error) {
+	if m.NewSyncInstrumentOutError != nil {
+		return nil, m.NewSyncInstrumentOutError
+	}
+	return metric.NoopSync{}, nil
+}
+
+func (m *mockMeterImpl) NewAsyncInstrument(descriptor metric.Descriptor, runner metric.AsyncRunner) (metric.AsyncImpl, error) {
+	if m.NewAsyncInstrumentOutError != nil {
+		return nil, m.NewAsyncInstrumentOutError
+	}
+	return metric.NoopAsync{}, nil
+}
+
+type mockServerTransportStream struct {
+	grpc.ServerTransportStream
+	RecvCompressOut string
+	SendHeaderInMD  metadata.MD
+}
+
+func (m *mockServerTransportStream) RecvCompress() string {
+	return m.RecvCompressOut
+}
+
+func (m *mockServerTransportStream) SendHeader(md metadata.MD) error {
+	m.SendHeaderInMD = md
+	return nil
+}
+
 type mockServerStream struct {
 	SetHeaderInMD     metadata.MD
 	SetHeaderOutError error
@@ -108,6 +150,505 @@ func (m *mockServerStream) RecvMsg(msg interface{}) error {
 	return m.RecvMsgOutError
 }
 
+func TestOptionsMetricLabels(t *testing.T) {
+	tests := []struct {
+		name           string
+		opts           Options
+		ctx            context.Context
+		expectedLabels []label.KeyValue
+	}{
+		{
+			name:           "NotSet",
+			opts:           Options{},
+			ctx:            context.Background(),
+			expectedLabels: nil,
+		},
+		{
+			name: "FromBaggage",
+			opts: Options{
+				MetricLabelsFromContext: func(ctx context.Context) []label.KeyValue {
+					return []label.KeyValue{
+						label.String("region", baggage.Value(ctx, label.Key("region")).Emit()),
+					}
+				},
+			},
+			ctx: baggage.ContextWithValues(context.Background(), label.String("region", "ca-central-1")),
+			expectedLabels: []label.KeyValue{
+				label.String("region", "ca-central-1"),
+			},
+		},
+		{
+			name: "Panics",
+			opts: Options{
+				MetricLabelsFromContext: func(ctx context.Context) []label.KeyValue {
+					panic("something went wrong")
+				},
+			},
+			ctx:            context.Background(),
+			expectedLabels: nil,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expectedLabels, tc.opts.metricLabels(tc.ctx, zap.NewNop()))
+		})
+	}
+}
+
+func TestOptionsTenantLabels(t *testing.T) {
+	tests := []struct {
+		name           string
+		opts           Options
+		ctx            context.Context
+		expectedLabels []label.KeyValue
+	}{
+		{
+			name:           "Disabled",
+			opts:           Options{},
+			ctx:            baggage.ContextWithValues(context.Background(), label.String("tenant", "acme")),
+			expectedLabels: nil,
+		},
+		{
+			name: "NoTenant",
+			opts: Options{
+				TenantBaggageKey:     "tenant",
+				TenantLabelAllowlist: []string{"acme"},
+			},
+			ctx:            context.Background(),
+			expectedLabels: []label.KeyValue{label.String("tenant", "other")},
+		},
+		{
+			name: "NotAllowed",
+			opts: Options{
+				TenantBaggageKey:     "tenant",
+				TenantLabelAllowlist: []string{"acme"},
+			},
+			ctx:            baggage.ContextWithValues(context.Background(), label.String("tenant", "umbrella")),
+			expectedLabels: []label.KeyValue{label.String("tenant", "other")},
+		},
+		{
+			name: "Allowed",
+			opts: Options{
+				TenantBaggageKey:     "tenant",
+				TenantLabelAllowlist: []string{"acme"},
+			},
+			ctx:            baggage.ContextWithValues(context.Background(), label.String("tenant", "acme")),
+			expectedLabels: []label.KeyValue{label.String("tenant", "acme")},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			labels := tc.opts.tenantLabels(tc.ctx)
+
+			assert.Equal(t, tc.expectedLabels, labels)
+		})
+	}
+}
+
+func TestOptionsValidRequestUUID(t *testing.T) {
+	tests := []struct {
+		name          string
+		opts          Options
+		id            string
+		expectedValid bool
+	}{
+		{
+			name:          "Empty",
+			opts:          Options{},
+			id:            "",
+			expectedValid: false,
+		},
+		{
+			name:          "UUID",
+			opts:          Options{},
+			id:            "aaaaaaaa-aaaa-aaaa-aaaa-aaaaaaaaaaaa",
+			expectedValid: true,
+		},
+		{
+			name:          "ULID",
+			opts:          Options{},
+			id:            "01ARZ3NDEKTSV4RRFFQ69G5FAV",
+			expectedValid: true,
+		},
+		{
+			name:          "Newline",
+			opts:          Options{},
+			id:            "aaaaaaaa-aaaa-aaaa-aaaa-aaaaaaaaaaaa\nlevel=error",
+			expectedValid: false,
+		},
+		{
+			name:          "Arbitrary",
+			opts:          Options{},
+			id:            "<script>alert(1)</script>",
+			expectedValid: false,
+		},
+		{
+			name: "CustomPattern",
+			opts: Options{
+				RequestUUIDRegexp: regexp.MustCompile(`^req-[0-9]+$`),
+			},
+			id:            "req-1234",
+			expectedValid: true,
+		},
+		{
+			name: "CustomPatternWithControlCharacter",
+			opts: Options{
+				RequestUUIDRegexp: regexp.MustCompile(`.*`),
+			},
+			id:            "req-1234\r",
+			expectedValid: false,
+		},
+		{
+			name: "CustomPatternTooLong",
+			opts: Options{
+				RequestUUIDRegexp: regexp.MustCompile(`.*`),
+			},
+			id:            strings.Repeat("a", 129),
+			expectedValid: false,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			opts := tc.opts.withDefaults()
+			assert.Equal(t, tc.expectedValid, opts.validRequestUUID(tc.id))
+		})
+	}
+}
+
+func TestOptionsIsExcluded(t *testing.T) {
+	tests := []struct {
+		name             string
+		opts             Options
+		fullMethod       string
+		expectedExcluded bool
+	}{
+		{
+			name:             "NoExcludedMethods",
+			opts:             Options{},
+			fullMethod:       "/itemPB.ItemManager/GetItem",
+			expectedExcluded: false,
+		},
+		{
+			name: "Excluded",
+			opts: Options{
+				ExcludedMethods: []string{"Check", "GetItem"},
+			},
+			fullMethod:       "/itemPB.ItemManager/GetItem",
+			expectedExcluded: true,
+		},
+		{
+			name: "NotExcluded",
+			opts: Options{
+				ExcludedMethods: []string{"Check", "GetItem"},
+			},
+			fullMethod:       "/itemPB.ItemManager/GetItems",
+			expectedExcluded: false,
+		},
+		{
+			name: "InvalidFullMethod",
+			opts: Options{
+				ExcludedMethods: []string{"GetItem"},
+			},
+			fullMethod:       "GetItem",
+			expectedExcluded: true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			opts := tc.opts.withDefaults()
+			assert.Equal(t, tc.expectedExcluded, opts.isExcluded(tc.fullMethod))
+		})
+	}
+}
+
+func BenchmarkExcludedMethods(b *testing.B) {
+	var methods []string
+	for i := 0; i < 100; i++ {
+		methods = append(methods, fmt.Sprintf("Method%d", i))
+	}
+
+	opts := Options{ExcludedMethods: methods}.withDefaults()
+	fullMethod := "/itemPB.ItemManager/GetItem"
+
+	b.Run("Slice", func(b *testing.B) {
+		b.ReportAllocs()
+		for n := 0; n < b.N; n++ {
+			e, _ := parseEndpoint(fullMethod)
+			for _, m := range opts.ExcludedMethods {
+				if e.Method == m {
+					break
+				}
+			}
+		}
+	})
+
+	b.Run("Map", func(b *testing.B) {
+		b.ReportAllocs()
+		for n := 0; n < b.N; n++ {
+			_ = opts.isExcluded(fullMethod)
+		}
+	})
+}
+
+func TestOptionsIsSystem(t *testing.T) {
+	tests := []struct {
+		name           string
+		opts           Options
+		fullMethod     string
+		expectedSystem bool
+	}{
+		{
+			name:           "DefaultHealth",
+			opts:           Options{},
+			fullMethod:     "/grpc.health.v1.Health/Check",
+			expectedSystem: true,
+		},
+		{
+			name:           "DefaultReflection",
+			opts:           Options{},
+			fullMethod:     "/grpc.reflection.v1alpha.ServerReflection/ServerReflectionInfo",
+			expectedSystem: true,
+		},
+		{
+			name:           "DefaultNotSystem",
+			opts:           Options{},
+			fullMethod:     "/itemPB.ItemManager/GetItem",
+			expectedSystem: false,
+		},
+		{
+			name: "Custom",
+			opts: Options{
+				SystemMethods: []string{"/itemPB.ItemManager/Ping"},
+			},
+			fullMethod:     "/itemPB.ItemManager/Ping",
+			expectedSystem: true,
+		},
+		{
+			name: "CustomReplacesDefaults",
+			opts: Options{
+				SystemMethods: []string{"/itemPB.ItemManager/Ping"},
+			},
+			fullMethod:     "/grpc.health.v1.Health/Check",
+			expectedSystem: false,
+		},
+		{
+			name: "Disabled",
+			opts: Options{
+				SystemMethods: []string{},
+			},
+			fullMethod:     "/grpc.health.v1.Health/Check",
+			expectedSystem: false,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			opts := tc.opts.withDefaults()
+			assert.Equal(t, tc.expectedSystem, opts.isSystem(tc.fullMethod))
+		})
+	}
+}
+
+func TestOptionsEchoMetadata(t *testing.T) {
+	tests := []struct {
+		name         string
+		opts         Options
+		key          string
+		expectedEcho bool
+	}{
+		{
+			name:         "Default",
+			opts:         Options{},
+			key:          clientNameKey,
+			expectedEcho: true,
+		},
+		{
+			name:         "SuppressAll",
+			opts:         Options{SuppressResponseMetadata: true},
+			key:          requestUUIDKey,
+			expectedEcho: false,
+		},
+		{
+			name:         "SuppressedKey",
+			opts:         Options{SuppressedResponseMetadata: []string{"Client-Name"}},
+			key:          clientNameKey,
+			expectedEcho: false,
+		},
+		{
+			name:         "NotSuppressedKey",
+			opts:         Options{SuppressedResponseMetadata: []string{"Client-Name"}},
+			key:          requestUUIDKey,
+			expectedEcho: true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expectedEcho, tc.opts.echoMetadata(tc.key))
+		})
+	}
+}
+
+func TestRequestEncoding(t *testing.T) {
+	tests := []struct {
+		name             string
+		ctx              context.Context
+		md               metadata.MD
+		expectedEncoding string
+	}{
+		{
+			name:             "NoEncoding",
+			ctx:              context.Background(),
+			md:               metadata.New(nil),
+			expectedEncoding: "",
+		},
+		{
+			name:             "FromMetadata",
+			ctx:              context.Background(),
+			md:               metadata.Pairs("grpc-encoding", "gzip"),
+			expectedEncoding: "gzip",
+		},
+		{
+			name:             "FromTransportStream",
+			ctx:              grpc.NewContextWithServerTransportStream(context.Background(), &mockServerTransportStream{RecvCompressOut: "gzip"}),
+			md:               metadata.New(nil),
+			expectedEncoding: "gzip",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expectedEncoding, requestEncoding(tc.ctx, tc.md))
+		})
+	}
+}
+
+func TestCallEncoding(t *testing.T) {
+	tests := []struct {
+		name             string
+		opts             []grpc.CallOption
+		expectedEncoding string
+	}{
+		{
+			name:             "NoCompressor",
+			opts:             []grpc.CallOption{grpc.WaitForReady(true)},
+			expectedEncoding: "",
+		},
+		{
+			name:             "Compressor",
+			opts:             []grpc.CallOption{grpc.WaitForReady(true), grpc.UseCompressor("gzip")},
+			expectedEncoding: "gzip",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expectedEncoding, callEncoding(tc.opts))
+		})
+	}
+}
+
+// unhashableObserver is an observer.Observer that cannot be used as a map key.
+type unhashableObserver struct {
+	*mockObserver
+	_ []string
+}
+
+func TestUnhashableObserver(t *testing.T) {
+	obsv := unhashableObserver{mockObserver: newMockObserver()}
+
+	assert.NotPanics(t, func() {
+		NewServerInterceptor(obsv, Options{})
+		NewClientInterceptor(obsv, Options{})
+	})
+}
+
+func TestDurationInstruments(t *testing.T) {
+	_, meter := oteltest.NewMeter()
+	server := newServerInstruments(meter, zap.NewNop())
+	client := newClientInstruments(meter, zap.NewNop())
+
+	tests := []struct {
+		name       string
+		instrument metric.Int64ValueRecorder
+	}{
+		{
+			name:       "ServerRequestDuration",
+			instrument: server.reqDuration,
+		},
+		{
+			name:       "ServerStreamActiveDuration",
+			instrument: server.streamActive,
+		},
+		{
+			name:       "ClientRequestDuration",
+			instrument: client.reqDuration,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			desc := tc.instrument.SyncImpl().Descriptor()
+
+			assert.Equal(t, unit.Milliseconds, desc.Unit())
+			assert.Contains(t, desc.Description(), "in milliseconds")
+		})
+	}
+}
+
+// stackError is an error with a stack trace like the errors of github.com/pkg/errors.
+type stackError struct {
+	msg   string
+	stack string
+	cause error
+}
+
+func (e *stackError) Error() string {
+	return e.msg
+}
+
+func (e *stackError) Unwrap() error {
+	return e.cause
+}
+
+func (e *stackError) StackTrace() string {
+	return e.stack
+}
+
+func TestMessageType(t *testing.T) {
+	tests := []struct {
+		name         string
+		m            interface{}
+		expectedType string
+	}{
+		{
+			name:         "Nil",
+			m:            nil,
+			expectedType: "",
+		},
+		{
+			name:         "NotProto",
+			m:            "item",
+			expectedType: "",
+		},
+		{
+			name:         "Proto",
+			m:            wrapperspb.String("item"),
+			expectedType: "google.protobuf.StringValue",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expectedType, messageType(tc.m))
+		})
+	}
+}
+
 func TestEndpoint(t *testing.T) {
 	tests := []struct {
 		name            string
@@ -187,3 +728,164 @@ func TestServerStream(t *testing.T) {
 		})
 	}
 }
+
+func TestCountingServerStream(t *testing.T) {
+	tests := []struct {
+		name             string
+		stream           *mockServerStream
+		sendMsgs         []interface{}
+		recvMsgs         []interface{}
+		expectedSent     int64
+		expectedReceived int64
+		expectedBytes    int64
+	}{
+		{
+			name:             "ProtoMessages",
+			stream:           &mockServerStream{},
+			sendMsgs:         []interface{}{wrapperspb.String("hello"), wrapperspb.String("world")},
+			recvMsgs:         []interface{}{wrapperspb.String("ping")},
+			expectedSent:     2,
+			expectedReceived: 1,
+			expectedBytes:    20,
+		},
+		{
+			name:             "NonProtoMessages",
+			stream:           &mockServerStream{},
+			sendMsgs:         []interface{}{"hello"},
+			recvMsgs:         []interface{}{"ping"},
+			expectedSent:     1,
+			expectedReceived: 1,
+			expectedBytes:    0,
+		},
+		{
+			name: "Errors",
+			stream: &mockServerStream{
+				SendMsgOutError: errors.New("send error"),
+				RecvMsgOutError: errors.New("recv error"),
+			},
+			sendMsgs:         []interface{}{wrapperspb.String("hello")},
+			recvMsgs:         []interface{}{wrapperspb.String("ping")},
+			expectedSent:     0,
+			expectedReceived: 0,
+			expectedBytes:    0,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			cs := newCountingServerStream(tc.stream, clock.Real)
+
+			for _, m := range tc.sendMsgs {
+				_ = cs.SendMsg(m)
+			}
+			for _, m := range tc.recvMsgs {
+				_ = cs.RecvMsg(m)
+			}
+
+			sent, received, bytes := cs.totals()
+			assert.Equal(t, tc.expectedSent, sent)
+			assert.Equal(t, tc.expectedReceived, received)
+			assert.Equal(t, tc.expectedBytes, bytes)
+		})
+	}
+}
+
+// slowRecvServerStream is a grpc.ServerStream that waits before receiving every message.
+type slowRecvServerStream struct {
+	grpc.ServerStream
+	delay time.Duration
+}
+
+func (s *slowRecvServerStream) RecvMsg(m interface{}) error {
+	time.Sleep(s.delay)
+	return s.ServerStream.RecvMsg(m)
+}
+
+func TestCountingServerStreamIdle(t *testing.T) {
+	cs := newCountingServerStream(&slowRecvServerStream{
+		ServerStream: &mockServerStream{},
+		delay:        10 * time.Millisecond,
+	}, clock.Real)
+
+	_ = cs.SendMsg(wrapperspb.String("hello"))
+	assert.Equal(t, time.Duration(0), cs.idle())
+
+	_ = cs.RecvMsg(wrapperspb.String("ping"))
+	_ = cs.RecvMsg(wrapperspb.String("ping"))
+	assert.GreaterOrEqual(t, int64(cs.idle()), int64(20*time.Millisecond))
+}
+
+// blockingSendServerStream is a grpc.ServerStream whose sends block until the client is ready to receive (i.e. flow control).
+type blockingSendServerStream struct {
+	grpc.ServerStream
+	ready chan struct{}
+}
+
+func (s *blockingSendServerStream) SendMsg(m interface{}) error {
+	<-s.ready
+	return s.ServerStream.SendMsg(m)
+}
+
+func TestCountingServerStreamOnSend(t *testing.T) {
+	ready := make(chan struct{}, 1)
+	cs := newCountingServerStream(&blockingSendServerStream{
+		ServerStream: &mockServerStream{},
+		ready:        ready,
+	}, clock.Real)
+
+	var sends []time.Duration
+	cs.onSend = func(d time.Duration) {
+		sends = append(sends, d)
+	}
+
+	ready <- struct{}{}
+	_ = cs.SendMsg(wrapperspb.String("hello"))
+
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		ready <- struct{}{}
+	}()
+	_ = cs.SendMsg(wrapperspb.String("hello"))
+
+	assert.Len(t, sends, 2)
+	assert.Less(t, int64(sends[0]), int64(20*time.Millisecond))
+	assert.GreaterOrEqual(t, int64(sends[1]), int64(20*time.Millisecond))
+
+	sent, _, _ := cs.totals()
+	assert.Equal(t, int64(2), sent)
+}
+
+// blockingSendClientStream is a grpc.ClientStream whose sends block until the server is ready to receive (i.e. flow control).
+type blockingSendClientStream struct {
+	grpc.ClientStream
+	ready chan struct{}
+}
+
+func (s *blockingSendClientStream) SendMsg(m interface{}) error {
+	<-s.ready
+	return nil
+}
+
+func TestTimingClientStream(t *testing.T) {
+	ready := make(chan struct{})
+
+	var sends []time.Duration
+	cs := &timingClientStream{
+		ClientStream: &blockingSendClientStream{ready: ready},
+		onSend: func(d time.Duration) {
+			sends = append(sends, d)
+		},
+	}
+
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		close(ready)
+	}()
+
+	assert.NoError(t, cs.SendMsg(wrapperspb.String("hello")))
+	assert.NoError(t, cs.SendMsg(wrapperspb.String("hello")))
+
+	assert.Len(t, sends, 2)
+	assert.GreaterOrEqual(t, int64(sends[0]), int64(20*time.Millisecond))
+	assert.Less(t, int64(sends[1]), int64(20*time.Millisecond))
+}
@@ -7,6 +7,10 @@ import (
 
 	"github.com/google/uuid"
 	"github.com/moorara/observer"
+	"github.com/moorara/observer/internal/errs"
+	"github.com/moorara/observer/internal/instrument"
+	"github.com/moorara/observer/internal/request"
+	"github.com/moorara/observer/internal/tracing"
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/baggage"
 	"go.opentelemetry.io/otel/codes"
@@ -24,12 +28,28 @@ import (
 type serverInstruments struct {
 	reqCounter   metric.Int64Counter
 	reqGauge     metric.Int64UpDownCounter
+	reqPeak      *instrument.HighWaterMark
 	reqDuration  metric.Int64ValueRecorder
+	reqSeconds   metric.Float64ValueRecorder
+	streamActive metric.Int64ValueRecorder
+	streamSend   metric.Int64ValueRecorder
 	panicCounter metric.Int64Counter
+	sysCounter   metric.Int64Counter
 }
 
-func newServerInstruments(meter metric.Meter) *serverInstruments {
-	mm := metric.Must(meter)
+func newServerInstruments(meter metric.Meter, logger *zap.Logger) *serverInstruments {
+	mm := instrument.NewSafeMeter(meter, logger)
+
+	reqPeak := new(instrument.HighWaterMark)
+	mm.NewInt64ValueObserver(
+		"incoming_grpc_requests_active_max",
+		func(_ context.Context, result metric.Int64ObserverResult) {
+			result.Observe(reqPeak.Collect())
+		},
+		metric.WithDescription("The peak number of in-flight incoming grpc requests since the last collection (server-side)"),
+		metric.WithUnit(unit.Dimensionless),
+		metric.WithInstrumentationName(libraryName),
+	)
 
 	return &serverInstruments{
 		reqCounter: mm.NewInt64Counter(
@@ -44,21 +64,48 @@ func newServerInstruments(meter metric.Meter) *serverInstruments {
 			metric.WithUnit(unit.Dimensionless),
 			metric.WithInstrumentationName(libraryName),
 		),
+		reqPeak: reqPeak,
 		reqDuration: mm.NewInt64ValueRecorder(
 			"incoming_grpc_requests_duration",
 			metric.WithDescription("The duration of incoming grpc requests in milliseconds (server-side)"),
 			metric.WithUnit(unit.Milliseconds),
 			metric.WithInstrumentationName(libraryName),
 		),
+		reqSeconds: mm.NewFloat64ValueRecorder(
+			"incoming_grpc_requests_duration_seconds",
+			metric.WithDescription("The duration of incoming grpc requests in seconds (server-side)"),
+			metric.WithUnit(unitSeconds),
+			metric.WithInstrumentationName(libraryName),
+		),
+		streamActive: mm.NewInt64ValueRecorder(
+			"incoming_grpc_streams_active_duration",
+			metric.WithDescription("The duration of incoming grpc streams excluding the time waiting for client messages in milliseconds (server-side)"),
+			metric.WithUnit(unit.Milliseconds),
+			metric.WithInstrumentationName(libraryName),
+		),
+		streamSend: mm.NewInt64ValueRecorder(
+			"incoming_grpc_streams_send_block_duration",
+			metric.WithDescription("The time sending messages on incoming grpc streams blocked in milliseconds (server-side)"),
+			metric.WithUnit(unit.Milliseconds),
+			metric.WithInstrumentationName(libraryName),
+		),
 		panicCounter: mm.NewInt64Counter(
 			"handler_panics_total",
 			metric.WithDescription("The total number of panics that happened in grpc handlers (server-side)"),
 			metric.WithUnit(unit.Dimensionless),
 			metric.WithInstrumentationName(libraryName),
 		),
+		sysCounter: mm.NewInt64Counter(
+			"system_grpc_requests_total",
+			metric.WithDescription("The total number of incoming grpc requests to system methods, i.e. health and reflection (server-side)"),
+			metric.WithUnit(unit.Dimensionless),
+			metric.WithInstrumentationName(libraryName),
+		),
 	}
 }
 
+var serverInstrumentsCache = instrument.NewCache()
+
 // ServerInterceptor creates interceptors with logging, metrics, and tracing for grpc servers.
 type ServerInterceptor struct {
 	opts        Options
@@ -69,7 +116,9 @@ type ServerInterceptor struct {
 // NewServerInterceptor creates a new server interceptor for observability.
 func NewServerInterceptor(observer observer.Observer, opts Options) *ServerInterceptor {
 	opts = opts.withDefaults()
-	instruments := newServerInstruments(observer.Meter())
+	instruments := serverInstrumentsCache.Get(observer.Meter(), func() interface{} {
+		return newServerInstruments(observer.Meter(), observer.Logger())
+	}).(*serverInstruments)
 
 	return &ServerInterceptor{
 		opts:        opts,
@@ -88,12 +137,18 @@ func (i *ServerInterceptor) ServerOptions() []grpc.ServerOption {
 	}
 }
 
-func (i *ServerInterceptor) callUnaryHandler(handler grpc.UnaryHandler, ctx context.Context, req interface{}) (resp interface{}, err error) {
+func (i *ServerInterceptor) callUnaryHandler(fullMethod string, handler grpc.UnaryHandler, ctx context.Context, req interface{}) (resp interface{}, err error) {
 	defer func() {
 		if r := recover(); r != nil {
-			err = fmt.Errorf("panic occurred: %v", r)
-			i.observer.Logger().Error("Panic occurred.", zap.Error(err))
-			i.instruments.panicCounter.Add(context.Background(), 1)
+			// The endpoint is only parsed when a panic occurs, so excluded methods are not parsed
+			e, _ := parseEndpoint(fullMethod)
+			err = errs.FromPanic("panic occurred", r)
+			i.observer.Logger().Error("Panic occurred.", zap.Error(err), zap.String("panic.type", fmt.Sprintf("%T", r)))
+			i.instruments.panicCounter.Add(context.Background(), 1,
+				label.String("package", e.Package),
+				label.String("service", e.Service),
+				label.String("method", e.Method),
+			)
 		}
 	}()
 
@@ -102,22 +157,39 @@ func (i *ServerInterceptor) callUnaryHandler(handler grpc.UnaryHandler, ctx cont
 	return resp, err
 }
 
+// requestAttributes calls the user function for computing custom attributes from a request message.
+// A panic in the user function is recovered and logged, and no attribute is returned.
+func (i *ServerInterceptor) requestAttributes(fullMethod string, req interface{}) (attrs []label.KeyValue) {
+	if i.opts.AttributesFromRequest == nil {
+		return nil
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			attrs = nil
+			err := fmt.Errorf("panic occurred: %v", r)
+			i.observer.Logger().Error("Panic occurred in AttributesFromRequest.", zap.String("req.method", fullMethod), zap.Error(err))
+		}
+	}()
+
+	return i.opts.AttributesFromRequest(fullMethod, req)
+}
+
 func (i *ServerInterceptor) unaryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
-	startTime := time.Now()
+	startTime := i.opts.clock.Now()
 	kind := "server"
 	stream := false
+	system := i.opts.isSystem(info.FullMethod)
+
+	// Check excluded methods before doing any work
+	if i.opts.isExcluded(info.FullMethod) {
+		return i.callUnaryHandler(info.FullMethod, handler, ctx, req)
+	}
 
 	// Get the package, service, and method name for the request
 	e, ok := parseEndpoint(info.FullMethod)
 	if !ok {
-		return i.callUnaryHandler(handler, ctx, req)
-	}
-
-	// Check excluded methods
-	for _, m := range i.opts.ExcludedMethods {
-		if e.Method == m {
-			return i.callUnaryHandler(handler, ctx, req)
-		}
+		return i.callUnaryHandler(info.FullMethod, handler, ctx, req)
 	}
 
 	// Increase the number of in-flight requests
@@ -136,6 +208,10 @@ func (i *ServerInterceptor) unaryInterceptor(ctx context.Context, req interface{
 		label.Bool("stream", stream),
 	)
 
+	// Keep track of the peak number of in-flight requests
+	i.instruments.reqPeak.Inc()
+	defer i.instruments.reqPeak.Dec()
+
 	// Get grpc request metadata
 	md, ok := metadata.FromIncomingContext(ctx)
 	if ok {
@@ -144,12 +220,13 @@ func (i *ServerInterceptor) unaryInterceptor(ctx context.Context, req interface{
 		md = metadata.New(nil)
 	}
 
-	// Make sure the request has a UUID
+	// Make sure the request has a valid UUID
 	var requestUUID string
 	if vals := md.Get(requestUUIDKey); len(vals) > 0 {
 		requestUUID = vals[0]
 	}
-	if requestUUID == "" {
+	clientRequestUUID := i.opts.untrustedRequestUUID(requestUUID)
+	if i.opts.IgnoreIncomingRequestID || !i.opts.validRequestUUID(requestUUID) {
 		requestUUID = uuid.New().String()
 		md.Set(requestUUIDKey, requestUUID)
 		ctx = metadata.NewIncomingContext(ctx, md)
@@ -161,12 +238,20 @@ func (i *ServerInterceptor) unaryInterceptor(ctx context.Context, req interface{
 		clientName = vals[0]
 	}
 
+	// Get the compression algorithm of the request if any
+	encoding := requestEncoding(ctx, md)
+
 	// Propagate request metadata by adding them to outgoing grpc response metadata
-	header := metadata.New(map[string]string{
-		requestUUIDKey: requestUUID,
-		clientNameKey:  clientName,
-	})
-	_ = grpc.SendHeader(ctx, header)
+	header := metadata.MD{}
+	if i.opts.echoMetadata(requestUUIDKey) {
+		header.Set(requestUUIDKey, requestUUID)
+	}
+	if i.opts.echoMetadata(clientNameKey) {
+		header.Set(clientNameKey, clientName)
+	}
+	if header.Len() > 0 {
+		_ = grpc.SendHeader(ctx, header)
+	}
 
 	// Extract context from the grpc metadata
 	ctx = otel.GetTextMapPropagator().Extract(ctx, &metadataTextMapCarrier{md: &md})
@@ -180,12 +265,26 @@ func (i *ServerInterceptor) unaryInterceptor(ctx context.Context, req interface{
 	)
 
 	// Start a new span
-	ctx, span := i.observer.Tracer().Start(ctx,
+	ctx, span := i.opts.tracer(e.Method, i.observer.Tracer()).Start(ctx,
 		fmt.Sprintf("%s (server unary)", e.Method),
 		trace.WithSpanKind(trace.SpanKindServer),
 	)
 	defer span.End()
 
+	if encoding != "" {
+		span.SetAttributes(label.String("grpc.encoding", encoding))
+	}
+
+	if i.opts.RecordCodeLocation {
+		span.SetAttributes(tracing.CodeLocation(handler)...)
+	}
+
+	// Compute custom attributes from the request
+	attrs := i.requestAttributes(info.FullMethod, req)
+	if len(attrs) > 0 {
+		span.SetAttributes(attrs...)
+	}
+
 	// Create a contextualized logger
 	contextFields := []zap.Field{
 		zap.String("req.uuid", requestUUID),
@@ -200,31 +299,60 @@ func (i *ServerInterceptor) unaryInterceptor(ctx context.Context, req interface{
 	if clientName != "" {
 		contextFields = append(contextFields, zap.String("client.name", clientName))
 	}
-	logger := i.observer.Logger().With(contextFields...)
+	if clientRequestUUID != "" {
+		contextFields = append(contextFields, zap.String("client.request_uuid", clientRequestUUID))
+	}
+	if encoding != "" {
+		contextFields = append(contextFields, zap.String("grpc.encoding", encoding))
+	}
+	if i.opts.BaggageToLogs {
+		contextFields = append(contextFields, request.BaggageFields(ctx)...)
+	}
+	contextFields = append(contextFields, request.LabelFields(attrs)...)
+	logger := i.observer.SpanLogger(span.SpanContext()).With(contextFields...)
 
 	// Augment the request context
-	ctx = observer.ContextWithUUID(ctx, requestUUID)
+	// The request metadata set by outer handlers (i.e. the principal set by an auth middleware) are kept
+	reqMD, _ := observer.RequestMetadataFromContext(ctx)
+	reqMD.UUID = requestUUID
+	reqMD.ClientName = clientName
+	reqMD.StartTime = startTime
+	reqMD.TraceID = span.SpanContext().TraceID
+	reqMD.SpanID = span.SpanContext().SpanID
+	ctx = observer.ContextWithRequestMetadata(ctx, reqMD)
 	ctx = observer.ContextWithLogger(ctx, logger)
+	ctx = observer.ContextWithObserver(ctx, i.observer)
+	ctx = observer.ContextWithFields(ctx)
 
 	// Call gRPC method handler
 	span.AddEvent("calling grpc method handler")
-	res, err := i.callUnaryHandler(handler, ctx, req)
+	res, err := i.callUnaryHandler(info.FullMethod, handler, ctx, req)
 
-	duration := time.Since(startTime).Milliseconds()
+	elapsed := i.opts.clock.Now().Sub(startTime)
+	duration := elapsed.Milliseconds()
 	success := err == nil
 
 	// Report metrics
-	i.observer.Meter().RecordBatch(ctx,
-		[]label.KeyValue{
-			label.String("package", e.Package),
-			label.String("service", e.Service),
-			label.String("method", e.Method),
-			label.Bool("stream", stream),
-			label.Bool("success", success),
-		},
-		i.instruments.reqCounter.Measurement(1),
-		i.instruments.reqDuration.Measurement(duration),
-	)
+	labels := []label.KeyValue{
+		label.String("package", e.Package),
+		label.String("service", e.Service),
+		label.String("method", e.Method),
+		label.Bool("stream", stream),
+		label.Bool("success", success),
+	}
+	labels = append(labels, i.opts.tenantLabels(ctx)...)
+	labels = append(labels, i.opts.metricLabels(ctx, i.observer.Logger())...)
+	if system {
+		i.instruments.sysCounter.Add(ctx, 1, labels...)
+	} else {
+		i.observer.Meter().RecordBatch(ctx, labels,
+			i.instruments.reqCounter.Measurement(1),
+			i.instruments.reqDuration.Measurement(duration),
+		)
+		if i.opts.DurationSeconds {
+			i.instruments.reqSeconds.Record(ctx, elapsed.Seconds(), labels...)
+		}
+	}
 
 	// Report logs
 	message := fmt.Sprintf("%s %s %dms", kind, e, duration)
@@ -234,15 +362,32 @@ func (i *ServerInterceptor) unaryInterceptor(ctx context.Context, req interface{
 	}
 	if err != nil {
 		fields = append(fields, zap.String("grpc.error", err.Error()))
+		if i.opts.ErrorStackInSpan {
+			fields = append(fields, tracing.RecordErrorStacks(span, err)...)
+		}
 	}
 
+	var typeAttrs []label.KeyValue
+	if i.opts.RecordMessageType {
+		var typeFields []zap.Field
+		typeFields, typeAttrs = messageTypes(req, res)
+		fields = append(fields, typeFields...)
+	}
+
+	// The fields added while handling the request (i.e. by auth interceptors) are read once the handler returns
+	fields = append(fields, observer.FieldsFromContext(ctx)...)
+
 	// Determine the log level based on the result
-	if success {
+	if system {
+		logger.Debug(message, fields...)
+	} else if success {
 		if i.opts.LogInDebugLevel {
 			logger.Debug(message, fields...)
 		} else {
 			logger.Info(message, fields...)
 		}
+	} else if i.opts.isQuiet(e.Method) {
+		logger.Info(message, fields...)
 	} else {
 		logger.Error(message, fields...)
 	}
@@ -255,6 +400,7 @@ func (i *ServerInterceptor) unaryInterceptor(ctx context.Context, req interface{
 		label.Bool("stream", stream),
 		label.Bool("success", success),
 	)
+	span.SetAttributes(typeAttrs...)
 	if err != nil {
 		code := codes.Code(status.Code(err))
 		span.SetStatus(code, err.Error())
@@ -263,12 +409,18 @@ func (i *ServerInterceptor) unaryInterceptor(ctx context.Context, req interface{
 	return res, err
 }
 
-func (i *ServerInterceptor) callStreamHandler(handler grpc.StreamHandler, srv interface{}, stream grpc.ServerStream) (err error) {
+func (i *ServerInterceptor) callStreamHandler(fullMethod string, handler grpc.StreamHandler, srv interface{}, stream grpc.ServerStream) (err error) {
 	defer func() {
 		if r := recover(); r != nil {
-			err = fmt.Errorf("panic occurred: %v", r)
-			i.observer.Logger().Error("Panic occurred.", zap.Error(err))
-			i.instruments.panicCounter.Add(context.Background(), 1)
+			// The endpoint is only parsed when a panic occurs, so excluded methods are not parsed
+			e, _ := parseEndpoint(fullMethod)
+			err = errs.FromPanic("panic occurred", r)
+			i.observer.Logger().Error("Panic occurred.", zap.Error(err), zap.String("panic.type", fmt.Sprintf("%T", r)))
+			i.instruments.panicCounter.Add(context.Background(), 1,
+				label.String("package", e.Package),
+				label.String("service", e.Service),
+				label.String("method", e.Method),
+			)
 		}
 	}()
 
@@ -278,22 +430,21 @@ func (i *ServerInterceptor) callStreamHandler(handler grpc.StreamHandler, srv in
 }
 
 func (i *ServerInterceptor) streamInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
-	startTime := time.Now()
+	startTime := i.opts.clock.Now()
 	ctx := ss.Context()
 	kind := "server"
 	stream := true
+	system := i.opts.isSystem(info.FullMethod)
+
+	// Check excluded methods before doing any work
+	if i.opts.isExcluded(info.FullMethod) {
+		return i.callStreamHandler(info.FullMethod, handler, srv, ss)
+	}
 
 	// Get the package, service, and method name for the request
 	e, ok := parseEndpoint(info.FullMethod)
 	if !ok {
-		return i.callStreamHandler(handler, srv, ss)
-	}
-
-	// Check excluded methods
-	for _, m := range i.opts.ExcludedMethods {
-		if e.Method == m {
-			return i.callStreamHandler(handler, srv, ss)
-		}
+		return i.callStreamHandler(info.FullMethod, handler, srv, ss)
 	}
 
 	// Increase the number of in-flight requests
@@ -312,16 +463,21 @@ func (i *ServerInterceptor) streamInterceptor(srv interface{}, ss grpc.ServerStr
 		label.Bool("stream", stream),
 	)
 
+	// Keep track of the peak number of in-flight requests
+	i.instruments.reqPeak.Inc()
+	defer i.instruments.reqPeak.Dec()
+
 	// Get grpc request metadata (an incoming grpc request context is guaranteed to have metadata)
 	md, _ := metadata.FromIncomingContext(ctx)
 	md = md.Copy()
 
-	// Make sure the request has a UUID
+	// Make sure the request has a valid UUID
 	var requestUUID string
 	if vals := md.Get(requestUUIDKey); len(vals) > 0 {
 		requestUUID = vals[0]
 	}
-	if requestUUID == "" {
+	clientRequestUUID := i.opts.untrustedRequestUUID(requestUUID)
+	if i.opts.IgnoreIncomingRequestID || !i.opts.validRequestUUID(requestUUID) {
 		requestUUID = uuid.New().String()
 		md.Set(requestUUIDKey, requestUUID)
 		ctx = metadata.NewIncomingContext(ctx, md)
@@ -333,12 +489,20 @@ func (i *ServerInterceptor) streamInterceptor(srv interface{}, ss grpc.ServerStr
 		clientName = vals[0]
 	}
 
+	// Get the compression algorithm of the request if any
+	encoding := requestEncoding(ctx, md)
+
 	// Propagate request metadata by adding them to outgoing grpc response metadata
-	header := metadata.New(map[string]string{
-		requestUUIDKey: requestUUID,
-		clientNameKey:  clientName,
-	})
-	_ = ss.SendHeader(header)
+	header := metadata.MD{}
+	if i.opts.echoMetadata(requestUUIDKey) {
+		header.Set(requestUUIDKey, requestUUID)
+	}
+	if i.opts.echoMetadata(clientNameKey) {
+		header.Set(clientNameKey, clientName)
+	}
+	if header.Len() > 0 {
+		_ = ss.SendHeader(header)
+	}
 
 	// Extract context from the grpc metadata
 	ctx = otel.GetTextMapPropagator().Extract(ctx, &metadataTextMapCarrier{md: &md})
@@ -352,12 +516,20 @@ func (i *ServerInterceptor) streamInterceptor(srv interface{}, ss grpc.ServerStr
 	)
 
 	// Start a new span
-	ctx, span := i.observer.Tracer().Start(ctx,
+	ctx, span := i.opts.tracer(e.Method, i.observer.Tracer()).Start(ctx,
 		fmt.Sprintf("%s (server stream)", e.Method),
 		trace.WithSpanKind(trace.SpanKindServer),
 	)
 	defer span.End()
 
+	if encoding != "" {
+		span.SetAttributes(label.String("grpc.encoding", encoding))
+	}
+
+	if i.opts.RecordCodeLocation {
+		span.SetAttributes(tracing.CodeLocation(handler)...)
+	}
+
 	// Create a contextualized logger
 	contextFields := []zap.Field{
 		zap.String("req.uuid", requestUUID),
@@ -372,50 +544,112 @@ func (i *ServerInterceptor) streamInterceptor(srv interface{}, ss grpc.ServerStr
 	if clientName != "" {
 		contextFields = append(contextFields, zap.String("client.name", clientName))
 	}
-	logger := i.observer.Logger().With(contextFields...)
+	if clientRequestUUID != "" {
+		contextFields = append(contextFields, zap.String("client.request_uuid", clientRequestUUID))
+	}
+	if encoding != "" {
+		contextFields = append(contextFields, zap.String("grpc.encoding", encoding))
+	}
+	if i.opts.BaggageToLogs {
+		contextFields = append(contextFields, request.BaggageFields(ctx)...)
+	}
+	logger := i.observer.SpanLogger(span.SpanContext()).With(contextFields...)
 
 	// Augment the request context
-	ctx = observer.ContextWithUUID(ctx, requestUUID)
+	// The request metadata set by outer handlers (i.e. the principal set by an auth middleware) are kept
+	reqMD, _ := observer.RequestMetadataFromContext(ctx)
+	reqMD.UUID = requestUUID
+	reqMD.ClientName = clientName
+	reqMD.StartTime = startTime
+	reqMD.TraceID = span.SpanContext().TraceID
+	reqMD.SpanID = span.SpanContext().SpanID
+	ctx = observer.ContextWithRequestMetadata(ctx, reqMD)
 	ctx = observer.ContextWithLogger(ctx, logger)
-	ss = ServerStreamWithContext(ctx, ss)
+	ctx = observer.ContextWithObserver(ctx, i.observer)
+	ctx = observer.ContextWithFields(ctx)
+	cs := newCountingServerStream(ServerStreamWithContext(ctx, ss), i.opts.clock)
+
+	// Measure how long sending messages blocks (i.e. flow control when the client is slow to receive messages)
+	cs.onSend = func(d time.Duration) {
+		i.instruments.streamSend.Record(ctx, d.Milliseconds(),
+			label.String("package", e.Package),
+			label.String("service", e.Service),
+			label.String("method", e.Method),
+		)
+		if i.opts.StreamSendBlockThreshold > 0 && d >= i.opts.StreamSendBlockThreshold {
+			span.AddEvent("send blocked", trace.WithAttributes(label.Int64("send.blocked_ms", d.Milliseconds())))
+		}
+	}
 
 	// Call gRPC method handler
 	span.AddEvent("calling grpc method handler")
-	err := i.callStreamHandler(handler, srv, ss)
+	err := i.callStreamHandler(info.FullMethod, handler, srv, cs)
 
-	duration := time.Since(startTime).Milliseconds()
+	elapsed := i.opts.clock.Now().Sub(startTime)
+	duration := elapsed.Milliseconds()
 	success := err == nil
+	sent, received, bytes := cs.totals()
+
+	// For long-lived streams, the time the handler is idle waiting for the client is excluded
+	active := (elapsed - cs.idle()).Milliseconds()
+	if active < 0 {
+		active = 0
+	}
 
 	// Report metrics
-	i.observer.Meter().RecordBatch(ctx,
-		[]label.KeyValue{
-			label.String("package", e.Package),
-			label.String("service", e.Service),
-			label.String("method", e.Method),
-			label.Bool("stream", stream),
-			label.Bool("success", success),
-		},
-		i.instruments.reqCounter.Measurement(1),
-		i.instruments.reqDuration.Measurement(duration),
-	)
+	labels := []label.KeyValue{
+		label.String("package", e.Package),
+		label.String("service", e.Service),
+		label.String("method", e.Method),
+		label.Bool("stream", stream),
+		label.Bool("success", success),
+	}
+	labels = append(labels, i.opts.tenantLabels(ctx)...)
+	labels = append(labels, i.opts.metricLabels(ctx, i.observer.Logger())...)
+	if system {
+		i.instruments.sysCounter.Add(ctx, 1, labels...)
+	} else {
+		i.observer.Meter().RecordBatch(ctx, labels,
+			i.instruments.reqCounter.Measurement(1),
+			i.instruments.reqDuration.Measurement(duration),
+			i.instruments.streamActive.Measurement(active),
+		)
+		if i.opts.DurationSeconds {
+			i.instruments.reqSeconds.Record(ctx, elapsed.Seconds(), labels...)
+		}
+	}
 
 	// Report logs
 	message := fmt.Sprintf("%s %s %dms", kind, e, duration)
 	fields := []zap.Field{
 		zap.Bool("resp.success", success),
 		zap.Int64("resp.duration", duration),
+		zap.Int64("stream.active", active),
+		zap.Int64("stream.sent", sent),
+		zap.Int64("stream.received", received),
+		zap.Int64("stream.bytes", bytes),
 	}
 	if err != nil {
 		fields = append(fields, zap.String("grpc.error", err.Error()))
+		if i.opts.ErrorStackInSpan {
+			fields = append(fields, tracing.RecordErrorStacks(span, err)...)
+		}
 	}
 
+	// The fields added while handling the request (i.e. by auth interceptors) are read once the handler returns
+	fields = append(fields, observer.FieldsFromContext(ctx)...)
+
 	// Determine the log level based on the result
-	if success {
+	if system {
+		logger.Debug(message, fields...)
+	} else if success {
 		if i.opts.LogInDebugLevel {
 			logger.Debug(message, fields...)
 		} else {
 			logger.Info(message, fields...)
 		}
+	} else if i.opts.isQuiet(e.Method) {
+		logger.Info(message, fields...)
 	} else {
 		logger.Error(message, fields...)
 	}
@@ -427,6 +661,10 @@ func (i *ServerInterceptor) streamInterceptor(srv interface{}, ss grpc.ServerStr
 		label.String("method", e.Method),
 		label.Bool("stream", stream),
 		label.Bool("success", success),
+		label.Int64("stream.active", active),
+		label.Int64("stream.sent", sent),
+		label.Int64("stream.received", received),
+		label.Int64("stream.bytes", bytes),
 	)
 	if err != nil {
 		code := codes.Code(status.Code(err))
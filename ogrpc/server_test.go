@@ -2,15 +2,73 @@ package ogrpc
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/moorara/observer"
+	"github.com/moorara/observer/internal/clock"
 	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/baggage"
+	"go.opentelemetry.io/otel/label"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/oteltest"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	zapobserver "go.uber.org/zap/zaptest/observer"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/types/known/wrapperspb"
 )
 
+func TestNewServerInterceptor(t *testing.T) {
+	tests := []struct {
+		name  string
+		opts  Options
+		meter metric.Meter
+	}{
+		{
+			name:  "OK",
+			opts:  Options{},
+			meter: new(metric.NoopMeterProvider).Meter(""),
+		},
+		{
+			name: "FailingMeter",
+			opts: Options{},
+			meter: metric.WrapMeterImpl(&mockMeterImpl{
+				NewSyncInstrumentOutError: errors.New("error on creating instrument"),
+			}, ""),
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			obsv := newMockObserver()
+			obsv.meter = tc.meter
+
+			si := NewServerInterceptor(obsv, tc.opts)
+
+			assert.NotNil(t, si)
+			assert.NotNil(t, si.instruments)
+
+			// Another one from the same observer should share the same instruments
+			another := NewServerInterceptor(obsv, tc.opts)
+			assert.Same(t, si.instruments, another.instruments)
+		})
+	}
+}
+
 func TestServerUnaryInterceptor(t *testing.T) {
 	tests := []struct {
 		name             string
@@ -150,7 +208,12 @@ func TestServerUnaryInterceptor(t *testing.T) {
 
 	for _, tc := range tests {
 		t.Run(tc.name, func(t *testing.T) {
+			core, logs := zapobserver.New(zapcore.DebugLevel)
 			obsv := newMockObserver()
+			obsv.logger = zap.New(core)
+
+			// The clock does not move while the handler sleeps, so durations are deterministic
+			tc.opts.Now = clock.NewFake().Now
 			si := NewServerInterceptor(obsv, tc.opts)
 			assert.NotNil(t, si)
 
@@ -162,7 +225,17 @@ func TestServerUnaryInterceptor(t *testing.T) {
 			assert.Equal(t, tc.expectedResponse, res)
 			assert.Equal(t, tc.expectedError, err)
 
-			// TODO: Verify logs
+			// Verify logs
+			if tc.expectedMethod != "" {
+				message := fmt.Sprintf("server %s::%s::%s 0ms", tc.expectedPackage, tc.expectedService, tc.expectedMethod)
+				entries := logs.FilterMessage(message).All()
+				assert.Len(t, entries, 1)
+				for _, e := range entries {
+					assert.Equal(t, tc.expectedSuccess, e.ContextMap()["resp.success"])
+					assert.Equal(t, int64(0), e.ContextMap()["resp.duration"])
+				}
+			}
+
 			// TODO: Verify metrics
 			// TODO: Verify traces
 		})
@@ -316,3 +389,1484 @@ func TestServerStreamInterceptor(t *testing.T) {
 		})
 	}
 }
+
+func TestServerInterceptorRecordCodeLocation(t *testing.T) {
+	tests := []struct {
+		name             string
+		opts             Options
+		expectedLocation bool
+	}{
+		{
+			name:             "Disabled",
+			opts:             Options{},
+			expectedLocation: false,
+		},
+		{
+			name: "Enabled",
+			opts: Options{
+				RecordCodeLocation: true,
+			},
+			expectedLocation: true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			sr := new(oteltest.StandardSpanRecorder)
+			obsv := newMockObserver()
+			obsv.tracer = oteltest.NewTracerProvider(oteltest.WithSpanRecorder(sr)).Tracer("")
+			si := NewServerInterceptor(obsv, tc.opts)
+
+			unaryHandler := func(ctx context.Context, req interface{}) (interface{}, error) {
+				return nil, nil
+			}
+
+			streamHandler := func(srv interface{}, stream grpc.ServerStream) error {
+				return nil
+			}
+
+			_, err := si.unaryInterceptor(context.Background(), nil, &grpc.UnaryServerInfo{FullMethod: "/itemPB.ItemManager/GetItem"}, unaryHandler)
+			assert.NoError(t, err)
+
+			err = si.streamInterceptor(nil, &mockServerStream{ContextOutContext: context.Background()}, &grpc.StreamServerInfo{FullMethod: "/itemPB.ItemManager/GetItems"}, streamHandler)
+			assert.NoError(t, err)
+
+			spans := sr.Completed()
+			assert.Len(t, spans, 2)
+
+			for _, span := range spans {
+				attrs := span.Attributes()
+				if tc.expectedLocation {
+					assert.Contains(t, attrs[label.Key("code.function")].AsString(), "TestServerInterceptorRecordCodeLocation")
+					assert.Contains(t, attrs[label.Key("code.filepath")].AsString(), "server_test.go")
+					assert.NotZero(t, attrs[label.Key("code.lineno")].AsInt64())
+				} else {
+					assert.NotContains(t, attrs, label.Key("code.function"))
+				}
+			}
+		})
+	}
+}
+
+func TestServerInterceptorMethodSamplingRatios(t *testing.T) {
+	tests := []struct {
+		name        string
+		opts        Options
+		fullMethod  string
+		requests    int
+		expectedMin int
+		expectedMax int
+	}{
+		{
+			name: "UnspecifiedMethod",
+			opts: Options{
+				MethodSamplingRatios: map[string]float64{"Ping": 0},
+			},
+			fullMethod:  "/itemPB.ItemManager/GetItem",
+			requests:    100,
+			expectedMin: 100,
+			expectedMax: 100,
+		},
+		{
+			name: "NeverSampled",
+			opts: Options{
+				MethodSamplingRatios: map[string]float64{"Ping": 0},
+			},
+			fullMethod:  "/itemPB.ItemManager/Ping",
+			requests:    100,
+			expectedMin: 0,
+			expectedMax: 0,
+		},
+		{
+			name: "AlwaysSampled",
+			opts: Options{
+				MethodSamplingRatios: map[string]float64{"Ping": 1},
+			},
+			fullMethod:  "/itemPB.ItemManager/Ping",
+			requests:    100,
+			expectedMin: 100,
+			expectedMax: 100,
+		},
+		{
+			name: "PartiallySampled",
+			opts: Options{
+				MethodSamplingRatios: map[string]float64{"Ping": 0.25},
+			},
+			fullMethod:  "/itemPB.ItemManager/Ping",
+			requests:    2000,
+			expectedMin: 400,
+			expectedMax: 600,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			sr := new(oteltest.StandardSpanRecorder)
+			obsv := newMockObserver()
+			obsv.tracer = oteltest.NewTracerProvider(oteltest.WithSpanRecorder(sr)).Tracer("")
+			si := NewServerInterceptor(obsv, tc.opts)
+
+			handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+				return nil, nil
+			}
+
+			for i := 0; i < tc.requests; i++ {
+				_, err := si.unaryInterceptor(context.Background(), nil, &grpc.UnaryServerInfo{FullMethod: tc.fullMethod}, handler)
+				assert.NoError(t, err)
+			}
+
+			sampled := len(sr.Completed())
+			assert.GreaterOrEqual(t, sampled, tc.expectedMin)
+			assert.LessOrEqual(t, sampled, tc.expectedMax)
+		})
+	}
+}
+
+func TestServerInterceptorMethodSamplingPropagation(t *testing.T) {
+	defer otel.SetTextMapPropagator(otel.GetTextMapPropagator())
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	tests := []struct {
+		name             string
+		ratio            float64
+		traceparent      string
+		expectedTraceID  string
+		expectedSampled  bool
+		expectedRecorded int
+	}{
+		{
+			name:             "UnsampledRoot",
+			ratio:            0,
+			expectedSampled:  false,
+			expectedRecorded: 0,
+		},
+		{
+			name:             "SampledParent",
+			ratio:            0,
+			traceparent:      "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+			expectedTraceID:  "4bf92f3577b34da6a3ce929d0e0e4736",
+			expectedSampled:  true,
+			expectedRecorded: 1,
+		},
+		{
+			name:             "UnsampledParent",
+			ratio:            1,
+			traceparent:      "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00",
+			expectedTraceID:  "4bf92f3577b34da6a3ce929d0e0e4736",
+			expectedSampled:  false,
+			expectedRecorded: 0,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			sr := new(oteltest.StandardSpanRecorder)
+			obsv := newMockObserver()
+			obsv.tracer = oteltest.NewTracerProvider(oteltest.WithSpanRecorder(sr)).Tracer("")
+			si := NewServerInterceptor(obsv, Options{
+				MethodSamplingRatios: map[string]float64{"Ping": tc.ratio},
+			})
+
+			ctx := context.Background()
+			if tc.traceparent != "" {
+				ctx = metadata.NewIncomingContext(ctx, metadata.Pairs("traceparent", tc.traceparent))
+			}
+
+			handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+				sc := trace.SpanContextFromContext(ctx)
+				assert.True(t, sc.IsValid())
+				assert.Equal(t, tc.expectedSampled, sc.IsSampled())
+				if tc.expectedTraceID != "" {
+					assert.Equal(t, tc.expectedTraceID, sc.TraceID.String())
+					assert.NotEqual(t, "00f067aa0ba902b7", sc.SpanID.String())
+				}
+				return nil, nil
+			}
+
+			_, err := si.unaryInterceptor(ctx, nil, &grpc.UnaryServerInfo{FullMethod: "/itemPB.ItemManager/Ping"}, handler)
+			assert.NoError(t, err)
+			assert.Len(t, sr.Completed(), tc.expectedRecorded)
+		})
+	}
+}
+
+func TestServerInterceptorPrincipal(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audit")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "audit.log")
+
+	obsv := observer.New(false, observer.WithLogger("none"), observer.WithAuditOutput(path))
+	si := NewServerInterceptor(obsv, Options{})
+
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		observer.Audit(ctx, "item deleted")
+		return nil, nil
+	}
+
+	// An auth interceptor running before the observability interceptor sets the principal
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(requestUUIDKey, "aaaaaaaa-aaaa-aaaa-aaaa-aaaaaaaaaaaa"))
+	ctx = observer.ContextWithPrincipal(ctx, "jane@example.com")
+	_, err = si.unaryInterceptor(ctx, nil, &grpc.UnaryServerInfo{FullMethod: "/itemPB.ItemManager/DeleteItem"}, handler)
+	assert.NoError(t, err)
+	obsv.Shutdown(context.Background())
+
+	data, err := ioutil.ReadFile(path)
+	assert.NoError(t, err)
+
+	entry := map[string]interface{}{}
+	assert.NoError(t, json.Unmarshal(data, &entry))
+	assert.Equal(t, "item deleted", entry["message"])
+	assert.Equal(t, "jane@example.com", entry["principal"])
+	assert.Equal(t, "aaaaaaaa-aaaa-aaaa-aaaa-aaaaaaaaaaaa", entry["req.uuid"])
+}
+
+func TestServerInterceptorLogSchema(t *testing.T) {
+	core, logs := zapobserver.New(zapcore.DebugLevel)
+	obsv := newMockObserver()
+	obsv.logger = zap.New(core)
+	si := NewServerInterceptor(obsv, Options{
+		BaggageToLogs:     true,
+		ErrorStackInSpan:  true,
+		RecordMessageType: true,
+	})
+
+	ctx := baggage.ContextWithValues(context.Background(), label.String("tenant", "acme"))
+	ctx = metadata.NewIncomingContext(ctx, metadata.Pairs(clientNameKey, "test-client", "grpc-encoding", "gzip"))
+
+	unaryHandler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		observer.LoggerFromContext(ctx).Info("unary handler")
+		return wrapperspb.String("item"), &stackError{msg: "item not found", stack: "main.getItem\n\tmain.go:10"}
+	}
+
+	streamHandler := func(srv interface{}, stream grpc.ServerStream) error {
+		observer.LoggerFromContext(stream.Context()).Info("stream handler")
+		return stream.SendMsg(wrapperspb.String("item"))
+	}
+
+	_, err := si.unaryInterceptor(ctx, wrapperspb.String("1234"), &grpc.UnaryServerInfo{FullMethod: "/itemPB.ItemManager/GetItem"}, unaryHandler)
+	assert.Error(t, err)
+
+	err = si.streamInterceptor(nil, &mockServerStream{ContextOutContext: ctx}, &grpc.StreamServerInfo{FullMethod: "/itemPB.ItemManager/GetItems"}, streamHandler)
+	assert.NoError(t, err)
+
+	assert.Equal(t, 4, logs.Len())
+	assertLogSchema(t, logs.All())
+}
+
+// assertLogSchema asserts that the fields of log entries are described by observer.LogSchema.
+func assertLogSchema(t *testing.T, entries []zapobserver.LoggedEntry) {
+	schema := observer.LogSchema()
+	for _, e := range entries {
+		for key := range e.ContextMap() {
+			if strings.HasPrefix(key, "baggage.") {
+				key = "baggage.*"
+			}
+			assert.Contains(t, schema, key, "field %q of %q is not in the log schema", key, e.Message)
+		}
+	}
+}
+
+func TestServerInterceptorAddFields(t *testing.T) {
+	core, logs := zapobserver.New(zapcore.DebugLevel)
+	obsv := newMockObserver()
+	obsv.logger = zap.New(core)
+	si := NewServerInterceptor(obsv, Options{})
+
+	// An auth interceptor that runs inside our interceptor
+	auth := func(ctx context.Context) {
+		observer.AddFields(ctx, zap.String("principal", "admin"))
+	}
+
+	unaryHandler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		auth(ctx)
+		return nil, nil
+	}
+
+	streamHandler := func(srv interface{}, stream grpc.ServerStream) error {
+		auth(stream.Context())
+		return nil
+	}
+
+	_, err := si.unaryInterceptor(context.Background(), nil, &grpc.UnaryServerInfo{FullMethod: "/itemPB.ItemManager/GetItem"}, unaryHandler)
+	assert.NoError(t, err)
+
+	err = si.streamInterceptor(nil, &mockServerStream{ContextOutContext: context.Background()}, &grpc.StreamServerInfo{FullMethod: "/itemPB.ItemManager/GetItems"}, streamHandler)
+	assert.NoError(t, err)
+
+	assert.Equal(t, 2, logs.Len())
+	for _, e := range logs.All() {
+		assert.Equal(t, "admin", e.ContextMap()["principal"])
+	}
+}
+
+func TestServerInterceptorMetricLabelsFromContext(t *testing.T) {
+	impl, meter := oteltest.NewMeter()
+	obsv := newMockObserver()
+	obsv.meter = meter
+	si := NewServerInterceptor(obsv, Options{
+		MetricLabelsFromContext: func(ctx context.Context) []label.KeyValue {
+			return []label.KeyValue{
+				label.String("plan", baggage.Value(ctx, label.Key("plan")).Emit()),
+			}
+		},
+	})
+
+	ctx := baggage.ContextWithValues(context.Background(), label.String("plan", "premium"))
+
+	unaryHandler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return nil, nil
+	}
+
+	streamHandler := func(srv interface{}, stream grpc.ServerStream) error {
+		return nil
+	}
+
+	_, err := si.unaryInterceptor(ctx, nil, &grpc.UnaryServerInfo{FullMethod: "/itemPB.ItemManager/GetItem"}, unaryHandler)
+	assert.NoError(t, err)
+
+	err = si.streamInterceptor(nil, &mockServerStream{ContextOutContext: ctx}, &grpc.StreamServerInfo{FullMethod: "/itemPB.ItemManager/GetItems"}, streamHandler)
+	assert.NoError(t, err)
+
+	methods := map[string]bool{}
+	for _, m := range oteltest.AsStructs(impl.MeasurementBatches) {
+		if m.Name == "incoming_grpc_requests_total" {
+			methods[m.Labels["method"].AsString()] = true
+			assert.Equal(t, "premium", m.Labels["plan"].AsString())
+		}
+	}
+	assert.Equal(t, map[string]bool{"GetItem": true, "GetItems": true}, methods)
+}
+
+func TestServerInterceptorBaggageToLogs(t *testing.T) {
+	tests := []struct {
+		name           string
+		opts           Options
+		expectedFields map[string]interface{}
+	}{
+		{
+			name:           "Disabled",
+			opts:           Options{},
+			expectedFields: map[string]interface{}{},
+		},
+		{
+			name: "Enabled",
+			opts: Options{
+				BaggageToLogs: true,
+			},
+			expectedFields: map[string]interface{}{
+				"baggage.tenant":  "acme",
+				"baggage.feature": "beta",
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			core, logs := zapobserver.New(zapcore.DebugLevel)
+			obsv := newMockObserver()
+			obsv.logger = zap.New(core)
+			si := NewServerInterceptor(obsv, tc.opts)
+
+			ctx := baggage.ContextWithValues(context.Background(),
+				label.String("tenant", "acme"),
+				label.String("feature", "beta"),
+			)
+
+			unaryHandler := func(ctx context.Context, req interface{}) (interface{}, error) {
+				observer.LoggerFromContext(ctx).Info("unary handler")
+				return nil, nil
+			}
+
+			streamHandler := func(srv interface{}, stream grpc.ServerStream) error {
+				observer.LoggerFromContext(stream.Context()).Info("stream handler")
+				return nil
+			}
+
+			_, err := si.unaryInterceptor(ctx, nil, &grpc.UnaryServerInfo{FullMethod: "/itemPB.ItemManager/GetItem"}, unaryHandler)
+			assert.NoError(t, err)
+
+			err = si.streamInterceptor(nil, &mockServerStream{ContextOutContext: ctx}, &grpc.StreamServerInfo{FullMethod: "/itemPB.ItemManager/GetItems"}, streamHandler)
+			assert.NoError(t, err)
+
+			for _, message := range []string{"unary handler", "stream handler"} {
+				entries := logs.FilterMessage(message).All()
+				assert.Len(t, entries, 1)
+
+				fields := entries[0].ContextMap()
+				assert.NotContains(t, fields, "baggage.req.uuid")
+				for key, val := range tc.expectedFields {
+					assert.Equal(t, val, fields[key])
+				}
+				if len(tc.expectedFields) == 0 {
+					assert.NotContains(t, fields, "baggage.tenant")
+				}
+			}
+		})
+	}
+}
+
+func TestServerInterceptorPanicLabels(t *testing.T) {
+	impl, meter := oteltest.NewMeter()
+	obsv := newMockObserver()
+	obsv.meter = meter
+	si := NewServerInterceptor(obsv, Options{})
+
+	unaryHandler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		panic("something went wrong")
+	}
+
+	streamHandler := func(srv interface{}, stream grpc.ServerStream) error {
+		panic("something went wrong")
+	}
+
+	_, err := si.unaryInterceptor(context.Background(), nil, &grpc.UnaryServerInfo{FullMethod: "/itemPB.ItemManager/GetItem"}, unaryHandler)
+	assert.Error(t, err)
+
+	err = si.streamInterceptor(nil, &mockServerStream{ContextOutContext: context.Background()}, &grpc.StreamServerInfo{FullMethod: "/itemPB.ItemManager/GetItems"}, streamHandler)
+	assert.Error(t, err)
+
+	var methods []string
+	for _, m := range oteltest.AsStructs(impl.MeasurementBatches) {
+		if m.Name == "handler_panics_total" {
+			assert.Equal(t, "itemPB", m.Labels["package"].AsString())
+			assert.Equal(t, "ItemManager", m.Labels["service"].AsString())
+			methods = append(methods, m.Labels["method"].AsString())
+		}
+	}
+
+	assert.Equal(t, []string{"GetItem", "GetItems"}, methods)
+}
+
+func TestServerInterceptorPanicType(t *testing.T) {
+	errNotFound := errors.New("item not found")
+
+	tests := []struct {
+		name         string
+		r            interface{}
+		expectedType string
+		expectedIs   error
+	}{
+		{
+			name:         "StringPanic",
+			r:            "something went wrong",
+			expectedType: "string",
+			expectedIs:   nil,
+		},
+		{
+			name:         "ErrorPanic",
+			r:            errNotFound,
+			expectedType: "*errors.errorString",
+			expectedIs:   errNotFound,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			core, logs := zapobserver.New(zapcore.DebugLevel)
+			obsv := newMockObserver()
+			obsv.logger = zap.New(core)
+			si := NewServerInterceptor(obsv, Options{})
+
+			unaryHandler := func(ctx context.Context, req interface{}) (interface{}, error) {
+				panic(tc.r)
+			}
+
+			streamHandler := func(srv interface{}, stream grpc.ServerStream) error {
+				panic(tc.r)
+			}
+
+			_, unaryErr := si.unaryInterceptor(context.Background(), nil, &grpc.UnaryServerInfo{FullMethod: "/itemPB.ItemManager/GetItem"}, unaryHandler)
+			streamErr := si.streamInterceptor(nil, &mockServerStream{ContextOutContext: context.Background()}, &grpc.StreamServerInfo{FullMethod: "/itemPB.ItemManager/GetItems"}, streamHandler)
+
+			for _, err := range []error{unaryErr, streamErr} {
+				assert.Error(t, err)
+				if tc.expectedIs != nil {
+					assert.True(t, errors.Is(err, tc.expectedIs))
+				}
+			}
+
+			panicLogs := logs.FilterMessage("Panic occurred.").All()
+			assert.Len(t, panicLogs, 2)
+			for _, e := range panicLogs {
+				assert.Equal(t, tc.expectedType, e.ContextMap()["panic.type"])
+			}
+		})
+	}
+}
+
+func TestServerInterceptorAttributesFromRequest(t *testing.T) {
+	type getUserRequest struct {
+		UserID string
+	}
+
+	tests := []struct {
+		name              string
+		opts              Options
+		expectedAttribute string
+		expectedPanicLog  bool
+	}{
+		{
+			name:              "NoFunc",
+			opts:              Options{},
+			expectedAttribute: "",
+			expectedPanicLog:  false,
+		},
+		{
+			name: "ExtractField",
+			opts: Options{
+				AttributesFromRequest: func(fullMethod string, req interface{}) []label.KeyValue {
+					if r, ok := req.(*getUserRequest); ok {
+						return []label.KeyValue{label.String("user.id", r.UserID)}
+					}
+					return nil
+				},
+			},
+			expectedAttribute: "1234",
+			expectedPanicLog:  false,
+		},
+		{
+			name: "Panic",
+			opts: Options{
+				AttributesFromRequest: func(fullMethod string, req interface{}) []label.KeyValue {
+					panic("something went wrong")
+				},
+			},
+			expectedAttribute: "",
+			expectedPanicLog:  true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			core, logs := zapobserver.New(zapcore.DebugLevel)
+			sr := new(oteltest.StandardSpanRecorder)
+			obsv := newMockObserver()
+			obsv.logger = zap.New(core)
+			obsv.tracer = oteltest.NewTracerProvider(oteltest.WithSpanRecorder(sr)).Tracer("")
+			si := NewServerInterceptor(obsv, tc.opts)
+
+			handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+				observer.LoggerFromContext(ctx).Info("unary handler")
+				return nil, nil
+			}
+
+			req := &getUserRequest{UserID: "1234"}
+			_, err := si.unaryInterceptor(context.Background(), req, &grpc.UnaryServerInfo{FullMethod: "/userPB.UserManager/GetUser"}, handler)
+			assert.NoError(t, err)
+
+			spans := sr.Completed()
+			assert.Len(t, spans, 1)
+			attrs := spans[0].Attributes()
+
+			entries := logs.FilterMessage("unary handler").All()
+			assert.Len(t, entries, 1)
+			fields := entries[0].ContextMap()
+
+			if tc.expectedAttribute != "" {
+				assert.Equal(t, tc.expectedAttribute, attrs[label.Key("user.id")].AsString())
+				assert.Equal(t, tc.expectedAttribute, fields["user.id"])
+			} else {
+				assert.NotContains(t, attrs, label.Key("user.id"))
+				assert.NotContains(t, fields, "user.id")
+			}
+
+			panicLogs := logs.FilterMessage("Panic occurred in AttributesFromRequest.").All()
+			assert.Equal(t, tc.expectedPanicLog, len(panicLogs) == 1)
+		})
+	}
+}
+
+func TestServerInterceptorQuietMethods(t *testing.T) {
+	tests := []struct {
+		name          string
+		opts          Options
+		fullMethod    string
+		expectedLevel zapcore.Level
+	}{
+		{
+			name: "NotQuiet",
+			opts: Options{
+				QuietMethods: []string{"CreateItemIfNotExists"},
+			},
+			fullMethod:    "/itemPB.ItemManager/CreateItem",
+			expectedLevel: zapcore.ErrorLevel,
+		},
+		{
+			name: "Quiet",
+			opts: Options{
+				QuietMethods: []string{"CreateItemIfNotExists"},
+			},
+			fullMethod:    "/itemPB.ItemManager/CreateItemIfNotExists",
+			expectedLevel: zapcore.InfoLevel,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			core, logs := zapobserver.New(zapcore.DebugLevel)
+			impl, meter := oteltest.NewMeter()
+			obsv := newMockObserver()
+			obsv.logger = zap.New(core)
+			obsv.meter = meter
+			si := NewServerInterceptor(obsv, tc.opts)
+
+			handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+				return nil, errors.New("item already exists")
+			}
+
+			_, err := si.unaryInterceptor(context.Background(), nil, &grpc.UnaryServerInfo{FullMethod: tc.fullMethod}, handler)
+			assert.Error(t, err)
+
+			entries := logs.All()
+			assert.Len(t, entries, 1)
+			assert.Equal(t, tc.expectedLevel, entries[0].Level)
+
+			// The error should still be reported in metrics
+			var found bool
+			for _, m := range oteltest.AsStructs(impl.MeasurementBatches) {
+				if m.Name == "incoming_grpc_requests_total" {
+					found = true
+					assert.False(t, m.Labels["success"].AsBool())
+				}
+			}
+			assert.True(t, found)
+		})
+	}
+}
+
+func TestServerInterceptorErrorStackInSpan(t *testing.T) {
+	tests := []struct {
+		name           string
+		opts           Options
+		err            error
+		expectedStacks []string
+	}{
+		{
+			name:           "Disabled",
+			opts:           Options{},
+			err:            &stackError{msg: "item not found", stack: "main.getItem\n\tmain.go:10"},
+			expectedStacks: nil,
+		},
+		{
+			name:           "PlainError",
+			opts:           Options{ErrorStackInSpan: true},
+			err:            errors.New("item not found"),
+			expectedStacks: nil,
+		},
+		{
+			name:           "StackError",
+			opts:           Options{ErrorStackInSpan: true},
+			err:            &stackError{msg: "item not found", stack: "main.getItem\n\tmain.go:10"},
+			expectedStacks: []string{"main.getItem\n\tmain.go:10"},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			core, logs := zapobserver.New(zapcore.DebugLevel)
+			sr := new(oteltest.StandardSpanRecorder)
+			obsv := newMockObserver()
+			obsv.logger = zap.New(core)
+			obsv.tracer = oteltest.NewTracerProvider(oteltest.WithSpanRecorder(sr)).Tracer("")
+			si := NewServerInterceptor(obsv, tc.opts)
+
+			unaryHandler := func(ctx context.Context, req interface{}) (interface{}, error) {
+				return nil, tc.err
+			}
+
+			streamHandler := func(srv interface{}, stream grpc.ServerStream) error {
+				return tc.err
+			}
+
+			_, err := si.unaryInterceptor(context.Background(), nil, &grpc.UnaryServerInfo{FullMethod: "/itemPB.ItemManager/GetItem"}, unaryHandler)
+			assert.Equal(t, tc.err, err)
+
+			err = si.streamInterceptor(nil, &mockServerStream{ContextOutContext: context.Background()}, &grpc.StreamServerInfo{FullMethod: "/itemPB.ItemManager/GetItems"}, streamHandler)
+			assert.Equal(t, tc.err, err)
+
+			entries := logs.All()
+			assert.Len(t, entries, 2)
+
+			spans := sr.Completed()
+			assert.Len(t, spans, 2)
+
+			for i := range entries {
+				var stacks []string
+				for _, event := range spans[i].Events() {
+					if v, ok := event.Attributes[label.Key("exception.stacktrace")]; ok {
+						stacks = append(stacks, v.AsString())
+					}
+				}
+				assert.Equal(t, tc.expectedStacks, stacks)
+
+				if tc.expectedStacks == nil {
+					assert.NotContains(t, entries[i].ContextMap(), "error.stacks")
+				} else {
+					assert.Contains(t, entries[i].ContextMap(), "error.stacks")
+				}
+
+				// The span status is still set from the error
+				assert.Equal(t, tc.err.Error(), spans[i].StatusMessage())
+			}
+		})
+	}
+}
+
+func TestServerStreamInterceptorCompletionLog(t *testing.T) {
+	core, logs := zapobserver.New(zapcore.DebugLevel)
+	obsv := newMockObserver()
+	obsv.logger = zap.New(core)
+	si := NewServerInterceptor(obsv, Options{})
+
+	streamHandler := func(srv interface{}, stream grpc.ServerStream) error {
+		for i := 0; i < 2; i++ {
+			if err := stream.RecvMsg(wrapperspb.String("ping")); err != nil {
+				return err
+			}
+		}
+		for i := 0; i < 3; i++ {
+			if err := stream.SendMsg(wrapperspb.String("hello")); err != nil {
+				return err
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+		return nil
+	}
+
+	ss := &mockServerStream{ContextOutContext: context.Background()}
+	err := si.streamInterceptor(nil, ss, &grpc.StreamServerInfo{FullMethod: "/itemPB.ItemManager/GetItems"}, streamHandler)
+	assert.NoError(t, err)
+
+	entries := logs.All()
+	assert.Len(t, entries, 1)
+
+	fields := entries[0].ContextMap()
+	assert.Equal(t, int64(3), fields["stream.sent"])
+	assert.Equal(t, int64(2), fields["stream.received"])
+	assert.Equal(t, int64(33), fields["stream.bytes"])
+	assert.GreaterOrEqual(t, fields["resp.duration"], int64(10))
+}
+
+func TestServerStreamInterceptorActiveDuration(t *testing.T) {
+	core, logs := zapobserver.New(zapcore.DebugLevel)
+	impl, meter := oteltest.NewMeter()
+	obsv := newMockObserver()
+	obsv.logger = zap.New(core)
+	obsv.meter = meter
+	si := NewServerInterceptor(obsv, Options{})
+
+	// The handler waits 30ms for the client and is active for 30ms
+	streamHandler := func(srv interface{}, stream grpc.ServerStream) error {
+		if err := stream.RecvMsg(wrapperspb.String("ping")); err != nil {
+			return err
+		}
+		for i := 0; i < 3; i++ {
+			time.Sleep(10 * time.Millisecond)
+			if err := stream.SendMsg(wrapperspb.String("hello")); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	ss := &slowRecvServerStream{
+		ServerStream: &mockServerStream{ContextOutContext: context.Background()},
+		delay:        30 * time.Millisecond,
+	}
+	err := si.streamInterceptor(nil, ss, &grpc.StreamServerInfo{FullMethod: "/itemPB.ItemManager/GetItems"}, streamHandler)
+	assert.NoError(t, err)
+
+	entries := logs.All()
+	assert.Len(t, entries, 1)
+
+	fields := entries[0].ContextMap()
+	duration := fields["resp.duration"].(int64)
+	active := fields["stream.active"].(int64)
+	assert.GreaterOrEqual(t, duration, int64(60))
+	assert.GreaterOrEqual(t, active, int64(30))
+	assert.LessOrEqual(t, active, duration-30)
+
+	var found bool
+	for _, m := range oteltest.AsStructs(impl.MeasurementBatches) {
+		if m.Name == "incoming_grpc_streams_active_duration" {
+			found = true
+			assert.Equal(t, active, m.Number.AsInt64())
+		}
+	}
+	assert.True(t, found)
+}
+
+func TestServerStreamInterceptorSendBlock(t *testing.T) {
+	impl, meter := oteltest.NewMeter()
+	sr := new(oteltest.StandardSpanRecorder)
+	obsv := newMockObserver()
+	obsv.meter = meter
+	obsv.tracer = oteltest.NewTracerProvider(oteltest.WithSpanRecorder(sr)).Tracer("")
+	si := NewServerInterceptor(obsv, Options{
+		StreamSendBlockThreshold: 20 * time.Millisecond,
+	})
+
+	// The first message is sent immediately and the client is slow to receive the second message
+	ready := make(chan struct{}, 1)
+	ready <- struct{}{}
+	go func() {
+		time.Sleep(30 * time.Millisecond)
+		ready <- struct{}{}
+	}()
+
+	streamHandler := func(srv interface{}, stream grpc.ServerStream) error {
+		for i := 0; i < 2; i++ {
+			if err := stream.SendMsg(wrapperspb.String("hello")); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	ss := &blockingSendServerStream{
+		ServerStream: &mockServerStream{ContextOutContext: context.Background()},
+		ready:        ready,
+	}
+	err := si.streamInterceptor(nil, ss, &grpc.StreamServerInfo{FullMethod: "/itemPB.ItemManager/GetItems"}, streamHandler)
+	assert.NoError(t, err)
+
+	var blocks []int64
+	for _, m := range oteltest.AsStructs(impl.MeasurementBatches) {
+		if m.Name == "incoming_grpc_streams_send_block_duration" {
+			blocks = append(blocks, m.Number.AsInt64())
+			assert.Equal(t, "GetItems", m.Labels["method"].AsString())
+		}
+	}
+	assert.Len(t, blocks, 2)
+	assert.Less(t, blocks[0], int64(20))
+	assert.GreaterOrEqual(t, blocks[1], int64(30))
+
+	spans := sr.Completed()
+	assert.Len(t, spans, 1)
+
+	var events []oteltest.Event
+	for _, e := range spans[0].Events() {
+		if e.Name == "send blocked" {
+			events = append(events, e)
+		}
+	}
+	assert.Len(t, events, 1)
+	assert.Equal(t, blocks[1], events[0].Attributes[label.Key("send.blocked_ms")].AsInt64())
+}
+
+func TestServerInterceptorActiveRequestsPeak(t *testing.T) {
+	impl, meter := oteltest.NewMeter()
+	obsv := newMockObserver()
+	obsv.meter = meter
+	si := NewServerInterceptor(obsv, Options{})
+
+	const burst = 5
+	var started, done sync.WaitGroup
+	release := make(chan struct{})
+
+	unaryHandler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		started.Done()
+		<-release
+		return nil, nil
+	}
+
+	streamHandler := func(srv interface{}, stream grpc.ServerStream) error {
+		started.Done()
+		<-release
+		return nil
+	}
+
+	peak := func() int64 {
+		impl.MeasurementBatches = nil
+		impl.RunAsyncInstruments()
+		for _, m := range oteltest.AsStructs(impl.MeasurementBatches) {
+			if m.Name == "incoming_grpc_requests_active_max" {
+				return m.Number.AsInt64()
+			}
+		}
+		t.Fatal("incoming_grpc_requests_active_max is not observed")
+		return 0
+	}
+
+	started.Add(2 * burst)
+	done.Add(2 * burst)
+	for i := 0; i < burst; i++ {
+		go func() {
+			defer done.Done()
+			_, _ = si.unaryInterceptor(context.Background(), nil, &grpc.UnaryServerInfo{FullMethod: "/itemPB.ItemManager/GetItem"}, unaryHandler)
+		}()
+		go func() {
+			defer done.Done()
+			_ = si.streamInterceptor(nil, &mockServerStream{ContextOutContext: context.Background()}, &grpc.StreamServerInfo{FullMethod: "/itemPB.ItemManager/GetItems"}, streamHandler)
+		}()
+	}
+
+	started.Wait()
+	close(release)
+	done.Wait()
+
+	// The peak of the burst is reported once and then reset
+	assert.Equal(t, int64(2*burst), peak())
+	assert.Equal(t, int64(0), peak())
+}
+
+func TestServerInterceptorRequestUUIDValidation(t *testing.T) {
+	tests := []struct {
+		name             string
+		requestUUID      string
+		expectedReplaced bool
+	}{
+		{
+			name:             "Valid",
+			requestUUID:      "aaaaaaaa-aaaa-aaaa-aaaa-aaaaaaaaaaaa",
+			expectedReplaced: false,
+		},
+		{
+			name:             "Newline",
+			requestUUID:      "aaaaaaaa-aaaa-aaaa-aaaa-aaaaaaaaaaaa\nlevel=error",
+			expectedReplaced: true,
+		},
+		{
+			name:             "TooLong",
+			requestUUID:      strings.Repeat("a", 1024),
+			expectedReplaced: true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			si := NewServerInterceptor(newMockObserver(), Options{})
+			ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(requestUUIDKey, tc.requestUUID))
+
+			var unaryUUID, streamUUID string
+
+			unaryHandler := func(ctx context.Context, req interface{}) (interface{}, error) {
+				unaryUUID, _ = observer.UUIDFromContext(ctx)
+				return nil, nil
+			}
+
+			streamHandler := func(srv interface{}, stream grpc.ServerStream) error {
+				streamUUID, _ = observer.UUIDFromContext(stream.Context())
+				return nil
+			}
+
+			_, err := si.unaryInterceptor(ctx, nil, &grpc.UnaryServerInfo{FullMethod: "/itemPB.ItemManager/GetItem"}, unaryHandler)
+			assert.NoError(t, err)
+
+			ss := &mockServerStream{ContextOutContext: ctx}
+			err = si.streamInterceptor(nil, ss, &grpc.StreamServerInfo{FullMethod: "/itemPB.ItemManager/GetItems"}, streamHandler)
+			assert.NoError(t, err)
+
+			for _, requestUUID := range []string{unaryUUID, streamUUID} {
+				if tc.expectedReplaced {
+					assert.NotEqual(t, tc.requestUUID, requestUUID)
+					assert.True(t, si.opts.validRequestUUID(requestUUID))
+				} else {
+					assert.Equal(t, tc.requestUUID, requestUUID)
+				}
+			}
+
+			assert.Equal(t, []string{streamUUID}, ss.SendHeaderInMD.Get(requestUUIDKey))
+		})
+	}
+}
+
+func TestServerInterceptorIgnoreIncomingRequestID(t *testing.T) {
+	tests := []struct {
+		name               string
+		requestUUID        string
+		expectedReplaced   bool
+		expectedClientUUID string
+	}{
+		{
+			name:               "Valid",
+			requestUUID:        "aaaaaaaa-aaaa-aaaa-aaaa-aaaaaaaaaaaa",
+			expectedReplaced:   true,
+			expectedClientUUID: "aaaaaaaa-aaaa-aaaa-aaaa-aaaaaaaaaaaa",
+		},
+		{
+			name:               "Newline",
+			requestUUID:        "aaaaaaaa-aaaa-aaaa-aaaa-aaaaaaaaaaaa\nlevel=error",
+			expectedReplaced:   true,
+			expectedClientUUID: "",
+		},
+		{
+			name:               "Missing",
+			requestUUID:        "",
+			expectedReplaced:   true,
+			expectedClientUUID: "",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			core, logs := zapobserver.New(zapcore.DebugLevel)
+			obsv := newMockObserver()
+			obsv.logger = zap.New(core)
+			si := NewServerInterceptor(obsv, Options{IgnoreIncomingRequestID: true})
+			ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(requestUUIDKey, tc.requestUUID))
+
+			var unaryUUID, streamUUID string
+
+			unaryHandler := func(ctx context.Context, req interface{}) (interface{}, error) {
+				unaryUUID, _ = observer.UUIDFromContext(ctx)
+				return nil, nil
+			}
+
+			streamHandler := func(srv interface{}, stream grpc.ServerStream) error {
+				streamUUID, _ = observer.UUIDFromContext(stream.Context())
+				return nil
+			}
+
+			_, err := si.unaryInterceptor(ctx, nil, &grpc.UnaryServerInfo{FullMethod: "/itemPB.ItemManager/GetItem"}, unaryHandler)
+			assert.NoError(t, err)
+
+			ss := &mockServerStream{ContextOutContext: ctx}
+			err = si.streamInterceptor(nil, ss, &grpc.StreamServerInfo{FullMethod: "/itemPB.ItemManager/GetItems"}, streamHandler)
+			assert.NoError(t, err)
+
+			for _, requestUUID := range []string{unaryUUID, streamUUID} {
+				if tc.expectedReplaced {
+					assert.NotEqual(t, tc.requestUUID, requestUUID)
+					assert.True(t, si.opts.validRequestUUID(requestUUID))
+				} else {
+					assert.Equal(t, tc.requestUUID, requestUUID)
+				}
+			}
+
+			assert.Equal(t, []string{streamUUID}, ss.SendHeaderInMD.Get(requestUUIDKey))
+
+			entries := logs.All()
+			assert.Len(t, entries, 2)
+			for _, e := range entries {
+				if tc.expectedClientUUID == "" {
+					assert.NotContains(t, e.ContextMap(), "client.request_uuid")
+				} else {
+					assert.Equal(t, tc.expectedClientUUID, e.ContextMap()["client.request_uuid"])
+				}
+			}
+		})
+	}
+}
+
+func TestServerInterceptorSuppressResponseMetadata(t *testing.T) {
+	tests := []struct {
+		name         string
+		opts         Options
+		expectedKeys []string
+	}{
+		{
+			name:         "Default",
+			opts:         Options{},
+			expectedKeys: []string{clientNameKey, requestUUIDKey},
+		},
+		{
+			name:         "SuppressAll",
+			opts:         Options{SuppressResponseMetadata: true},
+			expectedKeys: nil,
+		},
+		{
+			name:         "SuppressClientName",
+			opts:         Options{SuppressedResponseMetadata: []string{clientNameKey}},
+			expectedKeys: []string{requestUUIDKey},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			core, logs := zapobserver.New(zapcore.DebugLevel)
+			obsv := newMockObserver()
+			obsv.logger = zap.New(core)
+			si := NewServerInterceptor(obsv, tc.opts)
+
+			md := metadata.Pairs(clientNameKey, "internal-client")
+			ctx := metadata.NewIncomingContext(context.Background(), md)
+
+			unaryHandler := func(ctx context.Context, req interface{}) (interface{}, error) {
+				return nil, nil
+			}
+
+			streamHandler := func(srv interface{}, stream grpc.ServerStream) error {
+				return nil
+			}
+
+			sts := &mockServerTransportStream{}
+			_, err := si.unaryInterceptor(grpc.NewContextWithServerTransportStream(ctx, sts), nil, &grpc.UnaryServerInfo{FullMethod: "/itemPB.ItemManager/GetItem"}, unaryHandler)
+			assert.NoError(t, err)
+
+			ss := &mockServerStream{ContextOutContext: ctx}
+			err = si.streamInterceptor(nil, ss, &grpc.StreamServerInfo{FullMethod: "/itemPB.ItemManager/GetItems"}, streamHandler)
+			assert.NoError(t, err)
+
+			for _, header := range []metadata.MD{sts.SendHeaderInMD, ss.SendHeaderInMD} {
+				var keys []string
+				for k := range header {
+					keys = append(keys, k)
+				}
+				sort.Strings(keys)
+				assert.Equal(t, tc.expectedKeys, keys)
+			}
+
+			// The client name is still used for logging
+			for _, entry := range logs.All() {
+				assert.Equal(t, "internal-client", entry.ContextMap()["client.name"])
+			}
+		})
+	}
+}
+
+func TestServerInterceptorEncoding(t *testing.T) {
+	core, logs := zapobserver.New(zapcore.DebugLevel)
+	sr := new(oteltest.StandardSpanRecorder)
+	obsv := newMockObserver()
+	obsv.logger = zap.New(core)
+	obsv.tracer = oteltest.NewTracerProvider(oteltest.WithSpanRecorder(sr)).Tracer("")
+	si := NewServerInterceptor(obsv, Options{})
+
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("grpc-encoding", "gzip"))
+
+	unaryHandler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return nil, nil
+	}
+
+	streamHandler := func(srv interface{}, stream grpc.ServerStream) error {
+		return nil
+	}
+
+	_, err := si.unaryInterceptor(ctx, nil, &grpc.UnaryServerInfo{FullMethod: "/itemPB.ItemManager/GetItem"}, unaryHandler)
+	assert.NoError(t, err)
+
+	err = si.streamInterceptor(nil, &mockServerStream{ContextOutContext: ctx}, &grpc.StreamServerInfo{FullMethod: "/itemPB.ItemManager/GetItems"}, streamHandler)
+	assert.NoError(t, err)
+
+	entries := logs.All()
+	assert.Len(t, entries, 2)
+	for _, e := range entries {
+		assert.Equal(t, "gzip", e.ContextMap()["grpc.encoding"])
+	}
+
+	spans := sr.Completed()
+	assert.Len(t, spans, 2)
+	for _, s := range spans {
+		assert.Equal(t, label.StringValue("gzip"), s.Attributes()["grpc.encoding"])
+	}
+}
+
+func TestServerInterceptorRecordMessageType(t *testing.T) {
+	tests := []struct {
+		name           string
+		req            interface{}
+		res            interface{}
+		expectedFields map[string]interface{}
+	}{
+		{
+			name: "Proto",
+			req:  wrapperspb.String("1111"),
+			res:  wrapperspb.Int64(2),
+			expectedFields: map[string]interface{}{
+				"req.type":  "google.protobuf.StringValue",
+				"resp.type": "google.protobuf.Int64Value",
+			},
+		},
+		{
+			name:           "NotProto",
+			req:            "1111",
+			res:            nil,
+			expectedFields: map[string]interface{}{},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			core, logs := zapobserver.New(zapcore.DebugLevel)
+			sr := new(oteltest.StandardSpanRecorder)
+			obsv := newMockObserver()
+			obsv.logger = zap.New(core)
+			obsv.tracer = oteltest.NewTracerProvider(oteltest.WithSpanRecorder(sr)).Tracer("")
+			si := NewServerInterceptor(obsv, Options{
+				RecordMessageType: true,
+			})
+
+			handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+				return tc.res, nil
+			}
+
+			_, err := si.unaryInterceptor(context.Background(), tc.req, &grpc.UnaryServerInfo{FullMethod: "/itemPB.ItemManager/GetItem"}, handler)
+			assert.NoError(t, err)
+
+			entries := logs.All()
+			assert.Len(t, entries, 1)
+			spans := sr.Completed()
+			assert.Len(t, spans, 1)
+
+			for _, key := range []string{"req.type", "resp.type"} {
+				expected, ok := tc.expectedFields[key]
+				if ok {
+					assert.Equal(t, expected, entries[0].ContextMap()[key])
+					assert.Equal(t, label.StringValue(expected.(string)), spans[0].Attributes()[label.Key(key)])
+				} else {
+					assert.NotContains(t, entries[0].ContextMap(), key)
+					assert.NotContains(t, spans[0].Attributes(), label.Key(key))
+				}
+			}
+		})
+	}
+}
+
+func TestServerInterceptorNow(t *testing.T) {
+	core, logs := zapobserver.New(zapcore.DebugLevel)
+	impl, meter := oteltest.NewMeter()
+	clk := clock.NewFake()
+	obsv := newMockObserver()
+	obsv.logger = zap.New(core)
+	obsv.meter = meter
+	si := NewServerInterceptor(obsv, Options{
+		Now: clk.Now,
+	})
+
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		clk.Advance(250 * time.Millisecond)
+		return nil, nil
+	}
+
+	_, err := si.unaryInterceptor(context.Background(), nil, &grpc.UnaryServerInfo{FullMethod: "/itemPB.ItemManager/GetItem"}, handler)
+	assert.NoError(t, err)
+
+	var durations []int64
+	for _, m := range oteltest.AsStructs(impl.MeasurementBatches) {
+		if m.Name == "incoming_grpc_requests_duration" {
+			durations = append(durations, m.Number.AsInt64())
+		}
+	}
+	assert.Equal(t, []int64{250}, durations)
+
+	entries := logs.All()
+	assert.Len(t, entries, 1)
+	assert.Equal(t, "server itemPB::ItemManager::GetItem 250ms", entries[0].Message)
+	assert.Equal(t, int64(250), entries[0].ContextMap()["resp.duration"])
+}
+
+// advancingRecvServerStream is a grpc.ServerStream that advances a fake clock before receiving every message.
+type advancingRecvServerStream struct {
+	grpc.ServerStream
+	clock *clock.Fake
+	wait  time.Duration
+}
+
+func (s *advancingRecvServerStream) RecvMsg(m interface{}) error {
+	s.clock.Advance(s.wait)
+	return s.ServerStream.RecvMsg(m)
+}
+
+func TestServerInterceptorNowStream(t *testing.T) {
+	core, logs := zapobserver.New(zapcore.DebugLevel)
+	impl, meter := oteltest.NewMeter()
+	clk := clock.NewFake()
+	obsv := newMockObserver()
+	obsv.logger = zap.New(core)
+	obsv.meter = meter
+	si := NewServerInterceptor(obsv, Options{
+		Now: clk.Now,
+	})
+
+	ss := &advancingRecvServerStream{
+		ServerStream: &mockServerStream{ContextOutContext: context.Background()},
+		clock:        clk,
+		wait:         50 * time.Millisecond,
+	}
+
+	handler := func(srv interface{}, stream grpc.ServerStream) error {
+		_ = stream.RecvMsg(wrapperspb.String("ping"))
+		_ = stream.RecvMsg(wrapperspb.String("ping"))
+		clk.Advance(150 * time.Millisecond)
+		return nil
+	}
+
+	err := si.streamInterceptor(nil, ss, &grpc.StreamServerInfo{FullMethod: "/itemPB.ItemManager/GetItems"}, handler)
+	assert.NoError(t, err)
+
+	var durations, actives []int64
+	for _, m := range oteltest.AsStructs(impl.MeasurementBatches) {
+		switch m.Name {
+		case "incoming_grpc_requests_duration":
+			durations = append(durations, m.Number.AsInt64())
+		case "incoming_grpc_streams_active_duration":
+			actives = append(actives, m.Number.AsInt64())
+		}
+	}
+	assert.Equal(t, []int64{250}, durations)
+	assert.Equal(t, []int64{150}, actives)
+
+	entries := logs.All()
+	assert.Len(t, entries, 1)
+	assert.Equal(t, int64(250), entries[0].ContextMap()["resp.duration"])
+	assert.Equal(t, int64(150), entries[0].ContextMap()["stream.active"])
+}
+
+func TestServerInterceptorDurationSeconds(t *testing.T) {
+	tests := []struct {
+		name              string
+		opts              Options
+		expectedDurations []int64
+		expectedSeconds   []float64
+	}{
+		{
+			name:              "Disabled",
+			opts:              Options{},
+			expectedDurations: []int64{250},
+			expectedSeconds:   nil,
+		},
+		{
+			name: "Enabled",
+			opts: Options{
+				DurationSeconds: true,
+			},
+			expectedDurations: []int64{250},
+			expectedSeconds:   []float64{0.25},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			impl, meter := oteltest.NewMeter()
+			clk := clock.NewFake()
+			obsv := newMockObserver()
+			obsv.meter = meter
+			tc.opts.Now = clk.Now
+			si := NewServerInterceptor(obsv, tc.opts)
+
+			handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+				clk.Advance(250 * time.Millisecond)
+				return nil, nil
+			}
+
+			_, err := si.unaryInterceptor(context.Background(), nil, &grpc.UnaryServerInfo{FullMethod: "/itemPB.ItemManager/GetItem"}, handler)
+			assert.NoError(t, err)
+
+			var durations []int64
+			var seconds []float64
+			for _, m := range oteltest.AsStructs(impl.MeasurementBatches) {
+				switch m.Name {
+				case "incoming_grpc_requests_duration":
+					durations = append(durations, m.Number.AsInt64())
+				case "incoming_grpc_requests_duration_seconds":
+					assert.Equal(t, "GetItem", m.Labels["method"].AsString())
+					seconds = append(seconds, m.Number.AsFloat64())
+				}
+			}
+
+			assert.Equal(t, tc.expectedDurations, durations)
+			assert.Equal(t, tc.expectedSeconds, seconds)
+		})
+	}
+}
+
+func TestServerInterceptorSystemMethods(t *testing.T) {
+	tests := []struct {
+		name            string
+		opts            Options
+		fullMethod      string
+		stream          bool
+		expectedMetrics []string
+		expectedLevel   zapcore.Level
+	}{
+		{
+			name:            "Unary",
+			opts:            Options{},
+			fullMethod:      "/itemPB.ItemManager/GetItem",
+			expectedMetrics: []string{"incoming_grpc_requests_total", "incoming_grpc_requests_duration"},
+			expectedLevel:   zapcore.InfoLevel,
+		},
+		{
+			name:            "UnarySystem",
+			opts:            Options{},
+			fullMethod:      "/grpc.health.v1.Health/Check",
+			expectedMetrics: []string{"system_grpc_requests_total"},
+			expectedLevel:   zapcore.DebugLevel,
+		},
+		{
+			name:            "StreamSystem",
+			opts:            Options{},
+			fullMethod:      "/grpc.health.v1.Health/Watch",
+			stream:          true,
+			expectedMetrics: []string{"system_grpc_requests_total"},
+			expectedLevel:   zapcore.DebugLevel,
+		},
+		{
+			name: "UnarySystemDisabled",
+			opts: Options{
+				SystemMethods: []string{},
+			},
+			fullMethod:      "/grpc.health.v1.Health/Check",
+			expectedMetrics: []string{"incoming_grpc_requests_total", "incoming_grpc_requests_duration"},
+			expectedLevel:   zapcore.InfoLevel,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			core, logs := zapobserver.New(zapcore.DebugLevel)
+			impl, meter := oteltest.NewMeter()
+			obsv := newMockObserver()
+			obsv.logger = zap.New(core)
+			obsv.meter = meter
+			si := NewServerInterceptor(obsv, tc.opts)
+
+			if tc.stream {
+				handler := func(srv interface{}, stream grpc.ServerStream) error {
+					return nil
+				}
+
+				err := si.streamInterceptor(nil, &mockServerStream{ContextOutContext: context.Background()}, &grpc.StreamServerInfo{FullMethod: tc.fullMethod}, handler)
+				assert.NoError(t, err)
+			} else {
+				handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+					return nil, nil
+				}
+
+				_, err := si.unaryInterceptor(context.Background(), nil, &grpc.UnaryServerInfo{FullMethod: tc.fullMethod}, handler)
+				assert.NoError(t, err)
+			}
+
+			var names []string
+			for _, m := range oteltest.AsStructs(impl.MeasurementBatches) {
+				switch m.Name {
+				case "incoming_grpc_requests_total", "incoming_grpc_requests_duration", "system_grpc_requests_total":
+					names = append(names, m.Name)
+				}
+			}
+			assert.Equal(t, tc.expectedMetrics, names)
+
+			entries := logs.All()
+			assert.Len(t, entries, 1)
+			assert.Equal(t, tc.expectedLevel, entries[0].Level)
+		})
+	}
+}
+
+func TestServerInterceptorObserverInContext(t *testing.T) {
+	obsv := newMockObserver()
+	si := NewServerInterceptor(obsv, Options{})
+
+	var unaryObserver, streamObserver observer.Observer
+
+	unaryHandler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		unaryObserver = observer.ObserverFromContext(ctx)
+		return nil, nil
+	}
+
+	streamHandler := func(srv interface{}, stream grpc.ServerStream) error {
+		streamObserver = observer.ObserverFromContext(stream.Context())
+		return nil
+	}
+
+	_, err := si.unaryInterceptor(context.Background(), nil, &grpc.UnaryServerInfo{FullMethod: "/itemPB.ItemManager/GetItem"}, unaryHandler)
+	assert.NoError(t, err)
+
+	err = si.streamInterceptor(nil, &mockServerStream{ContextOutContext: context.Background()}, &grpc.StreamServerInfo{FullMethod: "/itemPB.ItemManager/GetItems"}, streamHandler)
+	assert.NoError(t, err)
+
+	assert.Same(t, obsv, unaryObserver)
+	assert.Same(t, obsv, streamObserver)
+}
@@ -1,15 +1,20 @@
 package ohttp
 
 import (
+	"context"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"net/http"
+	"net/http/httptrace"
 	"net/url"
 	"strings"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/moorara/observer"
+	"github.com/moorara/observer/internal/instrument"
+	"github.com/moorara/observer/internal/tracing"
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/baggage"
 	"go.opentelemetry.io/otel/label"
@@ -21,13 +26,17 @@ import (
 
 // Client-side instruments for metrics.
 type clientInstruments struct {
-	reqCounter  metric.Int64Counter
-	reqGauge    metric.Int64UpDownCounter
-	reqDuration metric.Int64ValueRecorder
+	reqCounter        metric.Int64Counter
+	reqGauge          metric.Int64UpDownCounter
+	reqDuration       metric.Int64ValueRecorder
+	reqSeconds        metric.Float64ValueRecorder
+	attemptCounter    metric.Int64Counter
+	newConnCounter    metric.Int64Counter
+	reusedConnCounter metric.Int64Counter
 }
 
-func newClientInstruments(meter metric.Meter) *clientInstruments {
-	mm := metric.Must(meter)
+func newClientInstruments(meter metric.Meter, logger *zap.Logger) *clientInstruments {
+	mm := instrument.NewSafeMeter(meter, logger)
 
 	return &clientInstruments{
 		reqCounter: mm.NewInt64Counter(
@@ -44,13 +53,39 @@ func newClientInstruments(meter metric.Meter) *clientInstruments {
 		),
 		reqDuration: mm.NewInt64ValueRecorder(
 			"outgoing_http_requests_duration",
-			metric.WithDescription("The duration of outgoing http requests in seconds (client-side)"),
+			metric.WithDescription("The duration of outgoing http requests in milliseconds (client-side)"),
 			metric.WithUnit(unit.Milliseconds),
 			metric.WithInstrumentationName(libraryName),
 		),
+		reqSeconds: mm.NewFloat64ValueRecorder(
+			"outgoing_http_requests_duration_seconds",
+			metric.WithDescription("The duration of outgoing http requests in seconds (client-side)"),
+			metric.WithUnit(unitSeconds),
+			metric.WithInstrumentationName(libraryName),
+		),
+		attemptCounter: mm.NewInt64Counter(
+			"outgoing_http_requests_attempts_total",
+			metric.WithDescription("The total number of attempts of outgoing http requests when retried (client-side)"),
+			metric.WithUnit(unit.Dimensionless),
+			metric.WithInstrumentationName(libraryName),
+		),
+		newConnCounter: mm.NewInt64Counter(
+			"http_client_connections_new_total",
+			metric.WithDescription("The total number of new connections made for outgoing http requests (client-side)"),
+			metric.WithUnit(unit.Dimensionless),
+			metric.WithInstrumentationName(libraryName),
+		),
+		reusedConnCounter: mm.NewInt64Counter(
+			"http_client_connections_reused_total",
+			metric.WithDescription("The total number of reused connections for outgoing http requests (client-side)"),
+			metric.WithUnit(unit.Dimensionless),
+			metric.WithInstrumentationName(libraryName),
+		),
 	}
 }
 
+var clientInstrumentsCache = instrument.NewCache()
+
 // Client is a drop-in replacement for the standard http.Client.
 // It is an observable http client with logging, metrics, and tracing.
 type Client struct {
@@ -63,7 +98,9 @@ type Client struct {
 // NewClient creates a new observable http client.
 func NewClient(client *http.Client, observer observer.Observer, opts Options) *Client {
 	opts = opts.withDefaults()
-	instruments := newClientInstruments(observer.Meter())
+	instruments := clientInstrumentsCache.Get(observer.Meter(), func() interface{} {
+		return newClientInstruments(observer.Meter(), observer.Logger())
+	}).(*clientInstruments)
 
 	return &Client{
 		opts:        opts,
@@ -80,31 +117,31 @@ func (c *Client) CloseIdleConnections() {
 
 // Get is the observable counterpart of standard http Client.Get.
 // Using this method, request context (UUID and trace) will be auto-generated.
-// If you have a context for the request, consider using the Do method.
+// If you have a context for the request, consider using the GetWithContext method.
 func (c *Client) Get(url string) (resp *http.Response, err error) {
 	req, err := http.NewRequest("GET", url, nil)
 	if err != nil {
 		return nil, err
 	}
 
-	return c.client.Do(req)
+	return c.Do(req)
 }
 
 // Head is the observable counterpart of standard http Client.Head.
 // Using this method, request context (UUID and trace) will be auto-generated.
-// If you have a context for the request, consider using the Do method.
+// If you have a context for the request, consider using the HeadWithContext method.
 func (c *Client) Head(url string) (resp *http.Response, err error) {
 	req, err := http.NewRequest("HEAD", url, nil)
 	if err != nil {
 		return nil, err
 	}
 
-	return c.client.Do(req)
+	return c.Do(req)
 }
 
 // Post is the observable counterpart of standard http Client.Post.
 // Using this method, request context (UUID and trace) will be auto-generated.
-// If you have a context for the request, consider using the Do method.
+// If you have a context for the request, consider using the PostWithContext method.
 func (c *Client) Post(url, contentType string, body io.Reader) (resp *http.Response, err error) {
 	req, err := http.NewRequest("POST", url, body)
 	if err != nil {
@@ -113,25 +150,68 @@ func (c *Client) Post(url, contentType string, body io.Reader) (resp *http.Respo
 
 	req.Header.Set("Content-Type", contentType)
 
-	return c.client.Do(req)
+	return c.Do(req)
 }
 
 // PostForm is the observable counterpart of standard http Client.PostForm.
 // Using this method, request context (UUID and trace) will be auto-generated.
-// If you have a context for the request, consider using the Do method.
+// If you have a context for the request, consider using the PostFormWithContext method.
 func (c *Client) PostForm(url string, data url.Values) (resp *http.Response, err error) {
 	contentType := "application/x-www-form-urlencoded"
 	body := strings.NewReader(data.Encode())
 	return c.Post(url, contentType, body)
 }
 
+// GetWithContext is the observable counterpart of standard http Client.Get with a context.
+// The request context (UUID and trace) will be propagated from the given context.
+func (c *Client) GetWithContext(ctx context.Context, url string) (resp *http.Response, err error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.Do(req)
+}
+
+// HeadWithContext is the observable counterpart of standard http Client.Head with a context.
+// The request context (UUID and trace) will be propagated from the given context.
+func (c *Client) HeadWithContext(ctx context.Context, url string) (resp *http.Response, err error) {
+	req, err := http.NewRequestWithContext(ctx, "HEAD", url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.Do(req)
+}
+
+// PostWithContext is the observable counterpart of standard http Client.Post with a context.
+// The request context (UUID and trace) will be propagated from the given context.
+func (c *Client) PostWithContext(ctx context.Context, url, contentType string, body io.Reader) (resp *http.Response, err error) {
+	req, err := http.NewRequestWithContext(ctx, "POST", url, body)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Content-Type", contentType)
+
+	return c.Do(req)
+}
+
+// PostFormWithContext is the observable counterpart of standard http Client.PostForm with a context.
+// The request context (UUID and trace) will be propagated from the given context.
+func (c *Client) PostFormWithContext(ctx context.Context, url string, data url.Values) (resp *http.Response, err error) {
+	contentType := "application/x-www-form-urlencoded"
+	body := strings.NewReader(data.Encode())
+	return c.PostWithContext(ctx, url, contentType, body)
+}
+
 // Do is the observable counterpart of standard http Client.Do.
 func (c *Client) Do(req *http.Request) (*http.Response, error) {
-	startTime := time.Now()
+	startTime := c.opts.clock.Now()
 	ctx := req.Context()
 	kind := "client"
 	method := req.Method
-	url := req.URL.Path
+	url, truncated := c.opts.truncateURL(req.URL.Path)
 	route := c.opts.IDRegexp.ReplaceAllString(url, ":id")
 
 	// Increase the number of in-flight requests
@@ -169,14 +249,35 @@ func (c *Client) Do(req *http.Request) (*http.Response, error) {
 	)
 	defer span.End()
 
-	// Inject the context and the span context into the http headers
-	otel.GetTextMapPropagator().Inject(ctx, req.Header)
+	// Observe whether or not connections are reused
+	if c.opts.ConnectionMetrics {
+		clientTrace := &httptrace.ClientTrace{
+			GotConn: func(info httptrace.GotConnInfo) {
+				if info.Reused {
+					c.instruments.reusedConnCounter.Add(ctx, 1)
+				} else {
+					c.instruments.newConnCounter.Add(ctx, 1)
+				}
+			},
+		}
+		req = req.WithContext(httptrace.WithClientTrace(req.Context(), clientTrace))
+	}
 
 	// Make the http call
 	span.AddEvent("making http call")
-	resp, err := c.client.Do(req)
+	var resp *http.Response
+	var err error
+	var attempts int
+	if c.opts.Retry == nil {
+		// Inject the context and the span context into the http headers
+		otel.GetTextMapPropagator().Inject(ctx, req.Header)
+		resp, err = c.client.Do(req)
+	} else {
+		resp, attempts, err = c.doWithRetry(ctx, req, route)
+	}
 
-	duration := time.Since(startTime).Milliseconds()
+	elapsed := c.opts.clock.Now().Sub(startTime)
+	duration := elapsed.Milliseconds()
 
 	var statusCode int
 	var statusClass string
@@ -187,16 +288,20 @@ func (c *Client) Do(req *http.Request) (*http.Response, error) {
 	}
 
 	// Report metrics
-	c.observer.Meter().RecordBatch(ctx,
-		[]label.KeyValue{
-			label.String("method", method),
-			label.String("route", route),
-			label.Int("status_code", statusCode),
-			label.String("status_class", statusClass),
-		},
+	labels := []label.KeyValue{
+		label.String("method", method),
+		label.String("route", route),
+	}
+	labels = append(labels, c.opts.statusLabels(statusCode, statusClass)...)
+	labels = append(labels, c.opts.tenantLabels(ctx)...)
+	labels = append(labels, c.opts.metricLabels(ctx, c.observer.Logger())...)
+	c.observer.Meter().RecordBatch(ctx, labels,
 		c.instruments.reqCounter.Measurement(1),
 		c.instruments.reqDuration.Measurement(duration),
 	)
+	if c.opts.DurationSeconds {
+		c.instruments.reqSeconds.Record(ctx, elapsed.Seconds(), labels...)
+	}
 
 	// Report logs
 	logger := c.observer.Logger()
@@ -213,6 +318,15 @@ func (c *Client) Do(req *http.Request) (*http.Response, error) {
 		zap.String("traceId", span.SpanContext().TraceID.String()),
 		zap.String("spanId", span.SpanContext().SpanID.String()),
 	}
+	if truncated {
+		fields = append(fields, zap.Bool("url.truncated", true))
+	}
+	if attempts > 0 {
+		fields = append(fields, zap.Int("req.attempts", attempts))
+	}
+	if err != nil && c.opts.ErrorStackInSpan {
+		fields = append(fields, tracing.RecordErrorStacks(span, err)...)
+	}
 
 	// Determine the log level based on the result
 	switch {
@@ -237,6 +351,85 @@ func (c *Client) Do(req *http.Request) (*http.Response, error) {
 		label.String("route", route),
 		label.Int("status_code", statusCode),
 	)
+	if truncated {
+		span.SetAttributes(label.Bool("url.truncated", true))
+	}
+	if attempts > 0 {
+		span.SetAttributes(label.Int("attempts", attempts))
+	}
+
+	return resp, err
+}
+
+// doWithRetry makes the http call and retries it based on the retry policy.
+// The response of the last attempt is returned with the number of attempts.
+func (c *Client) doWithRetry(ctx context.Context, req *http.Request, route string) (*http.Response, int, error) {
+	for n := 1; ; n++ {
+		if n > 1 && req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, n - 1, err
+			}
+			req.Body = body
+		}
+
+		resp, err := c.attempt(ctx, req, route, n)
+		if n >= c.opts.Retry.MaxAttempts || !c.opts.Retry.retryable(req, resp, err) {
+			return resp, n, err
+		}
+
+		// The response of the last attempt is returned if the request is canceled while waiting
+		timer := time.NewTimer(c.opts.Retry.Backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return resp, n, err
+		case <-timer.C:
+		}
+
+		// The response body should be read and closed, so the connection can be reused
+		if resp != nil {
+			_, _ = io.Copy(ioutil.Discard, resp.Body)
+			resp.Body.Close()
+		}
+	}
+}
+
+// attempt makes a single attempt of an http call with a child span of the request span.
+func (c *Client) attempt(ctx context.Context, req *http.Request, route string, n int) (*http.Response, error) {
+	ctx, span := c.observer.Tracer().Start(ctx,
+		fmt.Sprintf("attempt-%d", n),
+		trace.WithSpanKind(trace.SpanKindClient),
+	)
+	defer span.End()
+
+	// Inject the context and the span context of the attempt into the http headers
+	otel.GetTextMapPropagator().Inject(ctx, req.Header)
+
+	resp, err := c.client.Do(req)
+
+	var statusCode int
+	var statusClass string
+
+	if err == nil {
+		statusCode = resp.StatusCode
+		statusClass = fmt.Sprintf("%dxx", statusCode/100)
+	}
+
+	labels := []label.KeyValue{
+		label.String("method", req.Method),
+		label.String("route", route),
+	}
+	labels = append(labels, c.opts.statusLabels(statusCode, statusClass)...)
+	c.instruments.attemptCounter.Add(ctx, 1, labels...)
+
+	span.SetAttributes(
+		label.Int("attempt", n),
+		label.Int("status_code", statusCode),
+	)
+	if err != nil {
+		span.RecordError(err)
+	}
 
 	return resp, err
 }
@@ -2,13 +2,24 @@ package ohttp
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/moorara/observer"
 	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/label"
+	"go.opentelemetry.io/otel/oteltest"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	zapobserver "go.uber.org/zap/zaptest/observer"
 )
 
 func TestClientDo(t *testing.T) {
@@ -274,3 +285,412 @@ func TestClientMisc(t *testing.T) {
 		})
 	}
 }
+
+func TestClientConnectionMetrics(t *testing.T) {
+	tests := []struct {
+		name           string
+		opts           Options
+		requests       int
+		expectedNew    int64
+		expectedReused int64
+	}{
+		{
+			name:           "Disabled",
+			opts:           Options{},
+			requests:       2,
+			expectedNew:    0,
+			expectedReused: 0,
+		},
+		{
+			name: "Enabled",
+			opts: Options{
+				ConnectionMetrics: true,
+			},
+			requests:       2,
+			expectedNew:    1,
+			expectedReused: 1,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			impl, meter := oteltest.NewMeter()
+			obsv := newMockObserver()
+			obsv.meter = meter
+			client := NewClient(&http.Client{}, obsv, tc.opts)
+
+			// http server for testing
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			}))
+			defer ts.Close()
+
+			for i := 0; i < tc.requests; i++ {
+				req, _ := http.NewRequest("GET", ts.URL+"/v1/items", nil)
+				resp, err := client.Do(req)
+				assert.NoError(t, err)
+
+				// The response body should be read and closed, so the connection can be reused
+				_, _ = io.Copy(ioutil.Discard, resp.Body)
+				resp.Body.Close()
+			}
+
+			var newConns, reusedConns int64
+			for _, m := range oteltest.AsStructs(impl.MeasurementBatches) {
+				switch m.Name {
+				case "http_client_connections_new_total":
+					newConns += m.Number.AsInt64()
+				case "http_client_connections_reused_total":
+					reusedConns += m.Number.AsInt64()
+				}
+			}
+
+			assert.Equal(t, tc.expectedNew, newConns)
+			assert.Equal(t, tc.expectedReused, reusedConns)
+		})
+	}
+}
+
+func TestClientWithContext(t *testing.T) {
+	tests := []struct {
+		name         string
+		ctx          context.Context
+		url          string
+		expectError  bool
+		expectedUUID string
+	}{
+		{
+			name:        "InvalidURL",
+			ctx:         context.Background(),
+			url:         " ",
+			expectError: true,
+		},
+		{
+			name:         "Success",
+			ctx:          observer.ContextWithUUID(context.Background(), "aaaaaaaa-aaaa-aaaa-aaaa-aaaaaaaaaaaa"),
+			url:          "/v1/items",
+			expectedUUID: "aaaaaaaa-aaaa-aaaa-aaaa-aaaaaaaaaaaa",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			obsv := newMockObserver()
+			client := NewClient(&http.Client{}, obsv, Options{})
+
+			// http server for testing
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				assert.Equal(t, tc.expectedUUID, r.Header.Get(requestUUIDHeader))
+				w.WriteHeader(http.StatusOK)
+			}))
+			defer ts.Close()
+
+			calls := map[string]func() (*http.Response, error){
+				"GetWithContext": func() (*http.Response, error) {
+					return client.GetWithContext(tc.ctx, ts.URL+tc.url)
+				},
+				"HeadWithContext": func() (*http.Response, error) {
+					return client.HeadWithContext(tc.ctx, ts.URL+tc.url)
+				},
+				"PostWithContext": func() (*http.Response, error) {
+					return client.PostWithContext(tc.ctx, ts.URL+tc.url, "application/json", nil)
+				},
+				"PostFormWithContext": func() (*http.Response, error) {
+					return client.PostFormWithContext(tc.ctx, ts.URL+tc.url, nil)
+				},
+			}
+
+			for name, call := range calls {
+				t.Run(name, func(t *testing.T) {
+					resp, err := call()
+
+					if tc.expectError {
+						assert.Error(t, err)
+					} else {
+						assert.NoError(t, err)
+						assert.Equal(t, http.StatusOK, resp.StatusCode)
+					}
+				})
+			}
+		})
+	}
+}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestClientErrorStackInSpan(t *testing.T) {
+	tests := []struct {
+		name           string
+		opts           Options
+		err            error
+		expectedStacks []string
+	}{
+		{
+			name:           "Disabled",
+			opts:           Options{},
+			err:            &stackError{msg: "connection refused", stack: "main.dial\n\tmain.go:10"},
+			expectedStacks: nil,
+		},
+		{
+			name:           "PlainError",
+			opts:           Options{ErrorStackInSpan: true},
+			err:            errors.New("connection refused"),
+			expectedStacks: nil,
+		},
+		{
+			name:           "StackError",
+			opts:           Options{ErrorStackInSpan: true},
+			err:            &stackError{msg: "connection refused", stack: "main.dial\n\tmain.go:10"},
+			expectedStacks: []string{"main.dial\n\tmain.go:10"},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			core, logs := zapobserver.New(zapcore.DebugLevel)
+			sr := new(oteltest.StandardSpanRecorder)
+			obsv := newMockObserver()
+			obsv.logger = zap.New(core)
+			obsv.tracer = oteltest.NewTracerProvider(oteltest.WithSpanRecorder(sr)).Tracer("")
+
+			transport := roundTripperFunc(func(*http.Request) (*http.Response, error) {
+				return nil, tc.err
+			})
+			client := NewClient(&http.Client{Transport: transport}, obsv, tc.opts)
+
+			req, _ := http.NewRequest("GET", "http://example.com/v1/items", nil)
+			_, err := client.Do(req)
+			assert.True(t, errors.Is(err, tc.err))
+
+			entries := logs.All()
+			assert.Len(t, entries, 1)
+
+			spans := sr.Completed()
+			assert.Len(t, spans, 1)
+
+			var stacks []string
+			for _, event := range spans[0].Events() {
+				if v, ok := event.Attributes[label.Key("exception.stacktrace")]; ok {
+					stacks = append(stacks, v.AsString())
+				}
+			}
+			assert.Equal(t, tc.expectedStacks, stacks)
+
+			if tc.expectedStacks == nil {
+				assert.NotContains(t, entries[0].ContextMap(), "error.stacks")
+			} else {
+				assert.Contains(t, entries[0].ContextMap(), "error.stacks")
+			}
+		})
+	}
+}
+
+func TestClientRetry(t *testing.T) {
+	tests := []struct {
+		name                string
+		retry               *Retry
+		statusCodes         []int
+		expectedStatusCode  int
+		expectedAttempts    int
+		expectedAttemptTags []int64
+	}{
+		{
+			name:                "NoRetry",
+			retry:               nil,
+			statusCodes:         []int{503, 200},
+			expectedStatusCode:  503,
+			expectedAttempts:    0,
+			expectedAttemptTags: nil,
+		},
+		{
+			name:                "FirstAttemptSucceeds",
+			retry:               &Retry{MaxAttempts: 3},
+			statusCodes:         []int{200},
+			expectedStatusCode:  200,
+			expectedAttempts:    1,
+			expectedAttemptTags: []int64{200},
+		},
+		{
+			name:                "SecondAttemptSucceeds",
+			retry:               &Retry{MaxAttempts: 3},
+			statusCodes:         []int{503, 200},
+			expectedStatusCode:  200,
+			expectedAttempts:    2,
+			expectedAttemptTags: []int64{503, 200},
+		},
+		{
+			name:                "MaxAttempts",
+			retry:               &Retry{MaxAttempts: 2},
+			statusCodes:         []int{503, 503, 200},
+			expectedStatusCode:  503,
+			expectedAttempts:    2,
+			expectedAttemptTags: []int64{503, 503},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			core, logs := zapobserver.New(zapcore.DebugLevel)
+			impl, meter := oteltest.NewMeter()
+			sr := new(oteltest.StandardSpanRecorder)
+			obsv := newMockObserver()
+			obsv.logger = zap.New(core)
+			obsv.meter = meter
+			obsv.tracer = oteltest.NewTracerProvider(oteltest.WithSpanRecorder(sr)).Tracer("")
+			client := NewClient(&http.Client{}, obsv, Options{Retry: tc.retry})
+
+			// http server for testing
+			var calls int
+			var bodies []string
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body, _ := ioutil.ReadAll(r.Body)
+				bodies = append(bodies, string(body))
+				w.WriteHeader(tc.statusCodes[calls])
+				calls++
+			}))
+			defer ts.Close()
+
+			req, _ := http.NewRequest("PUT", ts.URL+"/v1/items/1234", strings.NewReader(`{"name":"item"}`))
+			resp, err := client.Do(req)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expectedStatusCode, resp.StatusCode)
+			resp.Body.Close()
+
+			// The request body should be sent on every attempt
+			for _, body := range bodies {
+				assert.Equal(t, `{"name":"item"}`, body)
+			}
+
+			// Verify logs
+			entries := logs.All()
+			assert.Len(t, entries, 1)
+			if tc.expectedAttempts == 0 {
+				assert.NotContains(t, entries[0].ContextMap(), "req.attempts")
+			} else {
+				assert.Equal(t, int64(tc.expectedAttempts), entries[0].ContextMap()["req.attempts"])
+			}
+
+			// Verify metrics
+			var requests, attempts int64
+			for _, m := range oteltest.AsStructs(impl.MeasurementBatches) {
+				switch m.Name {
+				case "outgoing_http_requests_total":
+					requests += m.Number.AsInt64()
+				case "outgoing_http_requests_attempts_total":
+					attempts += m.Number.AsInt64()
+				}
+			}
+			assert.Equal(t, int64(1), requests)
+			assert.Equal(t, int64(tc.expectedAttempts), attempts)
+
+			// Verify spans
+			spans := sr.Completed()
+			assert.Len(t, spans, len(tc.expectedAttemptTags)+1)
+
+			parent := spans[len(spans)-1]
+			assert.Equal(t, "http-client-request", parent.Name())
+
+			var statusCodes []int64
+			for i, span := range spans[:len(spans)-1] {
+				assert.Equal(t, fmt.Sprintf("attempt-%d", i+1), span.Name())
+				assert.Equal(t, parent.SpanContext().SpanID, span.ParentSpanID())
+				assert.Equal(t, trace.SpanKindClient, span.SpanKind())
+				assert.Equal(t, int64(i+1), span.Attributes()["attempt"].AsInt64())
+				statusCodes = append(statusCodes, span.Attributes()["status_code"].AsInt64())
+			}
+			assert.Equal(t, tc.expectedAttemptTags, statusCodes)
+		})
+	}
+}
+
+func TestClientRetryCanceled(t *testing.T) {
+	obsv := newMockObserver()
+	client := NewClient(&http.Client{}, obsv, Options{
+		Retry: &Retry{MaxAttempts: 3, Backoff: time.Hour},
+	})
+
+	var calls int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer ts.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	// The response of the last attempt is returned when the request is canceled while waiting for the next attempt
+	req, _ := http.NewRequestWithContext(ctx, "GET", ts.URL+"/v1/items", nil)
+	resp, err := client.Do(req)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+	assert.Equal(t, 1, calls)
+	resp.Body.Close()
+}
+
+func TestClientConvenienceMethodsAreObservable(t *testing.T) {
+	tests := []struct {
+		name string
+		call func(*Client, string) (*http.Response, error)
+	}{
+		{
+			name: "Get",
+			call: func(c *Client, url string) (*http.Response, error) {
+				return c.Get(url)
+			},
+		},
+		{
+			name: "Head",
+			call: func(c *Client, url string) (*http.Response, error) {
+				return c.Head(url)
+			},
+		},
+		{
+			name: "Post",
+			call: func(c *Client, url string) (*http.Response, error) {
+				return c.Post(url, "application/json", nil)
+			},
+		},
+		{
+			name: "PostForm",
+			call: func(c *Client, url string) (*http.Response, error) {
+				return c.PostForm(url, nil)
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			impl, meter := oteltest.NewMeter()
+			core, logs := zapobserver.New(zapcore.DebugLevel)
+			obsv := newMockObserver()
+			obsv.meter = meter
+			obsv.logger = zap.New(core)
+			client := NewClient(&http.Client{}, obsv, Options{})
+
+			// http server for testing
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			}))
+			defer ts.Close()
+
+			resp, err := tc.call(client, ts.URL+"/v1/items")
+			assert.NoError(t, err)
+			assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+			var count int64
+			for _, m := range oteltest.AsStructs(impl.MeasurementBatches) {
+				if m.Name == "outgoing_http_requests_total" {
+					count += m.Number.AsInt64()
+				}
+			}
+
+			assert.Equal(t, int64(1), count)
+			assert.Equal(t, 1, logs.Len())
+		})
+	}
+}
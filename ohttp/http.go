@@ -3,21 +3,306 @@
 package ohttp
 
 import (
+	"bufio"
+	"context"
+	"errors"
 	"fmt"
+	"io"
+	"math"
+	"mime"
+	"net"
 	"net/http"
 	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+
+	"github.com/moorara/observer/internal/clock"
+	"github.com/moorara/observer/internal/request"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/label"
+	"go.opentelemetry.io/otel/unit"
+	"go.uber.org/zap"
+)
+
+var (
+	defaultErrorBodyContentTypes = []string{"application/json", "text/*"}
 )
 
 const (
-	libraryName       = "observer/ohttp"
-	requestUUIDHeader = "Request-UUID"
-	clientNameHeader  = "Client-Name"
+	libraryName        = "observer/ohttp"
+	unitSeconds        = unit.Unit("s")
+	requestUUIDHeader  = "Request-UUID"
+	clientNameHeader   = "Client-Name"
+	traceIDHeader      = "Trace-ID"
+	serverTimingHeader = "Server-Timing"
+
+	defaultMaxURLLength = 2048
 )
 
 // Options are optional configurations for creating middleware and clients.
 type Options struct {
 	LogInDebugLevel bool
 	IDRegexp        *regexp.Regexp
+
+	// RequestUUIDRegexp is the pattern that an incoming request uuid must match.
+	// Request uuids are received from untrusted clients and propagated into logs and response headers,
+	// so a request uuid that does not match the pattern, is longer than 128 characters, or has control characters
+	// is replaced with a new one. The default pattern accepts UUIDs and ULIDs.
+	// This is only used by middleware.
+	RequestUUIDRegexp *regexp.Regexp
+
+	// IgnoreIncomingRequestID determines whether or not the request uuid received from clients (Request-UUID header)
+	// should be ignored. By default, a valid request uuid received from a client is reused for the request.
+	// If true, a new request uuid is always generated, so clients cannot make their request uuids collide
+	// with the ones of other clients (i.e. across tenants), and the request uuid claimed by the client is logged as client.request_uuid.
+	// This is only used by middleware.
+	IgnoreIncomingRequestID bool
+
+	// StatusLabelMode determines which status labels are added to request metrics.
+	// It can be both (status_code and status_class), code (status_code only), or class (status_class only).
+	// Using only one of them reduces the number of metric series. The default mode is both.
+	StatusLabelMode string
+
+	// MaxURLLength is the maximum length of the url path used for logs, span attributes, and the route label.
+	// Longer url paths are truncated (the routing of requests is not affected) and url.truncated is set to true.
+	// The default length is 2048.
+	MaxURLLength int
+
+	// RouteFn returns the route pattern matched for a request (e.g. /users/{id}).
+	// It is called after the http handler returns, so it can read the route from routers
+	// that populate the request context while routing (see the routeutil package for adapters).
+	// If not set or if it returns an empty string, the route is derived from the url path using IDRegexp.
+	// This is only used by middleware.
+	RouteFn func(*http.Request) string
+
+	// AttributesFromRequest computes custom attributes from a request (i.e. the subject of a JWT).
+	// The attributes are added to the span and as fields to the contextual logger.
+	// A panic in this function is recovered and logged, and no attribute is added.
+	// This is only used by middleware.
+	AttributesFromRequest func(*http.Request) []label.KeyValue
+
+	// RecordCodeLocation determines whether or not the source code location of http handlers
+	// should be added to server spans (code.function, code.filepath, and code.lineno).
+	RecordCodeLocation bool
+
+	// ErrorStackInSpan determines whether or not the stack traces of the errors returned by http clients should be recorded.
+	// If an error (or an error in its chain) implements a StackTrace() method (i.e. pkg/errors), or it is a multierror,
+	// the stack trace is recorded as a span event (exception.stacktrace) and a log field (error.stacks).
+	// Errors without stack traces are reported as before.
+	// Http handlers do not return errors, so this is only used by clients.
+	ErrorStackInSpan bool
+
+	// SlowBodyReadThreshold is the threshold for logging the time spent reading the request body.
+	// If reading the request body takes longer than this threshold, req.body_read_ms is added to the request log.
+	// This distinguishes slow clients streaming the request body from slow handlers.
+	// If not set, reading the request body is not timed.
+	// This is only used by middleware.
+	SlowBodyReadThreshold time.Duration
+
+	// MaxErrorBodySize is the maximum number of bytes of the request and response bodies captured for error responses (4xx and 5xx).
+	// The captured bodies are logged as req.body and resp.body if their content types match ErrorBodyContentTypes.
+	// For other bodies (i.e. binary bodies), only their sizes are logged (req.body_size and resp.body_size).
+	// Bodies are captured while they are read and written, so the memory used by every request grows by up to twice this size.
+	// If not set, bodies are not captured.
+	// This is only used by middleware.
+	MaxErrorBodySize int
+
+	// ErrorBodyContentTypes are the content types of the bodies logged for error responses (see MaxErrorBodySize).
+	// A content type can have a wildcard subtype (i.e. text/*). The default content types are application/json and text/*.
+	// This is only used by middleware.
+	ErrorBodyContentTypes []string
+
+	// TimeToFirstByte determines whether or not the time until the first byte of responses is written should be reported
+	// (incoming_http_requests_ttfb). The first byte is written when the handler writes the status code,
+	// or the response body for the first time. Compared to the request duration, this distinguishes handlers
+	// that start responding quickly but stream slowly (i.e. chunked responses) from handlers that are slow to respond.
+	// This is only used by middleware.
+	TimeToFirstByte bool
+
+	// ClientErrorSpanStatus determines whether or not the span status should be set to error for client errors (4xx).
+	// The span status is always set to error for server errors (5xx).
+	// This is only used by middleware.
+	ClientErrorSpanStatus bool
+
+	// PanicResponse returns the body of the 500 Internal Server Error response written when a handler panics.
+	// The error wraps the recovered value (if it is an error), so a sanitized message can be returned for known errors.
+	// The returned message is sent to clients as plain text, so it should not include internal details.
+	// If not set, the response has no body.
+	// This is only used by middleware.
+	PanicResponse func(error) string
+
+	// ServerTiming determines whether or not a Server-Timing header should be added to the responses
+	// with the duration of the request (app;dur=<ms>) and the trace id of the request (trace;desc=<traceID>).
+	// This lets front-end monitoring tools correlate browser requests with server-side traces.
+	// Headers cannot be changed after the status code is written, so the duration is measured
+	// until the handler writes the status code (or the response body) and does not include writing the response body.
+	// This is only used by middleware.
+	ServerTiming bool
+
+	// ExposeTraceHeader determines whether or not the trace id of a request should be added to the response headers
+	// (Trace-ID and traceparent) when the response is an error (5xx).
+	// This lets support engineers find the trace of a request from an error response.
+	// This is only used by middleware.
+	ExposeTraceHeader bool
+
+	// ConnectionMetrics determines whether or not the number of new and reused connections
+	// should be reported (http_client_connections_new_total and http_client_connections_reused_total).
+	// This is only used by clients.
+	ConnectionMetrics bool
+
+	// Retry is an optional retry policy for outgoing requests.
+	// If set, every attempt is reported as a child span (attempt-1, attempt-2, ...) of the span of the request
+	// with its own status code, and the attempts are counted separately (outgoing_http_requests_attempts_total).
+	// The request is still logged and counted once with the number of attempts (req.attempts).
+	// If not set, requests are not retried.
+	// This is only used by clients.
+	Retry *Retry
+
+	// BaggageToLogs determines whether or not the baggage key-values of a request
+	// should be added as fields (prefixed with baggage.) to the contextual logger.
+	// This is only used by server middleware.
+	BaggageToLogs bool
+
+	// TenantBaggageKey is the baggage key for reading the tenant of a request.
+	// If set, the tenant will be added as a label (tenant) to request metrics.
+	// For controlling the cardinality of metrics, tenants not in TenantLabelAllowlist are reported as "other".
+	TenantBaggageKey     string
+	TenantLabelAllowlist []string
+
+	// MetricLabelsFromContext computes custom labels for request metrics from the context of a request
+	// (i.e. the region or the plan tier of a customer read from baggage).
+	// The labels are added to the request metrics after the built-in labels.
+	// Every distinct combination of label values creates a new metric series, so the function is responsible
+	// for returning only a small and bounded set of values (i.e. by mapping unknown values to "other").
+	// A panic in this function is recovered and logged, and no label is added.
+	MetricLabelsFromContext func(context.Context) []label.KeyValue
+
+	// SuppressResponseMetadata determines whether or not the request metadata (Request-UUID and Client-Name)
+	// should be left out of the response headers. The request metadata are still used for logging and tracing.
+	// This prevents echoing internal metadata (i.e. the client name) back to untrusted clients.
+	// SuppressedResponseMetadata can be used for leaving out only some of the headers (i.e. Client-Name).
+	// This is only used by middleware.
+	SuppressResponseMetadata   bool
+	SuppressedResponseMetadata []string
+
+	// RateLimit is an optional token-bucket rate limit for incoming requests.
+	// Requests are limited per route (and per client name if PerClient is set).
+	// The route is derived from the url path using IDRegexp unless RateLimit.RouteFn is set,
+	// so requests for different ids (i.e. /users/1 and /users/2) are limited separately if IDRegexp does not match the ids.
+	// Rejected requests are responded with 429 Too Many Requests without calling the http handler,
+	// and they are still reported (logs, metrics, and traces) like other requests.
+	// If not set, requests are not limited.
+	// This is only used by middleware.
+	RateLimit *RateLimit
+
+	// DurationSeconds determines whether or not the durations of requests should also be recorded in seconds
+	// by a second value recorder (i.e. incoming_http_requests_duration_seconds) next to the one in milliseconds.
+	// This is meant for migrating dashboards from milliseconds to seconds without a flag day.
+	// Every duration is recorded twice while this is enabled, so the number of histogram series for durations doubles.
+	// If not set, durations are only recorded in milliseconds.
+	DurationSeconds bool
+
+	// ErrorLogInterval is the minimum interval between error logs of requests with the same fingerprint.
+	// The fingerprint of a request is its method, route, and status code.
+	// Identical error logs within the interval are suppressed and counted,
+	// and the count is logged as suppressed on the next error log emitted for the fingerprint.
+	// Metrics and traces are not affected. If not set, error logs are not throttled.
+	// This is only used by middleware.
+	ErrorLogInterval time.Duration
+
+	// Now returns the current time for measuring the duration of requests.
+	// If not set, the system time is used. This is meant for making durations deterministic in tests.
+	Now func() time.Time
+
+	// clock is the clock created from Now.
+	clock clock.Clock
+}
+
+// RateLimit is a token-bucket rate limit for requests.
+type RateLimit struct {
+	// Rate is the number of requests allowed per second.
+	// If zero or negative, requests are not limited.
+	Rate float64
+
+	// Burst is the maximum number of requests allowed at once.
+	// The default burst is the rate rounded up (at least one).
+	Burst int
+
+	// PerClient determines whether or not requests should be limited per client name (Client-Name header) too.
+	PerClient bool
+
+	// RouteFn returns the route pattern of a request (e.g. /users/{id}) before the http handler is called.
+	// Unlike Options.RouteFn, it cannot rely on routers populating the request context, since the handler has not run yet.
+	// If not set or if it returns an empty string, the route is derived from the url path using IDRegexp.
+	RouteFn func(*http.Request) string
+}
+
+// route returns the route of a request for rate limiting.
+func (rl *RateLimit) route(r *http.Request, route string) string {
+	if rl.RouteFn != nil {
+		if pattern := rl.RouteFn(r); pattern != "" {
+			return pattern
+		}
+	}
+
+	return route
+}
+
+// key returns the key of the bucket for a request.
+func (rl *RateLimit) key(route, clientName string) string {
+	if rl.PerClient {
+		return route + "|" + clientName
+	}
+
+	return route
+}
+
+// Retry is a retry policy for outgoing requests.
+type Retry struct {
+	// MaxAttempts is the maximum number of attempts including the first one.
+	// If less than two, requests are not retried.
+	MaxAttempts int
+
+	// Backoff is the delay between attempts.
+	Backoff time.Duration
+
+	// RetryOn determines whether or not a request should be retried after an attempt.
+	// By default, requests with idempotent methods are retried after transport errors
+	// and 502 Bad Gateway, 503 Service Unavailable, and 504 Gateway Timeout responses.
+	// Requests with a body that cannot be re-read (GetBody is not set) are never retried.
+	RetryOn func(*http.Response, error) bool
+}
+
+// retryable determines whether or not a request should be retried after an attempt.
+func (rt *Retry) retryable(req *http.Request, resp *http.Response, err error) bool {
+	if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+		return false
+	}
+
+	if rt.RetryOn != nil {
+		return rt.RetryOn(resp, err)
+	}
+
+	switch req.Method {
+	case "GET", "HEAD", "OPTIONS", "PUT", "DELETE", "TRACE":
+	default:
+		return false
+	}
+
+	// A canceled or timed out request is not retried
+	if err != nil {
+		return req.Context().Err() == nil
+	}
+
+	switch resp.StatusCode {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	default:
+		return false
+	}
 }
 
 func (opts Options) withDefaults() Options {
@@ -25,30 +310,444 @@ func (opts Options) withDefaults() Options {
 		opts.IDRegexp = regexp.MustCompile("[0-9A-Fa-f]{8}-[0-9A-Fa-f]{4}-[0-9A-Fa-f]{4}-[0-9A-Fa-f]{4}-[0-9A-Fa-f]{12}")
 	}
 
+	if opts.RequestUUIDRegexp == nil {
+		opts.RequestUUIDRegexp = request.DefaultUUIDRegexp
+	}
+
+	if opts.StatusLabelMode == "" {
+		opts.StatusLabelMode = "both"
+	}
+
+	if opts.MaxURLLength <= 0 {
+		opts.MaxURLLength = defaultMaxURLLength
+	}
+
+	if len(opts.ErrorBodyContentTypes) == 0 {
+		opts.ErrorBodyContentTypes = defaultErrorBodyContentTypes
+	}
+
+	opts.clock = clock.New(opts.Now)
+
 	return opts
 }
 
+// loggableBody determines whether or not a captured body with a content type should be logged.
+func (opts Options) loggableBody(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+
+	for _, t := range opts.ErrorBodyContentTypes {
+		t = strings.ToLower(t)
+		if t == mediaType || (strings.HasSuffix(t, "/*") && strings.HasPrefix(mediaType, strings.TrimSuffix(t, "*"))) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// echoMetadata determines whether or not a request metadata key should be echoed back in the response metadata.
+func (opts Options) echoMetadata(key string) bool {
+	if opts.SuppressResponseMetadata {
+		return false
+	}
+
+	for _, k := range opts.SuppressedResponseMetadata {
+		if strings.EqualFold(k, key) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// statusLabels returns the metric labels for the status of a response based on the status label mode.
+func (opts Options) statusLabels(statusCode int, statusClass string) []label.KeyValue {
+	switch opts.StatusLabelMode {
+	case "code":
+		return []label.KeyValue{
+			label.Int("status_code", statusCode),
+		}
+	case "class":
+		return []label.KeyValue{
+			label.String("status_class", statusClass),
+		}
+	case "both":
+		fallthrough
+	default:
+		return []label.KeyValue{
+			label.Int("status_code", statusCode),
+			label.String("status_class", statusClass),
+		}
+	}
+}
+
+// validRequestUUID determines whether or not an incoming request uuid can be safely used.
+func (opts Options) validRequestUUID(id string) bool {
+	return request.ValidUUID(id, opts.RequestUUIDRegexp)
+}
+
+// untrustedRequestUUID returns the request uuid claimed by a client if it is ignored.
+// An invalid request uuid is not returned, since it is not safe to be logged.
+func (opts Options) untrustedRequestUUID(requestUUID string) string {
+	if !opts.IgnoreIncomingRequestID || !opts.validRequestUUID(requestUUID) {
+		return ""
+	}
+	return requestUUID
+}
+
+// spanStatus returns the span status for the status code of a response.
+// The second return value determines whether or not the span status should be set.
+func (opts Options) spanStatus(statusCode int) (codes.Code, string, bool) {
+	if statusCode >= 500 || (opts.ClientErrorSpanStatus && statusCode >= 400) {
+		return codes.Error, http.StatusText(statusCode), true
+	}
+
+	return codes.Unset, "", false
+}
+
+// truncateURL truncates a url path to the maximum length without breaking a multi-byte character.
+// The second return value determines whether or not the url path was truncated.
+func (opts Options) truncateURL(url string) (string, bool) {
+	if len(url) <= opts.MaxURLLength {
+		return url, false
+	}
+
+	i := opts.MaxURLLength
+	for i > 0 && !utf8.RuneStart(url[i]) {
+		i--
+	}
+
+	return url[:i], true
+}
+
+// metricLabels calls the user function for computing custom metric labels from the context of a request.
+// A panic in the user function is recovered and logged, and no label is returned.
+func (opts Options) metricLabels(ctx context.Context, logger *zap.Logger) (labels []label.KeyValue) {
+	if opts.MetricLabelsFromContext == nil {
+		return nil
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			labels = nil
+			err := fmt.Errorf("critical error: %v", r)
+			logger.Error("Panic occurred in MetricLabelsFromContext.", zap.Error(err))
+		}
+	}()
+
+	return opts.MetricLabelsFromContext(ctx)
+}
+
+// tenantLabels returns the metric labels for the tenant of a request.
+// If the tenant is not in the allow-list, it will be reported as "other".
+func (opts Options) tenantLabels(ctx context.Context) []label.KeyValue {
+	return request.TenantLabels(ctx, opts.TenantBaggageKey, opts.TenantLabelAllowlist)
+}
+
+// tokenBucket keeps the number of available tokens for a key.
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// rateLimiter is a token-bucket rate limiter that keeps a separate bucket for every key.
+type rateLimiter struct {
+	sync.Mutex
+	rate      float64
+	burst     float64
+	clock     clock.Clock
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+}
+
+func newRateLimiter(rate float64, burst int, c clock.Clock) *rateLimiter {
+	if burst < 1 {
+		burst = int(math.Max(1, math.Ceil(rate)))
+	}
+
+	return &rateLimiter{
+		rate:    rate,
+		burst:   float64(burst),
+		clock:   c,
+		buckets: map[string]*tokenBucket{},
+	}
+}
+
+// allow takes a token from the bucket of a key if one is available.
+// If no token is available, the second return value is the time until the next token is available.
+func (r *rateLimiter) allow(key string) (bool, time.Duration) {
+	r.Lock()
+	defer r.Unlock()
+
+	now := r.clock.Now()
+
+	// Forget the buckets that are full again, so high-cardinality keys do not grow the map indefinitely
+	if refill := time.Duration(r.burst / r.rate * float64(time.Second)); now.Sub(r.lastSweep) >= refill {
+		for k, b := range r.buckets {
+			if b.tokens+now.Sub(b.last).Seconds()*r.rate >= r.burst {
+				delete(r.buckets, k)
+			}
+		}
+		r.lastSweep = now
+	}
+
+	b, ok := r.buckets[key]
+	if !ok {
+		b = &tokenBucket{tokens: r.burst, last: now}
+		r.buckets[key] = b
+	}
+
+	b.tokens = math.Min(r.burst, b.tokens+now.Sub(b.last).Seconds()*r.rate)
+	b.last = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+
+	return false, time.Duration((1 - b.tokens) / r.rate * float64(time.Second))
+}
+
+// logThrottler throttles logs with the same fingerprint to one per interval and counts the suppressed logs.
+type logThrottler struct {
+	sync.Mutex
+	interval  time.Duration
+	clock     clock.Clock
+	entries   map[string]*throttleEntry
+	lastSweep time.Time
+}
+
+type throttleEntry struct {
+	last       time.Time
+	suppressed int64
+}
+
+func newLogThrottler(interval time.Duration, c clock.Clock) *logThrottler {
+	return &logThrottler{
+		interval: interval,
+		clock:    c,
+		entries:  map[string]*throttleEntry{},
+	}
+}
+
+// allow determines whether or not a log with a fingerprint should be emitted.
+// If the log should be emitted, the second return value is the number of logs suppressed since the last emitted one.
+func (t *logThrottler) allow(fingerprint string) (bool, int64) {
+	t.Lock()
+	defer t.Unlock()
+
+	now := t.clock.Now()
+
+	// Forget the fingerprints that have no suppressed logs to report, so the map does not grow indefinitely
+	if now.Sub(t.lastSweep) >= t.interval {
+		for k, e := range t.entries {
+			if e.suppressed == 0 && now.Sub(e.last) >= t.interval {
+				delete(t.entries, k)
+			}
+		}
+		t.lastSweep = now
+	}
+
+	e, ok := t.entries[fingerprint]
+	if !ok {
+		t.entries[fingerprint] = &throttleEntry{last: now}
+		return true, 0
+	}
+
+	if now.Sub(e.last) < t.interval {
+		e.suppressed++
+		return false, 0
+	}
+
+	suppressed := e.suppressed
+	e.last, e.suppressed = now, 0
+
+	return true, suppressed
+}
+
+// timingReader is an io.ReadCloser that measures the total time spent reading a request body.
+type timingReader struct {
+	io.ReadCloser
+	duration int64
+}
+
+func newTimingReader(rc io.ReadCloser) *timingReader {
+	return &timingReader{
+		ReadCloser: rc,
+	}
+}
+
+func (r *timingReader) Read(p []byte) (int, error) {
+	start := time.Now()
+	n, err := r.ReadCloser.Read(p)
+	atomic.AddInt64(&r.duration, int64(time.Since(start)))
+
+	return n, err
+}
+
+// Duration returns the total time spent reading so far.
+func (r *timingReader) Duration() time.Duration {
+	return time.Duration(atomic.LoadInt64(&r.duration))
+}
+
+// bodyCapture keeps the first bytes of a body up to a maximum size and counts the total size of the body.
+type bodyCapture struct {
+	max  int
+	buf  []byte
+	size int64
+}
+
+func newBodyCapture(max int) *bodyCapture {
+	return &bodyCapture{
+		max: max,
+	}
+}
+
+func (c *bodyCapture) capture(p []byte) {
+	c.size += int64(len(p))
+	if n := c.max - len(c.buf); n > 0 {
+		if len(p) < n {
+			n = len(p)
+		}
+		c.buf = append(c.buf, p[:n]...)
+	}
+}
+
+// fields returns the log fields for the captured body.
+// The body itself is only logged if it is loggable, otherwise only its size is logged.
+func (c *bodyCapture) fields(prefix string, loggable bool) []zap.Field {
+	if c.size == 0 {
+		return nil
+	}
+
+	fields := []zap.Field{
+		zap.Int64(prefix+".body_size", c.size),
+	}
+	if loggable {
+		fields = append(fields, zap.String(prefix+".body", string(c.buf)))
+	}
+
+	return fields
+}
+
+// captureReader is an io.ReadCloser that captures the body it reads.
+type captureReader struct {
+	io.ReadCloser
+	*bodyCapture
+}
+
+func newCaptureReader(rc io.ReadCloser, max int) *captureReader {
+	return &captureReader{
+		ReadCloser:  rc,
+		bodyCapture: newBodyCapture(max),
+	}
+}
+
+func (r *captureReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.capture(p[:n])
+
+	return n, err
+}
+
 // responseWriter extends the standard http.ResponseWriter.
 type responseWriter struct {
 	http.ResponseWriter
 	StatusCode  int
 	StatusClass string
+
+	// Hijacked is true if the connection is hijacked (i.e. websockets).
+	Hijacked bool
+
+	// FirstByteTime is the time the status code is written for the first time,
+	// either explicitly or implicitly by the first Write or Flush.
+	FirstByteTime time.Time
+	clock         clock.Clock
+
+	// Body captures the response body if set.
+	Body *bodyCapture
+
+	// beforeWriteHeader is called before the status code is written for the first time.
+	// It can be used for setting headers based on the status code.
+	beforeWriteHeader func(statusCode int)
+
+	// afterHijack is called after the connection is successfully hijacked.
+	afterHijack func()
 }
 
 // NewResponseWriter creates a new response writer.
 func newResponseWriter(rw http.ResponseWriter) *responseWriter {
 	return &responseWriter{
 		ResponseWriter: rw,
+		clock:          clock.Real,
 	}
 }
 
 // WriteHeader overrides the implementation of http.WriteHeader.
 func (r *responseWriter) WriteHeader(statusCode int) {
+	if r.StatusCode == 0 && r.beforeWriteHeader != nil {
+		r.beforeWriteHeader(statusCode)
+	}
+
 	r.ResponseWriter.WriteHeader(statusCode)
 
 	// Only capture the first value
 	if r.StatusCode == 0 {
 		r.StatusCode = statusCode
 		r.StatusClass = fmt.Sprintf("%dxx", statusCode/100)
+		r.FirstByteTime = r.clock.Now()
+	}
+}
+
+// Write overrides the implementation of http.Write.
+// If the status code is not written yet, it is written as 200 (same as http.ResponseWriter).
+func (r *responseWriter) Write(b []byte) (int, error) {
+	if r.StatusCode == 0 {
+		r.WriteHeader(http.StatusOK)
+	}
+
+	n, err := r.ResponseWriter.Write(b)
+	if r.Body != nil {
+		r.Body.capture(b[:n])
 	}
+
+	return n, err
+}
+
+// Flush implements the http.Flusher interface if the underlying response writer supports it.
+func (r *responseWriter) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		if r.StatusCode == 0 {
+			r.WriteHeader(http.StatusOK)
+		}
+		f.Flush()
+	}
+}
+
+// Hijack implements the http.Hijacker interface if the underlying response writer supports it.
+func (r *responseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if h, ok := r.ResponseWriter.(http.Hijacker); ok {
+		conn, rw, err := h.Hijack()
+		if err == nil && !r.Hijacked {
+			r.Hijacked = true
+			if r.afterHijack != nil {
+				r.afterHijack()
+			}
+		}
+		return conn, rw, err
+	}
+
+	return nil, nil, errors.New("the underlying response writer does not implement http.Hijacker")
+}
+
+// Push implements the http.Pusher interface if the underlying response writer supports it.
+func (r *responseWriter) Push(target string, opts *http.PushOptions) error {
+	if p, ok := r.ResponseWriter.(http.Pusher); ok {
+		return p.Push(target, opts)
+	}
+
+	return http.ErrNotSupported
 }
@@ -1,38 +1,55 @@
 package ohttp
 
 import (
+	"bufio"
 	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"regexp"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/moorara/observer"
+	"github.com/moorara/observer/internal/clock"
 	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/baggage"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/label"
 	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/oteltest"
 	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/unit"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
 )
 
+// mockObserver is a no-op observer with overridable name, logger, meter, and tracer.
 type mockObserver struct {
+	observer.Observer
 	name   string
 	logger *zap.Logger
 	meter  metric.Meter
 	tracer trace.Tracer
 }
 
+var _ observer.Observer = (*mockObserver)(nil)
+
 func newMockObserver() *mockObserver {
+	noop := observer.NewNoop()
 	return &mockObserver{
-		name:   "test",
-		logger: zap.NewNop(),
-		meter:  new(metric.NoopMeterProvider).Meter(""),
-		tracer: trace.NewNoopTracerProvider().Tracer(""),
+		Observer: noop,
+		name:     "test",
+		logger:   noop.Logger(),
+		meter:    noop.Meter(),
+		tracer:   noop.Tracer(),
 	}
 }
 
-func (m *mockObserver) Shutdown(ctx context.Context) error {
-	return nil
-}
-
 func (m *mockObserver) Name() string {
 	return m.name
 }
@@ -41,12 +58,8 @@ func (m *mockObserver) Logger() *zap.Logger {
 	return m.logger
 }
 
-func (m *mockObserver) SetLogLevel(level zapcore.Level) {
-	// Noop
-}
-
-func (m *mockObserver) GetLogLevel() zapcore.Level {
-	return zapcore.Level(99)
+func (m *mockObserver) SpanLogger(trace.SpanContext) *zap.Logger {
+	return m.logger
 }
 
 func (m *mockObserver) Meter() metric.Meter {
@@ -57,10 +70,797 @@ func (m *mockObserver) Tracer() trace.Tracer {
 	return m.tracer
 }
 
-func (m *mockObserver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+type mockMeterImpl struct {
+	NewSyncInstrumentOutError  error
+	NewAsyncInstrumentOutError error
+}
+
+func (m *mockMeterImpl) RecordBatch(ctx context.Context, labels []label.KeyValue, measurement ...metric.Measurement) {
 	// Noop
 }
 
+func (m *mockMeterImpl) NewSyncInstrument(descriptor metric.Descriptor) (metric.SyncImpl, error) {
+	if m.NewSyncInstrumentOutError != nil {
+		return nil, m.NewSyncInstrumentOutError
+	}
+	return metric.NoopSync{}, nil
+}
+
+func (m *mockMeterImpl) NewAsyncInstrument(descriptor metric.Descriptor, runner metric.AsyncRunner) (metric.AsyncImpl, error) {
+	if m.NewAsyncInstrumentOutError != nil {
+		return nil, m.NewAsyncInstrumentOutError
+	}
+	return metric.NoopAsync{}, nil
+}
+
+func TestOptionsMetricLabels(t *testing.T) {
+	tests := []struct {
+		name           string
+		opts           Options
+		ctx            context.Context
+		expectedLabels []label.KeyValue
+	}{
+		{
+			name:           "NotSet",
+			opts:           Options{},
+			ctx:            context.Background(),
+			expectedLabels: nil,
+		},
+		{
+			name: "FromBaggage",
+			opts: Options{
+				MetricLabelsFromContext: func(ctx context.Context) []label.KeyValue {
+					return []label.KeyValue{
+						label.String("region", baggage.Value(ctx, label.Key("region")).Emit()),
+					}
+				},
+			},
+			ctx: baggage.ContextWithValues(context.Background(), label.String("region", "ca-central-1")),
+			expectedLabels: []label.KeyValue{
+				label.String("region", "ca-central-1"),
+			},
+		},
+		{
+			name: "Panics",
+			opts: Options{
+				MetricLabelsFromContext: func(ctx context.Context) []label.KeyValue {
+					panic("something went wrong")
+				},
+			},
+			ctx:            context.Background(),
+			expectedLabels: nil,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expectedLabels, tc.opts.metricLabels(tc.ctx, zap.NewNop()))
+		})
+	}
+}
+
+func TestOptionsTenantLabels(t *testing.T) {
+	tests := []struct {
+		name           string
+		opts           Options
+		ctx            context.Context
+		expectedLabels []label.KeyValue
+	}{
+		{
+			name:           "Disabled",
+			opts:           Options{},
+			ctx:            baggage.ContextWithValues(context.Background(), label.String("tenant", "acme")),
+			expectedLabels: nil,
+		},
+		{
+			name: "NoTenant",
+			opts: Options{
+				TenantBaggageKey:     "tenant",
+				TenantLabelAllowlist: []string{"acme"},
+			},
+			ctx:            context.Background(),
+			expectedLabels: []label.KeyValue{label.String("tenant", "other")},
+		},
+		{
+			name: "NotAllowed",
+			opts: Options{
+				TenantBaggageKey:     "tenant",
+				TenantLabelAllowlist: []string{"acme"},
+			},
+			ctx:            baggage.ContextWithValues(context.Background(), label.String("tenant", "umbrella")),
+			expectedLabels: []label.KeyValue{label.String("tenant", "other")},
+		},
+		{
+			name: "Allowed",
+			opts: Options{
+				TenantBaggageKey:     "tenant",
+				TenantLabelAllowlist: []string{"acme"},
+			},
+			ctx:            baggage.ContextWithValues(context.Background(), label.String("tenant", "acme")),
+			expectedLabels: []label.KeyValue{label.String("tenant", "acme")},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			labels := tc.opts.tenantLabels(tc.ctx)
+
+			assert.Equal(t, tc.expectedLabels, labels)
+		})
+	}
+}
+
+func TestOptionsStatusLabels(t *testing.T) {
+	tests := []struct {
+		name           string
+		opts           Options
+		expectedLabels []label.KeyValue
+	}{
+		{
+			name: "Default",
+			opts: Options{}.withDefaults(),
+			expectedLabels: []label.KeyValue{
+				label.Int("status_code", 404),
+				label.String("status_class", "4xx"),
+			},
+		},
+		{
+			name: "Both",
+			opts: Options{StatusLabelMode: "both"},
+			expectedLabels: []label.KeyValue{
+				label.Int("status_code", 404),
+				label.String("status_class", "4xx"),
+			},
+		},
+		{
+			name: "Code",
+			opts: Options{StatusLabelMode: "code"},
+			expectedLabels: []label.KeyValue{
+				label.Int("status_code", 404),
+			},
+		},
+		{
+			name: "Class",
+			opts: Options{StatusLabelMode: "class"},
+			expectedLabels: []label.KeyValue{
+				label.String("status_class", "4xx"),
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			labels := tc.opts.statusLabels(404, "4xx")
+
+			assert.Equal(t, tc.expectedLabels, labels)
+		})
+	}
+}
+
+func TestOptionsSpanStatus(t *testing.T) {
+	tests := []struct {
+		name                string
+		opts                Options
+		statusCode          int
+		expectedOK          bool
+		expectedCode        codes.Code
+		expectedDescription string
+	}{
+		{
+			name:         "Success",
+			opts:         Options{},
+			statusCode:   200,
+			expectedOK:   false,
+			expectedCode: codes.Unset,
+		},
+		{
+			name:         "ClientError",
+			opts:         Options{},
+			statusCode:   404,
+			expectedOK:   false,
+			expectedCode: codes.Unset,
+		},
+		{
+			name: "ClientErrorSpanStatus",
+			opts: Options{
+				ClientErrorSpanStatus: true,
+			},
+			statusCode:          404,
+			expectedOK:          true,
+			expectedCode:        codes.Error,
+			expectedDescription: "Not Found",
+		},
+		{
+			name:                "ServerError",
+			opts:                Options{},
+			statusCode:          503,
+			expectedOK:          true,
+			expectedCode:        codes.Error,
+			expectedDescription: "Service Unavailable",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			code, description, ok := tc.opts.spanStatus(tc.statusCode)
+
+			assert.Equal(t, tc.expectedOK, ok)
+			assert.Equal(t, tc.expectedCode, code)
+			assert.Equal(t, tc.expectedDescription, description)
+		})
+	}
+}
+
+func TestOptionsTruncateURL(t *testing.T) {
+	tests := []struct {
+		name              string
+		opts              Options
+		url               string
+		expectedURL       string
+		expectedTruncated bool
+	}{
+		{
+			name:              "Short",
+			opts:              Options{MaxURLLength: 8},
+			url:               "/v1/item",
+			expectedURL:       "/v1/item",
+			expectedTruncated: false,
+		},
+		{
+			name:              "Long",
+			opts:              Options{MaxURLLength: 8},
+			url:               "/v1/items/1234",
+			expectedURL:       "/v1/item",
+			expectedTruncated: true,
+		},
+		{
+			name:              "MultiByte",
+			opts:              Options{MaxURLLength: 7},
+			url:               "/v1/ité/1234",
+			expectedURL:       "/v1/it",
+			expectedTruncated: true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			url, truncated := tc.opts.truncateURL(tc.url)
+
+			assert.Equal(t, tc.expectedURL, url)
+			assert.Equal(t, tc.expectedTruncated, truncated)
+		})
+	}
+}
+
+func TestOptionsValidRequestUUID(t *testing.T) {
+	tests := []struct {
+		name          string
+		opts          Options
+		id            string
+		expectedValid bool
+	}{
+		{
+			name:          "Empty",
+			opts:          Options{},
+			id:            "",
+			expectedValid: false,
+		},
+		{
+			name:          "UUID",
+			opts:          Options{},
+			id:            "aaaaaaaa-aaaa-aaaa-aaaa-aaaaaaaaaaaa",
+			expectedValid: true,
+		},
+		{
+			name:          "ULID",
+			opts:          Options{},
+			id:            "01ARZ3NDEKTSV4RRFFQ69G5FAV",
+			expectedValid: true,
+		},
+		{
+			name:          "Newline",
+			opts:          Options{},
+			id:            "aaaaaaaa-aaaa-aaaa-aaaa-aaaaaaaaaaaa\nlevel=error",
+			expectedValid: false,
+		},
+		{
+			name:          "Arbitrary",
+			opts:          Options{},
+			id:            "<script>alert(1)</script>",
+			expectedValid: false,
+		},
+		{
+			name: "CustomPattern",
+			opts: Options{
+				RequestUUIDRegexp: regexp.MustCompile(`^req-[0-9]+$`),
+			},
+			id:            "req-1234",
+			expectedValid: true,
+		},
+		{
+			name: "CustomPatternWithControlCharacter",
+			opts: Options{
+				RequestUUIDRegexp: regexp.MustCompile(`.*`),
+			},
+			id:            "req-1234\r",
+			expectedValid: false,
+		},
+		{
+			name: "CustomPatternTooLong",
+			opts: Options{
+				RequestUUIDRegexp: regexp.MustCompile(`.*`),
+			},
+			id:            strings.Repeat("a", 129),
+			expectedValid: false,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			opts := tc.opts.withDefaults()
+			assert.Equal(t, tc.expectedValid, opts.validRequestUUID(tc.id))
+		})
+	}
+}
+
+func TestOptionsLoggableBody(t *testing.T) {
+	tests := []struct {
+		name             string
+		opts             Options
+		contentType      string
+		expectedLoggable bool
+	}{
+		{
+			name:             "JSON",
+			opts:             Options{}.withDefaults(),
+			contentType:      "application/json",
+			expectedLoggable: true,
+		},
+		{
+			name:             "JSONWithCharset",
+			opts:             Options{}.withDefaults(),
+			contentType:      "application/json; charset=utf-8",
+			expectedLoggable: true,
+		},
+		{
+			name:             "Text",
+			opts:             Options{}.withDefaults(),
+			contentType:      "text/plain",
+			expectedLoggable: true,
+		},
+		{
+			name:             "Binary",
+			opts:             Options{}.withDefaults(),
+			contentType:      "application/octet-stream",
+			expectedLoggable: false,
+		},
+		{
+			name:             "Empty",
+			opts:             Options{}.withDefaults(),
+			contentType:      "",
+			expectedLoggable: false,
+		},
+		{
+			name: "Custom",
+			opts: Options{
+				ErrorBodyContentTypes: []string{"application/problem+json"},
+			}.withDefaults(),
+			contentType:      "application/problem+json",
+			expectedLoggable: true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expectedLoggable, tc.opts.loggableBody(tc.contentType))
+		})
+	}
+}
+
+func TestOptionsEchoMetadata(t *testing.T) {
+	tests := []struct {
+		name         string
+		opts         Options
+		key          string
+		expectedEcho bool
+	}{
+		{
+			name:         "Default",
+			opts:         Options{},
+			key:          clientNameHeader,
+			expectedEcho: true,
+		},
+		{
+			name:         "SuppressAll",
+			opts:         Options{SuppressResponseMetadata: true},
+			key:          requestUUIDHeader,
+			expectedEcho: false,
+		},
+		{
+			name:         "SuppressedKey",
+			opts:         Options{SuppressedResponseMetadata: []string{"client-name"}},
+			key:          clientNameHeader,
+			expectedEcho: false,
+		},
+		{
+			name:         "NotSuppressedKey",
+			opts:         Options{SuppressedResponseMetadata: []string{"client-name"}},
+			key:          requestUUIDHeader,
+			expectedEcho: true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expectedEcho, tc.opts.echoMetadata(tc.key))
+		})
+	}
+}
+
+func TestRateLimitRoute(t *testing.T) {
+	tests := []struct {
+		name          string
+		rl            *RateLimit
+		route         string
+		expectedRoute string
+	}{
+		{
+			name:          "WithoutRouteFn",
+			rl:            &RateLimit{Rate: 1},
+			route:         "/users/1",
+			expectedRoute: "/users/1",
+		},
+		{
+			name: "WithEmptyRouteFn",
+			rl: &RateLimit{
+				Rate:    1,
+				RouteFn: func(*http.Request) string { return "" },
+			},
+			route:         "/users/1",
+			expectedRoute: "/users/1",
+		},
+		{
+			name: "WithRouteFn",
+			rl: &RateLimit{
+				Rate:    1,
+				RouteFn: func(*http.Request) string { return "/users/{id}" },
+			},
+			route:         "/users/1",
+			expectedRoute: "/users/{id}",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", tc.route, nil)
+			assert.Equal(t, tc.expectedRoute, tc.rl.route(req, tc.route))
+		})
+	}
+}
+
+func TestRateLimitKey(t *testing.T) {
+	tests := []struct {
+		name        string
+		rl          *RateLimit
+		route       string
+		clientName  string
+		expectedKey string
+	}{
+		{
+			name:        "PerRoute",
+			rl:          &RateLimit{Rate: 1},
+			route:       "/v1/items",
+			clientName:  "test-client",
+			expectedKey: "/v1/items",
+		},
+		{
+			name:        "PerClient",
+			rl:          &RateLimit{Rate: 1, PerClient: true},
+			route:       "/v1/items",
+			clientName:  "test-client",
+			expectedKey: "/v1/items|test-client",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expectedKey, tc.rl.key(tc.route, tc.clientName))
+		})
+	}
+}
+
+func TestRetryRetryable(t *testing.T) {
+	canceledCtx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	tests := []struct {
+		name              string
+		retry             *Retry
+		req               *http.Request
+		resp              *http.Response
+		err               error
+		expectedRetryable bool
+	}{
+		{
+			name:              "Success",
+			retry:             &Retry{},
+			req:               httptest.NewRequest("GET", "/v1/items", nil),
+			resp:              &http.Response{StatusCode: http.StatusOK},
+			expectedRetryable: false,
+		},
+		{
+			name:              "ServiceUnavailable",
+			retry:             &Retry{},
+			req:               httptest.NewRequest("GET", "/v1/items", nil),
+			resp:              &http.Response{StatusCode: http.StatusServiceUnavailable},
+			expectedRetryable: true,
+		},
+		{
+			name:              "InternalServerError",
+			retry:             &Retry{},
+			req:               httptest.NewRequest("GET", "/v1/items", nil),
+			resp:              &http.Response{StatusCode: http.StatusInternalServerError},
+			expectedRetryable: false,
+		},
+		{
+			name:              "TransportError",
+			retry:             &Retry{},
+			req:               httptest.NewRequest("GET", "/v1/items", nil),
+			err:               errors.New("connection refused"),
+			expectedRetryable: true,
+		},
+		{
+			name:              "Canceled",
+			retry:             &Retry{},
+			req:               httptest.NewRequest("GET", "/v1/items", nil).WithContext(canceledCtx),
+			err:               context.Canceled,
+			expectedRetryable: false,
+		},
+		{
+			name:              "NonIdempotentMethod",
+			retry:             &Retry{},
+			req:               httptest.NewRequest("POST", "/v1/items", nil),
+			resp:              &http.Response{StatusCode: http.StatusServiceUnavailable},
+			expectedRetryable: false,
+		},
+		{
+			name: "RetryOn",
+			retry: &Retry{
+				RetryOn: func(resp *http.Response, err error) bool {
+					return resp.StatusCode == http.StatusTooManyRequests
+				},
+			},
+			req:               httptest.NewRequest("POST", "/v1/items", nil),
+			resp:              &http.Response{StatusCode: http.StatusTooManyRequests},
+			expectedRetryable: true,
+		},
+		{
+			name: "BodyCannotBeReread",
+			retry: &Retry{
+				RetryOn: func(*http.Response, error) bool { return true },
+			},
+			req:               httptest.NewRequest("PUT", "/v1/items", strings.NewReader("{}")),
+			resp:              &http.Response{StatusCode: http.StatusServiceUnavailable},
+			expectedRetryable: false,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expectedRetryable, tc.retry.retryable(tc.req, tc.resp, tc.err))
+		})
+	}
+}
+
+func TestRateLimiter(t *testing.T) {
+	c := clock.NewFake()
+	r := newRateLimiter(2, 0, c)
+	assert.Equal(t, float64(2), r.burst)
+
+	ok, _ := r.allow("a")
+	assert.True(t, ok)
+	ok, _ = r.allow("a")
+	assert.True(t, ok)
+	ok, wait := r.allow("a")
+	assert.False(t, ok)
+	assert.Equal(t, 500*time.Millisecond, wait)
+
+	// Buckets are independent for every key
+	ok, _ = r.allow("b")
+	assert.True(t, ok)
+
+	c.Advance(250 * time.Millisecond)
+	ok, wait = r.allow("a")
+	assert.False(t, ok)
+	assert.Equal(t, 250*time.Millisecond, wait)
+
+	c.Advance(250 * time.Millisecond)
+	ok, _ = r.allow("a")
+	assert.True(t, ok)
+
+	// Full buckets are forgotten
+	c.Advance(time.Minute)
+	ok, _ = r.allow("a")
+	assert.True(t, ok)
+	assert.Len(t, r.buckets, 1)
+}
+
+func TestLogThrottler(t *testing.T) {
+	c := clock.NewFake()
+	th := newLogThrottler(10*time.Second, c)
+
+	ok, suppressed := th.allow("GET /v1/items 500")
+	assert.True(t, ok)
+	assert.Equal(t, int64(0), suppressed)
+
+	ok, _ = th.allow("GET /v1/items 500")
+	assert.False(t, ok)
+	ok, _ = th.allow("GET /v1/items 500")
+	assert.False(t, ok)
+
+	// Fingerprints are throttled independently
+	ok, _ = th.allow("GET /v1/items 503")
+	assert.True(t, ok)
+
+	c.Advance(10 * time.Second)
+	ok, suppressed = th.allow("GET /v1/items 500")
+	assert.True(t, ok)
+	assert.Equal(t, int64(2), suppressed)
+
+	// Fingerprints with no suppressed logs are forgotten
+	c.Advance(time.Minute)
+	ok, suppressed = th.allow("GET /v1/items 500")
+	assert.True(t, ok)
+	assert.Equal(t, int64(0), suppressed)
+	assert.Len(t, th.entries, 1)
+}
+
+// unhashableObserver is an observer.Observer that cannot be used as a map key.
+type unhashableObserver struct {
+	*mockObserver
+	_ []string
+}
+
+func TestUnhashableObserver(t *testing.T) {
+	obsv := unhashableObserver{mockObserver: newMockObserver()}
+
+	assert.NotPanics(t, func() {
+		NewMiddleware(obsv, Options{})
+		NewClient(&http.Client{}, obsv, Options{})
+	})
+}
+
+func TestDurationInstruments(t *testing.T) {
+	_, meter := oteltest.NewMeter()
+	server := newServerInstruments(meter, zap.NewNop())
+	client := newClientInstruments(meter, zap.NewNop())
+
+	tests := []struct {
+		name       string
+		instrument metric.Int64ValueRecorder
+	}{
+		{
+			name:       "ServerRequestDuration",
+			instrument: server.reqDuration,
+		},
+		{
+			name:       "ServerRequestQueueWait",
+			instrument: server.reqWait,
+		},
+		{
+			name:       "ServerRequestTTFB",
+			instrument: server.reqTTFB,
+		},
+		{
+			name:       "ClientRequestDuration",
+			instrument: client.reqDuration,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			desc := tc.instrument.SyncImpl().Descriptor()
+
+			assert.Equal(t, unit.Milliseconds, desc.Unit())
+			assert.Contains(t, desc.Description(), "in milliseconds")
+		})
+	}
+}
+
+// slowReader is an io.Reader that sleeps before every read.
+type slowReader struct {
+	r     io.Reader
+	delay time.Duration
+}
+
+func (r *slowReader) Read(p []byte) (int, error) {
+	time.Sleep(r.delay)
+	return r.r.Read(p)
+}
+
+// stackError is an error with a stack trace like the errors of github.com/pkg/errors.
+type stackError struct {
+	msg   string
+	stack string
+	cause error
+}
+
+func (e *stackError) Error() string {
+	return e.msg
+}
+
+func (e *stackError) Unwrap() error {
+	return e.cause
+}
+
+func (e *stackError) StackTrace() string {
+	return e.stack
+}
+
+func TestTimingReader(t *testing.T) {
+	tr := newTimingReader(ioutil.NopCloser(&slowReader{
+		r:     strings.NewReader("hello"),
+		delay: 5 * time.Millisecond,
+	}))
+
+	b, err := ioutil.ReadAll(tr)
+
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", string(b))
+	assert.True(t, tr.Duration() >= 10*time.Millisecond)
+	assert.NoError(t, tr.Close())
+}
+
+func TestCaptureReader(t *testing.T) {
+	tests := []struct {
+		name           string
+		body           string
+		max            int
+		expectedFields map[string]interface{}
+	}{
+		{
+			name:           "Empty",
+			body:           "",
+			max:            8,
+			expectedFields: map[string]interface{}{},
+		},
+		{
+			name: "Short",
+			body: "hello",
+			max:  8,
+			expectedFields: map[string]interface{}{
+				"req.body":      "hello",
+				"req.body_size": int64(5),
+			},
+		},
+		{
+			name: "Truncated",
+			body: "hello, world!",
+			max:  8,
+			expectedFields: map[string]interface{}{
+				"req.body":      "hello, w",
+				"req.body_size": int64(13),
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			cr := newCaptureReader(ioutil.NopCloser(strings.NewReader(tc.body)), tc.max)
+
+			b, err := ioutil.ReadAll(cr)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.body, string(b))
+
+			enc := zapcore.NewMapObjectEncoder()
+			for _, f := range cr.fields("req", true) {
+				f.AddTo(enc)
+			}
+			assert.Equal(t, tc.expectedFields, enc.Fields)
+		})
+	}
+}
+
 func TestResponseWriter(t *testing.T) {
 	tests := []struct {
 		name        string
@@ -103,3 +903,153 @@ func TestResponseWriter(t *testing.T) {
 		})
 	}
 }
+
+func TestResponseWriterFirstByteTime(t *testing.T) {
+	tests := []struct {
+		name  string
+		write func(http.ResponseWriter)
+	}{
+		{
+			name: "WriteHeader",
+			write: func(w http.ResponseWriter) {
+				w.WriteHeader(http.StatusAccepted)
+			},
+		},
+		{
+			name: "Write",
+			write: func(w http.ResponseWriter) {
+				w.Write([]byte("chunk"))
+			},
+		},
+		{
+			name: "Flush",
+			write: func(w http.ResponseWriter) {
+				w.(http.Flusher).Flush()
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			c := clock.NewFake()
+			rw := newResponseWriter(httptest.NewRecorder())
+			rw.clock = c
+			assert.True(t, rw.FirstByteTime.IsZero())
+
+			c.Advance(time.Second)
+			tc.write(rw)
+			firstByte := c.Now()
+
+			// Only the first write is captured
+			c.Advance(time.Second)
+			rw.Write([]byte("chunk"))
+
+			assert.Equal(t, firstByte, rw.FirstByteTime)
+		})
+	}
+}
+
+func TestResponseWriterWrite(t *testing.T) {
+	var hookStatusCode int
+
+	rec := httptest.NewRecorder()
+	rw := newResponseWriter(rec)
+	rw.beforeWriteHeader = func(statusCode int) {
+		hookStatusCode = statusCode
+	}
+
+	n, err := rw.Write([]byte("hello"))
+
+	assert.NoError(t, err)
+	assert.Equal(t, 5, n)
+	assert.Equal(t, http.StatusOK, hookStatusCode)
+	assert.Equal(t, http.StatusOK, rw.StatusCode)
+	assert.Equal(t, "2xx", rw.StatusClass)
+	assert.Equal(t, "hello", rec.Body.String())
+}
+
+// mockResponseWriter is an http.ResponseWriter that implements http.Hijacker and http.Pusher.
+type mockResponseWriter struct {
+	*httptest.ResponseRecorder
+
+	HijackOutConn  net.Conn
+	HijackOutError error
+
+	PushInTarget string
+	PushOutError error
+}
+
+func (m *mockResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return m.HijackOutConn, nil, m.HijackOutError
+}
+
+func (m *mockResponseWriter) Push(target string, opts *http.PushOptions) error {
+	m.PushInTarget = target
+	return m.PushOutError
+}
+
+// basicResponseWriter is an http.ResponseWriter that implements none of the optional interfaces.
+type basicResponseWriter struct {
+	http.ResponseWriter
+}
+
+func TestResponseWriterFlush(t *testing.T) {
+	t.Run("Supported", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		rw := newResponseWriter(rec)
+		rw.Flush()
+
+		assert.True(t, rec.Flushed)
+		assert.Equal(t, http.StatusOK, rw.StatusCode)
+	})
+
+	t.Run("NotSupported", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		rw := newResponseWriter(&basicResponseWriter{rec})
+		rw.Flush()
+
+		assert.False(t, rec.Flushed)
+		assert.Equal(t, 0, rw.StatusCode)
+	})
+}
+
+func TestResponseWriterHijack(t *testing.T) {
+	t.Run("Supported", func(t *testing.T) {
+		server, client := net.Pipe()
+		defer server.Close()
+		defer client.Close()
+
+		rw := newResponseWriter(&mockResponseWriter{
+			ResponseRecorder: httptest.NewRecorder(),
+			HijackOutConn:    server,
+		})
+
+		conn, _, err := rw.Hijack()
+		assert.NoError(t, err)
+		assert.Equal(t, server, conn)
+	})
+
+	t.Run("NotSupported", func(t *testing.T) {
+		rw := newResponseWriter(&basicResponseWriter{httptest.NewRecorder()})
+
+		conn, _, err := rw.Hijack()
+		assert.Nil(t, conn)
+		assert.EqualError(t, err, "the underlying response writer does not implement http.Hijacker")
+	})
+}
+
+func TestResponseWriterPush(t *testing.T) {
+	t.Run("Supported", func(t *testing.T) {
+		w := &mockResponseWriter{ResponseRecorder: httptest.NewRecorder()}
+		rw := newResponseWriter(w)
+
+		assert.NoError(t, rw.Push("/static/app.js", nil))
+		assert.Equal(t, "/static/app.js", w.PushInTarget)
+	})
+
+	t.Run("NotSupported", func(t *testing.T) {
+		rw := newResponseWriter(&basicResponseWriter{httptest.NewRecorder()})
+
+		assert.Equal(t, http.ErrNotSupported, rw.Push("/static/app.js", nil))
+	})
+}
@@ -0,0 +1,89 @@
+// Package routeutil provides adapters for reading the matched route pattern of a request.
+// These adapters can be used as ohttp.Options.RouteFn.
+//
+// The adapters for third-party routers are not provided here to avoid importing them.
+// You can wire them as follows.
+//
+// For chi (github.com/go-chi/chi):
+//
+//	opts := ohttp.Options{
+//	  RouteFn: func(r *http.Request) string {
+//	    return chi.RouteContext(r.Context()).RoutePattern()
+//	  },
+//	}
+//
+// For gorilla/mux (github.com/gorilla/mux):
+//
+//	opts := ohttp.Options{
+//	  RouteFn: func(r *http.Request) string {
+//	    if route := mux.CurrentRoute(r); route != nil {
+//	      tmpl, _ := route.GetPathTemplate()
+//	      return tmpl
+//	    }
+//	    return ""
+//	  },
+//	}
+//
+// Since the route is read after the request is routed,
+// the middleware should be registered on the router (i.e. router.Use) rather than wrapping the router.
+// ServeMuxHandler is an exception, since it looks up the pattern of a request on an http.ServeMux without routing it.
+package routeutil
+
+import (
+	"net/http"
+	"reflect"
+)
+
+// ServeMux returns a route function that reads the pattern matched by http.ServeMux.
+// The Pattern field of http.Request is only available in Go 1.23 and later;
+// on earlier versions, the returned function always returns an empty string.
+func ServeMux() func(*http.Request) string {
+	return func(r *http.Request) string {
+		if r == nil {
+			return ""
+		}
+
+		f := reflect.ValueOf(r).Elem().FieldByName("Pattern")
+		if !f.IsValid() || f.Kind() != reflect.String {
+			return ""
+		}
+
+		return f.String()
+	}
+}
+
+// ServeMuxHandler returns a route function that reads the pattern registered on an http.ServeMux for a request.
+// The pattern is looked up using mux.Handler, so it does not rely on reflection or on the request being routed,
+// and the middleware can wrap the mux itself. This keeps the route label bounded when there is no router
+// and the ids in url paths are not matched by ohttp.Options.IDRegexp (i.e. numeric ids).
+// If no pattern matches the request, the returned function returns an empty string.
+//
+//	mux := http.NewServeMux()
+//	mux.HandleFunc("/users/", usersHandler)
+//	mid := ohttp.NewMiddleware(obsv, ohttp.Options{
+//	  RouteFn: routeutil.ServeMuxHandler(mux),
+//	})
+//	handler := mid.Wrap(mux.ServeHTTP)
+func ServeMuxHandler(mux *http.ServeMux) func(*http.Request) string {
+	return func(r *http.Request) string {
+		if r == nil {
+			return ""
+		}
+
+		_, pattern := mux.Handler(r)
+		return pattern
+	}
+}
+
+// First returns a route function that returns the first non-empty route returned by the given route functions.
+func First(fns ...func(*http.Request) string) func(*http.Request) string {
+	return func(r *http.Request) string {
+		for _, fn := range fns {
+			if route := fn(r); route != "" {
+				return route
+			}
+		}
+
+		return ""
+	}
+}
@@ -0,0 +1,124 @@
+package routeutil
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServeMux(t *testing.T) {
+	tests := []struct {
+		name          string
+		pattern       string
+		expectedRoute string
+	}{
+		{
+			name:          "NoPattern",
+			pattern:       "",
+			expectedRoute: "",
+		},
+		{
+			name:          "WithPattern",
+			pattern:       "GET /users/{id}",
+			expectedRoute: "GET /users/{id}",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/users/1", nil)
+
+			f := reflect.ValueOf(req).Elem().FieldByName("Pattern")
+			if !f.IsValid() {
+				t.Skip("http.Request has no Pattern field")
+			}
+			f.SetString(tc.pattern)
+
+			route := ServeMux()(req)
+
+			assert.Equal(t, tc.expectedRoute, route)
+		})
+	}
+}
+
+func TestServeMuxHandler(t *testing.T) {
+	noop := func(w http.ResponseWriter, r *http.Request) {}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/users/", noop)
+	mux.HandleFunc("/health", noop)
+
+	tests := []struct {
+		name          string
+		req           *http.Request
+		expectedRoute string
+	}{
+		{
+			name:          "NilRequest",
+			req:           nil,
+			expectedRoute: "",
+		},
+		{
+			name:          "NoPattern",
+			req:           httptest.NewRequest("GET", "/items/1", nil),
+			expectedRoute: "",
+		},
+		{
+			name:          "ExactPattern",
+			req:           httptest.NewRequest("GET", "/health", nil),
+			expectedRoute: "/health",
+		},
+		{
+			name:          "SubtreePattern",
+			req:           httptest.NewRequest("GET", "/users/1234", nil),
+			expectedRoute: "/users/",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			route := ServeMuxHandler(mux)(tc.req)
+
+			assert.Equal(t, tc.expectedRoute, route)
+		})
+	}
+}
+
+func TestFirst(t *testing.T) {
+	empty := func(*http.Request) string { return "" }
+	users := func(*http.Request) string { return "/users/{id}" }
+	items := func(*http.Request) string { return "/items/{id}" }
+
+	tests := []struct {
+		name          string
+		fns           []func(*http.Request) string
+		expectedRoute string
+	}{
+		{
+			name:          "NoFunc",
+			fns:           nil,
+			expectedRoute: "",
+		},
+		{
+			name:          "AllEmpty",
+			fns:           []func(*http.Request) string{empty, empty},
+			expectedRoute: "",
+		},
+		{
+			name:          "FirstNonEmpty",
+			fns:           []func(*http.Request) string{empty, users, items},
+			expectedRoute: "/users/{id}",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/users/1", nil)
+			route := First(tc.fns...)(req)
+
+			assert.Equal(t, tc.expectedRoute, route)
+		})
+	}
+}
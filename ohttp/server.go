@@ -3,15 +3,23 @@ package ohttp
 import (
 	"context"
 	"fmt"
+	"math"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/hashicorp/go-multierror"
 	"github.com/moorara/observer"
+	"github.com/moorara/observer/internal/errs"
+	"github.com/moorara/observer/internal/instrument"
+	"github.com/moorara/observer/internal/request"
+	"github.com/moorara/observer/internal/tracing"
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/baggage"
 	"go.opentelemetry.io/otel/label"
 	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/propagation"
 	"go.opentelemetry.io/otel/trace"
 	"go.opentelemetry.io/otel/unit"
 	"go.uber.org/zap"
@@ -21,12 +29,29 @@ import (
 type serverInstruments struct {
 	reqCounter   metric.Int64Counter
 	reqGauge     metric.Int64UpDownCounter
+	reqPeak      *instrument.HighWaterMark
 	reqDuration  metric.Int64ValueRecorder
+	reqSeconds   metric.Float64ValueRecorder
+	reqWait      metric.Int64ValueRecorder
+	reqTTFB      metric.Int64ValueRecorder
+	connGauge    metric.Int64UpDownCounter
 	panicCounter metric.Int64Counter
+	limitCounter metric.Int64Counter
 }
 
-func newServerInstruments(meter metric.Meter) *serverInstruments {
-	mm := metric.Must(meter)
+func newServerInstruments(meter metric.Meter, logger *zap.Logger) *serverInstruments {
+	mm := instrument.NewSafeMeter(meter, logger)
+
+	reqPeak := new(instrument.HighWaterMark)
+	mm.NewInt64ValueObserver(
+		"incoming_http_requests_active_max",
+		func(_ context.Context, result metric.Int64ObserverResult) {
+			result.Observe(reqPeak.Collect())
+		},
+		metric.WithDescription("The peak number of in-flight incoming http requests since the last collection (server-side)"),
+		metric.WithUnit(unit.Dimensionless),
+		metric.WithInstrumentationName(libraryName),
+	)
 
 	return &serverInstruments{
 		reqCounter: mm.NewInt64Counter(
@@ -41,66 +66,178 @@ func newServerInstruments(meter metric.Meter) *serverInstruments {
 			metric.WithUnit(unit.Dimensionless),
 			metric.WithInstrumentationName(libraryName),
 		),
+		reqPeak: reqPeak,
 		reqDuration: mm.NewInt64ValueRecorder(
 			"incoming_http_requests_duration",
 			metric.WithDescription("The duration of incoming http requests in milliseconds (server-side)"),
 			metric.WithUnit(unit.Milliseconds),
 			metric.WithInstrumentationName(libraryName),
 		),
+		reqSeconds: mm.NewFloat64ValueRecorder(
+			"incoming_http_requests_duration_seconds",
+			metric.WithDescription("The duration of incoming http requests in seconds (server-side)"),
+			metric.WithUnit(unitSeconds),
+			metric.WithInstrumentationName(libraryName),
+		),
+		reqWait: mm.NewInt64ValueRecorder(
+			"incoming_http_requests_queue_wait",
+			metric.WithDescription("The time incoming http requests waited before being handled in milliseconds (server-side)"),
+			metric.WithUnit(unit.Milliseconds),
+			metric.WithInstrumentationName(libraryName),
+		),
+		reqTTFB: mm.NewInt64ValueRecorder(
+			"incoming_http_requests_ttfb",
+			metric.WithDescription("The time until the first byte of incoming http responses is written in milliseconds (server-side)"),
+			metric.WithUnit(unit.Milliseconds),
+			metric.WithInstrumentationName(libraryName),
+		),
+		connGauge: mm.NewInt64UpDownCounter(
+			"websocket_connections_active",
+			metric.WithDescription("The number of open hijacked connections such as websockets (server-side)"),
+			metric.WithUnit(unit.Dimensionless),
+			metric.WithInstrumentationName(libraryName),
+		),
 		panicCounter: mm.NewInt64Counter(
 			"handler_panics_total",
 			metric.WithDescription("The total number of panics that happened in http handlers (server-side)"),
 			metric.WithUnit(unit.Dimensionless),
 			metric.WithInstrumentationName(libraryName),
 		),
+		limitCounter: mm.NewInt64Counter(
+			"http_rate_limited_total",
+			metric.WithDescription("The total number of incoming http requests rejected by the rate limit (server-side)"),
+			metric.WithUnit(unit.Dimensionless),
+			metric.WithInstrumentationName(libraryName),
+		),
 	}
 }
 
+var serverInstrumentsCache = instrument.NewCache()
+
 // Middleware creates observable http handlers with logging, metrics, and tracing.
 type Middleware struct {
 	opts        Options
 	observer    observer.Observer
 	instruments *serverInstruments
+	limiter     *rateLimiter
+	throttler   *logThrottler
 }
 
 // NewMiddleware creates a new http middleware for observability.
 func NewMiddleware(observer observer.Observer, opts Options) *Middleware {
 	opts = opts.withDefaults()
-	instruments := newServerInstruments(observer.Meter())
+	instruments := serverInstrumentsCache.Get(observer.Meter(), func() interface{} {
+		return newServerInstruments(observer.Meter(), observer.Logger())
+	}).(*serverInstruments)
+
+	var limiter *rateLimiter
+	if opts.RateLimit != nil && opts.RateLimit.Rate > 0 {
+		limiter = newRateLimiter(opts.RateLimit.Rate, opts.RateLimit.Burst, opts.clock)
+	}
+
+	var throttler *logThrottler
+	if opts.ErrorLogInterval > 0 {
+		throttler = newLogThrottler(opts.ErrorLogInterval, opts.clock)
+	}
 
 	return &Middleware{
 		opts:        opts,
 		observer:    observer,
 		instruments: instruments,
+		limiter:     limiter,
+		throttler:   throttler,
 	}
 }
 
-func (m *Middleware) callHandlerFunc(handler http.HandlerFunc, w http.ResponseWriter, r *http.Request) {
+// serverTiming returns the value of Server-Timing header for a request.
+func serverTiming(duration time.Duration, traceID trace.TraceID) string {
+	value := fmt.Sprintf("app;dur=%d", duration.Milliseconds())
+	if traceID.IsValid() {
+		value += fmt.Sprintf(", trace;desc=%q", traceID.String())
+	}
+
+	return value
+}
+
+func (m *Middleware) callHandlerFunc(method, route string, handler http.HandlerFunc, w http.ResponseWriter, r *http.Request) {
 	defer func() {
 		if r := recover(); r != nil {
-			err := fmt.Errorf("critical error: %v", r)
-			m.observer.Logger().Error("Panic occurred.", zap.Error(err))
-			m.instruments.panicCounter.Add(context.Background(), 1)
-			w.WriteHeader(http.StatusInternalServerError)
+			err := errs.FromPanic("critical error", r)
+			m.observer.Logger().Error("Panic occurred.", zap.Error(err), zap.String("panic.type", fmt.Sprintf("%T", r)))
+			m.instruments.panicCounter.Add(context.Background(), 1,
+				label.String("method", method),
+				label.String("route", route),
+			)
+			if m.opts.PanicResponse != nil {
+				http.Error(w, m.opts.PanicResponse(err), http.StatusInternalServerError)
+			} else {
+				w.WriteHeader(http.StatusInternalServerError)
+			}
 		}
 	}()
 
 	handler(w, r)
 }
 
+// requestAttributes calls the user function for computing custom attributes from a request.
+// A panic in the user function is recovered and logged, and no attribute is returned.
+func (m *Middleware) requestAttributes(r *http.Request) (attrs []label.KeyValue) {
+	if m.opts.AttributesFromRequest == nil {
+		return nil
+	}
+
+	defer func() {
+		if rec := recover(); rec != nil {
+			attrs = nil
+			err := fmt.Errorf("critical error: %v", rec)
+			m.observer.Logger().Error("Panic occurred in AttributesFromRequest.", zap.String("req.url", r.URL.Path), zap.Error(err))
+		}
+	}()
+
+	return m.opts.AttributesFromRequest(r)
+}
+
 // Wrap wraps an existing http handler function and returns a new observable handler function.
 // This can be used for making http handlers observable via logging, metrics, tracing, etc.
 // It also observes and recovers panics that happened inside the inner http handler.
+//
+// If requests wait in a queue before they reach this handler (i.e. a concurrency limiter),
+// the arrival time of requests can be set on the request context using observer.ContextWithStartTime
+// before they are queued. The wait time is then reported separately from the handler duration.
+//
+//	limiter := func(w http.ResponseWriter, r *http.Request) {
+//	  r = r.WithContext(observer.ContextWithStartTime(r.Context(), time.Now()))
+//	  sem <- struct{}{}
+//	  defer func() { <-sem }()
+//	  handler(w, r)
+//	}
+//
+// If the handler hijacks the connection (i.e. websockets), the request is reported as a long-lived connection.
+// Instead of the request metrics, the number of open connections is reported and the opening and closing of connections are logged.
+// The connection is considered closed when the handler returns.
 func (m *Middleware) Wrap(next http.HandlerFunc) http.HandlerFunc {
+	// The source code location of the handler is only computed once
+	var location []label.KeyValue
+	if m.opts.RecordCodeLocation {
+		location = tracing.CodeLocation(next)
+	}
+
 	return func(w http.ResponseWriter, r *http.Request) {
-		startTime := time.Now()
+		startTime := m.opts.clock.Now()
 		ctx := r.Context()
 		kind := "server"
 		method := r.Method
-		url := r.URL.Path
+		url, truncated := m.opts.truncateURL(r.URL.Path)
 		route := m.opts.IDRegexp.ReplaceAllString(url, ":id")
 
+		// Compute the time the request waited before being handled if the arrival time is set
+		var queueWait int64 = -1
+		if arrivalTime, ok := observer.StartTimeFromContext(ctx); ok && arrivalTime.Before(startTime) {
+			queueWait = startTime.Sub(arrivalTime).Milliseconds()
+		}
+
 		// Increase the number of in-flight requests
+		// The matched route is not known yet, so the route derived from the url path is used
 		m.instruments.reqGauge.Add(ctx, 1,
 			label.String("method", method),
 			label.String("route", route),
@@ -112,9 +249,14 @@ func (m *Middleware) Wrap(next http.HandlerFunc) http.HandlerFunc {
 			label.String("route", route),
 		)
 
-		// Make sure the request has a UUID
+		// Keep track of the peak number of in-flight requests
+		m.instruments.reqPeak.Inc()
+		defer m.instruments.reqPeak.Dec()
+
+		// Make sure the request has a valid UUID
 		requestUUID := r.Header.Get(requestUUIDHeader)
-		if requestUUID == "" {
+		clientRequestUUID := m.opts.untrustedRequestUUID(requestUUID)
+		if m.opts.IgnoreIncomingRequestID || !m.opts.validRequestUUID(requestUUID) {
 			requestUUID = uuid.New().String()
 			r.Header.Set(requestUUIDHeader, requestUUID)
 		}
@@ -123,8 +265,12 @@ func (m *Middleware) Wrap(next http.HandlerFunc) http.HandlerFunc {
 		clientName := r.Header.Get(clientNameHeader)
 
 		// Propagate request metadata by adding them to outgoing http response headers
-		w.Header().Set(requestUUIDHeader, requestUUID)
-		w.Header().Set(clientNameHeader, clientName)
+		if m.opts.echoMetadata(requestUUIDHeader) {
+			w.Header().Set(requestUUIDHeader, requestUUID)
+		}
+		if m.opts.echoMetadata(clientNameHeader) {
+			w.Header().Set(clientNameHeader, clientName)
+		}
 
 		// Extract context from the http headers
 		ctx = otel.GetTextMapPropagator().Extract(ctx, r.Header)
@@ -144,48 +290,173 @@ func (m *Middleware) Wrap(next http.HandlerFunc) http.HandlerFunc {
 		)
 		defer span.End()
 
+		if location != nil {
+			span.SetAttributes(location...)
+		}
+
+		// Compute custom attributes from the request
+		attrs := m.requestAttributes(r)
+		if len(attrs) > 0 {
+			span.SetAttributes(attrs...)
+		}
+
 		// Create a contextualized logger
 		contextFields := []zap.Field{
 			zap.String("req.uuid", requestUUID),
 			zap.String("req.kind", kind),
 			zap.String("req.method", method),
 			zap.String("req.url", url),
-			zap.String("req.route", route),
+		}
+		// The matched route is only known after the request is routed
+		if m.opts.RouteFn == nil {
+			contextFields = append(contextFields, zap.String("req.route", route))
+		}
+		contextFields = append(contextFields,
 			zap.String("traceId", span.SpanContext().TraceID.String()),
 			zap.String("spanId", span.SpanContext().SpanID.String()),
-		}
+		)
 		if clientName != "" {
 			contextFields = append(contextFields, zap.String("client.name", clientName))
 		}
-		logger := m.observer.Logger().With(contextFields...)
+		if clientRequestUUID != "" {
+			contextFields = append(contextFields, zap.String("client.request_uuid", clientRequestUUID))
+		}
+		if truncated {
+			contextFields = append(contextFields, zap.Bool("url.truncated", true))
+		}
+		if m.opts.BaggageToLogs {
+			contextFields = append(contextFields, request.BaggageFields(ctx)...)
+		}
+		contextFields = append(contextFields, request.LabelFields(attrs)...)
+		logger := m.observer.SpanLogger(span.SpanContext()).With(contextFields...)
 
 		// Augment the request context
-		ctx = observer.ContextWithUUID(ctx, requestUUID)
+		// The request metadata set by outer handlers (i.e. the principal set by an auth middleware) are kept
+		reqMD, _ := observer.RequestMetadataFromContext(ctx)
+		reqMD.UUID = requestUUID
+		reqMD.ClientName = clientName
+		reqMD.StartTime = startTime
+		reqMD.TraceID = span.SpanContext().TraceID
+		reqMD.SpanID = span.SpanContext().SpanID
+		ctx = observer.ContextWithRequestMetadata(ctx, reqMD)
 		ctx = observer.ContextWithLogger(ctx, logger)
+		ctx = observer.ContextWithObserver(ctx, m.observer)
+		ctx = observer.ContextWithFields(ctx)
+
+		// Time reading the request body, so slow clients can be distinguished from slow handlers
+		var body *timingReader
+		if m.opts.SlowBodyReadThreshold > 0 && r.Body != nil && r.Body != http.NoBody {
+			body = newTimingReader(r.Body)
+			r.Body = body
+		}
+
+		// Capture the request and response bodies, so they can be logged for error responses
+		var reqBody *captureReader
+		if m.opts.MaxErrorBodySize > 0 && r.Body != nil && r.Body != http.NoBody {
+			reqBody = newCaptureReader(r.Body, m.opts.MaxErrorBodySize)
+			r.Body = reqBody
+		}
+
 		req := r.WithContext(ctx)
 
 		// Create a wrapped response writer, so we can know about the response
 		rw := newResponseWriter(w)
+		rw.clock = m.opts.clock
+		if m.opts.MaxErrorBodySize > 0 {
+			rw.Body = newBodyCapture(m.opts.MaxErrorBodySize)
+		}
+
+		// Set the response headers that depend on the status code or the duration of the request
+		rw.beforeWriteHeader = func(statusCode int) {
+			// Expose the trace for error responses
+			if m.opts.ExposeTraceHeader && statusCode >= 500 && span.SpanContext().TraceID.IsValid() {
+				w.Header().Set(traceIDHeader, span.SpanContext().TraceID.String())
+				propagation.TraceContext{}.Inject(ctx, w.Header())
+			}
+
+			if m.opts.ServerTiming {
+				w.Header().Set(serverTimingHeader, serverTiming(m.opts.clock.Now().Sub(startTime), span.SpanContext().TraceID))
+			}
+		}
 
-		// Call http handler
-		span.AddEvent("calling http handler")
-		m.callHandlerFunc(next, rw, req)
+		// Report long-lived connections once they are hijacked
+		rw.afterHijack = func() {
+			m.instruments.connGauge.Add(ctx, 1,
+				label.String("method", method),
+				label.String("route", route),
+			)
 
-		duration := time.Since(startTime).Milliseconds()
+			message := fmt.Sprintf("%s %s connection opened", method, url)
+			if m.opts.LogInDebugLevel {
+				logger.Debug(message, zap.Bool("conn.hijacked", true))
+			} else {
+				logger.Info(message, zap.Bool("conn.hijacked", true))
+			}
+		}
+
+		// Call http handler unless the request is rejected by the rate limit
+		var rateLimited bool
+		if m.limiter != nil {
+			limitRoute := m.opts.RateLimit.route(req, route)
+			if ok, wait := m.limiter.allow(m.opts.RateLimit.key(limitRoute, clientName)); !ok {
+				rateLimited = true
+				m.instruments.limitCounter.Add(ctx, 1,
+					label.String("route", limitRoute),
+				)
+				span.AddEvent("rate limited")
+				w.Header().Set("Retry-After", strconv.FormatInt(int64(math.Ceil(wait.Seconds())), 10))
+				rw.WriteHeader(http.StatusTooManyRequests)
+			}
+		}
+
+		if !rateLimited {
+			span.AddEvent("calling http handler")
+			m.callHandlerFunc(method, route, next, rw, req)
+		}
+
+		if rw.Hijacked {
+			m.reportHijacked(ctx, span, logger, method, url, route, startTime)
+			return
+		}
+
+		// If the handler has not written anything, the headers can still be set
+		if m.opts.ServerTiming && rw.StatusCode == 0 {
+			w.Header().Set(serverTimingHeader, serverTiming(m.opts.clock.Now().Sub(startTime), span.SpanContext().TraceID))
+		}
+
+		elapsed := m.opts.clock.Now().Sub(startTime)
+		duration := elapsed.Milliseconds()
 		statusCode := rw.StatusCode
 		statusClass := rw.StatusClass
 
+		// Read the matched route after the handler (router) is called
+		if m.opts.RouteFn != nil {
+			if pattern := m.opts.RouteFn(req); pattern != "" {
+				route = pattern
+			}
+		}
+
 		// Report metrics
-		m.observer.Meter().RecordBatch(ctx,
-			[]label.KeyValue{
-				label.String("method", method),
-				label.String("route", route),
-				label.Int("status_code", statusCode),
-				label.String("status_class", statusClass),
-			},
+		labels := []label.KeyValue{
+			label.String("method", method),
+			label.String("route", route),
+		}
+		labels = append(labels, m.opts.statusLabels(statusCode, statusClass)...)
+		labels = append(labels, m.opts.tenantLabels(ctx)...)
+		labels = append(labels, m.opts.metricLabels(ctx, m.observer.Logger())...)
+		m.observer.Meter().RecordBatch(ctx, labels,
 			m.instruments.reqCounter.Measurement(1),
 			m.instruments.reqDuration.Measurement(duration),
 		)
+		if m.opts.DurationSeconds {
+			m.instruments.reqSeconds.Record(ctx, elapsed.Seconds(), labels...)
+		}
+		if queueWait >= 0 {
+			m.instruments.reqWait.Record(ctx, queueWait, labels...)
+		}
+		if m.opts.TimeToFirstByte && !rw.FirstByteTime.IsZero() {
+			m.instruments.reqTTFB.Record(ctx, rw.FirstByteTime.Sub(startTime).Milliseconds(), labels...)
+		}
 
 		// Report logs
 		message := fmt.Sprintf("%s %s %d %dms", method, url, statusCode, duration)
@@ -194,11 +465,41 @@ func (m *Middleware) Wrap(next http.HandlerFunc) http.HandlerFunc {
 			zap.String("resp.statusClass", statusClass),
 			zap.Int64("resp.duration", duration),
 		}
+		if m.opts.RouteFn != nil {
+			fields = append(fields, zap.String("req.route", route))
+		}
+		if queueWait >= 0 {
+			fields = append(fields, zap.Int64("req.queue_wait", queueWait))
+		}
+		if body != nil && body.Duration() > m.opts.SlowBodyReadThreshold {
+			fields = append(fields, zap.Int64("req.body_read_ms", body.Duration().Milliseconds()))
+		}
+		if rateLimited {
+			fields = append(fields, zap.Bool("req.rate_limited", true))
+		}
+		if statusCode >= 400 {
+			if reqBody != nil {
+				fields = append(fields, reqBody.fields("req", m.opts.loggableBody(r.Header.Get("Content-Type")))...)
+			}
+			if rw.Body != nil {
+				fields = append(fields, rw.Body.fields("resp", m.opts.loggableBody(rw.Header().Get("Content-Type")))...)
+			}
+		}
+
+		// The fields added while handling the request (i.e. by auth middleware) are read once the handler returns
+		fields = append(fields, observer.FieldsFromContext(ctx)...)
 
 		// Determine the log level based on the result
 		switch {
 		case statusCode >= 500:
-			logger.Error(message, fields...)
+			if m.throttler == nil {
+				logger.Error(message, fields...)
+			} else if ok, suppressed := m.throttler.allow(fmt.Sprintf("%s %s %d", method, route, statusCode)); ok {
+				if suppressed > 0 {
+					fields = append(fields, zap.Int64("suppressed", suppressed))
+				}
+				logger.Error(message, fields...)
+			}
 		case statusCode >= 400:
 			logger.Warn(message, fields...)
 		case statusCode >= 100:
@@ -218,5 +519,110 @@ func (m *Middleware) Wrap(next http.HandlerFunc) http.HandlerFunc {
 			label.String("route", route),
 			label.Int("status_code", statusCode),
 		)
+		if truncated {
+			span.SetAttributes(label.Bool("url.truncated", true))
+		}
+		if rateLimited {
+			span.SetAttributes(label.Bool("rate_limited", true))
+		}
+		if code, description, ok := m.opts.spanStatus(statusCode); ok {
+			span.SetStatus(code, description)
+		}
+	}
+}
+
+// reportHijacked reports the closing of a hijacked connection.
+// The request duration and status code are not meaningful for hijacked connections, so they are not reported.
+func (m *Middleware) reportHijacked(ctx context.Context, span trace.Span, logger *zap.Logger, method, url, route string, startTime time.Time) {
+	duration := m.opts.clock.Now().Sub(startTime).Milliseconds()
+
+	m.instruments.connGauge.Add(ctx, -1,
+		label.String("method", method),
+		label.String("route", route),
+	)
+
+	message := fmt.Sprintf("%s %s connection closed %dms", method, url, duration)
+	fields := []zap.Field{
+		zap.Bool("conn.hijacked", true),
+		zap.Int64("conn.duration", duration),
 	}
+
+	if m.opts.LogInDebugLevel {
+		logger.Debug(message, fields...)
+	} else {
+		logger.Info(message, fields...)
+	}
+
+	span.SetAttributes(
+		label.String("method", method),
+		label.String("url", url),
+		label.String("route", route),
+		label.Bool("hijacked", true),
+	)
+}
+
+const (
+	serverReadHeaderTimeout = 10 * time.Second
+	serverIdleTimeout       = 2 * time.Minute
+	serverShutdownTimeout   = 30 * time.Second
+)
+
+// NewServer creates an http server with the middleware applied to a handler and the metrics endpoint mounted at /metrics.
+// The server has timeouts for reading request headers and for idle connections.
+// Read and write timeouts are not set, since they would break long-lived requests (i.e. server-sent events and websockets).
+// The server errors are written to the observer logger.
+func NewServer(addr string, handler http.Handler, obsv observer.Observer, opts Options) *http.Server {
+	mid := NewMiddleware(obsv, opts)
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", obsv)
+	mux.Handle("/", mid.Wrap(handler.ServeHTTP))
+
+	return &http.Server{
+		Addr:              addr,
+		Handler:           mux,
+		ReadHeaderTimeout: serverReadHeaderTimeout,
+		IdleTimeout:       serverIdleTimeout,
+		ErrorLog:          zap.NewStdLog(obsv.Logger()),
+	}
+}
+
+// ListenAndServeWithShutdown starts an http server and shuts it down gracefully when the context is done.
+// Once the server is closed, the observer is shut down, so the telemetry of the last requests is flushed.
+// The in-flight requests and the observer each have up to 30 seconds to finish.
+//
+//	server := ohttp.NewServer(":8080", handler, obsv, ohttp.Options{})
+//	err := ohttp.ListenAndServeWithShutdown(ctx, server, obsv)
+func ListenAndServeWithShutdown(ctx context.Context, server *http.Server, obsv observer.Observer) error {
+	var result error
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- server.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errCh:
+		result = multierror.Append(result, err)
+	case <-ctx.Done():
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), serverShutdownTimeout)
+		defer cancel()
+
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			result = multierror.Append(result, err)
+		}
+
+		if err := <-errCh; err != nil && err != http.ErrServerClosed {
+			result = multierror.Append(result, err)
+		}
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), serverShutdownTimeout)
+	defer cancel()
+
+	if err := obsv.Shutdown(shutdownCtx); err != nil {
+		result = multierror.Append(result, err)
+	}
+
+	return result
 }
@@ -1,14 +1,77 @@
 package ohttp
 
 import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/moorara/observer"
+	"github.com/moorara/observer/internal/clock"
+	dto "github.com/prometheus/client_model/go"
 	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/baggage"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/label"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/oteltest"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	zapobserver "go.uber.org/zap/zaptest/observer"
 )
 
+func TestNewMiddleware(t *testing.T) {
+	tests := []struct {
+		name  string
+		opts  Options
+		meter metric.Meter
+	}{
+		{
+			name:  "OK",
+			opts:  Options{},
+			meter: new(metric.NoopMeterProvider).Meter(""),
+		},
+		{
+			name: "FailingMeter",
+			opts: Options{},
+			meter: metric.WrapMeterImpl(&mockMeterImpl{
+				NewSyncInstrumentOutError: errors.New("error on creating instrument"),
+			}, ""),
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			obsv := newMockObserver()
+			obsv.meter = tc.meter
+
+			mid := NewMiddleware(obsv, tc.opts)
+
+			assert.NotNil(t, mid)
+			assert.NotNil(t, mid.instruments)
+
+			// Another one from the same observer should share the same instruments
+			another := NewMiddleware(obsv, tc.opts)
+			assert.Same(t, mid.instruments, another.instruments)
+		})
+	}
+}
+
 func TestMiddleware(t *testing.T) {
 	tests := []struct {
 		name                string
@@ -131,7 +194,7 @@ func TestMiddleware(t *testing.T) {
 
 	for _, tc := range tests {
 		t.Run(tc.name, func(t *testing.T) {
-			obsv := newMockObserver()
+			obsv := observer.New(false, observer.WithMetadata("test", "", "", "", nil), observer.WithPrometheus())
 			mid := NewMiddleware(obsv, tc.opts)
 			assert.NotNil(t, mid)
 
@@ -153,9 +216,2060 @@ func TestMiddleware(t *testing.T) {
 			resp := rec.Result()
 			assert.Equal(t, tc.expectedStatusCode, resp.StatusCode)
 
+			// Verify metrics
+			if tc.expectedMethod != "" {
+				families, err := obsv.Gather()
+				assert.NoError(t, err)
+				assert.Equal(t, float64(1), counterValue(families, "incoming_http_requests_total", map[string]string{
+					"method":       tc.expectedMethod,
+					"route":        tc.expectedRoute,
+					"status_code":  strconv.Itoa(tc.expectedStatusCode),
+					"status_class": tc.expectedStatusClass,
+				}))
+			}
+
 			// TODO: Verify logs
-			// TODO: Verify metrics
 			// TODO: Verify traces
 		})
 	}
 }
+
+func TestMiddlewareRecordCodeLocation(t *testing.T) {
+	tests := []struct {
+		name             string
+		opts             Options
+		expectedLocation bool
+	}{
+		{
+			name:             "Disabled",
+			opts:             Options{},
+			expectedLocation: false,
+		},
+		{
+			name: "Enabled",
+			opts: Options{
+				RecordCodeLocation: true,
+			},
+			expectedLocation: true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			sr := new(oteltest.StandardSpanRecorder)
+			obsv := newMockObserver()
+			obsv.tracer = oteltest.NewTracerProvider(oteltest.WithSpanRecorder(sr)).Tracer("")
+			mid := NewMiddleware(obsv, tc.opts)
+
+			handler := mid.Wrap(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			})
+
+			handler(httptest.NewRecorder(), httptest.NewRequest("GET", "/v1/items", nil))
+
+			spans := sr.Completed()
+			assert.Len(t, spans, 1)
+
+			attrs := spans[0].Attributes()
+			if tc.expectedLocation {
+				assert.Contains(t, attrs[label.Key("code.function")].AsString(), "TestMiddlewareRecordCodeLocation")
+				assert.Contains(t, attrs[label.Key("code.filepath")].AsString(), "server_test.go")
+				assert.NotZero(t, attrs[label.Key("code.lineno")].AsInt64())
+			} else {
+				assert.NotContains(t, attrs, label.Key("code.function"))
+			}
+		})
+	}
+}
+
+func TestMiddlewareTenantLabel(t *testing.T) {
+	tests := []struct {
+		name           string
+		opts           Options
+		ctx            context.Context
+		expectedTenant string
+	}{
+		{
+			name: "NotAllowed",
+			opts: Options{
+				TenantBaggageKey:     "tenant",
+				TenantLabelAllowlist: []string{"acme"},
+			},
+			ctx:            baggage.ContextWithValues(context.Background(), label.String("tenant", "umbrella")),
+			expectedTenant: "other",
+		},
+		{
+			name: "Allowed",
+			opts: Options{
+				TenantBaggageKey:     "tenant",
+				TenantLabelAllowlist: []string{"acme"},
+			},
+			ctx:            baggage.ContextWithValues(context.Background(), label.String("tenant", "acme")),
+			expectedTenant: "acme",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			impl, meter := oteltest.NewMeter()
+			obsv := newMockObserver()
+			obsv.meter = meter
+			mid := NewMiddleware(obsv, tc.opts)
+
+			handler := mid.Wrap(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			})
+
+			req := httptest.NewRequest("GET", "/v1/items", nil).WithContext(tc.ctx)
+			handler(httptest.NewRecorder(), req)
+
+			var found bool
+			for _, m := range oteltest.AsStructs(impl.MeasurementBatches) {
+				if m.Name == "incoming_http_requests_total" {
+					found = true
+					assert.Equal(t, tc.expectedTenant, m.Labels["tenant"].AsString())
+				}
+			}
+			assert.True(t, found)
+		})
+	}
+}
+
+func TestMiddlewareMetricLabelsFromContext(t *testing.T) {
+	impl, meter := oteltest.NewMeter()
+	obsv := newMockObserver()
+	obsv.meter = meter
+	mid := NewMiddleware(obsv, Options{
+		MetricLabelsFromContext: func(ctx context.Context) []label.KeyValue {
+			return []label.KeyValue{
+				label.String("plan", baggage.Value(ctx, label.Key("plan")).Emit()),
+			}
+		},
+	})
+
+	handler := mid.Wrap(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	ctx := baggage.ContextWithValues(context.Background(), label.String("plan", "premium"))
+	req := httptest.NewRequest("GET", "/v1/items", nil).WithContext(ctx)
+	handler(httptest.NewRecorder(), req)
+
+	var found int
+	for _, m := range oteltest.AsStructs(impl.MeasurementBatches) {
+		if m.Name == "incoming_http_requests_total" || m.Name == "incoming_http_requests_duration" {
+			found++
+			assert.Equal(t, "premium", m.Labels["plan"].AsString())
+			assert.Equal(t, "/v1/items", m.Labels["route"].AsString())
+		}
+	}
+	assert.Equal(t, 2, found)
+}
+
+// mockRouteContext mimics routers that populate a route context while routing.
+type mockRouteContext struct {
+	pattern string
+}
+
+type mockRouteContextKey struct{}
+
+func TestMiddlewareRouteFn(t *testing.T) {
+	tests := []struct {
+		name          string
+		routeFn       func(*http.Request) string
+		path          string
+		expectedRoute string
+	}{
+		{
+			name:          "NoRouteFn",
+			routeFn:       nil,
+			path:          "/v1/users/aaaaaaaa-bbbb-cccc-dddd-eeeeeeeeeeee",
+			expectedRoute: "/v1/users/:id",
+		},
+		{
+			name: "EmptyRoute",
+			routeFn: func(r *http.Request) string {
+				return ""
+			},
+			path:          "/v1/users/aaaaaaaa-bbbb-cccc-dddd-eeeeeeeeeeee",
+			expectedRoute: "/v1/users/:id",
+		},
+		{
+			name: "MatchedRoute",
+			routeFn: func(r *http.Request) string {
+				return r.Context().Value(mockRouteContextKey{}).(*mockRouteContext).pattern
+			},
+			path:          "/v1/users/aaaaaaaa-bbbb-cccc-dddd-eeeeeeeeeeee",
+			expectedRoute: "/v1/users/{id}",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			impl, meter := oteltest.NewMeter()
+			obsv := newMockObserver()
+			obsv.meter = meter
+			mid := NewMiddleware(obsv, Options{
+				RouteFn: tc.routeFn,
+			})
+
+			// The mock router sets the matched route pattern only when the request is routed
+			router := func(w http.ResponseWriter, r *http.Request) {
+				r.Context().Value(mockRouteContextKey{}).(*mockRouteContext).pattern = "/v1/users/{id}"
+				w.WriteHeader(http.StatusOK)
+			}
+
+			ctx := context.WithValue(context.Background(), mockRouteContextKey{}, &mockRouteContext{})
+			req := httptest.NewRequest("GET", tc.path, nil).WithContext(ctx)
+			mid.Wrap(router)(httptest.NewRecorder(), req)
+
+			var found bool
+			for _, m := range oteltest.AsStructs(impl.MeasurementBatches) {
+				if m.Name == "incoming_http_requests_total" {
+					found = true
+					assert.Equal(t, tc.expectedRoute, m.Labels["route"].AsString())
+				}
+			}
+			assert.True(t, found)
+		})
+	}
+}
+
+// counterValue returns the value of a counter with the given labels from a list of Prometheus metric families.
+func counterValue(families []*dto.MetricFamily, name string, labels map[string]string) float64 {
+	for _, f := range families {
+		if f.GetName() != name {
+			continue
+		}
+
+		for _, m := range f.GetMetric() {
+			matched := 0
+			for _, l := range m.GetLabel() {
+				if v, ok := labels[l.GetName()]; ok && v == l.GetValue() {
+					matched++
+				}
+			}
+			if matched == len(labels) {
+				return m.GetCounter().GetValue()
+			}
+		}
+	}
+
+	return 0
+}
+
+func TestMiddlewareLogSchema(t *testing.T) {
+	core, logs := zapobserver.New(zapcore.DebugLevel)
+	obsv := newMockObserver()
+	obsv.logger = zap.New(core)
+	mid := NewMiddleware(obsv, Options{
+		MaxURLLength:          8,
+		RouteFn:               func(*http.Request) string { return "/v1/items/{id}" },
+		SlowBodyReadThreshold: time.Nanosecond,
+		BaggageToLogs:         true,
+		RateLimit:             &RateLimit{Rate: 0.001, Burst: 1},
+	})
+
+	handler := mid.Wrap(func(w http.ResponseWriter, r *http.Request) {
+		ioutil.ReadAll(r.Body)
+		observer.LoggerFromContext(r.Context()).Info("handler")
+		w.WriteHeader(http.StatusOK)
+	})
+
+	ctx := baggage.ContextWithValues(context.Background(), label.String("tenant", "acme"))
+	ctx = observer.ContextWithStartTime(ctx, time.Now().Add(-time.Millisecond))
+
+	// The second request is rate limited
+	for i := 0; i < 2; i++ {
+		req := httptest.NewRequest("POST", "/v1/items/1234", strings.NewReader("{}")).WithContext(ctx)
+		req.Header.Set(clientNameHeader, "test-client")
+		handler(httptest.NewRecorder(), req)
+	}
+
+	assert.Equal(t, 3, logs.Len())
+	assertLogSchema(t, logs.All())
+}
+
+// assertLogSchema asserts that the fields of log entries are described by observer.LogSchema.
+func assertLogSchema(t *testing.T, entries []zapobserver.LoggedEntry) {
+	schema := observer.LogSchema()
+	for _, e := range entries {
+		for key := range e.ContextMap() {
+			if strings.HasPrefix(key, "baggage.") {
+				key = "baggage.*"
+			}
+			assert.Contains(t, schema, key, "field %q of %q is not in the log schema", key, e.Message)
+		}
+	}
+}
+
+func TestMiddlewareAddFields(t *testing.T) {
+	core, logs := zapobserver.New(zapcore.DebugLevel)
+	obsv := newMockObserver()
+	obsv.logger = zap.New(core)
+	mid := NewMiddleware(obsv, Options{})
+
+	// An auth middleware that runs inside our middleware
+	auth := func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			observer.AddFields(r.Context(), zap.String("principal", "admin"))
+			next(w, r)
+		}
+	}
+
+	handler := mid.Wrap(auth(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	handler(httptest.NewRecorder(), httptest.NewRequest("GET", "/v1/items", nil))
+
+	assert.Equal(t, 1, logs.Len())
+	assert.Equal(t, "admin", logs.All()[0].ContextMap()["principal"])
+}
+
+func TestMiddlewareRequestMetadata(t *testing.T) {
+	obsv := newMockObserver()
+	mid := NewMiddleware(obsv, Options{})
+
+	var md observer.RequestMetadata
+	var ok bool
+
+	handler := mid.Wrap(func(w http.ResponseWriter, r *http.Request) {
+		md, ok = observer.RequestMetadataFromContext(r.Context())
+		w.WriteHeader(http.StatusOK)
+	})
+
+	req := httptest.NewRequest("GET", "/v1/items", nil)
+	req.Header.Set(requestUUIDHeader, "aaaaaaaa-aaaa-aaaa-aaaa-aaaaaaaaaaaa")
+	req.Header.Set(clientNameHeader, "test-client")
+	handler(httptest.NewRecorder(), req)
+
+	assert.True(t, ok)
+	assert.Equal(t, "aaaaaaaa-aaaa-aaaa-aaaa-aaaaaaaaaaaa", md.UUID)
+	assert.Equal(t, "test-client", md.ClientName)
+	assert.False(t, md.StartTime.IsZero())
+}
+
+func TestMiddlewarePrincipal(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audit")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "audit.log")
+
+	obsv := observer.New(false, observer.WithLogger("none"), observer.WithAuditOutput(path))
+	mid := NewMiddleware(obsv, Options{})
+
+	handler := mid.Wrap(func(w http.ResponseWriter, r *http.Request) {
+		observer.Audit(r.Context(), "item deleted")
+		w.WriteHeader(http.StatusOK)
+	})
+
+	// An auth middleware running before the observability middleware sets the principal
+	req := httptest.NewRequest("GET", "/v1/items", nil)
+	req.Header.Set(requestUUIDHeader, "aaaaaaaa-aaaa-aaaa-aaaa-aaaaaaaaaaaa")
+	req = req.WithContext(observer.ContextWithPrincipal(req.Context(), "jane@example.com"))
+	handler(httptest.NewRecorder(), req)
+	obsv.Shutdown(context.Background())
+
+	data, err := ioutil.ReadFile(path)
+	assert.NoError(t, err)
+
+	entry := map[string]interface{}{}
+	assert.NoError(t, json.Unmarshal(data, &entry))
+	assert.Equal(t, "item deleted", entry["message"])
+	assert.Equal(t, "jane@example.com", entry["principal"])
+	assert.Equal(t, "aaaaaaaa-aaaa-aaaa-aaaa-aaaaaaaaaaaa", entry["req.uuid"])
+}
+
+func TestMiddlewareBaggageToLogs(t *testing.T) {
+	tests := []struct {
+		name           string
+		opts           Options
+		expectedFields map[string]interface{}
+	}{
+		{
+			name:           "Disabled",
+			opts:           Options{},
+			expectedFields: map[string]interface{}{},
+		},
+		{
+			name: "Enabled",
+			opts: Options{
+				BaggageToLogs: true,
+			},
+			expectedFields: map[string]interface{}{
+				"baggage.tenant":  "acme",
+				"baggage.feature": "beta",
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			core, logs := zapobserver.New(zapcore.DebugLevel)
+			obsv := newMockObserver()
+			obsv.logger = zap.New(core)
+			mid := NewMiddleware(obsv, tc.opts)
+
+			handler := mid.Wrap(func(w http.ResponseWriter, r *http.Request) {
+				observer.LoggerFromContext(r.Context()).Info("handler")
+				w.WriteHeader(http.StatusOK)
+			})
+
+			ctx := baggage.ContextWithValues(context.Background(),
+				label.String("tenant", "acme"),
+				label.String("feature", "beta"),
+			)
+
+			req := httptest.NewRequest("GET", "/v1/items", nil).WithContext(ctx)
+			handler(httptest.NewRecorder(), req)
+
+			entries := logs.FilterMessage("handler").All()
+			assert.Len(t, entries, 1)
+
+			fields := entries[0].ContextMap()
+			assert.NotContains(t, fields, "baggage.req.uuid")
+			for key, val := range tc.expectedFields {
+				assert.Equal(t, val, fields[key])
+			}
+			if len(tc.expectedFields) == 0 {
+				assert.NotContains(t, fields, "baggage.tenant")
+			}
+		})
+	}
+}
+
+func TestMiddlewareMaxURLLength(t *testing.T) {
+	tests := []struct {
+		name              string
+		opts              Options
+		path              string
+		expectedURL       string
+		expectedTruncated bool
+	}{
+		{
+			name:              "Default",
+			opts:              Options{},
+			path:              "/v1/items",
+			expectedURL:       "/v1/items",
+			expectedTruncated: false,
+		},
+		{
+			name:              "OverLength",
+			opts:              Options{MaxURLLength: 16},
+			path:              "/v1/items/" + strings.Repeat("a", 4096),
+			expectedURL:       "/v1/items/aaaaaa",
+			expectedTruncated: true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			core, logs := zapobserver.New(zapcore.DebugLevel)
+			sr := new(oteltest.StandardSpanRecorder)
+			obsv := newMockObserver()
+			obsv.logger = zap.New(core)
+			obsv.tracer = oteltest.NewTracerProvider(oteltest.WithSpanRecorder(sr)).Tracer("")
+			mid := NewMiddleware(obsv, tc.opts)
+
+			var path string
+			handler := mid.Wrap(func(w http.ResponseWriter, r *http.Request) {
+				path = r.URL.Path
+				w.WriteHeader(http.StatusOK)
+			})
+
+			req := httptest.NewRequest("GET", tc.path, nil)
+			handler(httptest.NewRecorder(), req)
+
+			// The actual request should not be affected
+			assert.Equal(t, tc.path, path)
+
+			entries := logs.All()
+			assert.Len(t, entries, 1)
+			fields := entries[0].ContextMap()
+			assert.Equal(t, tc.expectedURL, fields["req.url"])
+
+			spans := sr.Completed()
+			assert.Len(t, spans, 1)
+			attrs := spans[0].Attributes()
+			assert.Equal(t, tc.expectedURL, attrs[label.Key("url")].AsString())
+
+			if tc.expectedTruncated {
+				assert.Equal(t, true, fields["url.truncated"])
+				assert.True(t, attrs[label.Key("url.truncated")].AsBool())
+			} else {
+				assert.NotContains(t, fields, "url.truncated")
+				assert.NotContains(t, attrs, label.Key("url.truncated"))
+			}
+		})
+	}
+}
+
+func TestMiddlewarePanicLabels(t *testing.T) {
+	impl, meter := oteltest.NewMeter()
+	obsv := newMockObserver()
+	obsv.meter = meter
+	mid := NewMiddleware(obsv, Options{})
+
+	handler := mid.Wrap(func(w http.ResponseWriter, r *http.Request) {
+		panic("something went wrong!")
+	})
+
+	req := httptest.NewRequest("POST", "/v1/items/aaaaaaaa-bbbb-cccc-dddd-eeeeeeeeeeee", nil)
+	rec := httptest.NewRecorder()
+	handler(rec, req)
+
+	assert.Equal(t, http.StatusInternalServerError, rec.Code)
+
+	var found bool
+	for _, m := range oteltest.AsStructs(impl.MeasurementBatches) {
+		if m.Name == "handler_panics_total" {
+			found = true
+			assert.Equal(t, "POST", m.Labels["method"].AsString())
+			assert.Equal(t, "/v1/items/:id", m.Labels["route"].AsString())
+		}
+	}
+	assert.True(t, found)
+}
+
+func TestMiddlewarePanicType(t *testing.T) {
+	errNotFound := errors.New("item not found")
+
+	tests := []struct {
+		name          string
+		opts          Options
+		r             interface{}
+		expectedType  string
+		expectedError string
+		expectedBody  string
+	}{
+		{
+			name:          "StringPanic",
+			opts:          Options{},
+			r:             "something went wrong",
+			expectedType:  "string",
+			expectedError: "critical error: something went wrong",
+			expectedBody:  "",
+		},
+		{
+			name:          "ErrorPanic",
+			opts:          Options{},
+			r:             errNotFound,
+			expectedType:  "*errors.errorString",
+			expectedError: "critical error: item not found",
+			expectedBody:  "",
+		},
+		{
+			name: "PanicResponse",
+			opts: Options{
+				PanicResponse: func(err error) string {
+					if errors.Is(err, errNotFound) {
+						return "item not found"
+					}
+					return "internal error"
+				},
+			},
+			r:             errNotFound,
+			expectedType:  "*errors.errorString",
+			expectedError: "critical error: item not found",
+			expectedBody:  "item not found\n",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			core, logs := zapobserver.New(zapcore.DebugLevel)
+			obsv := newMockObserver()
+			obsv.logger = zap.New(core)
+			mid := NewMiddleware(obsv, tc.opts)
+
+			handler := mid.Wrap(func(w http.ResponseWriter, r *http.Request) {
+				panic(tc.r)
+			})
+
+			rec := httptest.NewRecorder()
+			handler(rec, httptest.NewRequest("GET", "/v1/items", nil))
+
+			assert.Equal(t, http.StatusInternalServerError, rec.Code)
+			assert.Equal(t, tc.expectedBody, rec.Body.String())
+
+			panicLogs := logs.FilterMessage("Panic occurred.").All()
+			assert.Len(t, panicLogs, 1)
+			assert.Equal(t, tc.expectedType, panicLogs[0].ContextMap()["panic.type"])
+			assert.Equal(t, tc.expectedError, panicLogs[0].ContextMap()["error"])
+		})
+	}
+}
+
+func TestMiddlewareAttributesFromRequest(t *testing.T) {
+	tests := []struct {
+		name              string
+		opts              Options
+		expectedAttribute string
+		expectedPanicLog  bool
+	}{
+		{
+			name:              "NoFunc",
+			opts:              Options{},
+			expectedAttribute: "",
+			expectedPanicLog:  false,
+		},
+		{
+			name: "ReadHeader",
+			opts: Options{
+				AttributesFromRequest: func(r *http.Request) []label.KeyValue {
+					return []label.KeyValue{label.String("user.id", r.Header.Get("User-ID"))}
+				},
+			},
+			expectedAttribute: "1234",
+			expectedPanicLog:  false,
+		},
+		{
+			name: "Panic",
+			opts: Options{
+				AttributesFromRequest: func(r *http.Request) []label.KeyValue {
+					panic("something went wrong")
+				},
+			},
+			expectedAttribute: "",
+			expectedPanicLog:  true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			core, logs := zapobserver.New(zapcore.DebugLevel)
+			sr := new(oteltest.StandardSpanRecorder)
+			obsv := newMockObserver()
+			obsv.logger = zap.New(core)
+			obsv.tracer = oteltest.NewTracerProvider(oteltest.WithSpanRecorder(sr)).Tracer("")
+			mid := NewMiddleware(obsv, tc.opts)
+
+			handler := mid.Wrap(func(w http.ResponseWriter, r *http.Request) {
+				observer.LoggerFromContext(r.Context()).Info("handler")
+				w.WriteHeader(http.StatusOK)
+			})
+
+			req := httptest.NewRequest("GET", "/v1/users/me", nil)
+			req.Header.Set("User-ID", "1234")
+			handler(httptest.NewRecorder(), req)
+
+			spans := sr.Completed()
+			assert.Len(t, spans, 1)
+			attrs := spans[0].Attributes()
+
+			entries := logs.FilterMessage("handler").All()
+			assert.Len(t, entries, 1)
+			fields := entries[0].ContextMap()
+
+			if tc.expectedAttribute != "" {
+				assert.Equal(t, tc.expectedAttribute, attrs[label.Key("user.id")].AsString())
+				assert.Equal(t, tc.expectedAttribute, fields["user.id"])
+			} else {
+				assert.NotContains(t, attrs, label.Key("user.id"))
+				assert.NotContains(t, fields, "user.id")
+			}
+
+			panicLogs := logs.FilterMessage("Panic occurred in AttributesFromRequest.").All()
+			assert.Equal(t, tc.expectedPanicLog, len(panicLogs) == 1)
+		})
+	}
+}
+
+func TestMiddlewareExposeTraceHeader(t *testing.T) {
+	tests := []struct {
+		name           string
+		opts           Options
+		handler        http.HandlerFunc
+		expectedHeader bool
+	}{
+		{
+			name: "Disabled",
+			opts: Options{},
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusInternalServerError)
+			},
+			expectedHeader: false,
+		},
+		{
+			name: "Success",
+			opts: Options{
+				ExposeTraceHeader: true,
+			},
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			},
+			expectedHeader: false,
+		},
+		{
+			name: "ServerError",
+			opts: Options{
+				ExposeTraceHeader: true,
+			},
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusInternalServerError)
+			},
+			expectedHeader: true,
+		},
+		{
+			name: "Panic",
+			opts: Options{
+				ExposeTraceHeader: true,
+			},
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				panic("something went wrong!")
+			},
+			expectedHeader: true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			sr := new(oteltest.StandardSpanRecorder)
+			obsv := newMockObserver()
+			obsv.tracer = oteltest.NewTracerProvider(oteltest.WithSpanRecorder(sr)).Tracer("")
+			mid := NewMiddleware(obsv, tc.opts)
+
+			req := httptest.NewRequest("GET", "/v1/items", nil)
+			rec := httptest.NewRecorder()
+			mid.Wrap(tc.handler)(rec, req)
+
+			spans := sr.Completed()
+			assert.Len(t, spans, 1)
+			traceID := spans[0].SpanContext().TraceID.String()
+
+			if tc.expectedHeader {
+				assert.Equal(t, traceID, rec.Header().Get("Trace-ID"))
+				assert.Contains(t, rec.Header().Get("traceparent"), traceID)
+			} else {
+				assert.Empty(t, rec.Header().Get("Trace-ID"))
+				assert.Empty(t, rec.Header().Get("traceparent"))
+			}
+		})
+	}
+}
+
+func TestMiddlewareStatusLabelMode(t *testing.T) {
+	tests := []struct {
+		name           string
+		opts           Options
+		expectedLabels []string
+	}{
+		{
+			name:           "Both",
+			opts:           Options{},
+			expectedLabels: []string{"method", "route", "status_class", "status_code"},
+		},
+		{
+			name:           "Code",
+			opts:           Options{StatusLabelMode: "code"},
+			expectedLabels: []string{"method", "route", "status_code"},
+		},
+		{
+			name:           "Class",
+			opts:           Options{StatusLabelMode: "class"},
+			expectedLabels: []string{"method", "route", "status_class"},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			impl, meter := oteltest.NewMeter()
+			obsv := newMockObserver()
+			obsv.meter = meter
+			mid := NewMiddleware(obsv, tc.opts)
+
+			handler := mid.Wrap(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusNotFound)
+			})
+
+			req := httptest.NewRequest("GET", "/v1/items", nil)
+			handler(httptest.NewRecorder(), req)
+
+			var found bool
+			for _, m := range oteltest.AsStructs(impl.MeasurementBatches) {
+				if m.Name == "incoming_http_requests_total" {
+					found = true
+
+					keys := []string{}
+					for k := range m.Labels {
+						keys = append(keys, string(k))
+					}
+					sort.Strings(keys)
+
+					assert.Equal(t, tc.expectedLabels, keys)
+				}
+			}
+			assert.True(t, found)
+		})
+	}
+}
+
+func TestMiddlewareRequestUUIDValidation(t *testing.T) {
+	tests := []struct {
+		name             string
+		requestUUID      string
+		expectedReplaced bool
+	}{
+		{
+			name:             "Valid",
+			requestUUID:      "aaaaaaaa-aaaa-aaaa-aaaa-aaaaaaaaaaaa",
+			expectedReplaced: false,
+		},
+		{
+			name:             "Newline",
+			requestUUID:      "aaaaaaaa-aaaa-aaaa-aaaa-aaaaaaaaaaaa\nlevel=error",
+			expectedReplaced: true,
+		},
+		{
+			name:             "TooLong",
+			requestUUID:      strings.Repeat("a", 1024),
+			expectedReplaced: true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			core, logs := zapobserver.New(zapcore.DebugLevel)
+			obsv := newMockObserver()
+			obsv.logger = zap.New(core)
+			mid := NewMiddleware(obsv, Options{})
+
+			var md observer.RequestMetadata
+			handler := mid.Wrap(func(w http.ResponseWriter, r *http.Request) {
+				md, _ = observer.RequestMetadataFromContext(r.Context())
+				w.WriteHeader(http.StatusOK)
+			})
+
+			req := httptest.NewRequest("GET", "/v1/items", nil)
+			req.Header.Set(requestUUIDHeader, tc.requestUUID)
+			rec := httptest.NewRecorder()
+			handler(rec, req)
+
+			requestUUID := rec.Header().Get(requestUUIDHeader)
+			if tc.expectedReplaced {
+				assert.NotEqual(t, tc.requestUUID, requestUUID)
+				assert.True(t, mid.opts.validRequestUUID(requestUUID))
+			} else {
+				assert.Equal(t, tc.requestUUID, requestUUID)
+			}
+
+			assert.Equal(t, requestUUID, md.UUID)
+			assert.Equal(t, requestUUID, req.Header.Get(requestUUIDHeader))
+
+			entries := logs.All()
+			assert.Len(t, entries, 1)
+			assert.Equal(t, requestUUID, entries[0].ContextMap()["req.uuid"])
+		})
+	}
+}
+
+func TestMiddlewareIgnoreIncomingRequestID(t *testing.T) {
+	tests := []struct {
+		name               string
+		requestUUID        string
+		expectedReplaced   bool
+		expectedClientUUID string
+	}{
+		{
+			name:               "Valid",
+			requestUUID:        "aaaaaaaa-aaaa-aaaa-aaaa-aaaaaaaaaaaa",
+			expectedReplaced:   true,
+			expectedClientUUID: "aaaaaaaa-aaaa-aaaa-aaaa-aaaaaaaaaaaa",
+		},
+		{
+			name:               "Newline",
+			requestUUID:        "aaaaaaaa-aaaa-aaaa-aaaa-aaaaaaaaaaaa\nlevel=error",
+			expectedReplaced:   true,
+			expectedClientUUID: "",
+		},
+		{
+			name:               "Missing",
+			requestUUID:        "",
+			expectedReplaced:   true,
+			expectedClientUUID: "",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			core, logs := zapobserver.New(zapcore.DebugLevel)
+			obsv := newMockObserver()
+			obsv.logger = zap.New(core)
+			mid := NewMiddleware(obsv, Options{IgnoreIncomingRequestID: true})
+
+			var md observer.RequestMetadata
+			handler := mid.Wrap(func(w http.ResponseWriter, r *http.Request) {
+				md, _ = observer.RequestMetadataFromContext(r.Context())
+				w.WriteHeader(http.StatusOK)
+			})
+
+			req := httptest.NewRequest("GET", "/v1/items", nil)
+			req.Header.Set(requestUUIDHeader, tc.requestUUID)
+			rec := httptest.NewRecorder()
+			handler(rec, req)
+
+			requestUUID := rec.Header().Get(requestUUIDHeader)
+			if tc.expectedReplaced {
+				assert.NotEqual(t, tc.requestUUID, requestUUID)
+				assert.True(t, mid.opts.validRequestUUID(requestUUID))
+			} else {
+				assert.Equal(t, tc.requestUUID, requestUUID)
+			}
+
+			assert.Equal(t, requestUUID, md.UUID)
+			assert.Equal(t, requestUUID, req.Header.Get(requestUUIDHeader))
+
+			entries := logs.All()
+			assert.Len(t, entries, 1)
+			assert.Equal(t, requestUUID, entries[0].ContextMap()["req.uuid"])
+			if tc.expectedClientUUID == "" {
+				assert.NotContains(t, entries[0].ContextMap(), "client.request_uuid")
+			} else {
+				assert.Equal(t, tc.expectedClientUUID, entries[0].ContextMap()["client.request_uuid"])
+			}
+		})
+	}
+}
+
+func TestMiddlewareSuppressResponseMetadata(t *testing.T) {
+	tests := []struct {
+		name               string
+		opts               Options
+		expectedUUID       bool
+		expectedClientName bool
+	}{
+		{
+			name:               "Default",
+			opts:               Options{},
+			expectedUUID:       true,
+			expectedClientName: true,
+		},
+		{
+			name:               "SuppressAll",
+			opts:               Options{SuppressResponseMetadata: true},
+			expectedUUID:       false,
+			expectedClientName: false,
+		},
+		{
+			name:               "SuppressClientName",
+			opts:               Options{SuppressedResponseMetadata: []string{clientNameHeader}},
+			expectedUUID:       true,
+			expectedClientName: false,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			core, logs := zapobserver.New(zapcore.DebugLevel)
+			obsv := newMockObserver()
+			obsv.logger = zap.New(core)
+			mid := NewMiddleware(obsv, tc.opts)
+
+			handler := mid.Wrap(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			})
+
+			req := httptest.NewRequest("GET", "/v1/books", nil)
+			req.Header.Set(clientNameHeader, "internal-client")
+			rec := httptest.NewRecorder()
+			handler(rec, req)
+
+			assert.Equal(t, tc.expectedUUID, rec.Header().Get(requestUUIDHeader) != "")
+			assert.Equal(t, tc.expectedClientName, rec.Header().Get(clientNameHeader) != "")
+
+			// The client name is still used for logging
+			entries := logs.All()
+			assert.Len(t, entries, 1)
+			assert.Equal(t, "internal-client", entries[0].ContextMap()["client.name"])
+		})
+	}
+}
+
+func TestMiddlewareServerTiming(t *testing.T) {
+	tests := []struct {
+		name           string
+		opts           Options
+		handler        http.HandlerFunc
+		expectedHeader bool
+	}{
+		{
+			name: "Disabled",
+			opts: Options{},
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			},
+			expectedHeader: false,
+		},
+		{
+			name: "WriteHeader",
+			opts: Options{
+				ServerTiming: true,
+			},
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusCreated)
+			},
+			expectedHeader: true,
+		},
+		{
+			name: "Write",
+			opts: Options{
+				ServerTiming: true,
+			},
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				_, _ = w.Write([]byte("hello"))
+			},
+			expectedHeader: true,
+		},
+		{
+			name: "NoWrite",
+			opts: Options{
+				ServerTiming: true,
+			},
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				time.Sleep(10 * time.Millisecond)
+			},
+			expectedHeader: true,
+		},
+		{
+			name: "WithExposeTraceHeader",
+			opts: Options{
+				ServerTiming:      true,
+				ExposeTraceHeader: true,
+			},
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusInternalServerError)
+			},
+			expectedHeader: true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			sr := new(oteltest.StandardSpanRecorder)
+			obsv := newMockObserver()
+			obsv.tracer = oteltest.NewTracerProvider(oteltest.WithSpanRecorder(sr)).Tracer("")
+			mid := NewMiddleware(obsv, tc.opts)
+
+			req := httptest.NewRequest("GET", "/v1/items", nil)
+			rec := httptest.NewRecorder()
+			mid.Wrap(tc.handler)(rec, req)
+
+			spans := sr.Completed()
+			assert.Len(t, spans, 1)
+			traceID := spans[0].SpanContext().TraceID.String()
+
+			// The headers of the result are the headers at the time the status code was written
+			header := rec.Result().Header.Get("Server-Timing")
+			if tc.expectedHeader {
+				assert.Regexp(t, `^app;dur=[0-9]+, trace;desc="`+traceID+`"$`, header)
+			} else {
+				assert.Empty(t, header)
+			}
+
+			if tc.opts.ExposeTraceHeader {
+				assert.Equal(t, traceID, rec.Result().Header.Get("Trace-ID"))
+			}
+		})
+	}
+}
+
+func TestMiddlewareSlowBodyReadThreshold(t *testing.T) {
+	tests := []struct {
+		name          string
+		opts          Options
+		delay         time.Duration
+		expectedField bool
+	}{
+		{
+			name:          "Disabled",
+			opts:          Options{},
+			delay:         20 * time.Millisecond,
+			expectedField: false,
+		},
+		{
+			name: "FastClient",
+			opts: Options{
+				SlowBodyReadThreshold: time.Second,
+			},
+			delay:         0,
+			expectedField: false,
+		},
+		{
+			name: "SlowClient",
+			opts: Options{
+				SlowBodyReadThreshold: 10 * time.Millisecond,
+			},
+			delay:         20 * time.Millisecond,
+			expectedField: true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			core, logs := zapobserver.New(zapcore.DebugLevel)
+			obsv := newMockObserver()
+			obsv.logger = zap.New(core)
+			mid := NewMiddleware(obsv, tc.opts)
+
+			handler := mid.Wrap(func(w http.ResponseWriter, r *http.Request) {
+				_, _ = ioutil.ReadAll(r.Body)
+				w.WriteHeader(http.StatusOK)
+			})
+
+			body := &slowReader{r: strings.NewReader(`{"name":"item"}`), delay: tc.delay}
+			req := httptest.NewRequest("POST", "/v1/items", body)
+			handler(httptest.NewRecorder(), req)
+
+			entries := logs.All()
+			assert.Len(t, entries, 1)
+
+			fields := entries[0].ContextMap()
+			if tc.expectedField {
+				assert.GreaterOrEqual(t, fields["req.body_read_ms"], int64(20))
+			} else {
+				assert.NotContains(t, fields, "req.body_read_ms")
+			}
+		})
+	}
+}
+
+func TestMiddlewareSpanStatus(t *testing.T) {
+	tests := []struct {
+		name                string
+		opts                Options
+		statusCode          int
+		expectedCode        codes.Code
+		expectedDescription string
+	}{
+		{
+			name:         "Success",
+			opts:         Options{},
+			statusCode:   http.StatusOK,
+			expectedCode: codes.Unset,
+		},
+		{
+			name:         "ClientError",
+			opts:         Options{},
+			statusCode:   http.StatusBadRequest,
+			expectedCode: codes.Unset,
+		},
+		{
+			name: "ClientErrorSpanStatus",
+			opts: Options{
+				ClientErrorSpanStatus: true,
+			},
+			statusCode:          http.StatusBadRequest,
+			expectedCode:        codes.Error,
+			expectedDescription: "Bad Request",
+		},
+		{
+			name:                "ServerError",
+			opts:                Options{},
+			statusCode:          http.StatusInternalServerError,
+			expectedCode:        codes.Error,
+			expectedDescription: "Internal Server Error",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			sr := new(oteltest.StandardSpanRecorder)
+			obsv := newMockObserver()
+			obsv.tracer = oteltest.NewTracerProvider(oteltest.WithSpanRecorder(sr)).Tracer("")
+			mid := NewMiddleware(obsv, tc.opts)
+
+			handler := mid.Wrap(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tc.statusCode)
+			})
+
+			req := httptest.NewRequest("GET", "/v1/items", nil)
+			handler(httptest.NewRecorder(), req)
+
+			spans := sr.Completed()
+			assert.Len(t, spans, 1)
+			assert.Equal(t, tc.expectedCode, spans[0].StatusCode())
+			assert.Equal(t, tc.expectedDescription, spans[0].StatusMessage())
+		})
+	}
+}
+
+func TestMiddlewareObserverInContext(t *testing.T) {
+	obsv := newMockObserver()
+	mid := NewMiddleware(obsv, Options{})
+
+	var o observer.Observer
+	handler := mid.Wrap(func(w http.ResponseWriter, r *http.Request) {
+		o = observer.ObserverFromContext(r.Context())
+		w.WriteHeader(http.StatusOK)
+	})
+
+	req := httptest.NewRequest("GET", "/v1/items", nil)
+	handler(httptest.NewRecorder(), req)
+
+	assert.Same(t, obsv, o)
+}
+
+func TestMiddlewareServerSentEvents(t *testing.T) {
+	mid := NewMiddleware(newMockObserver(), Options{})
+
+	// The handler does not return until the client has received the first event
+	received := make(chan struct{})
+	handler := mid.Wrap(func(w http.ResponseWriter, r *http.Request) {
+		flusher, ok := w.(http.Flusher)
+		assert.True(t, ok)
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, "data: first\n\n")
+		flusher.Flush()
+
+		select {
+		case <-received:
+		case <-time.After(time.Second):
+			t.Error("the first event is not received by the client")
+		}
+
+		fmt.Fprint(w, "data: second\n\n")
+	})
+
+	ts := httptest.NewServer(handler)
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL)
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+
+	reader := bufio.NewReader(resp.Body)
+	line, err := reader.ReadString('\n')
+	assert.NoError(t, err)
+	assert.Equal(t, "data: first\n", line)
+	close(received)
+
+	rest, err := ioutil.ReadAll(reader)
+	assert.NoError(t, err)
+	assert.Equal(t, "\ndata: second\n\n", string(rest))
+}
+
+func TestMiddlewareHijackedConnection(t *testing.T) {
+	core, logs := zapobserver.New(zapcore.DebugLevel)
+	impl, meter := oteltest.NewMeter()
+	obsv := newMockObserver()
+	obsv.logger = zap.New(core)
+	obsv.meter = meter
+	mid := NewMiddleware(obsv, Options{})
+
+	handler := mid.Wrap(func(w http.ResponseWriter, r *http.Request) {
+		conn, buf, err := w.(http.Hijacker).Hijack()
+		assert.NoError(t, err)
+		defer conn.Close()
+
+		_, _ = buf.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n\r\n")
+		_ = buf.Flush()
+
+		// Echo one message back to the client before closing the connection
+		msg, err := buf.ReadString('\n')
+		assert.NoError(t, err)
+		_, _ = buf.WriteString(msg)
+		_ = buf.Flush()
+	})
+
+	done := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer close(done)
+		handler(w, r)
+	}))
+	defer ts.Close()
+
+	conn, err := net.Dial("tcp", ts.Listener.Addr().String())
+	assert.NoError(t, err)
+	defer conn.Close()
+
+	fmt.Fprint(conn, "GET /v1/stream HTTP/1.1\r\nHost: localhost\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n\r\n")
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusSwitchingProtocols, resp.StatusCode)
+
+	fmt.Fprint(conn, "hello\n")
+	b, err := ioutil.ReadAll(conn)
+	assert.NoError(t, err)
+	assert.Contains(t, string(b), "hello\n")
+
+	// Wait for the handler to return
+	<-done
+
+	entries := logs.AllUntimed()
+	assert.Len(t, entries, 2)
+	assert.Equal(t, "GET /v1/stream connection opened", entries[0].Message)
+	assert.Contains(t, entries[1].Message, "GET /v1/stream connection closed")
+	assert.Contains(t, entries[1].ContextMap(), "conn.duration")
+
+	var gauge []int64
+	for _, m := range oteltest.AsStructs(impl.MeasurementBatches) {
+		switch m.Name {
+		case "websocket_connections_active":
+			gauge = append(gauge, m.Number.AsInt64())
+		case "incoming_http_requests_total", "incoming_http_requests_duration":
+			t.Errorf("unexpected measurement for hijacked connection: %s", m.Name)
+		}
+	}
+	assert.Equal(t, []int64{1, -1}, gauge)
+}
+
+func TestMiddlewareDebugForSampledTraces(t *testing.T) {
+	tests := []struct {
+		name            string
+		sampler         sdktrace.Sampler
+		expectedEntries []string
+	}{
+		{
+			name:            "Sampled",
+			sampler:         sdktrace.AlwaysSample(),
+			expectedEntries: []string{"handling request", "GET /v1/books"},
+		},
+		{
+			name:            "Unsampled",
+			sampler:         sdktrace.NeverSample(),
+			expectedEntries: []string{"GET /v1/books"},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var entries []string
+			obsv := observer.New(false,
+				observer.WithLogger("info"),
+				observer.WithLoggerDebugForSampledTraces(),
+				observer.WithLoggerHooks(func(e zapcore.Entry) error {
+					entries = append(entries, e.Message)
+					return nil
+				}),
+				observer.WithTracerProvider(sdktrace.NewTracerProvider(
+					sdktrace.WithConfig(sdktrace.Config{DefaultSampler: tc.sampler}),
+				)),
+			)
+
+			mid := NewMiddleware(obsv, Options{})
+			handler := mid.Wrap(func(w http.ResponseWriter, r *http.Request) {
+				observer.LoggerFromContext(r.Context()).Debug("handling request")
+				w.WriteHeader(http.StatusOK)
+			})
+
+			r := httptest.NewRequest("GET", "/v1/books", nil)
+			w := httptest.NewRecorder()
+			handler(w, r)
+
+			assert.Len(t, entries, len(tc.expectedEntries))
+			for i, message := range tc.expectedEntries {
+				assert.Contains(t, entries[i], message)
+			}
+		})
+	}
+}
+
+func TestMiddlewareActiveRequestsPeak(t *testing.T) {
+	impl, meter := oteltest.NewMeter()
+	obsv := newMockObserver()
+	obsv.meter = meter
+	mid := NewMiddleware(obsv, Options{})
+
+	const burst = 5
+	var started, done sync.WaitGroup
+	release := make(chan struct{})
+
+	handler := mid.Wrap(func(w http.ResponseWriter, r *http.Request) {
+		started.Done()
+		<-release
+		w.WriteHeader(http.StatusOK)
+	})
+
+	peak := func() int64 {
+		impl.MeasurementBatches = nil
+		impl.RunAsyncInstruments()
+		for _, m := range oteltest.AsStructs(impl.MeasurementBatches) {
+			if m.Name == "incoming_http_requests_active_max" {
+				return m.Number.AsInt64()
+			}
+		}
+		t.Fatal("incoming_http_requests_active_max is not observed")
+		return 0
+	}
+
+	started.Add(burst)
+	done.Add(burst)
+	for i := 0; i < burst; i++ {
+		go func() {
+			defer done.Done()
+			handler(httptest.NewRecorder(), httptest.NewRequest("GET", "/v1/books", nil))
+		}()
+	}
+
+	started.Wait()
+	close(release)
+	done.Wait()
+
+	// The peak of the burst is reported once and then reset
+	assert.Equal(t, int64(burst), peak())
+	assert.Equal(t, int64(0), peak())
+}
+
+func TestMiddlewareRateLimit(t *testing.T) {
+	tests := []struct {
+		name                string
+		opts                Options
+		clientNames         []string
+		expectedStatusCodes []int
+	}{
+		{
+			name:                "Unlimited",
+			opts:                Options{},
+			clientNames:         []string{"client-a", "client-a", "client-a"},
+			expectedStatusCodes: []int{200, 200, 200},
+		},
+		{
+			name: "Throttled",
+			opts: Options{
+				RateLimit: &RateLimit{Rate: 0.001, Burst: 2},
+			},
+			clientNames:         []string{"client-a", "client-b", "client-a"},
+			expectedStatusCodes: []int{200, 200, 429},
+		},
+		{
+			name: "ThrottledPerClient",
+			opts: Options{
+				RateLimit: &RateLimit{Rate: 0.001, Burst: 1, PerClient: true},
+			},
+			clientNames:         []string{"client-a", "client-b", "client-a"},
+			expectedStatusCodes: []int{200, 200, 429},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			core, logs := zapobserver.New(zapcore.DebugLevel)
+			impl, meter := oteltest.NewMeter()
+			sr := new(oteltest.StandardSpanRecorder)
+			obsv := newMockObserver()
+			obsv.logger = zap.New(core)
+			obsv.meter = meter
+			obsv.tracer = oteltest.NewTracerProvider(oteltest.WithSpanRecorder(sr)).Tracer("")
+			mid := NewMiddleware(obsv, tc.opts)
+
+			var calls int
+			handler := mid.Wrap(func(w http.ResponseWriter, r *http.Request) {
+				calls++
+				w.WriteHeader(http.StatusOK)
+			})
+
+			var limited int
+			for _, clientName := range tc.clientNames {
+				req := httptest.NewRequest("GET", "/v1/items", nil)
+				req.Header.Set(clientNameHeader, clientName)
+				rec := httptest.NewRecorder()
+				handler(rec, req)
+
+				if rec.Code == http.StatusTooManyRequests {
+					limited++
+					assert.NotEmpty(t, rec.Header().Get("Retry-After"))
+				}
+			}
+
+			assert.Equal(t, len(tc.clientNames)-limited, calls)
+
+			// Rejected requests are still observed
+			entries := logs.All()
+			assert.Len(t, entries, len(tc.clientNames))
+			spans := sr.Completed()
+			assert.Len(t, spans, len(tc.clientNames))
+
+			for i, statusCode := range tc.expectedStatusCodes {
+				assert.Equal(t, int64(statusCode), entries[i].ContextMap()["resp.statusCode"])
+				if statusCode == http.StatusTooManyRequests {
+					assert.Equal(t, zapcore.WarnLevel, entries[i].Level)
+					assert.Equal(t, true, entries[i].ContextMap()["req.rate_limited"])
+					assert.Equal(t, label.BoolValue(true), spans[i].Attributes()["rate_limited"])
+				} else {
+					assert.NotContains(t, entries[i].ContextMap(), "req.rate_limited")
+					assert.NotContains(t, spans[i].Attributes(), label.Key("rate_limited"))
+				}
+			}
+
+			var rateLimited int64
+			for _, m := range oteltest.AsStructs(impl.MeasurementBatches) {
+				if m.Name == "http_rate_limited_total" {
+					assert.Equal(t, "/v1/items", m.Labels["route"].AsString())
+					rateLimited += m.Number.AsInt64()
+				}
+			}
+			assert.Equal(t, int64(limited), rateLimited)
+		})
+	}
+}
+
+func TestMiddlewareRateLimitRoute(t *testing.T) {
+	tests := []struct {
+		name                string
+		opts                Options
+		paths               []string
+		expectedStatusCodes []int
+		expectedRoute       string
+	}{
+		{
+			name: "PerPath",
+			opts: Options{
+				RateLimit: &RateLimit{Rate: 0.001, Burst: 1},
+			},
+			paths:               []string{"/users/1", "/users/2"},
+			expectedStatusCodes: []int{200, 200},
+		},
+		{
+			name: "NormalizedByIDRegexp",
+			opts: Options{
+				IDRegexp:  regexp.MustCompile("[0-9]+"),
+				RateLimit: &RateLimit{Rate: 0.001, Burst: 1},
+			},
+			paths:               []string{"/users/1", "/users/2"},
+			expectedStatusCodes: []int{200, 429},
+			expectedRoute:       "/users/:id",
+		},
+		{
+			name: "WithRouteFn",
+			opts: Options{
+				RateLimit: &RateLimit{
+					Rate:  0.001,
+					Burst: 1,
+					RouteFn: func(r *http.Request) string {
+						if strings.HasPrefix(r.URL.Path, "/users/") {
+							return "/users/{id}"
+						}
+						return ""
+					},
+				},
+			},
+			paths:               []string{"/users/1", "/users/2"},
+			expectedStatusCodes: []int{200, 429},
+			expectedRoute:       "/users/{id}",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			impl, meter := oteltest.NewMeter()
+			obsv := newMockObserver()
+			obsv.meter = meter
+			mid := NewMiddleware(obsv, tc.opts)
+
+			handler := mid.Wrap(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			})
+
+			for i, path := range tc.paths {
+				req := httptest.NewRequest("GET", path, nil)
+				rec := httptest.NewRecorder()
+				handler(rec, req)
+				assert.Equal(t, tc.expectedStatusCodes[i], rec.Code)
+			}
+
+			var routes []string
+			for _, m := range oteltest.AsStructs(impl.MeasurementBatches) {
+				if m.Name == "http_rate_limited_total" {
+					routes = append(routes, m.Labels["route"].AsString())
+				}
+			}
+
+			if tc.expectedRoute == "" {
+				assert.Empty(t, routes)
+			} else {
+				assert.Equal(t, []string{tc.expectedRoute}, routes)
+			}
+		})
+	}
+}
+
+func TestMiddlewareTimeToFirstByte(t *testing.T) {
+	tests := []struct {
+		name             string
+		opts             Options
+		write            bool
+		expectedTTFB     []int64
+		expectedDuration int64
+	}{
+		{
+			name:             "Disabled",
+			opts:             Options{},
+			write:            true,
+			expectedTTFB:     nil,
+			expectedDuration: 130,
+		},
+		{
+			name:             "Enabled",
+			opts:             Options{TimeToFirstByte: true},
+			write:            true,
+			expectedTTFB:     []int64{30},
+			expectedDuration: 130,
+		},
+		{
+			name:             "NothingWritten",
+			opts:             Options{TimeToFirstByte: true},
+			write:            false,
+			expectedTTFB:     nil,
+			expectedDuration: 130,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			impl, meter := oteltest.NewMeter()
+			clk := clock.NewFake()
+			obsv := newMockObserver()
+			obsv.meter = meter
+			tc.opts.Now = clk.Now
+			mid := NewMiddleware(obsv, tc.opts)
+
+			// The handler delays before the first write and then streams slowly
+			handler := mid.Wrap(func(w http.ResponseWriter, r *http.Request) {
+				clk.Advance(30 * time.Millisecond)
+				if tc.write {
+					w.Write([]byte("first chunk"))
+				}
+				clk.Advance(100 * time.Millisecond)
+				if tc.write {
+					w.Write([]byte("second chunk"))
+				}
+			})
+
+			handler(httptest.NewRecorder(), httptest.NewRequest("GET", "/v1/items", nil))
+
+			var ttfb, durations []int64
+			for _, m := range oteltest.AsStructs(impl.MeasurementBatches) {
+				switch m.Name {
+				case "incoming_http_requests_ttfb":
+					assert.Equal(t, "/v1/items", m.Labels["route"].AsString())
+					ttfb = append(ttfb, m.Number.AsInt64())
+				case "incoming_http_requests_duration":
+					durations = append(durations, m.Number.AsInt64())
+				}
+			}
+
+			assert.Equal(t, tc.expectedTTFB, ttfb)
+			assert.Equal(t, []int64{tc.expectedDuration}, durations)
+		})
+	}
+}
+
+func TestMiddlewareQueueWait(t *testing.T) {
+	tests := []struct {
+		name             string
+		arrival          time.Duration
+		expectedWait     bool
+		expectedWaitMS   int64
+		expectedDuration int64
+	}{
+		{
+			name:             "NoArrivalTime",
+			arrival:          0,
+			expectedWait:     false,
+			expectedDuration: 20,
+		},
+		{
+			name:             "WithArrivalTime",
+			arrival:          -50 * time.Millisecond,
+			expectedWait:     true,
+			expectedWaitMS:   50,
+			expectedDuration: 20,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			core, logs := zapobserver.New(zapcore.DebugLevel)
+			impl, meter := oteltest.NewMeter()
+			clk := clock.NewFake()
+			obsv := newMockObserver()
+			obsv.logger = zap.New(core)
+			obsv.meter = meter
+			mid := NewMiddleware(obsv, Options{
+				Now: clk.Now,
+			})
+
+			handler := mid.Wrap(func(w http.ResponseWriter, r *http.Request) {
+				clk.Advance(20 * time.Millisecond)
+				w.WriteHeader(http.StatusOK)
+			})
+
+			ctx := context.Background()
+			if tc.arrival != 0 {
+				ctx = observer.ContextWithStartTime(ctx, clk.Now().Add(tc.arrival))
+			}
+
+			req := httptest.NewRequest("GET", "/v1/items", nil).WithContext(ctx)
+			handler(httptest.NewRecorder(), req)
+
+			var waits, durations []int64
+			for _, m := range oteltest.AsStructs(impl.MeasurementBatches) {
+				switch m.Name {
+				case "incoming_http_requests_queue_wait":
+					assert.Equal(t, "GET", m.Labels["method"].AsString())
+					assert.Equal(t, "/v1/items", m.Labels["route"].AsString())
+					waits = append(waits, m.Number.AsInt64())
+				case "incoming_http_requests_duration":
+					durations = append(durations, m.Number.AsInt64())
+				}
+			}
+
+			// The wait time is reported separately from the duration of the handler
+			assert.Equal(t, []int64{tc.expectedDuration}, durations)
+
+			entries := logs.All()
+			assert.Len(t, entries, 1)
+			assert.Equal(t, "GET /v1/items 200 20ms", entries[0].Message)
+			fields := entries[0].ContextMap()
+			assert.Equal(t, tc.expectedDuration, fields["resp.duration"])
+
+			if tc.expectedWait {
+				assert.Equal(t, []int64{tc.expectedWaitMS}, waits)
+				assert.Equal(t, tc.expectedWaitMS, fields["req.queue_wait"])
+			} else {
+				assert.Empty(t, waits)
+				assert.NotContains(t, fields, "req.queue_wait")
+			}
+		})
+	}
+}
+
+func TestMiddlewareQueueWaitNested(t *testing.T) {
+	core, logs := zapobserver.New(zapcore.DebugLevel)
+	impl, meter := oteltest.NewMeter()
+	clk := clock.NewFake()
+	obsv := newMockObserver()
+	obsv.logger = zap.New(core)
+	obsv.meter = meter
+	mid := NewMiddleware(obsv, Options{
+		Now: clk.Now,
+	})
+
+	inner := mid.Wrap(func(w http.ResponseWriter, r *http.Request) {
+		clk.Advance(20 * time.Millisecond)
+		w.WriteHeader(http.StatusOK)
+	})
+
+	// The time spent in the outer handler is not a queue wait for the inner handler
+	outer := mid.Wrap(func(w http.ResponseWriter, r *http.Request) {
+		clk.Advance(30 * time.Millisecond)
+		inner(w, r)
+	})
+
+	req := httptest.NewRequest("GET", "/v1/items", nil)
+	outer(httptest.NewRecorder(), req)
+
+	for _, m := range oteltest.AsStructs(impl.MeasurementBatches) {
+		assert.NotEqual(t, "incoming_http_requests_queue_wait", m.Name)
+	}
+
+	entries := logs.All()
+	assert.Len(t, entries, 2)
+	for _, e := range entries {
+		assert.NotContains(t, e.ContextMap(), "req.queue_wait")
+	}
+}
+
+func TestMiddlewareErrorBody(t *testing.T) {
+	tests := []struct {
+		name           string
+		opts           Options
+		reqContentType string
+		reqBody        string
+		contentType    string
+		statusCode     int
+		body           string
+		expectedFields map[string]interface{}
+		missingFields  []string
+	}{
+		{
+			name:          "Disabled",
+			opts:          Options{},
+			contentType:   "application/json",
+			statusCode:    http.StatusInternalServerError,
+			body:          `{"error":"internal"}`,
+			missingFields: []string{"resp.body", "resp.body_size"},
+		},
+		{
+			name:          "Success",
+			opts:          Options{MaxErrorBodySize: 64},
+			contentType:   "application/json",
+			statusCode:    http.StatusOK,
+			body:          `{"id":"1"}`,
+			missingFields: []string{"resp.body", "resp.body_size"},
+		},
+		{
+			name:        "JSONError",
+			opts:        Options{MaxErrorBodySize: 8},
+			contentType: "application/json",
+			statusCode:  http.StatusInternalServerError,
+			body:        `{"error":"internal"}`,
+			expectedFields: map[string]interface{}{
+				"resp.body":      `{"error"`,
+				"resp.body_size": int64(20),
+			},
+			missingFields: []string{"req.body", "req.body_size"},
+		},
+		{
+			name:        "BinaryError",
+			opts:        Options{MaxErrorBodySize: 64},
+			contentType: "application/octet-stream",
+			statusCode:  http.StatusInternalServerError,
+			body:        "\x00\x01\x02",
+			expectedFields: map[string]interface{}{
+				"resp.body_size": int64(3),
+			},
+			missingFields: []string{"resp.body"},
+		},
+		{
+			name:           "RequestBody",
+			opts:           Options{MaxErrorBodySize: 64},
+			reqContentType: "application/json",
+			reqBody:        `{"name":"item"}`,
+			contentType:    "text/plain; charset=utf-8",
+			statusCode:     http.StatusBadRequest,
+			body:           "invalid item",
+			expectedFields: map[string]interface{}{
+				"req.body":       `{"name":"item"}`,
+				"req.body_size":  int64(15),
+				"resp.body":      "invalid item",
+				"resp.body_size": int64(12),
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			core, logs := zapobserver.New(zapcore.DebugLevel)
+			obsv := newMockObserver()
+			obsv.logger = zap.New(core)
+			mid := NewMiddleware(obsv, tc.opts)
+
+			handler := mid.Wrap(func(w http.ResponseWriter, r *http.Request) {
+				_, _ = ioutil.ReadAll(r.Body)
+				w.Header().Set("Content-Type", tc.contentType)
+				w.WriteHeader(tc.statusCode)
+				_, _ = w.Write([]byte(tc.body))
+			})
+
+			req := httptest.NewRequest("POST", "/v1/items", strings.NewReader(tc.reqBody))
+			if tc.reqContentType != "" {
+				req.Header.Set("Content-Type", tc.reqContentType)
+			}
+			rec := httptest.NewRecorder()
+			handler(rec, req)
+
+			// The body is still written to the client in full
+			assert.Equal(t, tc.body, rec.Body.String())
+
+			entries := logs.All()
+			assert.Len(t, entries, 1)
+			fields := entries[0].ContextMap()
+			for k, v := range tc.expectedFields {
+				assert.Equal(t, v, fields[k], k)
+			}
+			for _, k := range tc.missingFields {
+				assert.NotContains(t, fields, k)
+			}
+		})
+	}
+}
+
+func TestMiddlewareErrorLogInterval(t *testing.T) {
+	core, logs := zapobserver.New(zapcore.DebugLevel)
+	impl, meter := oteltest.NewMeter()
+	clk := clock.NewFake()
+	obsv := newMockObserver()
+	obsv.logger = zap.New(core)
+	obsv.meter = meter
+	mid := NewMiddleware(obsv, Options{
+		ErrorLogInterval: 10 * time.Second,
+		Now:              clk.Now,
+	})
+
+	handler := mid.Wrap(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+
+	for i := 0; i < 5; i++ {
+		handler(httptest.NewRecorder(), httptest.NewRequest("GET", "/v1/items", nil))
+	}
+
+	// A different fingerprint is not throttled
+	handler(httptest.NewRecorder(), httptest.NewRequest("POST", "/v1/items", nil))
+
+	clk.Advance(10 * time.Second)
+	handler(httptest.NewRecorder(), httptest.NewRequest("GET", "/v1/items", nil))
+
+	entries := logs.All()
+	assert.Len(t, entries, 3)
+	assert.Equal(t, "GET /v1/items 500 0ms", entries[0].Message)
+	assert.NotContains(t, entries[0].ContextMap(), "suppressed")
+	assert.Equal(t, "POST /v1/items 500 0ms", entries[1].Message)
+	assert.NotContains(t, entries[1].ContextMap(), "suppressed")
+	assert.Equal(t, "GET /v1/items 500 0ms", entries[2].Message)
+	assert.Equal(t, int64(4), entries[2].ContextMap()["suppressed"])
+
+	// Every request is still counted
+	var count int64
+	for _, m := range oteltest.AsStructs(impl.MeasurementBatches) {
+		if m.Name == "incoming_http_requests_total" {
+			count += m.Number.AsInt64()
+		}
+	}
+	assert.Equal(t, int64(7), count)
+}
+
+func TestMiddlewareDurationSeconds(t *testing.T) {
+	tests := []struct {
+		name              string
+		opts              Options
+		expectedDurations []int64
+		expectedSeconds   []float64
+	}{
+		{
+			name:              "Disabled",
+			opts:              Options{},
+			expectedDurations: []int64{250},
+			expectedSeconds:   nil,
+		},
+		{
+			name: "Enabled",
+			opts: Options{
+				DurationSeconds: true,
+			},
+			expectedDurations: []int64{250},
+			expectedSeconds:   []float64{0.25},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			impl, meter := oteltest.NewMeter()
+			clk := clock.NewFake()
+			obsv := newMockObserver()
+			obsv.meter = meter
+			tc.opts.Now = clk.Now
+			mid := NewMiddleware(obsv, tc.opts)
+
+			handler := mid.Wrap(func(w http.ResponseWriter, r *http.Request) {
+				clk.Advance(250 * time.Millisecond)
+				w.WriteHeader(http.StatusOK)
+			})
+
+			handler(httptest.NewRecorder(), httptest.NewRequest("GET", "/v1/items", nil))
+
+			var durations []int64
+			var seconds []float64
+			for _, m := range oteltest.AsStructs(impl.MeasurementBatches) {
+				switch m.Name {
+				case "incoming_http_requests_duration":
+					durations = append(durations, m.Number.AsInt64())
+				case "incoming_http_requests_duration_seconds":
+					assert.Equal(t, "GET", m.Labels["method"].AsString())
+					assert.Equal(t, "/v1/items", m.Labels["route"].AsString())
+					seconds = append(seconds, m.Number.AsFloat64())
+				}
+			}
+
+			assert.Equal(t, tc.expectedDurations, durations)
+			assert.Equal(t, tc.expectedSeconds, seconds)
+		})
+	}
+}
+
+// shutdownObserver is a mock observer that records whether or not it is shut down.
+type shutdownObserver struct {
+	*mockObserver
+	shutdown bool
+}
+
+func (o *shutdownObserver) Shutdown(context.Context) error {
+	o.shutdown = true
+	return nil
+}
+
+func TestNewServer(t *testing.T) {
+	core, logs := zapobserver.New(zapcore.DebugLevel)
+	obsv := newMockObserver()
+	obsv.logger = zap.New(core)
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	server := NewServer(":8080", handler, obsv, Options{})
+	assert.Equal(t, ":8080", server.Addr)
+	assert.Equal(t, serverReadHeaderTimeout, server.ReadHeaderTimeout)
+	assert.Equal(t, serverIdleTimeout, server.IdleTimeout)
+	assert.NotNil(t, server.ErrorLog)
+
+	rec := httptest.NewRecorder()
+	server.Handler.ServeHTTP(rec, httptest.NewRequest("GET", "/v1/items", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	// The metrics endpoint is served by the observer (no-op) and is not observed
+	rec = httptest.NewRecorder()
+	server.Handler.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	entries := logs.All()
+	assert.Len(t, entries, 1)
+	assert.Equal(t, "GET /v1/items 200 0ms", entries[0].Message)
+}
+
+func TestListenAndServeWithShutdown(t *testing.T) {
+	t.Run("Shutdown", func(t *testing.T) {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		assert.NoError(t, err)
+		addr := ln.Addr().String()
+		ln.Close()
+
+		core, logs := zapobserver.New(zapcore.DebugLevel)
+		obsv := &shutdownObserver{mockObserver: newMockObserver()}
+		obsv.logger = zap.New(core)
+
+		handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		})
+
+		ctx, cancel := context.WithCancel(context.Background())
+		errCh := make(chan error, 1)
+		go func() {
+			errCh <- ListenAndServeWithShutdown(ctx, NewServer(addr, handler, obsv, Options{}), obsv)
+		}()
+
+		// Wait for the server to start listening
+		var resp *http.Response
+		for i := 0; i < 100; i++ {
+			if resp, err = http.Get("http://" + addr + "/v1/items"); err == nil {
+				break
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		resp.Body.Close()
+
+		cancel()
+		assert.NoError(t, <-errCh)
+		assert.True(t, obsv.shutdown)
+		assert.Equal(t, 1, logs.FilterMessageSnippet("/v1/items").Len())
+	})
+
+	t.Run("ListenError", func(t *testing.T) {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		assert.NoError(t, err)
+		defer ln.Close()
+
+		obsv := &shutdownObserver{mockObserver: newMockObserver()}
+		handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+		// The address is already in use
+		err = ListenAndServeWithShutdown(context.Background(), NewServer(ln.Addr().String(), handler, obsv, Options{}), obsv)
+		assert.Error(t, err)
+		assert.True(t, obsv.shutdown)
+	})
+}
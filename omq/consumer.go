@@ -0,0 +1,229 @@
+package omq
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/moorara/observer"
+	"github.com/moorara/observer/internal/errs"
+	"github.com/moorara/observer/internal/instrument"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/baggage"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/label"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/unit"
+	"go.uber.org/zap"
+)
+
+// Consumer-side instruments for metrics.
+type consumerInstruments struct {
+	msgCounter   metric.Int64Counter
+	msgGauge     metric.Int64UpDownCounter
+	msgDuration  metric.Int64ValueRecorder
+	msgSeconds   metric.Float64ValueRecorder
+	panicCounter metric.Int64Counter
+}
+
+func newConsumerInstruments(meter metric.Meter, logger *zap.Logger) *consumerInstruments {
+	mm := instrument.NewSafeMeter(meter, logger)
+
+	return &consumerInstruments{
+		msgCounter: mm.NewInt64Counter(
+			"consumed_messages_total",
+			metric.WithDescription("The total number of consumed messages (consumer-side)"),
+			metric.WithUnit(unit.Dimensionless),
+			metric.WithInstrumentationName(libraryName),
+		),
+		msgGauge: mm.NewInt64UpDownCounter(
+			"consumed_messages_active",
+			metric.WithDescription("The number of in-flight consumed messages (consumer-side)"),
+			metric.WithUnit(unit.Dimensionless),
+			metric.WithInstrumentationName(libraryName),
+		),
+		msgDuration: mm.NewInt64ValueRecorder(
+			"consumed_messages_duration",
+			metric.WithDescription("The duration of handling consumed messages in milliseconds (consumer-side)"),
+			metric.WithUnit(unit.Milliseconds),
+			metric.WithInstrumentationName(libraryName),
+		),
+		msgSeconds: mm.NewFloat64ValueRecorder(
+			"consumed_messages_duration_seconds",
+			metric.WithDescription("The duration of handling consumed messages in seconds (consumer-side)"),
+			metric.WithUnit(unitSeconds),
+			metric.WithInstrumentationName(libraryName),
+		),
+		panicCounter: mm.NewInt64Counter(
+			"consumer_panics_total",
+			metric.WithDescription("The total number of panics that happened in message handlers (consumer-side)"),
+			metric.WithUnit(unit.Dimensionless),
+			metric.WithInstrumentationName(libraryName),
+		),
+	}
+}
+
+var consumerInstrumentsCache = instrument.NewCache()
+
+// Consumer handles consumed messages with logging, metrics, and tracing.
+type Consumer struct {
+	opts        Options
+	observer    observer.Observer
+	instruments *consumerInstruments
+}
+
+// NewConsumer creates a new consumer for observability.
+func NewConsumer(observer observer.Observer, opts Options) *Consumer {
+	opts = opts.withDefaults()
+	instruments := consumerInstrumentsCache.Get(observer.Meter(), func() interface{} {
+		return newConsumerInstruments(observer.Meter(), observer.Logger())
+	}).(*consumerInstruments)
+
+	return &Consumer{
+		opts:        opts,
+		observer:    observer,
+		instruments: instruments,
+	}
+}
+
+func (c *Consumer) callHandler(topic string, handle func(context.Context) error, ctx context.Context) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = errs.FromPanic("panic occurred", r)
+			c.observer.Logger().Error("Panic occurred.", zap.Error(err), zap.String("panic.type", fmt.Sprintf("%T", r)))
+			c.instruments.panicCounter.Add(context.Background(), 1,
+				label.String("topic", topic),
+			)
+		}
+	}()
+
+	return handle(ctx)
+}
+
+// Consume makes handling a consumed message observable.
+// The request metadata and the trace context are extracted from the message headers,
+// so the span for handling the message continues the trace started by the producer.
+// The handle function receives a context with the request metadata and a contextualized logger.
+// It also observes and recovers panics that happened inside the handle function.
+func (c *Consumer) Consume(ctx context.Context, topic string, headers Headers, handle func(context.Context) error) error {
+	startTime := time.Now()
+	kind := "consumer"
+
+	// Increase the number of in-flight messages
+	c.instruments.msgGauge.Add(ctx, 1,
+		label.String("topic", topic),
+	)
+
+	// Make sure we decrease the number of in-flight messages
+	defer c.instruments.msgGauge.Add(ctx, -1,
+		label.String("topic", topic),
+	)
+
+	// Make sure the message has a UUID
+	requestUUID := headers.Get(requestUUIDKey)
+	if requestUUID == "" {
+		requestUUID = uuid.New().String()
+	}
+
+	// Get the name of producer for the message if any
+	producerName := headers.Get(producerNameKey)
+
+	// Extract context from the message headers
+	ctx = otel.GetTextMapPropagator().Extract(ctx, headers)
+
+	// Create a new context
+	ctx = baggage.ContextWithValues(ctx,
+		label.String("req.uuid", requestUUID),
+	)
+
+	// Start a new span
+	ctx, span := c.observer.Tracer().Start(ctx,
+		fmt.Sprintf("%s process", topic),
+		trace.WithSpanKind(trace.SpanKindConsumer),
+	)
+	defer span.End()
+
+	// Create a contextualized logger
+	contextFields := []zap.Field{
+		zap.String("req.uuid", requestUUID),
+		zap.String("req.kind", kind),
+		zap.String("msg.topic", topic),
+		zap.String("traceId", span.SpanContext().TraceID.String()),
+		zap.String("spanId", span.SpanContext().SpanID.String()),
+	}
+	if producerName != "" {
+		contextFields = append(contextFields, zap.String("producer.name", producerName))
+	}
+	logger := c.observer.SpanLogger(span.SpanContext()).With(contextFields...)
+
+	// Augment the request context
+	// The request metadata set by outer handlers (i.e. the principal set by an auth middleware) are kept
+	reqMD, _ := observer.RequestMetadataFromContext(ctx)
+	reqMD.UUID = requestUUID
+	reqMD.ClientName = producerName
+	reqMD.StartTime = startTime
+	reqMD.TraceID = span.SpanContext().TraceID
+	reqMD.SpanID = span.SpanContext().SpanID
+	ctx = observer.ContextWithRequestMetadata(ctx, reqMD)
+	ctx = observer.ContextWithLogger(ctx, logger)
+	ctx = observer.ContextWithObserver(ctx, c.observer)
+	ctx = observer.ContextWithFields(ctx)
+
+	// Call the handle function
+	span.AddEvent("handling message")
+	err := c.callHandler(topic, handle, ctx)
+
+	elapsed := time.Since(startTime)
+	duration := elapsed.Milliseconds()
+	success := err == nil
+
+	// Report metrics
+	labels := []label.KeyValue{
+		label.String("topic", topic),
+		label.Bool("success", success),
+	}
+	c.observer.Meter().RecordBatch(ctx, labels,
+		c.instruments.msgCounter.Measurement(1),
+		c.instruments.msgDuration.Measurement(duration),
+	)
+	if c.opts.DurationSeconds {
+		c.instruments.msgSeconds.Record(ctx, elapsed.Seconds(), labels...)
+	}
+
+	// Report logs
+	message := fmt.Sprintf("%s %s %dms", kind, topic, duration)
+	fields := []zap.Field{
+		zap.Bool("resp.success", success),
+		zap.Int64("resp.duration", duration),
+	}
+	if err != nil {
+		fields = append(fields, zap.String("mq.error", err.Error()))
+	}
+
+	// The fields added while handling the message are read once the handle function returns
+	fields = append(fields, observer.FieldsFromContext(ctx)...)
+
+	// Determine the log level based on the result
+	if success {
+		if c.opts.LogInDebugLevel {
+			logger.Debug(message, fields...)
+		} else {
+			logger.Info(message, fields...)
+		}
+	} else {
+		logger.Error(message, fields...)
+	}
+
+	// Report the span
+	span.SetAttributes(
+		label.String("messaging.destination", topic),
+		label.Bool("success", success),
+	)
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+	}
+
+	return err
+}
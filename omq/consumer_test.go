@@ -0,0 +1,357 @@
+package omq
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/moorara/observer"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/oteltest"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	zapobserver "go.uber.org/zap/zaptest/observer"
+)
+
+func TestNewConsumer(t *testing.T) {
+	obsv := newMockObserver()
+	c := NewConsumer(obsv, Options{})
+
+	assert.NotNil(t, c)
+	assert.Equal(t, obsv, c.observer)
+	assert.NotNil(t, c.instruments)
+}
+
+func TestConsumerConsume(t *testing.T) {
+	tests := []struct {
+		name             string
+		opts             Options
+		headers          MapHeaders
+		handle           func(context.Context) error
+		expectedError    string
+		expectedLevel    zapcore.Level
+		expectedUUID     string
+		expectedProducer string
+		expectedSuccess  bool
+		expectedPanics   int64
+	}{
+		{
+			name:            "Success",
+			opts:            Options{},
+			headers:         MapHeaders{},
+			handle:          func(context.Context) error { return nil },
+			expectedLevel:   zapcore.InfoLevel,
+			expectedSuccess: true,
+		},
+		{
+			name: "SuccessInDebugLevel",
+			opts: Options{
+				LogInDebugLevel: true,
+			},
+			headers: MapHeaders{
+				requestUUIDKey:  "aaaaaaaa-aaaa-aaaa-aaaa-aaaaaaaaaaaa",
+				producerNameKey: "producer-service",
+			},
+			handle:           func(context.Context) error { return nil },
+			expectedLevel:    zapcore.DebugLevel,
+			expectedUUID:     "aaaaaaaa-aaaa-aaaa-aaaa-aaaaaaaaaaaa",
+			expectedProducer: "producer-service",
+			expectedSuccess:  true,
+		},
+		{
+			name:            "Failure",
+			opts:            Options{},
+			headers:         MapHeaders{},
+			handle:          func(context.Context) error { return errors.New("invalid message") },
+			expectedError:   "invalid message",
+			expectedLevel:   zapcore.ErrorLevel,
+			expectedSuccess: false,
+		},
+		{
+			name:            "Panic",
+			opts:            Options{},
+			headers:         MapHeaders{},
+			handle:          func(context.Context) error { panic("something went wrong") },
+			expectedError:   "panic occurred: something went wrong",
+			expectedLevel:   zapcore.ErrorLevel,
+			expectedSuccess: false,
+			expectedPanics:  1,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			core, logs := zapobserver.New(zapcore.DebugLevel)
+			impl, meter := oteltest.NewMeter()
+			sr := new(oteltest.StandardSpanRecorder)
+			tp := oteltest.NewTracerProvider(oteltest.WithSpanRecorder(sr))
+
+			obsv := newMockObserver()
+			obsv.logger = zap.New(core)
+			obsv.meter = meter
+			obsv.tracer = tp.Tracer("test")
+			c := NewConsumer(obsv, tc.opts)
+
+			var md observer.RequestMetadata
+			err := c.Consume(context.Background(), "orders", tc.headers, func(ctx context.Context) error {
+				md, _ = observer.RequestMetadataFromContext(ctx)
+				return tc.handle(ctx)
+			})
+
+			if tc.expectedError == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.EqualError(t, err, tc.expectedError)
+			}
+
+			// Verify the request metadata
+			assert.NotEmpty(t, md.UUID)
+			if tc.expectedUUID != "" {
+				assert.Equal(t, tc.expectedUUID, md.UUID)
+			}
+			assert.Equal(t, tc.expectedProducer, md.ClientName)
+
+			// Verify the span
+			spans := sr.Completed()
+			assert.Len(t, spans, 1)
+			assert.Equal(t, "orders process", spans[0].Name())
+			assert.Equal(t, trace.SpanKindConsumer, spans[0].SpanKind())
+			assert.Equal(t, md.TraceID, spans[0].SpanContext().TraceID)
+
+			// Verify the metrics
+			var counter, panics int64
+			for _, m := range oteltest.AsStructs(impl.MeasurementBatches) {
+				switch m.Name {
+				case "consumed_messages_total":
+					counter += m.Number.AsInt64()
+					assert.Equal(t, "orders", m.Labels["topic"].AsString())
+					assert.Equal(t, tc.expectedSuccess, m.Labels["success"].AsBool())
+				case "consumer_panics_total":
+					panics += m.Number.AsInt64()
+				}
+			}
+			assert.Equal(t, int64(1), counter)
+			assert.Equal(t, tc.expectedPanics, panics)
+
+			// Verify the logs
+			entries := logs.FilterMessageSnippet("consumer orders").All()
+			assert.Len(t, entries, 1)
+			assert.Equal(t, tc.expectedLevel, entries[0].Level)
+			fields := entries[0].ContextMap()
+			assert.Equal(t, md.UUID, fields["req.uuid"])
+			assert.Equal(t, "consumer", fields["req.kind"])
+			assert.Equal(t, "orders", fields["msg.topic"])
+			assert.Equal(t, tc.expectedSuccess, fields["resp.success"])
+		})
+	}
+}
+
+func TestProducerConsumerRoundTrip(t *testing.T) {
+	defer otel.SetTextMapPropagator(otel.GetTextMapPropagator())
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	sr := new(oteltest.StandardSpanRecorder)
+	tp := oteltest.NewTracerProvider(oteltest.WithSpanRecorder(sr))
+
+	producerObsv := newMockObserver()
+	producerObsv.name = "producer-service"
+	producerObsv.tracer = tp.Tracer("producer")
+	p := NewProducer(producerObsv, Options{})
+
+	consumerObsv := newMockObserver()
+	consumerObsv.name = "consumer-service"
+	consumerObsv.tracer = tp.Tracer("consumer")
+	c := NewConsumer(consumerObsv, Options{})
+
+	broker := newFakeBroker()
+
+	ctx := observer.ContextWithUUID(context.Background(), "aaaaaaaa-aaaa-aaaa-aaaa-aaaaaaaaaaaa")
+	msg := fakeMessage{headers: map[string]string{}, body: "order created"}
+	err := p.Publish(ctx, "orders", MapHeaders(msg.headers), func(ctx context.Context) error {
+		broker.send("orders", msg)
+		return nil
+	})
+	assert.NoError(t, err)
+
+	received, ok := broker.receive("orders")
+	assert.True(t, ok)
+
+	var md observer.RequestMetadata
+	err = c.Consume(context.Background(), "orders", MapHeaders(received.headers), func(ctx context.Context) error {
+		md, _ = observer.RequestMetadataFromContext(ctx)
+		return nil
+	})
+	assert.NoError(t, err)
+
+	assert.Equal(t, "aaaaaaaa-aaaa-aaaa-aaaa-aaaaaaaaaaaa", md.UUID)
+	assert.Equal(t, "producer-service", md.ClientName)
+
+	spans := sr.Completed()
+	assert.Len(t, spans, 2)
+	producerSpan, consumerSpan := spans[0], spans[1]
+	assert.Equal(t, producerSpan.SpanContext().TraceID, consumerSpan.SpanContext().TraceID)
+	assert.Equal(t, producerSpan.SpanContext().SpanID, consumerSpan.ParentSpanID())
+}
+
+func TestConsumerObserverInContext(t *testing.T) {
+	obsv := newMockObserver()
+	c := NewConsumer(obsv, Options{})
+
+	var o observer.Observer
+	err := c.Consume(context.Background(), "orders", MapHeaders{}, func(ctx context.Context) error {
+		o = observer.ObserverFromContext(ctx)
+		return nil
+	})
+
+	assert.NoError(t, err)
+	assert.Same(t, obsv, o)
+}
+
+func TestConsumerPrincipal(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audit")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "audit.log")
+
+	obsv := observer.New(false, observer.WithLogger("none"), observer.WithAuditOutput(path))
+	c := NewConsumer(obsv, Options{})
+
+	ctx := observer.ContextWithPrincipal(context.Background(), "jane@example.com")
+	err = c.Consume(ctx, "orders", MapHeaders{requestUUIDKey: "aaaaaaaa-aaaa-aaaa-aaaa-aaaaaaaaaaaa"}, func(ctx context.Context) error {
+		observer.Audit(ctx, "item deleted")
+		return nil
+	})
+	assert.NoError(t, err)
+	obsv.Shutdown(context.Background())
+
+	data, err := ioutil.ReadFile(path)
+	assert.NoError(t, err)
+
+	entry := map[string]interface{}{}
+	assert.NoError(t, json.Unmarshal(data, &entry))
+	assert.Equal(t, "item deleted", entry["message"])
+	assert.Equal(t, "jane@example.com", entry["principal"])
+	assert.Equal(t, "aaaaaaaa-aaaa-aaaa-aaaa-aaaaaaaaaaaa", entry["req.uuid"])
+}
+
+func TestConsumerAddFields(t *testing.T) {
+	core, logs := zapobserver.New(zapcore.DebugLevel)
+	obsv := newMockObserver()
+	obsv.logger = zap.New(core)
+	c := NewConsumer(obsv, Options{})
+
+	err := c.Consume(context.Background(), "orders", MapHeaders{}, func(ctx context.Context) error {
+		observer.AddFields(ctx, zap.String("order.id", "1234"))
+		return nil
+	})
+
+	assert.NoError(t, err)
+	assert.Equal(t, 1, logs.Len())
+	assert.Equal(t, "1234", logs.All()[0].ContextMap()["order.id"])
+}
+
+func TestConsumerDurationSeconds(t *testing.T) {
+	tests := []struct {
+		name            string
+		opts            Options
+		expectedSeconds int
+	}{
+		{
+			name:            "Disabled",
+			opts:            Options{},
+			expectedSeconds: 0,
+		},
+		{
+			name: "Enabled",
+			opts: Options{
+				DurationSeconds: true,
+			},
+			expectedSeconds: 1,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			impl, meter := oteltest.NewMeter()
+			obsv := newMockObserver()
+			obsv.meter = meter
+			c := NewConsumer(obsv, tc.opts)
+
+			err := c.Consume(context.Background(), "orders", MapHeaders{}, func(context.Context) error {
+				time.Sleep(10 * time.Millisecond)
+				return nil
+			})
+			assert.NoError(t, err)
+
+			var durations, seconds int
+			for _, m := range oteltest.AsStructs(impl.MeasurementBatches) {
+				switch m.Name {
+				case "consumed_messages_duration":
+					durations++
+					assert.True(t, m.Number.AsInt64() >= 10)
+				case "consumed_messages_duration_seconds":
+					seconds++
+					assert.Equal(t, "orders", m.Labels["topic"].AsString())
+					assert.True(t, m.Number.AsFloat64() >= 0.01)
+				}
+			}
+
+			assert.Equal(t, 1, durations)
+			assert.Equal(t, tc.expectedSeconds, seconds)
+		})
+	}
+}
+
+func TestConsumerPanicType(t *testing.T) {
+	errNotFound := errors.New("item not found")
+
+	tests := []struct {
+		name         string
+		r            interface{}
+		expectedType string
+		expectedIs   error
+	}{
+		{
+			name:         "StringPanic",
+			r:            "something went wrong",
+			expectedType: "string",
+			expectedIs:   nil,
+		},
+		{
+			name:         "ErrorPanic",
+			r:            errNotFound,
+			expectedType: "*errors.errorString",
+			expectedIs:   errNotFound,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			core, logs := zapobserver.New(zapcore.DebugLevel)
+			obsv := newMockObserver()
+			obsv.logger = zap.New(core)
+			c := NewConsumer(obsv, Options{})
+
+			err := c.Consume(context.Background(), "orders", MapHeaders{}, func(context.Context) error {
+				panic(tc.r)
+			})
+
+			assert.Error(t, err)
+			if tc.expectedIs != nil {
+				assert.True(t, errors.Is(err, tc.expectedIs))
+			}
+
+			panicLogs := logs.FilterMessage("Panic occurred.").All()
+			assert.Len(t, panicLogs, 1)
+			assert.Equal(t, tc.expectedType, panicLogs[0].ContextMap()["panic.type"])
+		})
+	}
+}
@@ -0,0 +1,66 @@
+// Package omq is an observable messaging package.
+// It can be used for building message producers and consumers that automatically report logs, metrics, and traces.
+// It is transport-agnostic and can be used with any message queue or broker (i.e. Kafka, NATS, RabbitMQ, etc.).
+package omq
+
+import "go.opentelemetry.io/otel/unit"
+
+const (
+	libraryName     = "observer/omq"
+	unitSeconds     = unit.Unit("s")
+	requestUUIDKey  = "request-uuid"
+	producerNameKey = "producer-name"
+)
+
+// Options are optional configurations for creating producers and consumers.
+type Options struct {
+	LogInDebugLevel bool
+
+	// DurationSeconds records the durations of messages in seconds too (i.e. consumed_messages_duration_seconds), see ohttp.Options.DurationSeconds.
+	DurationSeconds bool
+}
+
+func (opts Options) withDefaults() Options {
+	return opts
+}
+
+// Headers is used for reading and writing the headers of a message.
+// It can be implemented for the headers of any messaging transport (i.e. Kafka record headers).
+type Headers interface {
+	Get(key string) string
+	Set(key, value string)
+}
+
+// MapHeaders implements the Headers interface for a map.
+type MapHeaders map[string]string
+
+// Get returns the value of a header.
+func (h MapHeaders) Get(key string) string {
+	return h[key]
+}
+
+// Set sets the value of a header.
+func (h MapHeaders) Set(key, value string) {
+	h[key] = value
+}
+
+// HeaderFuncs implements the Headers interface using get and set functions.
+type HeaderFuncs struct {
+	GetFunc func(key string) string
+	SetFunc func(key, value string)
+}
+
+// Get returns the value of a header.
+func (h HeaderFuncs) Get(key string) string {
+	if h.GetFunc == nil {
+		return ""
+	}
+	return h.GetFunc(key)
+}
+
+// Set sets the value of a header.
+func (h HeaderFuncs) Set(key, value string) {
+	if h.SetFunc != nil {
+		h.SetFunc(key, value)
+	}
+}
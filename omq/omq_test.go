@@ -0,0 +1,140 @@
+package omq
+
+import (
+	"testing"
+
+	"github.com/moorara/observer"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+)
+
+// mockObserver is a no-op observer with overridable name, logger, meter, and tracer.
+type mockObserver struct {
+	observer.Observer
+	name   string
+	logger *zap.Logger
+	meter  metric.Meter
+	tracer trace.Tracer
+}
+
+var _ observer.Observer = (*mockObserver)(nil)
+
+func newMockObserver() *mockObserver {
+	noop := observer.NewNoop()
+	return &mockObserver{
+		Observer: noop,
+		name:     "test",
+		logger:   noop.Logger(),
+		meter:    noop.Meter(),
+		tracer:   noop.Tracer(),
+	}
+}
+
+func (m *mockObserver) Name() string {
+	return m.name
+}
+
+func (m *mockObserver) Logger() *zap.Logger {
+	return m.logger
+}
+
+func (m *mockObserver) SpanLogger(trace.SpanContext) *zap.Logger {
+	return m.logger
+}
+
+func (m *mockObserver) Meter() metric.Meter {
+	return m.meter
+}
+
+func (m *mockObserver) Tracer() trace.Tracer {
+	return m.tracer
+}
+
+// fakeMessage is a message with headers in the fake broker.
+type fakeMessage struct {
+	headers map[string]string
+	body    string
+}
+
+// fakeBroker is an in-memory message broker for tests.
+type fakeBroker struct {
+	topics map[string][]fakeMessage
+}
+
+func newFakeBroker() *fakeBroker {
+	return &fakeBroker{
+		topics: map[string][]fakeMessage{},
+	}
+}
+
+func (b *fakeBroker) send(topic string, msg fakeMessage) {
+	b.topics[topic] = append(b.topics[topic], msg)
+}
+
+func (b *fakeBroker) receive(topic string) (fakeMessage, bool) {
+	msgs := b.topics[topic]
+	if len(msgs) == 0 {
+		return fakeMessage{}, false
+	}
+	b.topics[topic] = msgs[1:]
+	return msgs[0], true
+}
+
+func TestMapHeaders(t *testing.T) {
+	h := MapHeaders{}
+	h.Set("key", "value")
+
+	assert.Equal(t, "value", h.Get("key"))
+	assert.Equal(t, "", h.Get("unknown"))
+}
+
+func TestHeaderFuncs(t *testing.T) {
+	tests := []struct {
+		name          string
+		headers       HeaderFuncs
+		expectedValue string
+	}{
+		{
+			name:          "NoFuncs",
+			headers:       HeaderFuncs{},
+			expectedValue: "",
+		},
+		{
+			name: "WithFuncs",
+			headers: func() HeaderFuncs {
+				m := map[string]string{}
+				return HeaderFuncs{
+					GetFunc: func(key string) string { return m[key] },
+					SetFunc: func(key, value string) { m[key] = value },
+				}
+			}(),
+			expectedValue: "value",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.NotPanics(t, func() {
+				tc.headers.Set("key", "value")
+			})
+			assert.Equal(t, tc.expectedValue, tc.headers.Get("key"))
+		})
+	}
+}
+
+// unhashableObserver is an observer.Observer that cannot be used as a map key.
+type unhashableObserver struct {
+	*mockObserver
+	_ []string
+}
+
+func TestUnhashableObserver(t *testing.T) {
+	obsv := unhashableObserver{mockObserver: newMockObserver()}
+
+	assert.NotPanics(t, func() {
+		NewProducer(obsv, Options{})
+		NewConsumer(obsv, Options{})
+	})
+}
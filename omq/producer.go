@@ -0,0 +1,168 @@
+package omq
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/moorara/observer"
+	"github.com/moorara/observer/internal/instrument"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/baggage"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/label"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/unit"
+	"go.uber.org/zap"
+)
+
+// Producer-side instruments for metrics.
+type producerInstruments struct {
+	msgCounter  metric.Int64Counter
+	msgDuration metric.Int64ValueRecorder
+	msgSeconds  metric.Float64ValueRecorder
+}
+
+func newProducerInstruments(meter metric.Meter, logger *zap.Logger) *producerInstruments {
+	mm := instrument.NewSafeMeter(meter, logger)
+
+	return &producerInstruments{
+		msgCounter: mm.NewInt64Counter(
+			"published_messages_total",
+			metric.WithDescription("The total number of published messages (producer-side)"),
+			metric.WithUnit(unit.Dimensionless),
+			metric.WithInstrumentationName(libraryName),
+		),
+		msgDuration: mm.NewInt64ValueRecorder(
+			"published_messages_duration",
+			metric.WithDescription("The duration of publishing messages in milliseconds (producer-side)"),
+			metric.WithUnit(unit.Milliseconds),
+			metric.WithInstrumentationName(libraryName),
+		),
+		msgSeconds: mm.NewFloat64ValueRecorder(
+			"published_messages_duration_seconds",
+			metric.WithDescription("The duration of publishing messages in seconds (producer-side)"),
+			metric.WithUnit(unitSeconds),
+			metric.WithInstrumentationName(libraryName),
+		),
+	}
+}
+
+var producerInstrumentsCache = instrument.NewCache()
+
+// Producer publishes messages with logging, metrics, and tracing.
+type Producer struct {
+	opts        Options
+	observer    observer.Observer
+	instruments *producerInstruments
+}
+
+// NewProducer creates a new producer for observability.
+func NewProducer(observer observer.Observer, opts Options) *Producer {
+	opts = opts.withDefaults()
+	instruments := producerInstrumentsCache.Get(observer.Meter(), func() interface{} {
+		return newProducerInstruments(observer.Meter(), observer.Logger())
+	}).(*producerInstruments)
+
+	return &Producer{
+		opts:        opts,
+		observer:    observer,
+		instruments: instruments,
+	}
+}
+
+// Publish makes publishing a message to a topic observable.
+// The request metadata and the trace context are injected into the message headers,
+// so they can be propagated to consumers of the message.
+// The publish function should send the message with the headers using the underlying transport.
+func (p *Producer) Publish(ctx context.Context, topic string, headers Headers, publish func(context.Context) error) error {
+	startTime := time.Now()
+	kind := "producer"
+
+	// Make sure the message has a UUID
+	requestUUID, ok := observer.UUIDFromContext(ctx)
+	if !ok || requestUUID == "" {
+		requestUUID = uuid.New().String()
+	}
+
+	// Propagate request metadata by adding them to the message headers
+	headers.Set(requestUUIDKey, requestUUID)
+	headers.Set(producerNameKey, p.observer.Name())
+
+	// Create a new context
+	ctx = baggage.ContextWithValues(ctx,
+		label.String("req.uuid", requestUUID),
+		label.String("producer.name", p.observer.Name()),
+	)
+
+	// Start a new span
+	ctx, span := p.observer.Tracer().Start(ctx,
+		fmt.Sprintf("%s send", topic),
+		trace.WithSpanKind(trace.SpanKindProducer),
+	)
+	defer span.End()
+
+	// Inject the context and the span context into the message headers
+	otel.GetTextMapPropagator().Inject(ctx, headers)
+
+	// Call the publish function
+	span.AddEvent("publishing message")
+	err := publish(ctx)
+
+	elapsed := time.Since(startTime)
+	duration := elapsed.Milliseconds()
+	success := err == nil
+
+	// Report metrics
+	labels := []label.KeyValue{
+		label.String("topic", topic),
+		label.Bool("success", success),
+	}
+	p.observer.Meter().RecordBatch(ctx, labels,
+		p.instruments.msgCounter.Measurement(1),
+		p.instruments.msgDuration.Measurement(duration),
+	)
+	if p.opts.DurationSeconds {
+		p.instruments.msgSeconds.Record(ctx, elapsed.Seconds(), labels...)
+	}
+
+	// Report logs
+	logger := p.observer.Logger()
+	message := fmt.Sprintf("%s %s %dms", kind, topic, duration)
+	fields := []zap.Field{
+		zap.String("req.uuid", requestUUID),
+		zap.String("req.kind", kind),
+		zap.String("msg.topic", topic),
+		zap.Bool("resp.success", success),
+		zap.Int64("resp.duration", duration),
+		zap.String("traceId", span.SpanContext().TraceID.String()),
+		zap.String("spanId", span.SpanContext().SpanID.String()),
+	}
+	if err != nil {
+		fields = append(fields, zap.String("mq.error", err.Error()))
+	}
+
+	// Determine the log level based on the result
+	if success {
+		if p.opts.LogInDebugLevel {
+			logger.Debug(message, fields...)
+		} else {
+			logger.Info(message, fields...)
+		}
+	} else {
+		logger.Error(message, fields...)
+	}
+
+	// Report the span
+	span.SetAttributes(
+		label.String("messaging.destination", topic),
+		label.Bool("success", success),
+	)
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+	}
+
+	return err
+}
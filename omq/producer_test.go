@@ -0,0 +1,135 @@
+package omq
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/moorara/observer"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/oteltest"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	zapobserver "go.uber.org/zap/zaptest/observer"
+)
+
+func TestNewProducer(t *testing.T) {
+	obsv := newMockObserver()
+	p := NewProducer(obsv, Options{})
+
+	assert.NotNil(t, p)
+	assert.Equal(t, obsv, p.observer)
+	assert.NotNil(t, p.instruments)
+}
+
+func TestProducerPublish(t *testing.T) {
+	defer otel.SetTextMapPropagator(otel.GetTextMapPropagator())
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	tests := []struct {
+		name             string
+		opts             Options
+		ctx              context.Context
+		publishErr       error
+		expectedLevel    zapcore.Level
+		expectedUUID     string
+		expectedCounter  int64
+		expectedSuccess  bool
+		expectedSpanCode string
+	}{
+		{
+			name:            "Success",
+			opts:            Options{},
+			ctx:             context.Background(),
+			publishErr:      nil,
+			expectedLevel:   zapcore.InfoLevel,
+			expectedCounter: 1,
+			expectedSuccess: true,
+		},
+		{
+			name: "SuccessInDebugLevel",
+			opts: Options{
+				LogInDebugLevel: true,
+			},
+			ctx:             observer.ContextWithUUID(context.Background(), "aaaaaaaa-aaaa-aaaa-aaaa-aaaaaaaaaaaa"),
+			publishErr:      nil,
+			expectedLevel:   zapcore.DebugLevel,
+			expectedUUID:    "aaaaaaaa-aaaa-aaaa-aaaa-aaaaaaaaaaaa",
+			expectedCounter: 1,
+			expectedSuccess: true,
+		},
+		{
+			name:            "Failure",
+			opts:            Options{},
+			ctx:             context.Background(),
+			publishErr:      errors.New("broker unavailable"),
+			expectedLevel:   zapcore.ErrorLevel,
+			expectedCounter: 1,
+			expectedSuccess: false,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			core, logs := zapobserver.New(zapcore.DebugLevel)
+			impl, meter := oteltest.NewMeter()
+			sr := new(oteltest.StandardSpanRecorder)
+			tp := oteltest.NewTracerProvider(oteltest.WithSpanRecorder(sr))
+
+			obsv := newMockObserver()
+			obsv.name = "producer-service"
+			obsv.logger = zap.New(core)
+			obsv.meter = meter
+			obsv.tracer = tp.Tracer("test")
+			p := NewProducer(obsv, tc.opts)
+
+			headers := MapHeaders{}
+			var publishCtx context.Context
+			err := p.Publish(tc.ctx, "orders", headers, func(ctx context.Context) error {
+				publishCtx = ctx
+				return tc.publishErr
+			})
+
+			assert.Equal(t, tc.publishErr, err)
+
+			// Verify the headers
+			assert.NotEmpty(t, headers[requestUUIDKey])
+			if tc.expectedUUID != "" {
+				assert.Equal(t, tc.expectedUUID, headers[requestUUIDKey])
+			}
+			assert.Equal(t, "producer-service", headers[producerNameKey])
+			assert.NotEmpty(t, headers["traceparent"])
+
+			// Verify the span
+			spans := sr.Completed()
+			assert.Len(t, spans, 1)
+			assert.Equal(t, "orders send", spans[0].Name())
+			assert.Equal(t, trace.SpanKindProducer, spans[0].SpanKind())
+			assert.Equal(t, trace.SpanContextFromContext(publishCtx).TraceID, spans[0].SpanContext().TraceID)
+
+			// Verify the metrics
+			var counter int64
+			for _, m := range oteltest.AsStructs(impl.MeasurementBatches) {
+				if m.Name == "published_messages_total" {
+					counter += m.Number.AsInt64()
+					assert.Equal(t, "orders", m.Labels["topic"].AsString())
+					assert.Equal(t, tc.expectedSuccess, m.Labels["success"].AsBool())
+				}
+			}
+			assert.Equal(t, tc.expectedCounter, counter)
+
+			// Verify the logs
+			entries := logs.All()
+			assert.Len(t, entries, 1)
+			assert.Equal(t, tc.expectedLevel, entries[0].Level)
+			fields := entries[0].ContextMap()
+			assert.Equal(t, headers[requestUUIDKey], fields["req.uuid"])
+			assert.Equal(t, "producer", fields["req.kind"])
+			assert.Equal(t, "orders", fields["msg.topic"])
+			assert.Equal(t, tc.expectedSuccess, fields["resp.success"])
+		})
+	}
+}
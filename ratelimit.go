@@ -0,0 +1,133 @@
+package observer
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/moorara/observer/internal/clock"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// rateLimiter keeps track of the last time an entry with a given key was written.
+type rateLimiter struct {
+	sync.Mutex
+	every     time.Duration
+	clock     clock.Clock
+	last      map[string]time.Time
+	lastSweep time.Time
+}
+
+func newRateLimiter(every time.Duration) *rateLimiter {
+	return &rateLimiter{
+		every: every,
+		clock: clock.Real,
+		last:  map[string]time.Time{},
+	}
+}
+
+// allow returns true if no entry with the same key has been allowed within the current window.
+func (r *rateLimiter) allow(key string) bool {
+	r.Lock()
+	defer r.Unlock()
+
+	now := r.clock.Now()
+
+	// Forget the keys with expired windows, so high-cardinality keys do not grow the map indefinitely
+	if now.Sub(r.lastSweep) >= r.every {
+		for k, t := range r.last {
+			if now.Sub(t) >= r.every {
+				delete(r.last, k)
+			}
+		}
+		r.lastSweep = now
+	}
+
+	if t, ok := r.last[key]; ok && now.Sub(t) < r.every {
+		return false
+	}
+
+	r.last[key] = now
+	return true
+}
+
+// rateLimitCore is a zapcore.Core that writes the first entry per key in every window and drops the rest.
+// The key of an entry consists of its level, its message, and the values of the key fields.
+type rateLimitCore struct {
+	zapcore.Core
+	limiter   *rateLimiter
+	keyFields []string
+	fields    []zapcore.Field
+}
+
+func (c *rateLimitCore) With(fields []zapcore.Field) zapcore.Core {
+	return &rateLimitCore{
+		Core:      c.Core.With(fields),
+		limiter:   c.limiter,
+		keyFields: c.keyFields,
+		fields:    append(append([]zapcore.Field{}, c.fields...), fields...),
+	}
+}
+
+func (c *rateLimitCore) Check(e zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(e.Level) {
+		return ce.AddCore(e, c)
+	}
+	return ce
+}
+
+func (c *rateLimitCore) Write(e zapcore.Entry, fields []zapcore.Field) error {
+	if !c.limiter.allow(c.key(e, fields)) {
+		return nil
+	}
+
+	// The wrapped core is checked again, so only its cores that are enabled for the entry write it
+	if ce := c.Core.Check(e, nil); ce != nil {
+		ce.Write(fields...)
+	}
+
+	return nil
+}
+
+func (c *rateLimitCore) key(e zapcore.Entry, fields []zapcore.Field) string {
+	var b strings.Builder
+	b.WriteString(e.Level.String())
+	b.WriteString("|")
+	b.WriteString(e.Message)
+
+	if len(c.keyFields) == 0 {
+		return b.String()
+	}
+
+	enc := zapcore.NewMapObjectEncoder()
+	for _, f := range c.fields {
+		f.AddTo(enc)
+	}
+	for _, f := range fields {
+		f.AddTo(enc)
+	}
+
+	for _, k := range c.keyFields {
+		fmt.Fprintf(&b, "|%s=%v", k, enc.Fields[k])
+	}
+
+	return b.String()
+}
+
+// RateLimitedLogger returns a logger that writes repetitive log entries at most once in every window.
+// Entries are considered repetitive if they have the same level, message, and values for the given key fields (i.e. a tenant field).
+// The first entry per key in a window is always written and the subsequent ones are dropped until the window ends.
+// This is meant for noisy logs in hot paths and unlike the sampling of zap, the rate is limited independently for every key.
+func RateLimitedLogger(logger *zap.Logger, every time.Duration, keyFields ...string) *zap.Logger {
+	limiter := newRateLimiter(every)
+
+	return logger.WithOptions(zap.WrapCore(func(core zapcore.Core) zapcore.Core {
+		return &rateLimitCore{
+			Core:      core,
+			limiter:   limiter,
+			keyFields: keyFields,
+		}
+	}))
+}
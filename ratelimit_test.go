@@ -0,0 +1,111 @@
+package observer
+
+import (
+	"testing"
+	"time"
+
+	"github.com/moorara/observer/internal/clock"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	zapobserver "go.uber.org/zap/zaptest/observer"
+)
+
+func TestRateLimiter(t *testing.T) {
+	now := time.Now()
+	r := newRateLimiter(time.Second)
+	r.clock = clock.Func(func() time.Time { return now })
+
+	assert.True(t, r.allow("a"))
+	assert.False(t, r.allow("a"))
+	assert.True(t, r.allow("b"))
+
+	now = now.Add(500 * time.Millisecond)
+	assert.False(t, r.allow("a"))
+
+	now = now.Add(500 * time.Millisecond)
+	assert.True(t, r.allow("a"))
+	assert.Len(t, r.last, 1)
+}
+
+func TestRateLimitedLogger(t *testing.T) {
+	tests := []struct {
+		name            string
+		keyFields       []string
+		log             func(*zap.Logger)
+		expectedEntries []string
+	}{
+		{
+			name:      "SameMessage",
+			keyFields: nil,
+			log: func(logger *zap.Logger) {
+				for i := 0; i < 10; i++ {
+					logger.Debug("processing item", zap.Int("item", i))
+				}
+			},
+			expectedEntries: []string{"processing item"},
+		},
+		{
+			name:      "DifferentMessages",
+			keyFields: nil,
+			log: func(logger *zap.Logger) {
+				logger.Debug("processing item")
+				logger.Debug("processing item")
+				logger.Debug("skipping item")
+				logger.Info("processing item")
+			},
+			expectedEntries: []string{"processing item", "skipping item", "processing item"},
+		},
+		{
+			name:      "PerKey",
+			keyFields: []string{"tenant"},
+			log: func(logger *zap.Logger) {
+				for i := 0; i < 10; i++ {
+					logger.Debug("processing item", zap.String("tenant", "aaaa"))
+					logger.Debug("processing item", zap.String("tenant", "bbbb"))
+				}
+			},
+			expectedEntries: []string{"processing item", "processing item"},
+		},
+		{
+			name:      "PerKeyFromContext",
+			keyFields: []string{"tenant"},
+			log: func(logger *zap.Logger) {
+				l1 := logger.With(zap.String("tenant", "aaaa"))
+				l2 := logger.With(zap.String("tenant", "bbbb"))
+				for i := 0; i < 10; i++ {
+					l1.Debug("processing item")
+					l2.Debug("processing item")
+				}
+			},
+			expectedEntries: []string{"processing item", "processing item"},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			core, logs := zapobserver.New(zapcore.DebugLevel)
+			logger := RateLimitedLogger(zap.New(core), time.Minute, tc.keyFields...)
+
+			tc.log(logger)
+
+			entries := logs.AllUntimed()
+			assert.Len(t, entries, len(tc.expectedEntries))
+			for i, message := range tc.expectedEntries {
+				assert.Equal(t, message, entries[i].Message)
+			}
+		})
+	}
+}
+
+func TestRateLimitedLoggerLevel(t *testing.T) {
+	core, logs := zapobserver.New(zapcore.InfoLevel)
+	logger := RateLimitedLogger(zap.New(core), time.Minute)
+
+	logger.Debug("processing item")
+	logger.Info("processing item")
+	logger.Info("processing item")
+
+	assert.Equal(t, 1, logs.Len())
+	assert.Equal(t, zapcore.InfoLevel, logs.All()[0].Level)
+}
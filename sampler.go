@@ -0,0 +1,166 @@
+package observer
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/moorara/observer/internal/clock"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/label"
+	tracesdk "go.opentelemetry.io/otel/sdk/trace"
+)
+
+// errorKey is the span attribute for marking a span as associated with an error when it is started (i.e. retrying a failed operation).
+const errorKey = label.Key("error")
+
+// adaptiveSamplingWindow is the duration of the window for measuring the recent error rate.
+const adaptiveSamplingWindow = 10 * time.Second
+
+// errorRate is a tracesdk.SpanProcessor that measures the ratio of ended spans with an error status.
+// The rate is measured over the current and the previous window, so it does not drop to zero at the start of every window.
+// Span processors are only called for recording spans, so the adaptive sampler records the spans it does not sample
+// for the error rate to be measured over all spans.
+type errorRate struct {
+	sync.Mutex
+	clock                 clock.Clock
+	window                time.Duration
+	start                 time.Time
+	currTotal, currErrors int64
+	prevTotal, prevErrors int64
+}
+
+func newErrorRate(window time.Duration, c clock.Clock) *errorRate {
+	return &errorRate{
+		clock:  c,
+		window: window,
+		start:  c.Now(),
+	}
+}
+
+// rotate moves to a new window if the current window is over.
+// It must be called while the lock is held.
+func (r *errorRate) rotate() {
+	now := r.clock.Now()
+	switch elapsed := now.Sub(r.start); {
+	case elapsed >= 2*r.window:
+		r.prevTotal, r.prevErrors = 0, 0
+	case elapsed >= r.window:
+		r.prevTotal, r.prevErrors = r.currTotal, r.currErrors
+	default:
+		return
+	}
+
+	r.currTotal, r.currErrors = 0, 0
+	r.start = now
+}
+
+// Rate returns the recent error rate between 0 and 1.
+func (r *errorRate) Rate() float64 {
+	r.Lock()
+	defer r.Unlock()
+
+	r.rotate()
+
+	total := r.prevTotal + r.currTotal
+	if total == 0 {
+		return 0
+	}
+
+	return float64(r.prevErrors+r.currErrors) / float64(total)
+}
+
+func (r *errorRate) OnStart(context.Context, tracesdk.ReadWriteSpan) {}
+
+func (r *errorRate) OnEnd(s tracesdk.ReadOnlySpan) {
+	r.Lock()
+	defer r.Unlock()
+
+	r.rotate()
+
+	r.currTotal++
+	if s.StatusCode() == codes.Error {
+		r.currErrors++
+	}
+}
+
+func (r *errorRate) Shutdown(context.Context) error {
+	return nil
+}
+
+func (r *errorRate) ForceFlush() {}
+
+// adaptiveSampler is a tracesdk.Sampler that raises the sampling probability when errors are likely.
+// Spans with a sampled parent are always sampled.
+// New traces are sampled with the base ratio plus the error boost times the recent error rate.
+// Spans started with the error attribute set to true are sampled with the base ratio plus the error boost,
+// even if their parent is not sampled, in which case the sampled trace starts at the error span and misses its ancestors.
+// Other spans with a non-sampled parent are not sampled.
+//
+// The spans that are not sampled are still recorded (but not exported), so the error rate is measured over all ended spans.
+// This costs the memory of recording every span until it ends in exchange for an unbiased error rate.
+type adaptiveSampler struct {
+	baseRatio  float64
+	errorBoost float64
+	errorRate  *errorRate
+}
+
+func newAdaptiveSampler(baseRatio, errorBoost float64, errorRate *errorRate) *adaptiveSampler {
+	return &adaptiveSampler{
+		baseRatio:  math.Max(0, math.Min(1, baseRatio)),
+		errorBoost: math.Max(0, errorBoost),
+		errorRate:  errorRate,
+	}
+}
+
+// ratio returns the effective sampling ratio for a span.
+func (s *adaptiveSampler) ratio(errorAssociated bool) float64 {
+	boost := s.errorBoost * s.errorRate.Rate()
+	if errorAssociated {
+		boost = s.errorBoost
+	}
+
+	return math.Min(1, s.baseRatio+boost)
+}
+
+func (s *adaptiveSampler) ShouldSample(p tracesdk.SamplingParameters) tracesdk.SamplingResult {
+	errorAssociated := false
+	for _, kv := range p.Attributes {
+		if kv.Key == errorKey && kv.Value.AsBool() {
+			errorAssociated = true
+		}
+	}
+
+	switch {
+	case p.ParentContext.IsSampled():
+		return tracesdk.SamplingResult{Decision: tracesdk.RecordAndSample, Tracestate: p.ParentContext.TraceState}
+	case p.ParentContext.IsValid() && !errorAssociated:
+		return tracesdk.SamplingResult{Decision: tracesdk.RecordOnly, Tracestate: p.ParentContext.TraceState}
+	}
+
+	// The decision is made from the trace id like the trace id ratio sampler, so it is consistent for a trace
+	ratio := s.ratio(errorAssociated)
+	x := binary.BigEndian.Uint64(p.TraceID[0:8]) >> 1
+	if ratio >= 1 || float64(x) < ratio*(1<<63) {
+		return tracesdk.SamplingResult{Decision: tracesdk.RecordAndSample, Tracestate: p.ParentContext.TraceState}
+	}
+
+	return tracesdk.SamplingResult{Decision: tracesdk.RecordOnly, Tracestate: p.ParentContext.TraceState}
+}
+
+func (s *adaptiveSampler) Description() string {
+	return fmt.Sprintf("AdaptiveSampler{%g,%g}", s.baseRatio, s.errorBoost)
+}
+
+// newSampler returns the sampler for the tracer provider and an optional span processor that the sampler depends on.
+func newSampler(c configs) (tracesdk.Sampler, tracesdk.SpanProcessor) {
+	if !c.adaptiveSampling {
+		return tracesdk.AlwaysSample(), nil
+	}
+
+	errorRate := newErrorRate(adaptiveSamplingWindow, clock.Real)
+	return newAdaptiveSampler(c.samplingBaseRatio, c.samplingErrorBoost, errorRate), errorRate
+}
@@ -0,0 +1,247 @@
+package observer
+
+import (
+	"context"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/moorara/observer/internal/clock"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/label"
+	tracesdk "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// recordSpans ends a number of spans with and without an error status for an error rate.
+func recordSpans(r *errorRate, total, errors int) {
+	tp := tracesdk.NewTracerProvider(tracesdk.WithSpanProcessor(r))
+	tracer := tp.Tracer("test")
+
+	for i := 0; i < total; i++ {
+		_, span := tracer.Start(context.Background(), "span")
+		if i < errors {
+			span.SetStatus(codes.Error, "failed")
+		}
+		span.End()
+	}
+}
+
+func TestErrorRate(t *testing.T) {
+	now := time.Now()
+	r := newErrorRate(10*time.Second, clock.Func(func() time.Time { return now }))
+	assert.Equal(t, float64(0), r.Rate())
+
+	recordSpans(r, 4, 1)
+	assert.Equal(t, 0.25, r.Rate())
+
+	// The previous window is still counted
+	now = now.Add(10 * time.Second)
+	recordSpans(r, 4, 3)
+	assert.Equal(t, 0.5, r.Rate())
+
+	now = now.Add(10 * time.Second)
+	assert.Equal(t, 0.75, r.Rate())
+
+	// The rate goes back to zero after two windows without spans
+	now = now.Add(20 * time.Second)
+	assert.Equal(t, float64(0), r.Rate())
+}
+
+// sampledRatio returns the ratio of root spans sampled by a sampler for random trace ids.
+func sampledRatio(s tracesdk.Sampler, parent trace.SpanContext, attrs ...label.KeyValue) float64 {
+	rnd := rand.New(rand.NewSource(1))
+
+	const n = 10000
+	var sampled int
+	for i := 0; i < n; i++ {
+		var tid trace.TraceID
+		rnd.Read(tid[:])
+
+		res := s.ShouldSample(tracesdk.SamplingParameters{
+			ParentContext: parent,
+			TraceID:       tid,
+			Name:          "span",
+			Attributes:    attrs,
+		})
+
+		if res.Decision == tracesdk.RecordAndSample {
+			sampled++
+		}
+	}
+
+	return float64(sampled) / n
+}
+
+func TestAdaptiveSampler(t *testing.T) {
+	sampledParent := trace.SpanContext{
+		TraceID:    trace.TraceID{0x01},
+		SpanID:     trace.SpanID{0x01},
+		TraceFlags: trace.FlagsSampled,
+	}
+
+	notSampledParent := trace.SpanContext{
+		TraceID: trace.TraceID{0x01},
+		SpanID:  trace.SpanID{0x01},
+	}
+
+	tests := []struct {
+		name          string
+		baseRatio     float64
+		errorBoost    float64
+		total, errors int
+		parent        trace.SpanContext
+		attrs         []label.KeyValue
+		expectedRatio float64
+	}{
+		{
+			name:          "BaseRatio",
+			baseRatio:     0.1,
+			errorBoost:    0.5,
+			parent:        trace.SpanContext{},
+			expectedRatio: 0.1,
+		},
+		{
+			name:          "SampledParent",
+			baseRatio:     0.1,
+			errorBoost:    0.5,
+			parent:        sampledParent,
+			expectedRatio: 1,
+		},
+		{
+			name:          "NotSampledParent",
+			baseRatio:     0.1,
+			errorBoost:    0.5,
+			parent:        notSampledParent,
+			expectedRatio: 0,
+		},
+		{
+			name:          "ErrorAttribute",
+			baseRatio:     0.1,
+			errorBoost:    0.5,
+			parent:        trace.SpanContext{},
+			attrs:         []label.KeyValue{errorKey.Bool(true)},
+			expectedRatio: 0.6,
+		},
+		{
+			name:          "ErrorAttributeWithNotSampledParent",
+			baseRatio:     0.1,
+			errorBoost:    0.5,
+			parent:        notSampledParent,
+			attrs:         []label.KeyValue{errorKey.Bool(true)},
+			expectedRatio: 0.6,
+		},
+		{
+			name:          "ErrorRate",
+			baseRatio:     0.1,
+			errorBoost:    0.5,
+			total:         10,
+			errors:        4,
+			parent:        trace.SpanContext{},
+			expectedRatio: 0.3,
+		},
+		{
+			name:          "Capped",
+			baseRatio:     0.5,
+			errorBoost:    2,
+			total:         10,
+			errors:        5,
+			parent:        trace.SpanContext{},
+			expectedRatio: 1,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			r := newErrorRate(10*time.Second, clock.Real)
+			recordSpans(r, tc.total, tc.errors)
+			s := newAdaptiveSampler(tc.baseRatio, tc.errorBoost, r)
+
+			assert.InDelta(t, tc.expectedRatio, sampledRatio(s, tc.parent, tc.attrs...), 0.02)
+		})
+	}
+}
+
+func TestAdaptiveSamplerErrorsSampledMore(t *testing.T) {
+	r := newErrorRate(10*time.Second, clock.Real)
+	s := newAdaptiveSampler(0.05, 0.5, r)
+
+	base := sampledRatio(s, trace.SpanContext{})
+	errorAssociated := sampledRatio(s, trace.SpanContext{}, errorKey.Bool(true))
+	assert.Greater(t, errorAssociated, base)
+
+	// An error rate spike raises the ratio for all new traces
+	recordSpans(r, 10, 10)
+	spike := sampledRatio(s, trace.SpanContext{})
+	assert.Greater(t, spike, base)
+}
+
+func TestAdaptiveSamplerErrorRateOfAllSpans(t *testing.T) {
+	r := newErrorRate(10*time.Second, clock.Real)
+	s := newAdaptiveSampler(0, 0, r)
+	tracer := tracesdk.NewTracerProvider(
+		tracesdk.WithConfig(tracesdk.Config{DefaultSampler: s}),
+		tracesdk.WithSpanProcessor(r),
+	).Tracer("test")
+
+	for i := 0; i < 10; i++ {
+		_, span := tracer.Start(context.Background(), "span")
+
+		// The spans are not sampled, but they are recorded for the error rate
+		assert.False(t, span.SpanContext().IsSampled())
+		assert.True(t, span.IsRecording())
+
+		if i < 5 {
+			span.SetStatus(codes.Error, "failed")
+		}
+		span.End()
+	}
+
+	assert.Equal(t, 0.5, r.Rate())
+}
+
+func TestNewSampler(t *testing.T) {
+	tests := []struct {
+		name                string
+		configs             configs
+		expectedDescription string
+		expectedProcessor   bool
+	}{
+		{
+			name:                "Default",
+			configs:             configs{},
+			expectedDescription: "AlwaysOnSampler",
+			expectedProcessor:   false,
+		},
+		{
+			name: "Adaptive",
+			configs: configs{
+				adaptiveSampling:   true,
+				samplingBaseRatio:  0.1,
+				samplingErrorBoost: 0.5,
+			},
+			expectedDescription: "AdaptiveSampler{0.1,0.5}",
+			expectedProcessor:   true,
+		},
+		{
+			name: "AdaptiveClamped",
+			configs: configs{
+				adaptiveSampling:   true,
+				samplingBaseRatio:  2,
+				samplingErrorBoost: -1,
+			},
+			expectedDescription: "AdaptiveSampler{1,0}",
+			expectedProcessor:   true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			sampler, processor := newSampler(tc.configs)
+
+			assert.Equal(t, tc.expectedDescription, sampler.Description())
+			assert.Equal(t, tc.expectedProcessor, processor != nil)
+		})
+	}
+}
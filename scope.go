@@ -0,0 +1,70 @@
+package observer
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel/label"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+)
+
+// Scope binds an observer to a context for the telemetry of work that is not handling a request (i.e. background workers).
+// It offers an API parallel to the contextual logger and tracing of request handlers.
+//
+//	scope := obsv.WithContext(ctx)
+//	scope, span := scope.StartSpan("sync-accounts")
+//	defer span.End()
+//	scope.Logger().Info("Accounts synced.")
+type Scope struct {
+	ctx      context.Context
+	observer Observer
+}
+
+func newScope(ctx context.Context, observer Observer) *Scope {
+	return &Scope{
+		ctx:      ctx,
+		observer: observer,
+	}
+}
+
+// Context returns the context of the scope.
+func (s *Scope) Context() context.Context {
+	return s.ctx
+}
+
+// Logger returns a logger for the context of the scope.
+// If the context has a span, the log entries are correlated with it (traceId and spanId).
+func (s *Scope) Logger() *zap.Logger {
+	sc := trace.SpanContextFromContext(s.ctx)
+	if !sc.IsValid() {
+		return s.observer.Logger()
+	}
+
+	return s.observer.SpanLogger(sc).With(
+		zap.String("traceId", sc.TraceID.String()),
+		zap.String("spanId", sc.SpanID.String()),
+	)
+}
+
+// StartSpan starts a new span that is a child of the span of the context (if any).
+// The returned scope is bound to the context with the new span and the caller is responsible for ending the span.
+func (s *Scope) StartSpan(name string, opts ...trace.SpanOption) (*Scope, trace.Span) {
+	ctx, span := s.observer.Tracer().Start(s.ctx, name, opts...)
+	return newScope(ctx, s.observer), span
+}
+
+// Meter returns the meter of the observer.
+func (s *Scope) Meter() metric.Meter {
+	return s.observer.Meter()
+}
+
+// Add adds a value to a counter with the context of the scope.
+func (s *Scope) Add(counter metric.Int64Counter, value int64, labels ...label.KeyValue) {
+	counter.Add(s.ctx, value, labels...)
+}
+
+// Record records a value in a value recorder with the context of the scope.
+func (s *Scope) Record(recorder metric.Int64ValueRecorder, value int64, labels ...label.KeyValue) {
+	recorder.Record(s.ctx, value, labels...)
+}
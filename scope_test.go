@@ -0,0 +1,118 @@
+package observer
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/label"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/oteltest"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	zapobserver "go.uber.org/zap/zaptest/observer"
+)
+
+func TestScopeContext(t *testing.T) {
+	ctx := context.WithValue(context.Background(), struct{}{}, "value")
+	scope := NewNoop().WithContext(ctx)
+
+	assert.Equal(t, ctx, scope.Context())
+}
+
+func TestScopeStartSpan(t *testing.T) {
+	sr := new(oteltest.StandardSpanRecorder)
+	tp := oteltest.NewTracerProvider(oteltest.WithSpanRecorder(sr))
+	obsv := New(false, WithTracerProvider(tp))
+
+	ctx, parent := tp.Tracer("worker").Start(context.Background(), "parent")
+	scope := obsv.WithContext(ctx)
+
+	childScope, child := scope.StartSpan("child")
+	child.End()
+	parent.End()
+
+	// The scope of the child span is bound to the context with the child span
+	assert.Equal(t, child.SpanContext(), trace.SpanContextFromContext(childScope.Context()))
+	assert.Equal(t, parent.SpanContext(), trace.SpanContextFromContext(scope.Context()))
+
+	spans := sr.Completed()
+	assert.Len(t, spans, 2)
+	assert.Equal(t, "child", spans[0].Name())
+	assert.Equal(t, parent.SpanContext().TraceID, spans[0].SpanContext().TraceID)
+	assert.Equal(t, parent.SpanContext().SpanID, spans[0].ParentSpanID())
+}
+
+func TestScopeLogger(t *testing.T) {
+	sr := new(oteltest.StandardSpanRecorder)
+	tp := oteltest.NewTracerProvider(oteltest.WithSpanRecorder(sr))
+
+	tests := []struct {
+		name           string
+		ctx            func() (context.Context, trace.Span)
+		expectedFields bool
+	}{
+		{
+			name: "WithoutSpan",
+			ctx: func() (context.Context, trace.Span) {
+				return context.Background(), nil
+			},
+			expectedFields: false,
+		},
+		{
+			name: "WithSpan",
+			ctx: func() (context.Context, trace.Span) {
+				return tp.Tracer("worker").Start(context.Background(), "sync")
+			},
+			expectedFields: true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			core, logs := zapobserver.New(zapcore.InfoLevel)
+			obsv := newNoop()
+			obsv.logger = zap.New(core)
+
+			ctx, span := tc.ctx()
+			obsv.WithContext(ctx).Logger().Info("Accounts synced.")
+
+			entries := logs.All()
+			assert.Len(t, entries, 1)
+			fields := entries[0].ContextMap()
+
+			if tc.expectedFields {
+				assert.Equal(t, span.SpanContext().TraceID.String(), fields["traceId"])
+				assert.Equal(t, span.SpanContext().SpanID.String(), fields["spanId"])
+			} else {
+				assert.NotContains(t, fields, "traceId")
+				assert.NotContains(t, fields, "spanId")
+			}
+		})
+	}
+}
+
+func TestScopeMetrics(t *testing.T) {
+	impl, meter := oteltest.NewMeter()
+	obsv := newNoop()
+	obsv.meter = meter
+
+	scope := obsv.WithContext(context.Background())
+	assert.Equal(t, meter, scope.Meter())
+
+	mm := metric.Must(scope.Meter())
+	counter := mm.NewInt64Counter("jobs_total")
+	recorder := mm.NewInt64ValueRecorder("jobs_duration")
+
+	scope.Add(counter, 2, label.String("job", "sync"))
+	scope.Record(recorder, 250, label.String("job", "sync"))
+
+	measurements := oteltest.AsStructs(impl.MeasurementBatches)
+	assert.Len(t, measurements, 2)
+	assert.Equal(t, "jobs_total", measurements[0].Name)
+	assert.Equal(t, int64(2), measurements[0].Number.AsInt64())
+	assert.Equal(t, "sync", measurements[0].Labels["job"].AsString())
+	assert.Equal(t, "jobs_duration", measurements[1].Name)
+	assert.Equal(t, int64(250), measurements[1].Number.AsInt64())
+}
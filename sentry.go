@@ -0,0 +1,171 @@
+package observer
+
+import (
+	"context"
+	"errors"
+	"runtime/debug"
+	"sync/atomic"
+	"time"
+
+	"github.com/getsentry/sentry-go"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// sentryFlushTimeout is the time for sending the pending events on shutdown if the context has no deadline.
+const sentryFlushTimeout = 5 * time.Second
+
+// sentryReporter reports events to Sentry using the Sentry client.
+// The HTTP transport of the client queues up to 30 events and sends them one at a time from a single goroutine,
+// so an error storm drops events instead of creating unbounded goroutines and requests.
+type sentryReporter struct {
+	client *sentry.Client
+	tags   map[string]string
+	closed int32
+}
+
+// newSentryReporter creates a new Sentry reporter from a DSN (https://<key>@<host>/<project>).
+func newSentryReporter(c configs) (*sentryReporter, error) {
+	client, err := sentry.NewClient(sentry.ClientOptions{
+		Dsn:           c.sentryDSN,
+		Release:       c.version,
+		Environment:   c.environment,
+		HTTPTransport: c.sentryTransport,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	tags := map[string]string{}
+	for k, v := range map[string]string{
+		"service":     c.name,
+		"version":     c.version,
+		"environment": c.environment,
+		"region":      c.region,
+	} {
+		if v != "" {
+			tags[k] = v
+		}
+	}
+
+	return &sentryReporter{
+		client: client,
+		tags:   tags,
+	}, nil
+}
+
+// capture queues an event for Sentry. The event is dropped if the reporter is flushed or the queue is full.
+func (r *sentryReporter) capture(e *sentry.Event) {
+	if atomic.LoadInt32(&r.closed) == 1 {
+		return
+	}
+
+	r.client.CaptureEvent(e, nil, nil)
+}
+
+// flush stops accepting new events and waits for the pending events to be sent to Sentry or the context to be done.
+func (r *sentryReporter) flush(ctx context.Context) error {
+	atomic.StoreInt32(&r.closed, 1)
+
+	timeout := sentryFlushTimeout
+	if deadline, ok := ctx.Deadline(); ok {
+		timeout = time.Until(deadline)
+	}
+
+	if !r.client.Flush(timeout) {
+		return errors.New("timed out sending the pending events to Sentry")
+	}
+
+	return nil
+}
+
+// sentryCore is a zapcore.Core that reports error-and-above log entries to Sentry.
+type sentryCore struct {
+	reporter *sentryReporter
+	fields   []zapcore.Field
+}
+
+func (c *sentryCore) Enabled(level zapcore.Level) bool {
+	return level >= zapcore.ErrorLevel
+}
+
+func (c *sentryCore) With(fields []zapcore.Field) zapcore.Core {
+	return &sentryCore{
+		reporter: c.reporter,
+		fields:   append(append([]zapcore.Field{}, c.fields...), fields...),
+	}
+}
+
+func (c *sentryCore) Check(e zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(e.Level) {
+		return ce.AddCore(e, c)
+	}
+	return ce
+}
+
+func (c *sentryCore) Write(e zapcore.Entry, fields []zapcore.Field) error {
+	enc := zapcore.NewMapObjectEncoder()
+	for _, f := range c.fields {
+		f.AddTo(enc)
+	}
+	for _, f := range fields {
+		f.AddTo(enc)
+	}
+
+	tags := map[string]string{}
+	for k, v := range c.reporter.tags {
+		tags[k] = v
+	}
+
+	// Correlate the event with the trace of the request
+	for _, key := range []string{"traceId", "spanId"} {
+		if v, ok := enc.Fields[key].(string); ok {
+			tags[key] = v
+			delete(enc.Fields, key)
+		}
+	}
+
+	// For panics recovered by a deferred function, the current stack still includes the origin of the panic
+	stack := e.Stack
+	if stack == "" {
+		stack = string(debug.Stack())
+	}
+	enc.Fields["stacktrace"] = stack
+
+	level := sentry.LevelError
+	if e.Level > zapcore.ErrorLevel {
+		level = sentry.LevelFatal
+	}
+
+	event := sentry.NewEvent()
+	event.Timestamp = e.Time
+	event.Level = level
+	event.Logger = e.LoggerName
+	event.Message = e.Message
+	event.Tags = tags
+	event.Extra = enc.Fields
+
+	c.reporter.capture(event)
+
+	return nil
+}
+
+func (c *sentryCore) Sync() error {
+	return nil
+}
+
+// initSentry returns a logger that also reports error-and-above entries to Sentry.
+// If the Sentry reporter cannot be created, the logger is returned unchanged.
+func initSentry(c configs, logger *zap.Logger) (*zap.Logger, shutdownFunc) {
+	reporter, err := newSentryReporter(c)
+	if err != nil {
+		logger.Warn("Failed to initialize Sentry.", zap.Error(err))
+		return logger, func(context.Context) error { return nil }
+	}
+
+	logger = logger.WithOptions(zap.WrapCore(func(core zapcore.Core) zapcore.Core {
+		return zapcore.NewTee(core, &sentryCore{reporter: reporter})
+	}))
+
+	return logger, reporter.flush
+}
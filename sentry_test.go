@@ -0,0 +1,232 @@
+package observer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/getsentry/sentry-go"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	zapobserver "go.uber.org/zap/zaptest/observer"
+)
+
+type mockRoundTripper struct {
+	sync.Mutex
+	requests   []*http.Request
+	bodies     [][]byte
+	statusCode int
+	err        error
+}
+
+func (m *mockRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	m.Lock()
+	defer m.Unlock()
+
+	body, _ := ioutil.ReadAll(req.Body)
+	m.requests = append(m.requests, req)
+	m.bodies = append(m.bodies, body)
+
+	if m.err != nil {
+		return nil, m.err
+	}
+
+	return &http.Response{
+		StatusCode: m.statusCode,
+		Body:       ioutil.NopCloser(strings.NewReader("{}")),
+		Request:    req,
+	}, nil
+}
+
+type blockingRoundTripper struct {
+	mockRoundTripper
+	release chan struct{}
+}
+
+func (m *blockingRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	<-m.release
+	return m.mockRoundTripper.RoundTrip(req)
+}
+
+func TestNewSentryReporter(t *testing.T) {
+	tests := []struct {
+		name          string
+		configs       configs
+		expectedError bool
+		expectedTags  map[string]string
+	}{
+		{
+			name:          "NoKey",
+			configs:       configs{sentryDSN: "https://sentry.io/1234"},
+			expectedError: true,
+		},
+		{
+			name:          "NoProject",
+			configs:       configs{sentryDSN: "https://key@sentry.io/"},
+			expectedError: true,
+		},
+		{
+			name: "Success",
+			configs: configs{
+				name:        "my-service",
+				version:     "0.1.0",
+				environment: "production",
+				sentryDSN:   "https://key@sentry.io/1234",
+			},
+			expectedTags: map[string]string{
+				"service":     "my-service",
+				"version":     "0.1.0",
+				"environment": "production",
+			},
+		},
+		{
+			name:         "WithPath",
+			configs:      configs{sentryDSN: "https://key@example.com/sentry/1234"},
+			expectedTags: map[string]string{},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			r, err := newSentryReporter(tc.configs)
+
+			if tc.expectedError {
+				assert.Nil(t, r)
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+				assert.NotNil(t, r.client)
+				assert.Equal(t, tc.expectedTags, r.tags)
+			}
+		})
+	}
+}
+
+func TestInitSentry(t *testing.T) {
+	tests := []struct {
+		name           string
+		transport      *mockRoundTripper
+		expectedEvents int
+	}{
+		{
+			name:           "Success",
+			transport:      &mockRoundTripper{statusCode: 200},
+			expectedEvents: 1,
+		},
+		{
+			name:           "Failure",
+			transport:      &mockRoundTripper{err: errors.New("connection refused")},
+			expectedEvents: 1,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			c := configs{
+				name:            "my-service",
+				version:         "0.1.0",
+				sentryDSN:       "https://key@sentry.io/1234",
+				sentryTransport: tc.transport,
+			}
+
+			logger, flush := initSentry(c, zap.NewNop())
+			logger = logger.With(zap.String("traceId", "4bf92f3577b34da6a3ce929d0e0e4736"))
+			logger.Info("this entry is not reported")
+			logger.Error("something went wrong", zap.String("user", "jane"))
+
+			assert.NoError(t, flush(context.Background()))
+			assert.Len(t, tc.transport.requests, tc.expectedEvents)
+
+			req := tc.transport.requests[0]
+			assert.Equal(t, "https://sentry.io/api/1234/store/", req.URL.String())
+			assert.Contains(t, req.Header.Get("X-Sentry-Auth"), "sentry_key=key")
+
+			var event sentry.Event
+			assert.NoError(t, json.Unmarshal(tc.transport.bodies[0], &event))
+			assert.Len(t, event.EventID, 32)
+			assert.Equal(t, sentry.LevelError, event.Level)
+			assert.Equal(t, "something went wrong", event.Message)
+			assert.Equal(t, "0.1.0", event.Release)
+			assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", event.Tags["traceId"])
+			assert.Equal(t, "my-service", event.Tags["service"])
+			assert.Equal(t, "jane", event.Extra["user"])
+			assert.NotEmpty(t, event.Extra["stacktrace"])
+		})
+	}
+}
+
+func TestInitSentryInvalidDSN(t *testing.T) {
+	core, logs := zapobserver.New(zapcore.InfoLevel)
+	logger := zap.New(core)
+
+	l, flush := initSentry(configs{sentryDSN: "invalid"}, logger)
+
+	assert.Equal(t, logger, l)
+	assert.NoError(t, flush(context.Background()))
+	assert.Len(t, logs.FilterMessage("Failed to initialize Sentry.").All(), 1)
+}
+
+func TestSentryReporterFlush(t *testing.T) {
+	transport := &mockRoundTripper{statusCode: 200}
+	r, err := newSentryReporter(configs{
+		sentryDSN:       "https://key@sentry.io/1234",
+		sentryTransport: transport,
+	})
+	assert.NoError(t, err)
+
+	r.capture(sentry.NewEvent())
+	assert.NoError(t, r.flush(context.Background()))
+	assert.Len(t, transport.requests, 1)
+
+	// Events captured after flushing are dropped
+	r.capture(sentry.NewEvent())
+	assert.NoError(t, r.flush(context.Background()))
+	assert.Len(t, transport.requests, 1)
+}
+
+func TestSentryReporterBounded(t *testing.T) {
+	release := make(chan struct{})
+	transport := &blockingRoundTripper{
+		mockRoundTripper: mockRoundTripper{statusCode: 200},
+		release:          release,
+	}
+
+	logger, flush := initSentry(configs{
+		sentryDSN:       "https://key@sentry.io/1234",
+		sentryTransport: transport,
+	}, zap.NewNop())
+
+	// While Sentry is not responding, the events beyond the queue size are dropped
+	for i := 0; i < 100; i++ {
+		logger.Error("something went wrong")
+	}
+	close(release)
+
+	assert.NoError(t, flush(context.Background()))
+	assert.NotEmpty(t, transport.requests)
+	assert.Less(t, len(transport.requests), 100)
+}
+
+func TestNewWithSentry(t *testing.T) {
+	transport := &mockRoundTripper{statusCode: 200}
+	obsv := New(false,
+		WithMetadata("my-service", "0.1.0", "", "", nil),
+		WithLogger("none"),
+		WithSentry("https://key@sentry.io/1234"),
+		func(c *configs) {
+			c.sentryTransport = transport
+		},
+	)
+
+	obsv.Logger().Error("Panic occurred.", zap.Error(errors.New("panic occurred: something went wrong")))
+	// Syncing stdout may fail in tests, but pending events are flushed before the logger is synced
+	_ = obsv.Shutdown(context.Background())
+
+	assert.Len(t, transport.requests, 1)
+}
@@ -0,0 +1,209 @@
+package observer
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/go-multierror"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/metric/number"
+	"go.opentelemetry.io/otel/sdk/export/metric/aggregation"
+	"go.opentelemetry.io/otel/sdk/metric/selector/simple"
+
+	export "go.opentelemetry.io/otel/sdk/export/metric"
+	controller "go.opentelemetry.io/otel/sdk/metric/controller/basic"
+	processor "go.opentelemetry.io/otel/sdk/metric/processor/basic"
+)
+
+// statsdMaxPacketSize is the maximum size of a UDP packet sent to StatsD.
+// This is the recommended size for avoiding fragmentation on most networks.
+const statsdMaxPacketSize = 1432
+
+// statsdReplacer replaces the characters that have a meaning in the StatsD protocol.
+var statsdReplacer = strings.NewReplacer(":", "_", "|", "_", "@", "_", "#", "_", ",", "_", "\n", "_")
+
+// statsdExporter is an export.Exporter that writes metrics in the DogStatsD format to a UDP connection.
+// Value recorders are reported as timers (one line per measurement), counters are reported as counters (the change since the last export),
+// and up-down counters and observers are reported as gauges. Labels are reported as DogStatsD tags.
+type statsdExporter struct {
+	conn   net.Conn
+	prefix string
+}
+
+func newStatsDExporter(addr, prefix string) (*statsdExporter, error) {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	return &statsdExporter{
+		conn:   conn,
+		prefix: prefix,
+	}, nil
+}
+
+// ExportKindFor reports the changes of counters, so StatsD can aggregate them,
+// and the current values of up-down counters, since StatsD gauges are absolute values.
+func (e *statsdExporter) ExportKindFor(desc *metric.Descriptor, _ aggregation.Kind) export.ExportKind {
+	switch desc.InstrumentKind() {
+	case metric.UpDownCounterInstrumentKind, metric.UpDownSumObserverInstrumentKind:
+		return export.CumulativeExportKind
+	default:
+		return export.DeltaExportKind
+	}
+}
+
+// line formats a metric in the DogStatsD format (name:value|type|#key:value,...).
+func (e *statsdExporter) line(desc *metric.Descriptor, tags string, value number.Number, typ string) string {
+	var b strings.Builder
+	b.WriteString(statsdReplacer.Replace(e.prefix + desc.Name()))
+	b.WriteByte(':')
+	if desc.NumberKind() == number.Float64Kind {
+		b.WriteString(strconv.FormatFloat(value.AsFloat64(), 'g', -1, 64))
+	} else {
+		b.WriteString(strconv.FormatInt(value.AsInt64(), 10))
+	}
+	b.WriteByte('|')
+	b.WriteString(typ)
+	if tags != "" {
+		b.WriteString("|#")
+		b.WriteString(tags)
+	}
+
+	return b.String()
+}
+
+// lines formats a record as StatsD lines.
+func (e *statsdExporter) lines(r export.Record) ([]string, error) {
+	desc := r.Descriptor()
+
+	var tags []string
+	for iter := r.Labels().Iter(); iter.Next(); {
+		kv := iter.Label()
+		tags = append(tags, statsdReplacer.Replace(string(kv.Key))+":"+statsdReplacer.Replace(kv.Value.Emit()))
+	}
+	tagList := strings.Join(tags, ",")
+
+	switch agg := r.Aggregation().(type) {
+	case aggregation.Points:
+		points, err := agg.Points()
+		if err != nil {
+			return nil, err
+		}
+
+		lines := make([]string, 0, len(points))
+		for _, p := range points {
+			lines = append(lines, e.line(desc, tagList, p.Number, "ms"))
+		}
+		return lines, nil
+
+	case aggregation.Sum:
+		sum, err := agg.Sum()
+		if err != nil {
+			return nil, err
+		}
+
+		if desc.InstrumentKind().Monotonic() {
+			// Counters without any change since the last export are not reported
+			if sum.IsZero(desc.NumberKind()) {
+				return nil, nil
+			}
+			return []string{e.line(desc, tagList, sum, "c")}, nil
+		}
+		return []string{e.line(desc, tagList, sum, "g")}, nil
+
+	case aggregation.LastValue:
+		value, _, err := agg.LastValue()
+		if err == aggregation.ErrNoData {
+			return nil, nil
+		} else if err != nil {
+			return nil, err
+		}
+		return []string{e.line(desc, tagList, value, "g")}, nil
+	}
+
+	return nil, nil
+}
+
+// Export writes the lines of all records to the UDP connection.
+// The lines are batched into packets no larger than statsdMaxPacketSize.
+func (e *statsdExporter) Export(_ context.Context, checkpointSet export.CheckpointSet) error {
+	var result error
+	var buf bytes.Buffer
+
+	flush := func() {
+		if buf.Len() == 0 {
+			return
+		}
+		if _, err := e.conn.Write(buf.Bytes()); err != nil {
+			result = multierror.Append(result, err)
+		}
+		buf.Reset()
+	}
+
+	err := checkpointSet.ForEach(e, func(r export.Record) error {
+		lines, err := e.lines(r)
+		if err != nil {
+			return err
+		}
+
+		for _, line := range lines {
+			if buf.Len() > 0 && buf.Len()+1+len(line) > statsdMaxPacketSize {
+				flush()
+			}
+			if buf.Len() > 0 {
+				buf.WriteByte('\n')
+			}
+			buf.WriteString(line)
+		}
+
+		return nil
+	})
+
+	flush()
+
+	if err != nil {
+		result = multierror.Append(result, err)
+	}
+
+	return result
+}
+
+func initStatsD(c configs) (metric.Meter, shutdownFunc) {
+	exporter, err := newStatsDExporter(c.statsdAddr, c.statsdPrefix)
+	if err != nil {
+		panic(err)
+	}
+
+	checkpointer := processor.New(simple.NewWithExactDistribution(), exporter)
+	cont := controller.New(checkpointer,
+		controller.WithPusher(exporter),
+		controller.WithCollectPeriod(2*time.Second),
+	)
+
+	if err := cont.Start(context.Background()); err != nil {
+		panic(err)
+	}
+
+	otel.SetMeterProvider(cont.MeterProvider())
+	meter := cont.MeterProvider().Meter(c.name)
+
+	// The controller is stopped before the connection is closed, so the last measurements are exported.
+	shutdown := func(ctx context.Context) error {
+		var err error
+		if e := cont.Stop(ctx); e != nil {
+			err = multierror.Append(err, e)
+		}
+		if e := exporter.conn.Close(); e != nil {
+			err = multierror.Append(err, e)
+		}
+		return err
+	}
+
+	return meter, shutdown
+}
@@ -0,0 +1,146 @@
+package observer
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/label"
+	"go.opentelemetry.io/otel/metric"
+
+	controller "go.opentelemetry.io/otel/sdk/metric/controller/basic"
+	processor "go.opentelemetry.io/otel/sdk/metric/processor/basic"
+	"go.opentelemetry.io/otel/sdk/metric/selector/simple"
+)
+
+// readStatsD reads the lines received by a UDP listener until no more packets are received.
+func readStatsD(t *testing.T, conn net.PacketConn) []string {
+	var lines []string
+	buf := make([]byte, 65536)
+
+	for {
+		assert.NoError(t, conn.SetReadDeadline(time.Now().Add(200*time.Millisecond)))
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			return lines
+		}
+
+		lines = append(lines, strings.Split(string(buf[:n]), "\n")...)
+	}
+}
+
+func TestStatsDExporter(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer conn.Close()
+
+	exporter, err := newStatsDExporter(conn.LocalAddr().String(), "app.")
+	assert.NoError(t, err)
+	defer exporter.conn.Close()
+
+	cont := controller.New(
+		processor.New(simple.NewWithExactDistribution(), exporter),
+		controller.WithPusher(exporter),
+		controller.WithCollectPeriod(time.Hour),
+	)
+	assert.NoError(t, cont.Start(context.Background()))
+
+	ctx := context.Background()
+	mm := metric.Must(cont.MeterProvider().Meter("test"))
+
+	counter := mm.NewInt64Counter("requests_total")
+	counter.Add(ctx, 1, label.String("method", "GET"), label.Int("status", 200))
+	counter.Add(ctx, 2, label.String("method", "GET"), label.Int("status", 200))
+
+	recorder := mm.NewInt64ValueRecorder("requests_duration")
+	recorder.Record(ctx, 120, label.String("method", "GET"))
+	recorder.Record(ctx, 250, label.String("method", "GET"))
+
+	gauge := mm.NewInt64UpDownCounter("requests_active")
+	gauge.Add(ctx, 3)
+	gauge.Add(ctx, -1)
+
+	mm.NewFloat64ValueObserver("memory_ratio", func(_ context.Context, result metric.Float64ObserverResult) {
+		result.Observe(0.5, label.String("unsafe|tag", "a:b"))
+	})
+
+	// Stopping the controller exports the last measurements
+	assert.NoError(t, cont.Stop(ctx))
+
+	assert.ElementsMatch(t, []string{
+		"app.requests_total:3|c|#method:GET,status:200",
+		"app.requests_duration:120|ms|#method:GET",
+		"app.requests_duration:250|ms|#method:GET",
+		"app.requests_active:2|g",
+		"app.memory_ratio:0.5|g|#unsafe_tag:a_b",
+	}, readStatsD(t, conn))
+}
+
+func TestStatsDExporterPacketSize(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer conn.Close()
+
+	exporter, err := newStatsDExporter(conn.LocalAddr().String(), "")
+	assert.NoError(t, err)
+	defer exporter.conn.Close()
+
+	cont := controller.New(
+		processor.New(simple.NewWithExactDistribution(), exporter),
+		controller.WithPusher(exporter),
+		controller.WithCollectPeriod(time.Hour),
+	)
+	assert.NoError(t, cont.Start(context.Background()))
+
+	ctx := context.Background()
+	recorder := metric.Must(cont.MeterProvider().Meter("test")).NewInt64ValueRecorder("jobs_duration")
+	for i := 0; i < 500; i++ {
+		recorder.Record(ctx, 100)
+	}
+
+	assert.NoError(t, cont.Stop(ctx))
+
+	buf := make([]byte, 65536)
+	var total int
+	for {
+		assert.NoError(t, conn.SetReadDeadline(time.Now().Add(200*time.Millisecond)))
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			break
+		}
+
+		assert.LessOrEqual(t, n, statsdMaxPacketSize)
+		total += len(strings.Split(string(buf[:n]), "\n"))
+	}
+
+	assert.Equal(t, 500, total)
+}
+
+func TestNewWithStatsD(t *testing.T) {
+	defer otel.SetMeterProvider(otel.GetMeterProvider())
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer conn.Close()
+
+	obsv := New(false, WithStatsD(conn.LocalAddr().String(), "myservice."))
+	assert.NotNil(t, obsv)
+
+	ctx := context.Background()
+	mm := metric.Must(obsv.Meter())
+	mm.NewInt64Counter("jobs_total").Add(ctx, 1, label.String("job", "sync"))
+	mm.NewInt64ValueRecorder("jobs_duration").Record(ctx, 250, label.String("job", "sync"))
+
+	// Shutting down the observer flushes the metrics
+	assert.NoError(t, obsv.Shutdown(ctx))
+
+	// The metrics of the observer itself (i.e. build_info) are also reported
+	assert.Subset(t, readStatsD(t, conn), []string{
+		"myservice.jobs_total:1|c|#job:sync",
+		"myservice.jobs_duration:250|ms|#job:sync",
+	})
+}
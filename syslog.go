@@ -0,0 +1,109 @@
+//go:build !windows && !plan9
+// +build !windows,!plan9
+
+package observer
+
+import (
+	"log/syslog"
+
+	"go.uber.org/zap/zapcore"
+)
+
+// syslogSeverity maps a logging level to a syslog severity.
+func syslogSeverity(level zapcore.Level) syslog.Priority {
+	switch level {
+	case zapcore.DebugLevel:
+		return syslog.LOG_DEBUG
+	case zapcore.InfoLevel:
+		return syslog.LOG_INFO
+	case zapcore.WarnLevel:
+		return syslog.LOG_WARNING
+	case zapcore.ErrorLevel:
+		return syslog.LOG_ERR
+	case zapcore.DPanicLevel, zapcore.PanicLevel:
+		return syslog.LOG_CRIT
+	case zapcore.FatalLevel:
+		return syslog.LOG_EMERG
+	default:
+		return syslog.LOG_INFO
+	}
+}
+
+// syslogCore is a zapcore.Core that writes encoded log entries to a syslog connection.
+// The severity of syslog messages is determined by the level of log entries.
+type syslogCore struct {
+	zapcore.LevelEnabler
+	enc    zapcore.Encoder
+	writer *syslog.Writer
+}
+
+// newSyslogCore connects to a syslog server and creates a core for writing log entries to it.
+// If network is empty, it connects to the local syslog server.
+// The returned function closes the connection.
+func newSyslogCore(network, addr, tag string, enc zapcore.Encoder, enab zapcore.LevelEnabler) (zapcore.Core, func() error, error) {
+	writer, err := syslog.Dial(network, addr, syslog.LOG_USER|syslog.LOG_INFO, tag)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	core := &syslogCore{
+		LevelEnabler: enab,
+		enc:          enc,
+		writer:       writer,
+	}
+
+	return core, writer.Close, nil
+}
+
+func (c *syslogCore) With(fields []zapcore.Field) zapcore.Core {
+	enc := c.enc.Clone()
+	for _, f := range fields {
+		f.AddTo(enc)
+	}
+
+	return &syslogCore{
+		LevelEnabler: c.LevelEnabler,
+		enc:          enc,
+		writer:       c.writer,
+	}
+}
+
+func (c *syslogCore) Check(e zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(e.Level) {
+		return ce.AddCore(e, c)
+	}
+	return ce
+}
+
+func (c *syslogCore) Write(e zapcore.Entry, fields []zapcore.Field) error {
+	buf, err := c.enc.EncodeEntry(e, fields)
+	if err != nil {
+		return err
+	}
+	defer buf.Free()
+
+	// The syslog writer adds the line ending
+	msg := buf.String()
+	if n := len(msg); n > 0 && msg[n-1] == '\n' {
+		msg = msg[:n-1]
+	}
+
+	switch syslogSeverity(e.Level) {
+	case syslog.LOG_DEBUG:
+		return c.writer.Debug(msg)
+	case syslog.LOG_WARNING:
+		return c.writer.Warning(msg)
+	case syslog.LOG_ERR:
+		return c.writer.Err(msg)
+	case syslog.LOG_CRIT:
+		return c.writer.Crit(msg)
+	case syslog.LOG_EMERG:
+		return c.writer.Emerg(msg)
+	default:
+		return c.writer.Info(msg)
+	}
+}
+
+func (c *syslogCore) Sync() error {
+	return nil
+}
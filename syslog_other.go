@@ -0,0 +1,15 @@
+//go:build windows || plan9
+// +build windows plan9
+
+package observer
+
+import (
+	"errors"
+
+	"go.uber.org/zap/zapcore"
+)
+
+// newSyslogCore always fails since syslog is only available on Unix systems.
+func newSyslogCore(network, addr, tag string, enc zapcore.Encoder, enab zapcore.LevelEnabler) (zapcore.Core, func() error, error) {
+	return nil, nil, errors.New("syslog is not supported on this platform")
+}
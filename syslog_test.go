@@ -0,0 +1,191 @@
+//go:build !windows && !plan9
+// +build !windows,!plan9
+
+package observer
+
+import (
+	"context"
+	"log/syslog"
+	"net"
+	"regexp"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// syslogMessage is a message received by a fake syslog server.
+type syslogMessage struct {
+	facility syslog.Priority
+	severity syslog.Priority
+	content  string
+}
+
+var syslogMessageRegexp = regexp.MustCompile(`(?s)^<([0-9]+)>(.*)$`)
+
+// newFakeSyslogServer starts a udp server that receives syslog messages.
+func newFakeSyslogServer(t *testing.T) (string, <-chan syslogMessage) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	t.Cleanup(func() {
+		conn.Close()
+	})
+
+	messages := make(chan syslogMessage, 10)
+	go func() {
+		buf := make([]byte, 65536)
+		for {
+			n, _, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+
+			if m := syslogMessageRegexp.FindStringSubmatch(string(buf[:n])); m != nil {
+				pri, _ := strconv.Atoi(m[1])
+				messages <- syslogMessage{
+					facility: syslog.Priority(pri) &^ 7,
+					severity: syslog.Priority(pri) & 7,
+					content:  m[2],
+				}
+			}
+		}
+	}()
+
+	return conn.LocalAddr().String(), messages
+}
+
+func receiveSyslogMessage(t *testing.T, messages <-chan syslogMessage) syslogMessage {
+	select {
+	case m := <-messages:
+		return m
+	case <-time.After(time.Second):
+		t.Fatal("no syslog message received")
+		return syslogMessage{}
+	}
+}
+
+func TestSyslogSeverity(t *testing.T) {
+	tests := []struct {
+		level            zapcore.Level
+		expectedSeverity syslog.Priority
+	}{
+		{zapcore.DebugLevel, syslog.LOG_DEBUG},
+		{zapcore.InfoLevel, syslog.LOG_INFO},
+		{zapcore.WarnLevel, syslog.LOG_WARNING},
+		{zapcore.ErrorLevel, syslog.LOG_ERR},
+		{zapcore.DPanicLevel, syslog.LOG_CRIT},
+		{zapcore.PanicLevel, syslog.LOG_CRIT},
+		{zapcore.FatalLevel, syslog.LOG_EMERG},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.level.String(), func(t *testing.T) {
+			assert.Equal(t, tc.expectedSeverity, syslogSeverity(tc.level))
+		})
+	}
+}
+
+func TestSyslogCore(t *testing.T) {
+	addr, messages := newFakeSyslogServer(t)
+
+	enc := zapcore.NewJSONEncoder(zapcore.EncoderConfig{MessageKey: "message"})
+	core, closeSyslog, err := newSyslogCore("udp", addr, "my-service", enc, zapcore.InfoLevel)
+	assert.NoError(t, err)
+	defer closeSyslog()
+
+	logger := zap.New(core).With(zap.String("version", "0.1.0"))
+	logger.Debug("this entry is not enabled")
+	logger.Info("info message")
+	logger.Warn("warn message")
+	logger.Error("error message", zap.String("user", "jane"))
+
+	tests := []struct {
+		expectedSeverity syslog.Priority
+		expectedContent  string
+	}{
+		{syslog.LOG_INFO, `{"message":"info message","version":"0.1.0"}`},
+		{syslog.LOG_WARNING, `{"message":"warn message","version":"0.1.0"}`},
+		{syslog.LOG_ERR, `{"message":"error message","version":"0.1.0","user":"jane"}`},
+	}
+
+	for _, tc := range tests {
+		m := receiveSyslogMessage(t, messages)
+		assert.Equal(t, syslog.LOG_USER, m.facility)
+		assert.Equal(t, tc.expectedSeverity, m.severity)
+		assert.Contains(t, m.content, "my-service[")
+		assert.Contains(t, m.content, tc.expectedContent)
+	}
+}
+
+func TestNewSyslogCoreFailure(t *testing.T) {
+	enc := zapcore.NewJSONEncoder(zapcore.EncoderConfig{MessageKey: "message"})
+	core, closeSyslog, err := newSyslogCore("tcp", "127.0.0.1:1", "my-service", enc, zapcore.InfoLevel)
+
+	assert.Error(t, err)
+	assert.Nil(t, core)
+	assert.Nil(t, closeSyslog)
+}
+
+func TestInitLoggerWithSyslog(t *testing.T) {
+	addr, messages := newFakeSyslogServer(t)
+
+	tests := []struct {
+		name             string
+		network          string
+		addr             string
+		expectedSyslog   bool
+		expectedMessages []string
+	}{
+		{
+			name:             "Success",
+			network:          "udp",
+			addr:             addr,
+			expectedSyslog:   true,
+			expectedMessages: []string{"hello"},
+		},
+		{
+			name:             "Failure",
+			network:          "tcp",
+			addr:             "127.0.0.1:1",
+			expectedSyslog:   false,
+			expectedMessages: []string{"Failed to connect to syslog, logging to stdout.", "hello"},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var entries []string
+
+			c := configs{
+				name:          "my-service",
+				loggerLevel:   "info",
+				syslogEnabled: true,
+				syslogNetwork: tc.network,
+				syslogAddr:    tc.addr,
+				syslogTag:     "my-service",
+				loggerHooks: []func(zapcore.Entry) error{
+					func(e zapcore.Entry) error {
+						entries = append(entries, e.Message)
+						return nil
+					},
+				},
+			}
+
+			logger, _, shutdown := initLogger(c)
+			logger.Info("hello")
+
+			assert.Equal(t, tc.expectedMessages, entries)
+
+			if tc.expectedSyslog {
+				m := receiveSyslogMessage(t, messages)
+				assert.Equal(t, syslog.LOG_INFO, m.severity)
+				assert.Contains(t, m.content, `"message":"hello"`)
+				assert.Contains(t, m.content, `"logger":"my-service"`)
+				assert.NoError(t, shutdown(context.Background()))
+			}
+		})
+	}
+}
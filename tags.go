@@ -0,0 +1,94 @@
+package observer
+
+import (
+	"sort"
+	"sync"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// tags keeps the tags that are set at runtime and added to every log entry.
+type tags struct {
+	sync.RWMutex
+	values map[string]string
+	fields []zapcore.Field
+}
+
+func newTags() *tags {
+	return &tags{
+		values: map[string]string{},
+	}
+}
+
+// set adds or updates a tag.
+// The fields are rebuilt on every change, so writing log entries does not allocate them.
+func (t *tags) set(key, value string) {
+	t.Lock()
+	defer t.Unlock()
+
+	t.values[key] = value
+
+	keys := make([]string, 0, len(t.values))
+	for k := range t.values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	fields := make([]zapcore.Field, len(keys))
+	for i, k := range keys {
+		fields[i] = zap.String(k, t.values[k])
+	}
+
+	t.fields = fields
+}
+
+func (t *tags) get() []zapcore.Field {
+	t.RLock()
+	defer t.RUnlock()
+
+	return t.fields
+}
+
+// tagsCore is a zapcore.Core that adds the current tags to every log entry it writes.
+type tagsCore struct {
+	zapcore.Core
+	tags *tags
+}
+
+func (c *tagsCore) With(fields []zapcore.Field) zapcore.Core {
+	return &tagsCore{
+		Core: c.Core.With(fields),
+		tags: c.tags,
+	}
+}
+
+func (c *tagsCore) Check(e zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(e.Level) {
+		return ce.AddCore(e, c)
+	}
+	return ce
+}
+
+func (c *tagsCore) Write(e zapcore.Entry, fields []zapcore.Field) error {
+	if tagFields := c.tags.get(); len(tagFields) > 0 {
+		fields = append(append([]zapcore.Field{}, tagFields...), fields...)
+	}
+
+	// The wrapped core is checked again, so only its cores that are enabled for the entry write it
+	if ce := c.Core.Check(e, nil); ce != nil {
+		ce.Write(fields...)
+	}
+
+	return nil
+}
+
+// withTags returns a logger that adds the current tags to every log entry.
+func withTags(logger *zap.Logger, t *tags) *zap.Logger {
+	return logger.WithOptions(zap.WrapCore(func(core zapcore.Core) zapcore.Core {
+		return &tagsCore{
+			Core: core,
+			tags: t,
+		}
+	}))
+}
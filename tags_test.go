@@ -0,0 +1,54 @@
+package observer
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	zapobserver "go.uber.org/zap/zaptest/observer"
+)
+
+func TestTags(t *testing.T) {
+	tg := newTags()
+	assert.Empty(t, tg.get())
+
+	tg.set("region", "us-east-1")
+	tg.set("deployment", "blue")
+	assert.Equal(t, []zapcore.Field{
+		zap.String("deployment", "blue"),
+		zap.String("region", "us-east-1"),
+	}, tg.get())
+
+	tg.set("deployment", "green")
+	assert.Equal(t, []zapcore.Field{
+		zap.String("deployment", "green"),
+		zap.String("region", "us-east-1"),
+	}, tg.get())
+}
+
+func TestWithTags(t *testing.T) {
+	core, logs := zapobserver.New(zapcore.InfoLevel)
+	tg := newTags()
+	logger := withTags(zap.New(core), tg)
+	child := logger.With(zap.String("component", "worker"))
+
+	logger.Info("before")
+	tg.set("deployment", "blue")
+	logger.Info("after", zap.String("user", "jane"))
+	child.Info("child")
+	child.Debug("disabled")
+
+	entries := logs.AllUntimed()
+	assert.Len(t, entries, 3)
+
+	assert.Equal(t, map[string]interface{}{}, entries[0].ContextMap())
+	assert.Equal(t, map[string]interface{}{
+		"deployment": "blue",
+		"user":       "jane",
+	}, entries[1].ContextMap())
+	assert.Equal(t, map[string]interface{}{
+		"component":  "worker",
+		"deployment": "blue",
+	}, entries[2].ContextMap())
+}
@@ -0,0 +1,35 @@
+package observer
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel/label"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// deploymentColorKey is the span attribute for the deployment color (i.e. blue, green, or canary).
+const deploymentColorKey = label.Key("deployment.color")
+
+// attributesTracer is a trace.Tracer that adds a set of attributes to every span it starts.
+type attributesTracer struct {
+	trace.Tracer
+	attrs []label.KeyValue
+}
+
+// withSpanAttributes returns a tracer that adds the given attributes to every span.
+// The attributes are added before the options of the caller, so the caller can override them.
+func withSpanAttributes(tracer trace.Tracer, attrs ...label.KeyValue) trace.Tracer {
+	if len(attrs) == 0 {
+		return tracer
+	}
+
+	return &attributesTracer{
+		Tracer: tracer,
+		attrs:  attrs,
+	}
+}
+
+func (t *attributesTracer) Start(ctx context.Context, spanName string, opts ...trace.SpanOption) (context.Context, trace.Span) {
+	opts = append([]trace.SpanOption{trace.WithAttributes(t.attrs...)}, opts...)
+	return t.Tracer.Start(ctx, spanName, opts...)
+}
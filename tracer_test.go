@@ -0,0 +1,62 @@
+package observer
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/label"
+	"go.opentelemetry.io/otel/oteltest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestWithSpanAttributes(t *testing.T) {
+	tests := []struct {
+		name          string
+		attrs         []label.KeyValue
+		opts          []trace.SpanOption
+		expectedAttrs map[label.Key]label.Value
+	}{
+		{
+			name:          "NoAttribute",
+			attrs:         nil,
+			opts:          nil,
+			expectedAttrs: map[label.Key]label.Value{},
+		},
+		{
+			name:  "Attributes",
+			attrs: []label.KeyValue{deploymentColorKey.String("canary")},
+			opts: []trace.SpanOption{
+				trace.WithAttributes(label.String("tenant", "aaaa")),
+			},
+			expectedAttrs: map[label.Key]label.Value{
+				deploymentColorKey: label.StringValue("canary"),
+				"tenant":           label.StringValue("aaaa"),
+			},
+		},
+		{
+			name:  "Overridden",
+			attrs: []label.KeyValue{deploymentColorKey.String("canary")},
+			opts: []trace.SpanOption{
+				trace.WithAttributes(deploymentColorKey.String("blue")),
+			},
+			expectedAttrs: map[label.Key]label.Value{
+				deploymentColorKey: label.StringValue("blue"),
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			sr := new(oteltest.StandardSpanRecorder)
+			tracer := oteltest.NewTracerProvider(oteltest.WithSpanRecorder(sr)).Tracer("test")
+
+			_, span := withSpanAttributes(tracer, tc.attrs...).Start(context.Background(), "request", tc.opts...)
+			span.End()
+
+			spans := sr.Completed()
+			assert.Len(t, spans, 1)
+			assert.Equal(t, tc.expectedAttrs, spans[0].Attributes())
+		})
+	}
+}